@@ -24,6 +24,7 @@ import (
 	"github.com/DowLucas/gin-ticket-release/pkg/jobs/tasks"
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"github.com/DowLucas/gin-ticket-release/pkg/routes"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
 )
 
 var log = logrus.New()
@@ -147,6 +148,36 @@ func setupCronJobs(db *gorm.DB) *cron.Cron {
 		}).Fatal("Failed to add StartEventSiteVisitsJob to cron")
 	}
 
+	// Retry failed webhook events once their backoff has passed
+	_, err = c.AddFunc("@every 5m", func() {
+		if err := services.NewPaymentService(db).RetryFailedWebhookEvents(); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Failed to retry failed webhook events")
+		}
+	})
+
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to add RetryFailedWebhookEvents to cron")
+	}
+
+	// Issue refunds that were recorded but never reached the payment provider
+	_, err = c.AddFunc("@every 5m", func() {
+		if err := services.IssuePendingRefunds(db); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Failed to issue pending refunds")
+		}
+	})
+
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to add IssuePendingRefunds to cron")
+	}
+
 	fmt.Println("Starting cron jobs")
 	c.Start()
 
@@ -245,10 +276,14 @@ func main() {
 		panic("Failed to initialize organization roles: " + err.Error())
 	}
 
-	if err := models.InitializeTicketReleaseMethods(db); err != nil {
+	if err := services.InitializeTicketReleaseMethods(db); err != nil {
 		panic("Failed to initialize ticket release methods: " + err.Error())
 	}
 
+	if err := services.MigrateTicketReleasePromoCodes(db); err != nil {
+		panic("Failed to migrate promo codes: " + err.Error())
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 
 	// Setup cron jobs
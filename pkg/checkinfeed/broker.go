@@ -0,0 +1,94 @@
+// Package checkinfeed broadcasts the check-ins of an event to the live check-in dashboards
+package checkinfeed
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
+)
+
+// Update is a change of the check-in state of a ticket
+type Update struct {
+	EventID      uint      `json:"event_id"`
+	TicketID     uint      `json:"ticket_id"`
+	TicketTypeID uint      `json:"ticket_type_id"`
+	CheckedIn    bool      `json:"checked_in"`
+	Action       string    `json:"action"`
+	Gate         string    `json:"gate"`
+	Organizer    string    `json:"organizer"`
+	At           time.Time `json:"at"`
+}
+
+// Broker publishes updates to the subscribers of the event, on every replica of the server
+type Broker interface {
+	Publish(ctx context.Context, update Update) error
+	// Subscribe returns the updates of the event until cancel is called. Updates are dropped for
+	// subscribers that do not keep up.
+	Subscribe(eventID uint) (updates <-chan Update, cancel func())
+}
+
+// NewBroker returns the Redis broker if CHECK_IN_FEED_STORE is "redis", otherwise the memory broker.
+// Redis is needed when there are several replicas so that check-ins on one reach the dashboards connected to the others
+func NewBroker(prefix string) (Broker, error) {
+	if os.Getenv("CHECK_IN_FEED_STORE") != "redis" {
+		return NewMemoryBroker(), nil
+	}
+
+	client, err := ratelimit.NewRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRedisBroker(client, prefix), nil
+}
+
+// hub fans out the updates to the subscribers connected to this replica
+type hub struct {
+	subscribers map[uint]map[chan Update]struct{}
+	mtx         sync.Mutex
+}
+
+func newHub() *hub {
+	return &hub{subscribers: make(map[uint]map[chan Update]struct{})}
+}
+
+func (h *hub) subscribe(eventID uint) (<-chan Update, func()) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	updates := make(chan Update, 64)
+	if h.subscribers[eventID] == nil {
+		h.subscribers[eventID] = make(map[chan Update]struct{})
+	}
+	h.subscribers[eventID][updates] = struct{}{}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mtx.Lock()
+			defer h.mtx.Unlock()
+
+			delete(h.subscribers[eventID], updates)
+			if len(h.subscribers[eventID]) == 0 {
+				delete(h.subscribers, eventID)
+			}
+		})
+	}
+
+	return updates, cancel
+}
+
+func (h *hub) broadcast(update Update) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for updates := range h.subscribers[update.EventID] {
+		select {
+		case updates <- update:
+		default:
+		}
+	}
+}
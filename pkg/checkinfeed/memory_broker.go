@@ -0,0 +1,23 @@
+package checkinfeed
+
+import (
+	"context"
+)
+
+// MemoryBroker only reaches the subscribers of this instance of the server
+type MemoryBroker struct {
+	hub *hub
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{hub: newHub()}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, update Update) error {
+	b.hub.broadcast(update)
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(eventID uint) (<-chan Update, func()) {
+	return b.hub.subscribe(eventID)
+}
@@ -0,0 +1,53 @@
+package checkinfeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker publishes the updates of an event on the channel <prefix><eventID>. Every replica keeps
+// one pattern subscription to all the channels and fans the updates out to its own subscribers.
+type RedisBroker struct {
+	client *redis.Client
+	prefix string
+	hub    *hub
+}
+
+func NewRedisBroker(client *redis.Client, prefix string) *RedisBroker {
+	b := &RedisBroker{client: client, prefix: prefix, hub: newHub()}
+	go b.listen(context.Background())
+	return b
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, update Update) error {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	return b.client.Publish(ctx, fmt.Sprintf("%s%d", b.prefix, update.EventID), payload).Err()
+}
+
+func (b *RedisBroker) Subscribe(eventID uint) (<-chan Update, func()) {
+	return b.hub.subscribe(eventID)
+}
+
+// listen receives the updates of all events, go-redis reconnects the subscription if the connection is lost
+func (b *RedisBroker) listen(ctx context.Context) {
+	pubsub := b.client.PSubscribe(ctx, b.prefix+"*")
+	defer pubsub.Close()
+
+	for message := range pubsub.Channel() {
+		var update Update
+		if err := json.Unmarshal([]byte(message.Payload), &update); err != nil {
+			log.Printf("Invalid check-in update on %s: %v", message.Channel, err)
+			continue
+		}
+
+		b.hub.broadcast(update)
+	}
+}
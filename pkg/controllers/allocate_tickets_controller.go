@@ -22,17 +22,19 @@ func NewAllocateTicketsController(db *gorm.DB, ats *services.AllocateTicketsServ
 	return &AllocateTicketsController{DB: db, AllocateTicketsService: ats}
 }
 
-func (atc *AllocateTicketsController) AllocateTickets(c *gin.Context) {
+// getAllocationRequest binds the allocation request body and loads the ticket release,
+// it writes the error response itself and returns false if anything is invalid
+func (atc *AllocateTicketsController) getAllocationRequest(c *gin.Context) (*models.TicketRelease, *types.AllocateTicketsRequest, bool) {
 	var allocateTicketsRequest types.AllocateTicketsRequest
 	if err := c.ShouldBindJSON(&allocateTicketsRequest); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
+		return nil, nil, false
 	}
 
 	duration, err := time.ParseDuration(allocateTicketsRequest.ReservePaymentDuration)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration"})
-		return
+		return nil, nil, false
 	}
 
 	allocateTicketsRequest.CalculatedDuration = duration
@@ -49,15 +51,24 @@ func (atc *AllocateTicketsController) AllocateTickets(c *gin.Context) {
 		Preload("Event").
 		Where("event_id = ? AND id = ?", eventID, ticketReleaseID).First(&ticketRelease).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID or ticket release ID"})
-		return
+		return nil, nil, false
 	}
 
 	if ticketRelease.HasAllocatedTickets {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Tickets already allocated"})
+		return nil, nil, false
+	}
+
+	return &ticketRelease, &allocateTicketsRequest, true
+}
+
+func (atc *AllocateTicketsController) AllocateTickets(c *gin.Context) {
+	ticketRelease, allocateTicketsRequest, ok := atc.getAllocationRequest(c)
+	if !ok {
 		return
 	}
 
-	err = atc.AllocateTicketsService.AllocateTickets(&ticketRelease, &allocateTicketsRequest)
+	err := atc.AllocateTicketsService.AllocateTickets(ticketRelease, allocateTicketsRequest)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -67,7 +78,7 @@ func (atc *AllocateTicketsController) AllocateTickets(c *gin.Context) {
 	// Close the ticket release
 	ticketRelease.Close = time.Now().Unix()
 
-	if err := atc.DB.Save(&ticketRelease).Error; err != nil {
+	if err := atc.DB.Save(ticketRelease).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error closing ticket release"})
 		return
 	}
@@ -75,6 +86,23 @@ func (atc *AllocateTicketsController) AllocateTickets(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Tickets allocated"})
 }
 
+// PreviewAllocateTickets shows what AllocateTickets would do with the same request body,
+// without allocating any tickets or notifying anyone
+func (atc *AllocateTicketsController) PreviewAllocateTickets(c *gin.Context) {
+	ticketRelease, allocateTicketsRequest, ok := atc.getAllocationRequest(c)
+	if !ok {
+		return
+	}
+
+	preview, err := atc.AllocateTicketsService.PreviewAllocateTickets(ticketRelease, allocateTicketsRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preview": preview})
+}
+
 func (atc *AllocateTicketsController) ListAllocatedTickets(c *gin.Context) {
 	ticketReleaseID := c.Param("ticketReleaseID")
 
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AllocationRunController struct {
+	DB      *gorm.DB
+	service *services.AllocationRunService
+}
+
+func NewAllocationRunController(db *gorm.DB, service *services.AllocationRunService) *AllocationRunController {
+	return &AllocationRunController{DB: db, service: service}
+}
+
+func (arc *AllocationRunController) getTicketReleaseID(c *gin.Context) (uint, bool) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return 0, false
+	}
+
+	ticketReleaseID, err := strconv.Atoi(c.Param("ticketReleaseID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket release ID"})
+		return 0, false
+	}
+
+	var ticketRelease models.TicketRelease
+	if err := arc.DB.Where("event_id = ? AND id = ?", eventID, ticketReleaseID).First(&ticketRelease).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket release not found"})
+		return 0, false
+	}
+
+	return ticketRelease.ID, true
+}
+
+// ListAllocationRuns lists the allocation runs of the ticket release, newest first
+func (arc *AllocationRunController) ListAllocationRuns(c *gin.Context) {
+	ticketReleaseID, ok := arc.getTicketReleaseID(c)
+	if !ok {
+		return
+	}
+
+	runs, rerr := arc.service.ListAllocationRuns(ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"allocation_runs": runs})
+}
+
+// UndoAllocationRun reverts an allocation run that is still within its grace period
+func (arc *AllocationRunController) UndoAllocationRun(c *gin.Context) {
+	ticketReleaseID, ok := arc.getTicketReleaseID(c)
+	if !ok {
+		return
+	}
+
+	runID, err := strconv.Atoi(c.Param("allocationRunID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allocation run ID"})
+		return
+	}
+
+	ugKthID := c.GetString("ugkthid")
+
+	if rerr := arc.service.UndoAllocationRun(ticketReleaseID, uint(runID), ugKthID); rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Allocation undone"})
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// Scans are pushed as they happen, the dashboard counts at most this often
+	checkInDashboardRefresh = 2 * time.Second
+	// Keeps idle streams from being closed by proxies
+	checkInStreamHeartbeat = 15 * time.Second
+)
+
+type CheckInController struct {
+	DB      *gorm.DB
+	service *services.CheckInService
+}
+
+func NewCheckInController(db *gorm.DB, service *services.CheckInService) *CheckInController {
+	return &CheckInController{DB: db, service: service}
+}
+
+// QrCodeCheckIn checks in a ticket using a QR code
+func (cic *CheckInController) QrCodeCheckIn(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var req types.CheckInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, rerr := cic.service.CheckIn(eventID, c.GetString("ugkthid"), &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	message := "User " + result.Ticket.User.FullName() + " checked in successfully!"
+	if result.AlreadyCheckedIn {
+		message = "User " + result.Ticket.User.FullName() + " was already checked in at " + result.CheckedInAt.Format("15:04:05")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "check_in": result})
+}
+
+func (cic *CheckInController) UndoCheckIn(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	// The gate is optional, so an empty body is allowed
+	var req types.UndoCheckInRequest
+	_ = c.ShouldBindJSON(&req)
+
+	ticket, rerr := cic.service.UndoCheckIn(eventID, ticketID, c.GetString("ugkthid"), req.Gate)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket})
+}
+
+// ListCheckInLogs lists the latest scans of the event, or of one ticket with the ticket_id query
+func (cic *CheckInController) ListCheckInLogs(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	ticketID, err := strconv.Atoi(c.DefaultQuery("ticket_id", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	logs, rerr := cic.service.GetCheckInLogs(eventID, ticketID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"check_in_logs": logs})
+}
+
+// Stream sends the check-in dashboard of the event over Server-Sent Events. A "dashboard" event with the
+// counts is sent when the stream opens and after scans, and a "scan" event for every scan on any replica.
+func (cic *CheckInController) Stream(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	// Subscribe before the first dashboard so that no scan in between is missed
+	updates, cancel := cic.service.Subscribe(eventID)
+	defer cancel()
+
+	dashboard, rerr := cic.service.GetDashboard(eventID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	refresh := time.NewTicker(checkInDashboardRefresh)
+	defer refresh.Stop()
+	heartbeat := time.NewTicker(checkInStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("dashboard", dashboard)
+
+	stale := false
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case update := <-updates:
+			c.SSEvent("scan", update)
+			stale = true
+		case <-refresh.C:
+			if !stale {
+				return true
+			}
+
+			dashboard, rerr := cic.service.GetDashboard(eventID)
+			if rerr != nil {
+				c.SSEvent("error", gin.H{"error": rerr.Message})
+				return false
+			}
+
+			c.SSEvent("dashboard", dashboard)
+			stale = false
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", time.Now().Unix())
+		}
+
+		return true
+	})
+}
+
+// ReconcileOfflineCheckIns uploads the check-ins that a scanner made while it was offline
+func (cic *CheckInController) ReconcileOfflineCheckIns(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var req types.OfflineCheckInBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, rerr := cic.service.ReconcileOfflineCheckIns(eventID, c.GetString("ugkthid"), &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
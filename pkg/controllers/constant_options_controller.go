@@ -19,13 +19,17 @@ func NewConstantOptionsController(db *gorm.DB) *ConstantOptionsController {
 type Constants struct {
 	CancellationPolicies []string `json:"cancellation_policies"`
 	NotificationMethods  []string `json:"notification_methods"`
+	LotteryPriorityModes []string `json:"lottery_priority_modes"`
+	PaymentProviders     []string `json:"payment_providers"`
 }
 
 func (co *ConstantOptionsController) ListTicketReleaseConstants(c *gin.Context) {
 	// may look confusing, but these are just constants defined in the models package
 	constants := Constants{
-		CancellationPolicies: []string{models.FULL_REFUND, models.NO_REFUND},
+		CancellationPolicies: []string{models.FULL_REFUND, models.NO_REFUND, models.TIERED_REFUND},
 		NotificationMethods:  []string{models.EMAIL},
+		LotteryPriorityModes: []string{models.LOTTERY_PRIORITY_TIERED, models.LOTTERY_PRIORITY_WEIGHTED},
+		PaymentProviders:     []string{string(models.STRIPE), string(models.SWISH)},
 	}
 
 	c.JSON(http.StatusOK, constants)
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type DiscountCodeController struct {
+	DB      *gorm.DB
+	service *services.DiscountCodeService
+}
+
+func NewDiscountCodeController(db *gorm.DB, service *services.DiscountCodeService) *DiscountCodeController {
+	return &DiscountCodeController{DB: db, service: service}
+}
+
+// ListDiscountCodes lists the discount codes of the event with the number of times they have been used
+func (dcc *DiscountCodeController) ListDiscountCodes(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	discountCodes, rerr := dcc.service.ListDiscountCodes(eventID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"discount_codes": discountCodes})
+}
+
+func (dcc *DiscountCodeController) CreateDiscountCode(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var req types.DiscountCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	discountCode, rerr := dcc.service.CreateDiscountCode(eventID, &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"discount_code": discountCode})
+}
+
+func (dcc *DiscountCodeController) UpdateDiscountCode(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	discountCodeID, err := strconv.Atoi(c.Param("discountCodeID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discount code ID"})
+		return
+	}
+
+	var req types.DiscountCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	discountCode, rerr := dcc.service.UpdateDiscountCode(eventID, discountCodeID, &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"discount_code": discountCode})
+}
+
+func (dcc *DiscountCodeController) DeleteDiscountCode(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	discountCodeID, err := strconv.Atoi(c.Param("discountCodeID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discount code ID"})
+		return
+	}
+
+	if rerr := dcc.service.DeleteDiscountCode(eventID, discountCodeID); rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Discount code deleted"})
+}
@@ -167,6 +167,7 @@ func (ec *EventController) GetEvent(c *gin.Context) {
 		Preload("TicketReleases.Event").
 		Preload("TicketReleases.TicketReleaseMethodDetail.TicketReleaseMethod").
 		Preload("TicketReleases.PaymentDeadline").
+		Preload("TicketReleases.WaitingRoom").
 		Preload("FormFields").
 		Preload("TicketReleases.AddOns").
 		First(&event, id).Error
@@ -313,7 +314,7 @@ func (ec *EventController) ListTickets(c *gin.Context) {
 	var ticketRequests []models.TicketRequest
 	if err := ec.DB.
 		Unscoped().
-		Preload("Transaction").
+		Preload("Transaction", "transaction_type <> ?", models.TypeRefund).
 		Preload("User.FoodPreferences").
 		Preload("TicketRequest.TicketType").
 		Preload("TicketRequest.EventFormReponses.EventFormField").
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type LotteryDrawController struct {
+	DB      *gorm.DB
+	service *services.LotteryDrawService
+}
+
+func NewLotteryDrawController(db *gorm.DB, service *services.LotteryDrawService) *LotteryDrawController {
+	return &LotteryDrawController{DB: db, service: service}
+}
+
+func (ldc *LotteryDrawController) getTicketReleaseID(c *gin.Context) (uint, bool) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return 0, false
+	}
+
+	ticketReleaseID, err := strconv.Atoi(c.Param("ticketReleaseID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket release ID"})
+		return 0, false
+	}
+
+	var ticketRelease models.TicketRelease
+	if err := ldc.DB.Where("event_id = ? AND id = ?", eventID, ticketReleaseID).First(&ticketRelease).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket release not found"})
+		return 0, false
+	}
+
+	return ticketRelease.ID, true
+}
+
+// GetLotteryDraw returns the published seed and hash of the lottery draw
+func (ldc *LotteryDrawController) GetLotteryDraw(c *gin.Context) {
+	ticketReleaseID, ok := ldc.getTicketReleaseID(c)
+	if !ok {
+		return
+	}
+
+	draw, rerr := ldc.service.GetLotteryDraw(ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lottery_draw": draw})
+}
+
+// VerifyLotteryDraw re-runs the lottery draw in dry mode and confirms the outcome
+func (ldc *LotteryDrawController) VerifyLotteryDraw(c *gin.Context) {
+	ticketReleaseID, ok := ldc.getTicketReleaseID(c)
+	if !ok {
+		return
+	}
+
+	verification, rerr := ldc.service.VerifyLotteryDraw(ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"verification": verification})
+}
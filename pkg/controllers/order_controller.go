@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type OrderController struct {
+	DB      *gorm.DB
+	service *services.PaymentService
+}
+
+func NewOrderController(db *gorm.DB, service *services.PaymentService) *OrderController {
+	return &OrderController{DB: db, service: service}
+}
+
+// CreateOrder starts one payment of several tickets of the user, the client secret is what the
+// frontend needs to complete the payment with the provider
+func (oc *OrderController) CreateOrder(c *gin.Context) {
+	ugkthid := c.MustGet("ugkthid").(string)
+
+	var body types.CreateOrderRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, payment, rerr := oc.service.CreateOrder(ugkthid, body.TicketIDs, body.DiscountCode)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order":         order,
+		"client_secret": payment.ClientSecret,
+		"payment_id":    payment.ID,
+		"provider":      order.PaymentProvider,
+	})
+}
+
+func (oc *OrderController) ListOrders(c *gin.Context) {
+	ugkthid := c.MustGet("ugkthid").(string)
+
+	orders, rerr := oc.service.GetOrders(ugkthid)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"orders": orders})
+}
+
+func (oc *OrderController) GetOrder(c *gin.Context) {
+	ugkthid := c.MustGet("ugkthid").(string)
+
+	orderID, err := strconv.Atoi(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	order, rerr := oc.service.GetOrder(ugkthid, uint(orderID))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order": order})
+}
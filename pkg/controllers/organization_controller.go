@@ -113,9 +113,10 @@ func (ec *OrganisationController) GetOrganization(c *gin.Context) {
 }
 
 type UpdateOrganizationRequest struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	ID              int    `json:"id"`
+	Email           string `json:"email"`
+	Name            string `json:"name"`
+	PaymentProvider string `json:"payment_provider"`
 }
 
 func (ec *OrganisationController) UpdateOrganization(c *gin.Context) {
@@ -156,8 +157,14 @@ func (ec *OrganisationController) UpdateOrganization(c *gin.Context) {
 		}
 	}
 
+	if err := models.ValidatePaymentProvider(models.PaymentProviderName(req.PaymentProvider)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	organization.Email = req.Email
 	organization.Name = req.Name
+	organization.PaymentProvider = models.PaymentProviderName(req.PaymentProvider)
 
 	if err := ec.DB.Save(&organization).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
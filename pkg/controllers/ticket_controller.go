@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/DowLucas/gin-ticket-release/pkg/checkinfeed"
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"github.com/DowLucas/gin-ticket-release/pkg/services"
 	"github.com/DowLucas/gin-ticket-release/pkg/types"
@@ -16,8 +17,9 @@ type TicketController struct {
 	Service *services.TicketService
 }
 
-func NewTicketController(db *gorm.DB) *TicketController {
+func NewTicketController(db *gorm.DB, feed checkinfeed.Broker) *TicketController {
 	service := services.NewTicketService(db)
+	service.Feed = feed
 	return &TicketController{DB: db, Service: service}
 }
 
@@ -126,25 +128,51 @@ func (tc *TicketController) CancelTicket(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-type QrCodeCheckInRequest struct {
-	QrCode string `json:"qr_code"`
+func (tc *TicketController) UpdateGuestName(c *gin.Context) {
+	UGKthId, _ := c.Get("ugkthid")
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var body types.UpdateGuestNameBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ticket, errResponse := tc.Service.UpdateGuestName(UGKthId.(string), ticketID, body.GuestName)
+	if errResponse != nil {
+		c.JSON(errResponse.StatusCode, gin.H{"error": errResponse.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket})
 }
 
-// QrCodeCheckIn checks in a ticket using a QR code
-func (tc *TicketController) QrCodeCheckIn(c *gin.Context) {
-	var req QrCodeCheckInRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+// RefundAddOn refunds add-ons on one of the user's paid tickets, the ticket is kept
+func (tc *TicketController) RefundAddOn(c *gin.Context) {
+	UGKthId, _ := c.Get("ugkthid")
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ticketAddOnID, err := strconv.Atoi(c.Param("ticketAddOnID"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	ticket, errResponse := tc.Service.CheckInViaQrCode(req.QrCode)
+	ticketAddOn, errResponse := tc.Service.RefundAddOn(UGKthId.(string), ticketID, ticketAddOnID)
 	if errResponse != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": errResponse.Message})
+		c.JSON(errResponse.StatusCode, gin.H{"error": errResponse.Message})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User" + ticket.User.FullName() + " checked in successfully!"})
+	c.JSON(http.StatusOK, gin.H{"ticket_add_on": ticketAddOn})
 }
 
 func (tc *TicketController) UpdateTicketType(c *gin.Context) {
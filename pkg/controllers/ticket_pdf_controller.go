@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TicketPDFController struct {
+	DB      *gorm.DB
+	service *services.TicketPDFService
+}
+
+func NewTicketPDFController(db *gorm.DB, service *services.TicketPDFService) *TicketPDFController {
+	return &TicketPDFController{DB: db, service: service}
+}
+
+// GetTicketPDF returns the printable PDF of one of the user's tickets
+func (tpc *TicketPDFController) GetTicketPDF(c *gin.Context) {
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	pdf, rerr := tpc.service.TicketPDF(c.GetString("ugkthid"), ticketID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"ticket-%d.pdf\"", ticketID))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -24,21 +24,33 @@ func NewTicketReleaseController(db *gorm.DB) *TicketReleaseController {
 }
 
 type TicketReleaseRequest struct {
-	EventID               int    `json:"event_id"`
-	Name                  string `json:"name"`
-	Description           string `json:"description"`
-	Open                  int    `json:"open"`
-	Close                 int    `json:"close"`
-	AllowExternal         bool   `json:"allow_external"`
-	TicketReleaseMethodID int    `json:"ticket_release_method_id"`
-	OpenWindowDuration    int    `json:"open_window_duration"`
-	MaxTicketsPerUser     int    `json:"max_tickets_per_user"`
-	NotificationMethod    string `json:"notification_method"`
-	CancellationPolicy    string `json:"cancellation_policy"`
-	IsReserved            bool   `json:"is_reserved"`
-	PromoCode             string `json:"promo_code"`
-	TicketsAvailable      int    `json:"tickets_available"`
-	MethodDescription     string `json:"method_description"`
+	EventID               int                 `json:"event_id"`
+	Name                  string              `json:"name"`
+	Description           string              `json:"description"`
+	Open                  int                 `json:"open"`
+	Close                 int                 `json:"close"`
+	AllowExternal         bool                `json:"allow_external"`
+	AllowTransfers        bool                `json:"allow_transfers"`
+	TransferDeadline      *int64              `json:"transfer_deadline"`
+	TransferPaidOnly      bool                `json:"transfer_paid_only"`
+	AllowResale           bool                `json:"allow_resale"`
+	PaymentProvider       string              `json:"payment_provider"`
+	TicketReleaseMethodID int                 `json:"ticket_release_method_id"`
+	OpenWindowDuration    int                 `json:"open_window_duration"`
+	MaxTicketsPerUser     int                 `json:"max_tickets_per_user"`
+	NotificationMethod    string              `json:"notification_method"`
+	CancellationPolicy    string              `json:"cancellation_policy"`
+	RefundTiers           []models.RefundTier `json:"refund_tiers"`
+	RefundHandlingFee     float64             `json:"refund_handling_fee"`
+	IsReserved            bool                `json:"is_reserved"`
+	PromoCode             string              `json:"promo_code"`
+	TicketsAvailable      int                 `json:"tickets_available"`
+	MethodDescription     string              `json:"method_description"`
+
+	LotteryPriorityMode    string `json:"lottery_priority_mode"`
+	MemberLotteryWeight    int    `json:"member_lottery_weight"`
+	InternalLotteryWeight  int    `json:"internal_lottery_weight"`
+	PromoCodeLotteryWeight int    `json:"promo_code_lottery_weight"`
 }
 
 func (trmc *TicketReleaseController) CreateTicketRelease(c *gin.Context) {
@@ -69,7 +81,14 @@ func (trmc *TicketReleaseController) CreateTicketRelease(c *gin.Context) {
 		MethodDescription:     req.MethodDescription,
 		NotificationMethod:    req.NotificationMethod,
 		CancellationPolicy:    req.CancellationPolicy,
+		RefundTiers:           req.RefundTiers,
+		RefundHandlingFee:     req.RefundHandlingFee,
 		MaxTicketsPerUser:     uint(req.MaxTicketsPerUser),
+
+		LotteryPriorityMode:    req.LotteryPriorityMode,
+		MemberLotteryWeight:    models.NormalizeLotteryWeight(req.MemberLotteryWeight),
+		InternalLotteryWeight:  models.NormalizeLotteryWeight(req.InternalLotteryWeight),
+		PromoCodeLotteryWeight: models.NormalizeLotteryWeight(req.PromoCodeLotteryWeight),
 	}
 
 	if err := ticketReleaseMethodDetails.Validate(); err != nil {
@@ -77,6 +96,11 @@ func (trmc *TicketReleaseController) CreateTicketRelease(c *gin.Context) {
 		return
 	}
 
+	if err := models.ValidatePaymentProvider(models.PaymentProviderName(req.PaymentProvider)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Start transaction
 	tx := trmc.DB.Begin()
 	defer func() {
@@ -91,13 +115,8 @@ func (trmc *TicketReleaseController) CreateTicketRelease(c *gin.Context) {
 		return
 	}
 
-	method, err := models.NewTicketReleaseConfig(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	if err := method.Validate(); err != nil {
+	if err := services.ValidateTicketReleaseMethod(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails); err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -130,6 +149,11 @@ func (trmc *TicketReleaseController) CreateTicketRelease(c *gin.Context) {
 		PromoCode:                   promoCode,
 		TicketsAvailable:            req.TicketsAvailable,
 		AllowExternal:               req.AllowExternal,
+		AllowTransfers:              req.AllowTransfers,
+		TransferDeadline:            req.TransferDeadline,
+		TransferPaidOnly:            req.TransferPaidOnly,
+		AllowResale:                 req.AllowResale,
+		PaymentProvider:             models.PaymentProviderName(req.PaymentProvider),
 	}
 
 	if err := tx.Create(&ticketRelease).Error; err != nil {
@@ -138,6 +162,14 @@ func (trmc *TicketReleaseController) CreateTicketRelease(c *gin.Context) {
 		return
 	}
 
+	if req.IsReserved {
+		if err := services.SetPrimaryPromoCode(tx, &ticketRelease, req.PromoCode); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Commit transaction
 	tx.Commit()
 
@@ -234,8 +266,7 @@ func (trmc *TicketReleaseController) GetTicketRelease(c *gin.Context) {
 			return
 		}
 
-		// Hash the promo code
-		checked, err := utils.CompareHashAndString(*ticketRelease.PromoCode, promoCode)
+		checked, err := services.CheckPromoCode(trmc.DB, &ticketRelease, promoCode)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code"})
 			return
@@ -370,6 +401,16 @@ func (trmc *TicketReleaseController) UpdateTicketRelease(c *gin.Context) {
 	ticketRelease.IsReserved = req.IsReserved
 	ticketRelease.PromoCode = promoCode
 	ticketRelease.AllowExternal = req.AllowExternal
+	ticketRelease.AllowTransfers = req.AllowTransfers
+	ticketRelease.TransferDeadline = req.TransferDeadline
+	ticketRelease.TransferPaidOnly = req.TransferPaidOnly
+	ticketRelease.AllowResale = req.AllowResale
+
+	if err := models.ValidatePaymentProvider(models.PaymentProviderName(req.PaymentProvider)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ticketRelease.PaymentProvider = models.PaymentProviderName(req.PaymentProvider)
 
 	// Update ticket release method details
 	var ticketReleaseMethodDetails models.TicketReleaseMethodDetail
@@ -381,15 +422,34 @@ func (trmc *TicketReleaseController) UpdateTicketRelease(c *gin.Context) {
 	ticketReleaseMethodDetails.OpenWindowDuration = int64(req.OpenWindowDuration)
 	ticketReleaseMethodDetails.NotificationMethod = req.NotificationMethod
 	ticketReleaseMethodDetails.CancellationPolicy = req.CancellationPolicy
+	ticketReleaseMethodDetails.RefundTiers = req.RefundTiers
+	ticketReleaseMethodDetails.RefundHandlingFee = req.RefundHandlingFee
 	ticketReleaseMethodDetails.MaxTicketsPerUser = uint(req.MaxTicketsPerUser)
 	ticketReleaseMethodDetails.TicketReleaseMethodID = uint(req.TicketReleaseMethodID)
 	ticketReleaseMethodDetails.MethodDescription = req.MethodDescription
+	ticketReleaseMethodDetails.LotteryPriorityMode = req.LotteryPriorityMode
+	ticketReleaseMethodDetails.MemberLotteryWeight = models.NormalizeLotteryWeight(req.MemberLotteryWeight)
+	ticketReleaseMethodDetails.InternalLotteryWeight = models.NormalizeLotteryWeight(req.InternalLotteryWeight)
+	ticketReleaseMethodDetails.PromoCodeLotteryWeight = models.NormalizeLotteryWeight(req.PromoCodeLotteryWeight)
 
 	if err := ticketReleaseMethodDetails.Validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	var ticketReleaseMethod models.TicketReleaseMethod
+	if err := tx.First(&ticketReleaseMethod, "id = ?", req.TicketReleaseMethodID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket release method ID"})
+		return
+	}
+
+	if err := services.ValidateTicketReleaseMethod(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := tx.Save(&ticketReleaseMethodDetails).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "There was an error updating the ticket release method details"})
@@ -402,6 +462,17 @@ func (trmc *TicketReleaseController) UpdateTicketRelease(c *gin.Context) {
 		return
 	}
 
+	primaryPromoCode := ""
+	if req.IsReserved {
+		primaryPromoCode = req.PromoCode
+	}
+
+	if err := services.SetPrimaryPromoCode(tx, &ticketRelease, primaryPromoCode); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	tx.Commit()
 
 	c.JSON(http.StatusOK, gin.H{"ticket_release": ticketRelease})
@@ -4,18 +4,19 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/DowLucas/gin-ticket-release/pkg/models"
-	"github.com/DowLucas/gin-ticket-release/utils"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type TicketReleasePromoCodeController struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	service *services.PromoCodeService
 }
 
-func NewTicketReleasePromoCodeController(db *gorm.DB) *TicketReleasePromoCodeController {
-	return &TicketReleasePromoCodeController{DB: db}
+func NewTicketReleasePromoCodeController(db *gorm.DB, service *services.PromoCodeService) *TicketReleasePromoCodeController {
+	return &TicketReleasePromoCodeController{DB: db, service: service}
 }
 
 func (ctrl *TicketReleasePromoCodeController) Create(c *gin.Context) {
@@ -29,12 +30,6 @@ func (ctrl *TicketReleasePromoCodeController) Create(c *gin.Context) {
 
 	ugKthId := c.GetString("ugkthid")
 
-	var user models.User
-	if err := ctrl.DB.Where("ug_kth_id = ?", ugKthId).First(&user).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user"})
-		return
-	}
-
 	promoCode := c.DefaultQuery("promo_code", "")
 
 	if promoCode == "" {
@@ -42,42 +37,143 @@ func (ctrl *TicketReleasePromoCodeController) Create(c *gin.Context) {
 		return
 	}
 
-	// Check if the user is a super admin
-	var event models.Event
-	if err := ctrl.DB.First(&event, eventID).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+	if _, rerr := ctrl.service.ActivatePromoCode(ugKthId, c.ClientIP(), intEventID, promoCode); rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
 		return
 	}
 
-	// Find ticket release based on promo code
-	var ticketReleases []models.TicketRelease
-	if err := ctrl.DB.Where("event_id = ?", intEventID).Find(&ticketReleases).Error; err != nil {
+	c.JSON(http.StatusOK, gin.H{"message": "User has successfully unlocked the ticket release!"})
+}
+
+// GetPromoCodeAttempts shows the organizers how many invalid promo codes have been tried for the event
+func (ctrl *TicketReleasePromoCodeController) GetPromoCodeAttempts(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
 		return
 	}
 
-	for _, ticketRelease := range ticketReleases {
-		if ticketRelease.PromoCode != nil {
-			decryptedPromoCode, err := utils.DecryptString(*ticketRelease.PromoCode)
+	stats, rerr := ctrl.service.GetPromoCodeAttemptStats(eventID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_code_attempts": stats})
+}
+
+// ListPromoCodes lists the promo codes of the ticket release with the number of times they have been used
+func (ctrl *TicketReleasePromoCodeController) ListPromoCodes(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parsePromoCodeParams(c)
+	if !ok {
+		return
+	}
+
+	promoCodes, rerr := ctrl.service.ListPromoCodes(eventID, ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_codes": promoCodes})
+}
+
+// ListPromoCodeUnlocks lists which users have unlocked the ticket release with which promo code
+func (ctrl *TicketReleasePromoCodeController) ListPromoCodeUnlocks(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parsePromoCodeParams(c)
+	if !ok {
+		return
+	}
+
+	unlocks, rerr := ctrl.service.ListPromoCodeUnlocks(eventID, ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unlocks": unlocks})
+}
+
+func (ctrl *TicketReleasePromoCodeController) CreatePromoCode(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parsePromoCodeParams(c)
+	if !ok {
+		return
+	}
+
+	var req types.TicketReleasePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promoCode, rerr := ctrl.service.CreatePromoCode(eventID, ticketReleaseID, &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"promo_code": promoCode})
+}
+
+func (ctrl *TicketReleasePromoCodeController) UpdatePromoCode(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parsePromoCodeParams(c)
+	if !ok {
+		return
+	}
+
+	promoCodeID, err := strconv.Atoi(c.Param("promoCodeID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	var req types.TicketReleasePromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promoCode, rerr := ctrl.service.UpdatePromoCode(eventID, ticketReleaseID, promoCodeID, &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
 
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decrypting promo code"})
-				return
-			}
+	c.JSON(http.StatusOK, gin.H{"promo_code": promoCode})
+}
 
-			if decryptedPromoCode == promoCode {
-				ticketRelease.UserUnlockReservedTicketRelease(&user)
+func (ctrl *TicketReleasePromoCodeController) DeletePromoCode(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parsePromoCodeParams(c)
+	if !ok {
+		return
+	}
 
-				// Save ticket release
-				if err := ctrl.DB.Save(&ticketRelease).Error; err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving ticket release"})
-					return
-				}
-				c.JSON(http.StatusOK, gin.H{"message": "User has successfully unlocked the ticket release!"})
-				return
-			}
-		}
+	promoCodeID, err := strconv.Atoi(c.Param("promoCodeID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	if rerr := ctrl.service.DeletePromoCode(eventID, ticketReleaseID, promoCodeID); rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promo code deleted"})
+}
+
+func parsePromoCodeParams(c *gin.Context) (int, int, bool) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return 0, 0, false
+	}
+
+	ticketReleaseID, err := strconv.Atoi(c.Param("ticketReleaseID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket release ID"})
+		return 0, 0, false
 	}
 
-	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code"})
+	return eventID, ticketReleaseID, true
 }
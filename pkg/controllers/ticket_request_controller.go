@@ -13,12 +13,13 @@ import (
 )
 
 type TicketRequestController struct {
-	Service *services.TicketRequestService
+	Service     *services.TicketRequestService
+	waitingRoom *services.WaitingRoomService
 }
 
-func NewTicketRequestController(db *gorm.DB) *TicketRequestController {
+func NewTicketRequestController(db *gorm.DB, waitingRoom *services.WaitingRoomService) *TicketRequestController {
 	service := services.NewTicketRequestService(db)
-	return &TicketRequestController{Service: service}
+	return &TicketRequestController{Service: service, waitingRoom: waitingRoom}
 }
 
 func (trc *TicketRequestController) UsersList(c *gin.Context) {
@@ -71,6 +72,17 @@ func (trc *TicketRequestController) Create(c *gin.Context) {
 	var ticketRequests []models.TicketRequest = request.TicketRequests
 	// var addOns []models.AddOn = request.AddOns
 
+	if len(ticketRequests) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No ticket requests"})
+		return
+	}
+
+	// Releases with a waiting room only take ticket requests from admitted users
+	if rerr := trc.waitingRoom.CheckAdmitted(UGKthID.(string), ticketRequests[0].TicketReleaseID); rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
 	for i := range ticketRequests {
 		ticketRequests[i].UserUGKthID = UGKthID.(string)
 	}
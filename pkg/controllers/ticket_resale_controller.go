@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TicketResaleController struct {
+	DB      *gorm.DB
+	service *services.TicketResaleService
+}
+
+func NewTicketResaleController(db *gorm.DB, service *services.TicketResaleService) *TicketResaleController {
+	return &TicketResaleController{DB: db, service: service}
+}
+
+// ListTicket lists one of the user's tickets for resale to the reserve list
+func (trc *TicketResaleController) ListTicket(c *gin.Context) {
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	resale, rerr := trc.service.ListTicket(c.GetString("ugkthid"), ticketID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"resale": resale})
+}
+
+// ListResales lists the user's tickets that have been listed for resale
+func (trc *TicketResaleController) ListResales(c *gin.Context) {
+	resales, rerr := trc.service.ListResalesForUser(c.GetString("ugkthid"))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"resales": resales})
+}
+
+// CancelResale takes the user's tickets off the resale list
+func (trc *TicketResaleController) CancelResale(c *gin.Context) {
+	resaleID, err := strconv.Atoi(c.Param("resaleID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resale ID"})
+		return
+	}
+
+	resale, rerr := trc.service.CancelResale(c.GetString("ugkthid"), uint(resaleID))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"resale": resale})
+}
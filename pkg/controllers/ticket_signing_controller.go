@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TicketSigningController struct {
+	DB      *gorm.DB
+	service *services.TicketSigningService
+}
+
+func NewTicketSigningController(db *gorm.DB, service *services.TicketSigningService) *TicketSigningController {
+	return &TicketSigningController{DB: db, service: service}
+}
+
+// GetPublicKey returns the public key that scanners use to verify the signed QR codes of the event offline
+func (tsc *TicketSigningController) GetPublicKey(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	publicKey, rerr := tsc.service.GetPublicKey(eventID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, publicKey)
+}
+
+// SignTicket returns the signed QR code of one of the user's tickets
+func (tsc *TicketSigningController) SignTicket(c *gin.Context) {
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	qrCode, rerr := tsc.service.SignTicket(c.GetString("ugkthid"), ticketID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"qr_code": qrCode})
+}
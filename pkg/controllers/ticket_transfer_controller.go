@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TicketTransferController struct {
+	DB      *gorm.DB
+	service *services.TicketTransferService
+}
+
+func NewTicketTransferController(db *gorm.DB, service *services.TicketTransferService) *TicketTransferController {
+	return &TicketTransferController{DB: db, service: service}
+}
+
+// InitiateTransfer starts a transfer of one of the user's tickets
+func (ttc *TicketTransferController) InitiateTransfer(c *gin.Context) {
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	var body types.TicketTransferRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, rerr := ttc.service.InitiateTransfer(c.GetString("ugkthid"), ticketID, body.Recipient)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"transfer": transfer})
+}
+
+// ListTransfers lists the transfers the user has sent or received
+func (ttc *TicketTransferController) ListTransfers(c *gin.Context) {
+	transfers, rerr := ttc.service.ListTransfersForUser(c.GetString("ugkthid"))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfers": transfers})
+}
+
+func (ttc *TicketTransferController) respond(c *gin.Context, respond func(ugKthID string, transferID uint) (*models.TicketTransfer, *types.ErrorResponse)) {
+	transferID, err := strconv.Atoi(c.Param("transferID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return
+	}
+
+	transfer, rerr := respond(c.GetString("ugkthid"), uint(transferID))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfer": transfer})
+}
+
+// AcceptTransfer accepts a transfer sent to the user
+func (ttc *TicketTransferController) AcceptTransfer(c *gin.Context) {
+	ttc.respond(c, ttc.service.AcceptTransfer)
+}
+
+// DeclineTransfer declines a transfer sent to the user
+func (ttc *TicketTransferController) DeclineTransfer(c *gin.Context) {
+	ttc.respond(c, ttc.service.DeclineTransfer)
+}
+
+// CancelTransfer cancels a transfer the user has sent
+func (ttc *TicketTransferController) CancelTransfer(c *gin.Context) {
+	ttc.respond(c, ttc.service.CancelTransfer)
+}
@@ -61,6 +61,12 @@ func (ttc *TicketTypeController) CreateTicketTypes(c *gin.Context) {
 			return
 		}
 
+		if err := ticketType.Validate(); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error() + " at index " + strconv.Itoa(idx)})
+			return
+		}
+
 		if err := tx.Create(&ticketType).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -157,6 +163,12 @@ func (ttc *TicketTypeController) UpdateEventTicketTypes(c *gin.Context) {
 			return
 		}
 
+		if err := ticketType.Validate(); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		if !checkTicketTypeExists(ttc, ticketType.ID) {
 			// Create the ticket type
 			if err := tx.Create(&ticketType).Error; err != nil {
@@ -171,6 +183,7 @@ func (ttc *TicketTypeController) UpdateEventTicketTypes(c *gin.Context) {
 			"price":       ticketType.Price,
 			"name":        ticketType.Name,
 			"description": ticketType.Description,
+			"capacity":    ticketType.Capacity,
 		}
 
 		// Try to update the ticket type
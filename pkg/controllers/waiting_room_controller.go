@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WaitingRoomController struct {
+	DB      *gorm.DB
+	service *services.WaitingRoomService
+}
+
+func NewWaitingRoomController(db *gorm.DB, service *services.WaitingRoomService) *WaitingRoomController {
+	return &WaitingRoomController{DB: db, service: service}
+}
+
+func (wrc *WaitingRoomController) SetWaitingRoom(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parseWaitingRoomParams(c)
+	if !ok {
+		return
+	}
+
+	var req types.WaitingRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	waitingRoom, rerr := wrc.service.SetWaitingRoom(eventID, ticketReleaseID, &req)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"waiting_room": waitingRoom})
+}
+
+func (wrc *WaitingRoomController) RemoveWaitingRoom(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parseWaitingRoomParams(c)
+	if !ok {
+		return
+	}
+
+	if rerr := wrc.service.RemoveWaitingRoom(eventID, ticketReleaseID); rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Waiting room removed"})
+}
+
+func (wrc *WaitingRoomController) JoinWaitingRoom(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parseWaitingRoomParams(c)
+	if !ok {
+		return
+	}
+
+	status, rerr := wrc.service.JoinWaitingRoom(c.GetString("ugkthid"), eventID, ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"waiting_room": status})
+}
+
+// GetWaitingRoomStatus returns the position of the user in the queue and the estimated wait
+func (wrc *WaitingRoomController) GetWaitingRoomStatus(c *gin.Context) {
+	eventID, ticketReleaseID, ok := parseWaitingRoomParams(c)
+	if !ok {
+		return
+	}
+
+	status, rerr := wrc.service.GetWaitingRoomStatus(c.GetString("ugkthid"), eventID, ticketReleaseID)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"waiting_room": status})
+}
+
+func parseWaitingRoomParams(c *gin.Context) (int, int, bool) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return 0, 0, false
+	}
+
+	ticketReleaseID, err := strconv.Atoi(c.Param("ticketReleaseID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket release ID"})
+		return 0, 0, false
+	}
+
+	return eventID, ticketReleaseID, true
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WalletPassController struct {
+	DB      *gorm.DB
+	service *services.WalletPassService
+}
+
+func NewWalletPassController(db *gorm.DB, service *services.WalletPassService) *WalletPassController {
+	return &WalletPassController{DB: db, service: service}
+}
+
+// GetPass returns the .pkpass bundle of the ticket, or the Google Wallet save link with ?wallet=google
+func (wpc *WalletPassController) GetPass(c *gin.Context) {
+	ticketID, err := strconv.Atoi(c.Param("ticketID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	ugkthid := c.GetString("ugkthid")
+
+	switch c.DefaultQuery("wallet", "apple") {
+	case "apple":
+		pass, rerr := wpc.service.ApplePass(ugkthid, ticketID)
+		if rerr != nil {
+			c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"ticket-%d.pkpass\"", ticketID))
+		c.Data(http.StatusOK, "application/vnd.apple.pkpass", pass)
+	case "google":
+		link, rerr := wpc.service.GoogleSaveLink(ugkthid, ticketID)
+		if rerr != nil {
+			c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"save_url": link})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wallet, must be apple or google"})
+	}
+}
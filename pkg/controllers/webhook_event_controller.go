@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebhookEventController struct {
+	DB      *gorm.DB
+	service *services.PaymentService
+}
+
+func NewWebhookEventController(db *gorm.DB, service *services.PaymentService) *WebhookEventController {
+	return &WebhookEventController{DB: db, service: service}
+}
+
+// ListWebhookEvents lists the webhook events that have not been processed,
+// the status query parameter can be failed or dead_letter
+func (wec *WebhookEventController) ListWebhookEvents(c *gin.Context) {
+	events, rerr := wec.service.ListWebhookEvents(c.Query("status"))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook_events": events})
+}
+
+// GetWebhookEvent shows a webhook event together with its raw payload
+func (wec *WebhookEventController) GetWebhookEvent(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event ID"})
+		return
+	}
+
+	event, rerr := wec.service.GetWebhookEvent(uint(eventID))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook_event": event})
+}
+
+// ReplayWebhookEvent processes a webhook event again
+func (wec *WebhookEventController) ReplayWebhookEvent(c *gin.Context) {
+	eventID, err := strconv.Atoi(c.Param("eventID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event ID"})
+		return
+	}
+
+	event, rerr := wec.service.ReplayWebhookEvent(uint(eventID))
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook_event": event})
+}
+
+// ReplayWebhookEvents processes the given webhook events again, or all failed events if none are given
+func (wec *WebhookEventController) ReplayWebhookEvents(c *gin.Context) {
+	var req struct {
+		EventIDs []uint `json:"event_ids"`
+	}
+
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	events, rerr := wec.service.ReplayWebhookEvents(req.EventIDs)
+	if rerr != nil {
+		c.JSON(rerr.StatusCode, gin.H{"error": rerr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook_events": events})
+}
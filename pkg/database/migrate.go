@@ -37,7 +37,30 @@ func Migrate(db *gorm.DB) error {
 		&models.EventSiteVisit{},
 		&models.EventSiteVisitSummary{},
 		&models.BankingDetail{},
+		&models.LotteryDraw{},
+		&models.AllocationRun{},
+		&models.AllocationRunTicket{},
+		&models.TicketTransfer{},
+		&models.TicketResale{},
+		&models.Order{},
+		&models.OrderItem{},
+		&models.DiscountCode{},
+		&models.TicketReleasePromoCode{},
+		&models.TicketReleasePromoCodeUnlock{},
+		&models.PromoCodeAttempt{},
+		&models.TicketReleaseWaitingRoom{},
+		&models.EventSigningKey{},
+		&models.CheckInLog{},
 		&tr_methods.LotteryConfig{},
 	)
+	if err != nil {
+		return err
+	}
+
+	// Refunds are recorded as transactions of the refunded ticket, so a ticket can have several transactions
+	if db.Migrator().HasConstraint(&models.Transaction{}, "transactions_ticket_id_key") {
+		err = db.Migrator().DropConstraint(&models.Transaction{}, "transactions_ticket_id_key")
+	}
+
 	return err
 }
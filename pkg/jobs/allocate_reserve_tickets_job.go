@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/allocate_service"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -165,9 +166,6 @@ func process_mpartj(db *gorm.DB, ticketRelease models.TicketRelease) error {
 		"number_of_reserved_tickets":  len(reservedTickets),
 	}).Info("Got all allocated and reserved tickets")
 
-	// Initialize newReserveTickets by taking the tickets available and subtracting the number of allocated tickets that haven't been deleted
-	var newReserveTickets int64 = int64(ticketRelease.TicketsAvailable) - int64(len(allocatedTickets))
-
 	var newlyAllocatedTicketIDs []int
 	var newlyRemovedTicket []*models.Ticket
 
@@ -194,7 +192,7 @@ func process_mpartj(db *gorm.DB, ticketRelease models.TicketRelease) error {
 
 		// If we reach this point then the ticket has not been paid within the time limit
 		// We can say that the user looses the ticket, we set the ticket to deleted and
-		// increment the number of new tickets to be allocated from the reserve list
+		// the ticket can be allocated to someone on the reserve list
 		err := tx.Where("id = ?", ticket.ID).Delete(&models.Ticket{}).Error
 		if err != nil {
 			allocator_logger.WithFields(logrus.Fields{
@@ -208,98 +206,80 @@ func process_mpartj(db *gorm.DB, ticketRelease models.TicketRelease) error {
 			"id": ticketRelease.ID,
 		}).Infof("Deleted ticket with ID %d", ticket.ID)
 
-		newlyRemovedTicket = append(newlyRemovedTicket, &ticket)
-		// Increment number of new tickets to be allocated from the reserve list
-		newReserveTickets++
+		removedTicket := ticket
+		newlyRemovedTicket = append(newlyRemovedTicket, &removedTicket)
 	}
 
-	// We now have the number of new tickets to be allocated from the reserve list
-	if newReserveTickets > 0 {
-		// We now want to allocate newReserveTickets from the reserve list
-		// Each ticket has a reserve number, we want to allocate the tickets with the lowest reserve number
-		// The list is already sorted by reserve number so we can just iterate through the list
-		var ticket models.Ticket
-		for i := 0; i < len(reservedTickets); i++ {
-			ticket = reservedTickets[i]
-			// If i is greater than or equal to newReserveTickets then we have alalocated all tickets
-			// And we want to update the reserve number for the remaining tickets
-			// This should be i - newReserveTickets + 1
-			if i >= int(newReserveTickets) {
-				ticket.ReserveNumber = uint(i - int(newReserveTickets) + 1)
+	// Remaining tickets of the ticket release and of the ticket types with a capacity,
+	// the tickets deleted above have been given back
+	capacity, err := allocate_service.LoadTicketCapacity(tx, &ticketRelease)
+	if err != nil {
+		allocator_logger.WithFields(logrus.Fields{
+			"id": ticketRelease.ID,
+		}).Errorf("Error getting capacity of ticket release with ID %d: %s", ticketRelease.ID, err.Error())
+		tx.Rollback()
+		return err
+	}
 
-				if err := tx.Save(&ticket).Error; err != nil {
-					allocator_logger.WithFields(logrus.Fields{
-						"id": ticketRelease.ID,
-					}).Errorf("Error saving ticket with ID %d: %s", ticketRelease.ID, err.Error())
-				}
+	// The tickets of a ticket request are on the reserve list together, keep the order of the list
+	var reserveGroups [][]models.Ticket
+	reserveGroupIndex := map[uint]int{}
+	for _, ticket := range reservedTickets {
+		index, ok := reserveGroupIndex[ticket.TicketRequestID]
+		if !ok {
+			index = len(reserveGroups)
+			reserveGroupIndex[ticket.TicketRequestID] = index
+			reserveGroups = append(reserveGroups, nil)
+		}
 
-				continue
-			}
+		reserveGroups[index] = append(reserveGroups[index], ticket)
+	}
 
-			// We want to allocate the ticket
-			// We set the ticket to not be a reserve ticket and set the reserve number to 0
+	// Tickets listed for resale are offered to the reserves first. They do not change the number
+	// of remaining tickets, the seller keeps the tickets until the reserve has paid for them.
+	offeredGroups, offeredTicketIDs, err := offerResoldTickets(tx, ticketRelease, reserveGroups)
+	if err != nil {
+		allocator_logger.WithFields(logrus.Fields{
+			"id": ticketRelease.ID,
+		}).Errorf("Error offering resold tickets of ticket release with ID %d: %s", ticketRelease.ID, err.Error())
+		tx.Rollback()
+		return err
+	}
+	newlyAllocatedTicketIDs = append(newlyAllocatedTicketIDs, offeredTicketIDs...)
+
+	// The reserve list is sorted by reserve number, a reserve gets tickets if there are
+	// tickets left of its ticket type for the whole group. The reserves that remain are
+	// renumbered per ticket type.
+	reserveNumbers := map[uint]uint{}
+	for _, group := range reserveGroups {
+		if offeredGroups[group[0].TicketRequestID] {
+			continue
+		}
 
-			if ticket.TicketRequest.TicketType.ID == 0 {
-				// Fatal error, but we can just load the ticket type
-				if err := tx.Preload("TicketRequest.TicketType").First(&ticket).Error; err != nil {
-					allocator_logger.WithFields(logrus.Fields{
-						"id": ticketRelease.ID,
-					}).Errorf("Error getting ticket type for ticket with ID %d: %s", ticket.ID, err.Error())
+		ticketTypeID := group[0].TicketRequest.TicketTypeID
+
+		if !capacity.CanAllocate(ticketTypeID, len(group)) {
+			reserveNumbers[ticketTypeID]++
+			for _, ticket := range group {
+				if ticket.ReserveNumber == reserveNumbers[ticketTypeID] {
 					continue
 				}
-			}
 
-			var isPaid bool = false
-			if ticket.TicketRequest.TicketType.Price == 0 && ticket.TicketRequest.TicketType.ID != 0 {
-				isPaid = true
-			}
-
-			now := time.Now()
-			var paymentDeadline time.Time = time.Now()
-			if ticketRelease.PaymentDeadline != nil {
-				if ticketRelease.PaymentDeadline.ReservePaymentDuration != nil {
-					paymentDeadline = now.Add(*ticketRelease.PaymentDeadline.ReservePaymentDuration)
-					// Add an hour first to ensure rounding up to the next hour
-					paymentDeadline = paymentDeadline.Add(time.Hour)
-					// Then, set the minutes, seconds, and nanoseconds to 0, rounding up to the next hour
-					paymentDeadline = time.Date(paymentDeadline.Year(), paymentDeadline.Month(), paymentDeadline.Day(), paymentDeadline.Hour(), 0, 0, 0, paymentDeadline.Location())
-					// Check if the payment deadline is before now, which shouldn't normally happen since we're rounding up,
-					// but it's good to keep the logic to ensure the deadline is always in the future
-					if paymentDeadline.Before(now) {
-						paymentDeadline = paymentDeadline.Add(time.Hour)
-					}
+				ticket.ReserveNumber = reserveNumbers[ticketTypeID]
+				if err := tx.Save(&ticket).Error; err != nil {
+					allocator_logger.WithFields(logrus.Fields{
+						"id": ticketRelease.ID,
+					}).Errorf("Error saving ticket with ID %d: %s", ticket.ID, err.Error())
 				}
 			}
 
-			// check if the deadline is after the event date
-
-			ticket.IsReserve = false
-			ticket.ReserveNumber = 0
-			ticket.IsPaid = isPaid
-			ticket.PurchasableAt = &now
-
-			if paymentDeadline.After(ticketRelease.Event.Date) {
-				// If it is, then there is no deadline since it will automatically be set to the event date
-				ticket.PaymentDeadline = nil
-			} else {
-				ticket.PaymentDeadline = &paymentDeadline
-			}
-
-			if err := tx.Save(&ticket).Error; err != nil {
-				allocator_logger.WithFields(logrus.Fields{
-					"id": ticketRelease.ID,
-				}).Errorf("Error saving ticket with ID %d: %s", ticketRelease.ID, err.Error())
-
-				continue
-			}
-
-			allocator_logger.WithFields(logrus.Fields{
-				"id": ticketRelease.ID,
-			}).Infof("Allocated ticket with ID %d", ticket.ID)
-
-			newlyAllocatedTicketIDs = append(newlyAllocatedTicketIDs, int(ticket.ID))
+			continue
 		}
 
+		capacity.Allocate(ticketTypeID, len(group))
+		// The user is notified once for the whole group
+		newlyAllocatedTicketIDs = append(newlyAllocatedTicketIDs, int(group[0].ID))
+		promoteReserveGroup(tx, ticketRelease, group)
 	}
 
 	err = tx.Commit().Error
@@ -322,7 +302,14 @@ func process_mpartj(db *gorm.DB, ticketRelease models.TicketRelease) error {
 		}
 	}
 
+	notifiedTicketRequests := map[uint]bool{}
 	for _, ticket := range newlyRemovedTicket {
+		// The tickets of a ticket request have the same payment deadline, the user is notified once
+		if notifiedTicketRequests[ticket.TicketRequestID] {
+			continue
+		}
+		notifiedTicketRequests[ticket.TicketRequestID] = true
+
 		err := Notify_TicketNotPaidInTime(db, ticket)
 
 		if err != nil {
@@ -339,3 +326,136 @@ func process_mpartj(db *gorm.DB, ticketRelease models.TicketRelease) error {
 	return nil
 
 }
+
+// promoteReserveGroup allocates the tickets of a reserve's ticket request, the reserve gets a
+// payment deadline based on the reserve payment duration of the ticket release
+func promoteReserveGroup(tx *gorm.DB, ticketRelease models.TicketRelease, group []models.Ticket) {
+	for _, ticket := range group {
+		// We want to allocate the ticket
+		// We set the ticket to not be a reserve ticket and set the reserve number to 0
+
+		var isPaid bool = false
+		if ticket.TicketRequest.TicketType.Price == 0 && ticket.TicketRequest.TicketType.ID != 0 {
+			isPaid = true
+		}
+
+		now := time.Now()
+		var paymentDeadline time.Time = time.Now()
+		if ticketRelease.PaymentDeadline != nil {
+			if ticketRelease.PaymentDeadline.ReservePaymentDuration != nil {
+				paymentDeadline = now.Add(*ticketRelease.PaymentDeadline.ReservePaymentDuration)
+				// Add an hour first to ensure rounding up to the next hour
+				paymentDeadline = paymentDeadline.Add(time.Hour)
+				// Then, set the minutes, seconds, and nanoseconds to 0, rounding up to the next hour
+				paymentDeadline = time.Date(paymentDeadline.Year(), paymentDeadline.Month(), paymentDeadline.Day(), paymentDeadline.Hour(), 0, 0, 0, paymentDeadline.Location())
+				// Check if the payment deadline is before now, which shouldn't normally happen since we're rounding up,
+				// but it's good to keep the logic to ensure the deadline is always in the future
+				if paymentDeadline.Before(now) {
+					paymentDeadline = paymentDeadline.Add(time.Hour)
+				}
+			}
+		}
+
+		// check if the deadline is after the event date
+
+		ticket.IsReserve = false
+		ticket.ReserveNumber = 0
+		ticket.IsPaid = isPaid
+		ticket.PurchasableAt = &now
+
+		if paymentDeadline.After(ticketRelease.Event.Date) {
+			// If it is, then there is no deadline since it will automatically be set to the event date
+			ticket.PaymentDeadline = nil
+		} else {
+			ticket.PaymentDeadline = &paymentDeadline
+		}
+
+		if err := tx.Save(&ticket).Error; err != nil {
+			allocator_logger.WithFields(logrus.Fields{
+				"id": ticketRelease.ID,
+			}).Errorf("Error saving ticket with ID %d: %s", ticketRelease.ID, err.Error())
+
+			continue
+		}
+
+		allocator_logger.WithFields(logrus.Fields{
+			"id": ticketRelease.ID,
+		}).Infof("Allocated ticket with ID %d", ticket.ID)
+	}
+}
+
+// offerResoldTickets offers the tickets listed for resale to the first reserve of the same ticket
+// type that requested as many tickets. A listing that was offered to a reserve who did not pay in
+// time, and therefore lost their tickets above, is offered to the next reserve. It returns the
+// ticket requests of the reserves that got an offer and the tickets to notify the reserves about.
+func offerResoldTickets(tx *gorm.DB, ticketRelease models.TicketRelease, reserveGroups [][]models.Ticket) (map[uint]bool, []int, error) {
+	resales, err := models.GetActiveTicketResalesToTicketRelease(tx, ticketRelease.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offeredGroups := map[uint]bool{}
+	var offeredTicketIDs []int
+
+	for i := range resales {
+		resale := &resales[i]
+
+		if resale.Status == models.TicketResaleOffered {
+			var buyerTickets int64
+			if err := tx.Model(&models.Ticket{}).Where("ticket_request_id = ?", *resale.BuyerTicketRequestID).Count(&buyerTickets).Error; err != nil {
+				return nil, nil, err
+			}
+
+			if buyerTickets > 0 {
+				continue
+			}
+
+			resale.Status = models.TicketResaleListed
+			resale.BuyerTicketRequestID = nil
+			resale.OfferedAt = nil
+		}
+
+		sellerTickets, err := models.GetTicketsInRequest(tx, resale.TicketRequestID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(sellerTickets) == 0 {
+			// The seller no longer has the tickets
+			resale.Status = models.TicketResaleCancelled
+		}
+
+		for _, group := range reserveGroups {
+			if resale.Status != models.TicketResaleListed {
+				break
+			}
+
+			buyerTicketRequestID := group[0].TicketRequestID
+			if offeredGroups[buyerTicketRequestID] ||
+				group[0].TicketRequest.TicketTypeID != resale.TicketRequest.TicketTypeID ||
+				len(group) != len(sellerTickets) {
+				continue
+			}
+
+			promoteReserveGroup(tx, ticketRelease, group)
+
+			now := time.Now()
+			resale.Status = models.TicketResaleOffered
+			resale.BuyerTicketRequestID = &buyerTicketRequestID
+			resale.OfferedAt = &now
+
+			offeredGroups[buyerTicketRequestID] = true
+			offeredTicketIDs = append(offeredTicketIDs, int(group[0].ID))
+
+			allocator_logger.WithFields(logrus.Fields{
+				"id": ticketRelease.ID,
+			}).Infof("Offered resold tickets of ticket request with ID %d to ticket request with ID %d", resale.TicketRequestID, buyerTicketRequestID)
+		}
+
+		if err := tx.Omit("TicketRequest").Save(resale).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return offeredGroups, offeredTicketIDs, nil
+}
@@ -97,17 +97,13 @@ func process_artd(db *gorm.DB, ticketRelease *models.TicketRelease) error {
 		}
 	}
 
-	// This is the total number of tickets available for the ticket release
-	var totalTicketsAvailable int = ticketRelease.TicketsAvailable
-
-	allAllocatedTickets, err := models.GetAllTicketsToTicketRelease(tx, ticketRelease.ID)
+	// Remaining tickets of the ticket release and of the ticket types with a capacity
+	capacity, err := allocate_service.LoadTicketCapacity(tx, ticketRelease)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	var numberOfAlreadyAllocatedTickets int = len(allAllocatedTickets)
-
 	ticketRequests, err := models.GetAllValidTicketRequestsToTicketRelease(tx, ticketRelease.ID)
 
 	if err != nil {
@@ -117,26 +113,34 @@ func process_artd(db *gorm.DB, ticketRelease *models.TicketRelease) error {
 
 	for _, ticketRequest := range ticketRequests {
 		// Allocate ticket requests directly
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		if totalTicketsAvailable < numberOfAlreadyAllocatedTickets {
+		if capacity.Remaining <= 0 {
 			artd_logger.WithFields(logrus.Fields{
 				"ticket_release_id": ticketRelease.ID,
 			}).Info("No more tickets available for ticket release")
 			break
 		}
 
-		ticket, err := allocate_service.AllocateTicket(ticketRequest, tx)
+		// A request is only allocated if all of its tickets fit, a smaller request after it may still fit
+		amount := ticketRequest.NumberOfTickets()
+		if !capacity.CanAllocate(ticketRequest.TicketTypeID, amount) {
+			artd_logger.WithFields(logrus.Fields{
+				"ticket_release_id": ticketRelease.ID,
+				"ticket_request_id": ticketRequest.ID,
+				"ticket_type_id":    ticketRequest.TicketTypeID,
+				"number_of_tickets": amount,
+			}).Info("Not enough tickets left for ticket request")
+			continue
+		}
+
+		tickets, err := allocate_service.AllocateTicket(ticketRequest, tx)
 
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 
-		err = Notify_ReservedTicketAllocated(tx, int(ticket.ID), ticket.PaymentDeadline)
+		// The user is notified once for all the tickets in the request
+		err = Notify_ReservedTicketAllocated(tx, int(tickets[0].ID), tickets[0].PaymentDeadline)
 
 		if err != nil {
 			tx.Rollback()
@@ -144,11 +148,12 @@ func process_artd(db *gorm.DB, ticketRelease *models.TicketRelease) error {
 		}
 
 		artd_logger.WithFields(logrus.Fields{
-			"ticket_id":         ticket.ID,
-			"ticket_request_id": ticket.TicketRequestID,
+			"ticket_id":         tickets[0].ID,
+			"ticket_request_id": ticketRequest.ID,
+			"number_of_tickets": len(tickets),
 		}).Info("Allocated ticket directly")
 
-		numberOfAlreadyAllocatedTickets++
+		capacity.Allocate(ticketRequest.TicketTypeID, amount)
 	}
 
 	err = tx.Commit().Error
@@ -23,6 +23,9 @@ type SaleRecord struct {
 	Message     string
 	FileName    string
 	AddonsSales []models.AddOnRecord
+
+	TotalDiscounts float64
+	Discounts      []models.DiscountRecord
 }
 
 func GenerateSalesReportPDF(db *gorm.DB, data *SaleRecord, ticketReleases []models.TicketRelease) error {
@@ -97,13 +100,13 @@ func GenerateSalesReportPDF(db *gorm.DB, data *SaleRecord, ticketReleases []mode
 		// And in your loop:
 		for _, t := range tickets {
 			var transaction models.Transaction
-			if err := db.Where("ticket_id = ?", t.ID).First(&transaction).Error; err != nil {
+			if err := db.Where("ticket_id = ? AND transaction_type <> ?", t.ID, models.TypeRefund).First(&transaction).Error; err != nil {
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					// Check if the ticket is free
 					if t.TicketRequest.TicketType.Price == 0 {
 						group := ticketGroups[t.TicketRequest.TicketType.Name]
 						group.Subtotal += 0
-						group.NumSold += t.TicketRequest.TicketAmount
+						group.NumSold++
 						group.Tickets = append(group.Tickets, t)
 						ticketGroups[t.TicketRequest.TicketType.Name] = group
 					}
@@ -190,6 +193,36 @@ func GenerateSalesReportPDF(db *gorm.DB, data *SaleRecord, ticketReleases []mode
 		addonTotalSales += addOnSale.TotalSales
 	}
 
+	// Discounts section, the sales above are what was paid after the discounts
+	if len(data.Discounts) > 0 {
+		if currentY > 250 {
+			pdf.AddPage()
+			currentY = 20.0 // Reset Y position
+		}
+
+		pdf.SetFont("Arial", "B", 10)
+		pdf.Line(20, currentY, 190, currentY)
+		currentY += lineHt
+
+		pdf.Text(20, currentY, "Discounts")
+		currentY += lineHt * 1.5
+
+		for _, discount := range data.Discounts {
+			if currentY > 250 {
+				pdf.AddPage()
+				currentY = 20.0 // Reset Y position
+			}
+
+			pdf.SetFont("Arial", "", 10)
+			pdf.Text(20, currentY, fmt.Sprintf("Discount Code: %s", discount.Code))
+			currentY += lineHt
+			pdf.Text(20, currentY, fmt.Sprintf("Times Used: %d", discount.Redemptions))
+			currentY += lineHt
+			pdf.Text(20, currentY, fmt.Sprintf("Total Discount: %.2f", discount.TotalDiscount))
+			currentY += lineHt * 2
+		}
+	}
+
 	// Add horizontal line
 	pdf.Line(20, currentY, 190, currentY)
 	currentY += 1
@@ -201,6 +234,10 @@ func GenerateSalesReportPDF(db *gorm.DB, data *SaleRecord, ticketReleases []mode
 	pdf.Text(20, currentY, fmt.Sprintf("Tickets Sold: %d", data.TicketsSold))
 	currentY += lineHt
 	pdf.Text(20, currentY, fmt.Sprintf("Total Sales: %.2f", data.TotalSales))
+	if data.TotalDiscounts > 0 {
+		currentY += lineHt
+		pdf.Text(20, currentY, fmt.Sprintf("Total Discounts: %.2f", data.TotalDiscounts))
+	}
 
 	s3Client, err := aws_service.NewS3Client()
 	if err != nil {
@@ -3,6 +3,7 @@ package jobs
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/url"
 	"os"
@@ -55,7 +56,7 @@ func CreateNotificationInDb(tx *gorm.DB, notification *models.Notification) erro
 	return nil
 }
 
-func connectAsynqClient() *asynq.Client {
+func asynqRedisConnOpt() asynq.RedisClientOpt {
 	// Parse the REDIS_URL
 	redisURL, err := url.Parse(os.Getenv("REDIS_URL"))
 	if err != nil {
@@ -66,36 +67,62 @@ func connectAsynqClient() *asynq.Client {
 	redisHost := redisURL.Host
 	redisPassword, _ := redisURL.User.Password()
 
-	// Create a new Asynq client instance with RedisClientOpt.
-	var client *asynq.Client
-
 	if os.Getenv("ENV") == "dev" {
-		client = asynq.NewClient(asynq.RedisClientOpt{Addr: os.Getenv("REDIS_URL")})
-	} else {
-		client = asynq.NewClient(asynq.RedisClientOpt{Addr: redisHost, Password: redisPassword})
+		return asynq.RedisClientOpt{Addr: os.Getenv("REDIS_URL")}
 	}
 
-	return client
+	return asynq.RedisClientOpt{Addr: redisHost, Password: redisPassword}
+}
+
+func connectAsynqClient() *asynq.Client {
+	// Create a new Asynq client instance with RedisClientOpt.
+	return asynq.NewClient(asynqRedisConnOpt())
+}
+
+// EmailJobOptions are optional settings for an email task
+type EmailJobOptions struct {
+	TaskID      string     // Lets the task be cancelled with CancelEmailJobs
+	ProcessAt   *time.Time // Holds back the email, the task can be cancelled until then
+	Attachments []tasks.EmailAttachment
 }
 
 func AddEmailJobToQueue(db *gorm.DB, user *models.User, subject, content string, eventId *uint) error {
+	return AddEmailJobToQueueWithOptions(db, user, subject, content, eventId, EmailJobOptions{})
+}
+
+func AddEmailJobToQueueWithOptions(db *gorm.DB, user *models.User, subject, content string, eventId *uint, options EmailJobOptions) error {
 	client := connectAsynqClient()
 	defer client.Close()
 
-	payload, err := json.Marshal(tasks.EmailPayload{User: user, Subject: subject, Content: content, EventID: eventId})
+	payload, err := json.Marshal(tasks.EmailPayload{User: user, Subject: subject, Content: content, EventID: eventId, Attachments: options.Attachments})
 	if err != nil {
 		return err
 	}
 
 	// Calculate the schedule time
-	deadline := time.Now().Add(10 * time.Minute)
+	processAt := time.Now()
+	if options.ProcessAt != nil {
+		processAt = *options.ProcessAt
+	}
+	deadline := processAt.Add(10 * time.Minute)
 
-	task := asynq.NewTask(tasks.TypeEmail, payload)
-	info, err := client.Enqueue(task,
+	opts := []asynq.Option{
 		asynq.Queue("email"),
 		asynq.MaxRetry(3),
 		asynq.Deadline(deadline),
-		asynq.Timeout(3*time.Minute))
+		asynq.Timeout(3 * time.Minute),
+	}
+
+	if options.ProcessAt != nil {
+		opts = append(opts, asynq.ProcessAt(processAt))
+	}
+
+	if options.TaskID != "" {
+		opts = append(opts, asynq.TaskID(options.TaskID))
+	}
+
+	task := asynq.NewTask(tasks.TypeEmail, payload)
+	info, err := client.Enqueue(task, opts...)
 
 	if err != nil {
 		return err
@@ -109,6 +136,40 @@ func AddEmailJobToQueue(db *gorm.DB, user *models.User, subject, content string,
 	return err
 }
 
+// CancelEmailJobs deletes the email tasks that have not been processed yet,
+// tasks that have already been sent are skipped. All tasks are attempted,
+// the first error is returned.
+func CancelEmailJobs(taskIDs []string) error {
+	inspector := asynq.NewInspector(asynqRedisConnOpt())
+	defer inspector.Close()
+
+	var firstErr error
+	for _, taskID := range taskIDs {
+		err := inspector.DeleteTask("email", taskID)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			continue
+		}
+
+		if err != nil {
+			notification_logger.WithFields(logrus.Fields{
+				"id":    taskID,
+				"error": err,
+			}).Error("Error cancelling email task")
+
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		notification_logger.WithFields(logrus.Fields{
+			"id": taskID,
+		}).Info("Cancelled email task")
+	}
+
+	return firstErr
+}
+
 func AddReminderEmailJobToQueueAt(db *gorm.DB, user *models.User,
 	subject, content string, reminderId uint, scheduleTime time.Time) error {
 	client := connectAsynqClient()
@@ -177,7 +238,7 @@ func HandleEmailJob(db *gorm.DB) func(ctx context.Context, t *asynq.Task) error
 			return err
 		}
 
-		err = SendEmail(p.User, p.Subject, p.Content, tx)
+		err = SendEmail(p.User, p.Subject, p.Content, tx, p.Attachments...)
 		if err != nil {
 			notification_logger.WithFields(logrus.Fields{
 				"notification": notification,
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/DowLucas/gin-ticket-release/pkg/jobs/tasks"
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"gorm.io/gorm"
 )
@@ -19,6 +20,16 @@ type MailData struct {
 	Subject string `json:"subject"`
 	Content string `json:"content"`
 	ReplyTo string `json:"replyTo"`
+
+	Attachments []MailAttachment `json:"attachments,omitempty"`
+}
+
+// MailAttachment is a file attached to the email, the content is sent base64 encoded
+type MailAttachment struct {
+	OriginalName string `json:"originalname"`
+	MimeType     string `json:"mimetype"`
+	Buffer       []byte `json:"buffer"`
+	Encoding     string `json:"encoding"`
 }
 
 // From is the email address that the emails will be sent from
@@ -76,8 +87,8 @@ func SendContactEmail(name, email_to, from, subject, content string) error {
 	return nil
 }
 
-// SendEmail sends an email to the user
-func SendEmail(user *models.User, subject, content string, db *gorm.DB) error {
+// SendEmail sends an email to the user with the given attachments
+func SendEmail(user *models.User, subject, content string, db *gorm.DB, attachments ...tasks.EmailAttachment) error {
 	// Create the data to be sent
 	var to string
 	if os.Getenv("ENV") == "dev" {
@@ -94,6 +105,15 @@ func SendEmail(user *models.User, subject, content string, db *gorm.DB) error {
 		Content: content,
 	}
 
+	for _, attachment := range attachments {
+		data.Attachments = append(data.Attachments, MailAttachment{
+			OriginalName: attachment.Name,
+			MimeType:     attachment.ContentType,
+			Buffer:       attachment.Content,
+			Encoding:     "base64",
+		})
+	}
+
 	// Marshal the data into a JSON payload
 	payloadBytes, err := json.Marshal(data)
 	if err != nil {
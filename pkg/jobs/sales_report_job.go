@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -80,7 +81,7 @@ func GetCompletedTransactionsByEvent(db *gorm.DB, eventID int) ([]models.Transac
 	// Assuming db is your *gorm.DB connection and Ticket and Transaction models are properly set up
 	err := db.Joins("JOIN transactions ON transactions.ticket_id = tickets.id").
 		Where("transactions.event_id = ? AND transactions.status = ?", eventID, models.TransactionStatusCompleted).
-		Preload("Transaction").
+		Preload("Transaction", "transaction_type <> ?", models.TypeRefund).
 		Find(&tickets).Error
 
 	if err != nil {
@@ -116,6 +117,19 @@ func GetPaymentIntentsByEvent(db *gorm.DB, eventID int) ([]*stripe.PaymentIntent
 	wg.Add(len(transactions))
 
 	for _, transaction := range transactions {
+		if transaction.Provider() != models.STRIPE || transaction.OrderID != nil {
+			// Only Stripe has payment intents, and the payment of an order pays for tickets of
+			// several ticket requests, so the payment is described from what is stored
+			pi, err := describeStoredPayment(db, transaction)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			paymentIntents = append(paymentIntents, pi)
+			wg.Done()
+			continue
+		}
+
 		go func(transaction models.Transaction) {
 			defer wg.Done()
 
@@ -151,6 +165,27 @@ func GetPaymentIntentsByEvent(db *gorm.DB, eventID int) ([]*stripe.PaymentIntent
 	return paymentIntents, transactions, nil
 }
 
+// describeStoredPayment describes the purchase from what is stored the way the sales report
+// reads payment intents
+func describeStoredPayment(db *gorm.DB, transaction models.Transaction) (*stripe.PaymentIntent, error) {
+	var ticket models.Ticket
+	if err := db.Unscoped().Where("id = ?", transaction.TicketID).First(&ticket).Error; err != nil {
+		return nil, err
+	}
+
+	var ticketsSold int64
+	if err := db.Model(&models.Ticket{}).Where("ticket_request_id = ?", ticket.TicketRequestID).Count(&ticketsSold).Error; err != nil {
+		return nil, err
+	}
+
+	return &stripe.PaymentIntent{
+		ID:       transaction.PaymentIntentID,
+		Amount:   int64(transaction.Amount),
+		Currency: transaction.Currency,
+		Metadata: map[string]string{"tessera_ticket_amount": strconv.Itoa(int(ticketsSold))},
+	}, nil
+}
+
 func GetFreeTicketsByEvent(db *gorm.DB, eventID int) ([]models.Ticket, error) {
 	var tickets []models.Ticket
 
@@ -225,6 +260,11 @@ func GenerateReportForEvent(eventID int, db *gorm.DB) (*models.EventSalesReport,
 		updatedAddOnsSales = append(updatedAddOnsSales, *addOn)
 	}
 
+	discounts, totalDiscounts, err := getDiscountsSales(db, transactions)
+	if err != nil {
+		return nil, err
+	}
+
 	randomUUID := uuid.New()
 	fileName := fmt.Sprintf("sales_report-%d-%s.pdf", eventID, randomUUID.String())
 
@@ -237,12 +277,20 @@ func GenerateReportForEvent(eventID int, db *gorm.DB) (*models.EventSalesReport,
 		Transactions: transactions,
 		FileName:     fileName,
 		AddOnsSales:  updatedAddOnsSales,
+
+		TotalDiscounts: totalDiscounts,
+		Discounts:      discounts,
 	}
 
 	for _, pi := range paymentIntents {
 		// Sum total sales and count tickets
 		report.TotalSales += float64(pi.Amount)
-		report.TicketsSold += 1 // TODO: Should be changed when we support multiple tickets per payment intent
+		// A payment intent pays for all the tickets of a ticket request
+		ticketsSold, err := strconv.Atoi(pi.Metadata["tessera_ticket_amount"])
+		if err != nil || ticketsSold < 1 {
+			ticketsSold = 1
+		}
+		report.TicketsSold += ticketsSold
 	}
 
 	report.TicketsSold += len(freeTickets)
@@ -255,6 +303,43 @@ func GenerateReportForEvent(eventID int, db *gorm.DB) (*models.EventSalesReport,
 	return report, nil
 }
 
+// getDiscountsSales sums the discounts of the purchases for each discount code, in SEK
+func getDiscountsSales(db *gorm.DB, transactions []models.Transaction) ([]models.DiscountRecord, float64, error) {
+	records := make(map[uint]*models.DiscountRecord)
+	var ids []uint
+	var totalDiscounts float64
+
+	for _, transaction := range transactions {
+		if transaction.DiscountCodeID == nil || transaction.TransactionType != models.TypePurchase {
+			continue
+		}
+
+		id := *transaction.DiscountCodeID
+		record, ok := records[id]
+		if !ok {
+			var discountCode models.DiscountCode
+			if err := db.Unscoped().Where("id = ?", id).First(&discountCode).Error; err != nil {
+				return nil, 0, err
+			}
+
+			record = &models.DiscountRecord{ID: id, Code: discountCode.Code}
+			records[id] = record
+			ids = append(ids, id)
+		}
+
+		record.Redemptions++
+		record.TotalDiscount += float64(transaction.DiscountAmount) / 100
+		totalDiscounts += float64(transaction.DiscountAmount) / 100
+	}
+
+	var discounts []models.DiscountRecord
+	for _, id := range ids {
+		discounts = append(discounts, *records[id])
+	}
+
+	return discounts, totalDiscounts, nil
+}
+
 func SaveReport(db *gorm.DB, report *models.EventSalesReport) error {
 	if err := db.Create(&report).Error; err != nil {
 		return err
@@ -311,6 +396,9 @@ func HandleSalesReportJob(db *gorm.DB) func(ctx context.Context, t *asynq.Task)
 			Message:     msg,
 			FileName:    report.FileName,
 			AddonsSales: report.AddOnsSales,
+
+			TotalDiscounts: report.TotalDiscounts,
+			Discounts:      report.Discounts,
 		}
 
 		trs, err := models.GetTicketReleasesToEvent(db, uint(p.EventID))
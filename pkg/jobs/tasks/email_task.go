@@ -13,10 +13,18 @@ const (
 
 // Define task payloads.
 type EmailPayload struct {
-	User    *models.User
-	Subject string
-	Content string
-	EventID *uint
+	User        *models.User
+	Subject     string
+	Content     string
+	EventID     *uint
+	Attachments []EmailAttachment
+}
+
+// EmailAttachment is a file attached to an email
+type EmailAttachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
 }
 
 type SendOutEmailPayload struct {
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc returns the key of the bucket that a request is counted in, false if the request has no such key
+type KeyFunc func(c *gin.Context) (string, bool)
+
+// KeyByUser counts the requests of each user
+func KeyByUser(c *gin.Context) (string, bool) {
+	userID := c.GetString("ugkthid")
+	return "user:" + userID, userID != ""
+}
+
+// KeyByIP counts the requests from each IP address
+func KeyByIP(c *gin.Context) (string, bool) {
+	return "ip:" + c.ClientIP(), true
+}
+
+// KeyByEvent counts the requests to each event, e.g. all ticket requests during a big release
+func KeyByEvent(c *gin.Context) (string, bool) {
+	eventID := c.Param("eventID")
+	return "event:" + eventID, eventID != ""
+}
+
+// RateLimiterMiddleware limits the rate of the requests of a route group
+type RateLimiterMiddleware struct {
+	limiter ratelimit.Limiter
+	group   string
+	limit   ratelimit.Limit
+	key     KeyFunc
+}
+
+// NewRateLimiterMiddleware limits the requests of the group with the limit, which can be overridden
+// with RATE_LIMIT_<GROUP>. The requests are counted per key, the groups do not share buckets.
+func NewRateLimiterMiddleware(limiter ratelimit.Limiter, group string, limit ratelimit.Limit, key KeyFunc) *RateLimiterMiddleware {
+	limit, err := ratelimit.LimitFromEnv(group, limit)
+	if err != nil {
+		panic("Invalid rate limit: " + err.Error())
+	}
+
+	return &RateLimiterMiddleware{
+		limiter: limiter,
+		group:   group,
+		limit:   limit,
+		key:     key,
+	}
+}
+
+// MiddlewareFunc returns the Gin middleware function
+func (m *RateLimiterMiddleware) MiddlewareFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := m.key(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Missing rate limit key"})
+			return
+		}
+
+		result, err := m.limiter.Allow(c.Request.Context(), m.group+":"+key, m.limit)
+		if err != nil {
+			// The requests are let through rather than failing because the rate limit store is down
+			log.Printf("Rate limiter %s failed: %v", m.group, err)
+			c.Next()
+			return
+		}
+
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
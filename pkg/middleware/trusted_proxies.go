@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetTrustedProxies decides which client IP c.ClientIP() returns, which the rate limits and the
+// promo code lockouts count requests by. X-Forwarded-For is only read from the proxies in
+// TRUSTED_PROXIES, a comma separated list of IP addresses and CIDRs. TRUSTED_PLATFORM takes the IP
+// from the header set by the platform instead, "cloudflare", "google-app-engine" or a header name.
+// Without either the IP address of the connection is used, since anyone can set the headers.
+func SetTrustedProxies(r *gin.Engine) error {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return err
+	}
+
+	switch platform := strings.TrimSpace(os.Getenv("TRUSTED_PLATFORM")); strings.ToLower(platform) {
+	case "":
+	case "cloudflare":
+		r.TrustedPlatform = gin.PlatformCloudflare
+	case "google-app-engine":
+		r.TrustedPlatform = gin.PlatformGoogleAppEngine
+	default:
+		r.TrustedPlatform = platform
+	}
+
+	return nil
+}
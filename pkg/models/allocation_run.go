@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AllocationRun records the tickets created when the tickets of a ticket release
+// were allocated. Until UndoDeadline has passed the run can be reverted, the emails
+// to the users are held back until then so that they can be cancelled.
+type AllocationRun struct {
+	gorm.Model
+	TicketReleaseID   uint                  `gorm:"index" json:"ticket_release_id"`
+	TicketRelease     TicketRelease         `json:"-"`
+	PaymentDeadlineID *uint                 `json:"payment_deadline_id"`
+	PreviousClose     int64                 `json:"previous_close"` // Close of the ticket release before it was closed by the allocation
+	UndoDeadline      time.Time             `json:"undo_deadline"`
+	RevertedAt        *time.Time            `json:"reverted_at"`
+	RevertedBy        *string               `json:"reverted_by"`
+	Tickets           []AllocationRunTicket `json:"tickets"`
+}
+
+type AllocationRunTicket struct {
+	gorm.Model
+	AllocationRunID uint   `gorm:"index" json:"allocation_run_id"`
+	TicketID        uint   `gorm:"index" json:"ticket_id"`
+	Ticket          Ticket `json:"-"`
+}
+
+func (ar *AllocationRun) IsReverted() bool {
+	return ar.RevertedAt != nil
+}
+
+// CanUndo returns true if the run has not been reverted and the grace period has not passed
+func (ar *AllocationRun) CanUndo(now time.Time) bool {
+	return !ar.IsReverted() && now.Before(ar.UndoDeadline)
+}
+
+// EmailTaskID is the ID of the email task queued for the ticket, the ID is used to cancel the task
+func (ar *AllocationRun) EmailTaskID(ticketID uint) string {
+	return fmt.Sprintf("allocation-run:%d:ticket:%d", ar.ID, ticketID)
+}
+
+func (ar *AllocationRun) TicketIDs() []uint {
+	ids := make([]uint, len(ar.Tickets))
+	for i, ticket := range ar.Tickets {
+		ids[i] = ticket.TicketID
+	}
+
+	return ids
+}
+
+func GetAllocationRunsForTicketRelease(db *gorm.DB, ticketReleaseID uint) ([]AllocationRun, error) {
+	var runs []AllocationRun
+	if err := db.Preload("Tickets").Where("ticket_release_id = ?", ticketReleaseID).Order("created_at DESC").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CheckInAction string
+
+const (
+	CheckInActionCheckedIn  CheckInAction = "checked_in"  // The ticket was checked in
+	CheckInActionRepeatScan CheckInAction = "repeat_scan" // The ticket had already been checked in
+	CheckInActionRejected   CheckInAction = "rejected"    // The ticket is not valid for entry
+	CheckInActionUndone     CheckInAction = "undone"      // An organizer undid the check-in of the ticket
+)
+
+// CheckInLog is the audit trail of the scans and check-ins of the tickets to an event
+type CheckInLog struct {
+	gorm.Model
+	EventID          uint          `gorm:"index" json:"event_id"`
+	TicketID         uint          `gorm:"index" json:"ticket_id"`
+	OrganizerUGKthID string        `json:"organizer_ug_kth_id"`
+	Organizer        User          `gorm:"foreignKey:OrganizerUGKthID;references:UGKthID" json:"organizer"`
+	Gate             string        `json:"gate"` // The gate or device that scanned the ticket
+	Action           CheckInAction `json:"action"`
+	Reason           string        `json:"reason,omitempty"` // Why the ticket was rejected
+	Offline          bool          `json:"offline"`          // The scan was uploaded after it was made offline
+	ScannedAt        time.Time     `json:"scanned_at"`
+}
+
+// TicketTypeCheckIns is how many of the tickets of a ticket type have been checked in
+type TicketTypeCheckIns struct {
+	TicketTypeID uint   `json:"ticket_type_id"`
+	Name         string `json:"name"`
+	CheckedIn    int64  `json:"checked_in"`
+	Total        int64  `json:"total"`
+}
+
+func GetCheckInLogs(db *gorm.DB, eventID uint, ticketID uint, limit int) (logs []CheckInLog, err error) {
+	query := db.Preload("Organizer").Where("event_id = ?", eventID)
+	if ticketID != 0 {
+		query = query.Where("ticket_id = ?", ticketID)
+	}
+
+	err = query.Order("scanned_at DESC, id DESC").Limit(limit).Find(&logs).Error
+	return
+}
+
+// GetTicketTypeCheckIns counts the checked in tickets of every ticket type of the event, reserve and
+// refunded tickets are not counted
+func GetTicketTypeCheckIns(db *gorm.DB, eventID uint) (checkIns []TicketTypeCheckIns, err error) {
+	err = db.Table("tickets").
+		Select("ticket_types.id AS ticket_type_id, ticket_types.name AS name, "+
+			"SUM(CASE WHEN tickets.checked_in THEN 1 ELSE 0 END) AS checked_in, COUNT(tickets.id) AS total").
+		Joins("JOIN ticket_requests ON ticket_requests.id = tickets.ticket_request_id").
+		Joins("JOIN ticket_types ON ticket_types.id = ticket_requests.ticket_type_id").
+		Joins("JOIN ticket_releases ON ticket_releases.id = ticket_requests.ticket_release_id").
+		Where("ticket_releases.event_id = ? AND tickets.deleted_at IS NULL AND tickets.is_reserve = ? AND tickets.refunded = ?", eventID, false, false).
+		Group("ticket_types.id, ticket_types.name").
+		Order("ticket_types.id").
+		Scan(&checkIns).Error
+	return
+}
+
+// CountCheckInsSince counts the tickets of the event that were checked in after since
+func CountCheckInsSince(db *gorm.DB, eventID uint, since time.Time) (count int64, err error) {
+	err = db.Model(&CheckInLog{}).
+		Where("event_id = ? AND action = ? AND scanned_at >= ?", eventID, CheckInActionCheckedIn, since).
+		Count(&count).Error
+	return
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DiscountType string
+
+const (
+	DiscountFixed   DiscountType = "fixed"   // Amount is taken off the price of each ticket, in SEK
+	DiscountPercent DiscountType = "percent" // Amount is the percentage taken off the price of each ticket
+)
+
+// DiscountCode reduces the price of the tickets of an event. The code can be limited to a ticket
+// release or a ticket type, to a number of redemptions and to a period of time. A code is redeemed
+// by the purchases paid with it, add-ons are never discounted.
+type DiscountCode struct {
+	gorm.Model
+	EventID               int          `gorm:"index" json:"event_id"`
+	TicketReleaseID       *uint        `json:"ticket_release_id"` // nil if the code applies to all ticket releases of the event
+	TicketTypeID          *uint        `json:"ticket_type_id"`    // nil if the code applies to all ticket types
+	Code                  string       `gorm:"index" json:"code"` // Stored in upper case, codes are not case sensitive
+	DiscountType          DiscountType `json:"discount_type"`
+	Amount                float64      `json:"amount"`
+	MaxRedemptions        *int         `json:"max_redemptions"`          // nil if the code can be used any number of times
+	MaxRedemptionsPerUser *int         `json:"max_redemptions_per_user"` // nil if a user can use the code any number of times
+	ValidFrom             *time.Time   `json:"valid_from"`
+	ValidUntil            *time.Time   `json:"valid_until"`
+
+	Redemptions int `gorm:"-" json:"redemptions"`
+}
+
+func NormalizeDiscountCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (dc *DiscountCode) Validate() error {
+	if dc.Code == "" {
+		return errors.New("discount code is required")
+	}
+
+	switch dc.DiscountType {
+	case DiscountFixed:
+		if dc.Amount <= 0 {
+			return errors.New("discount amount must be positive")
+		}
+	case DiscountPercent:
+		if dc.Amount <= 0 || dc.Amount > 100 {
+			return errors.New("discount percentage must be between 0 and 100")
+		}
+	default:
+		return errors.New("invalid discount type")
+	}
+
+	if dc.MaxRedemptions != nil && *dc.MaxRedemptions < 1 {
+		return errors.New("max redemptions must be at least 1")
+	}
+
+	if dc.MaxRedemptionsPerUser != nil && *dc.MaxRedemptionsPerUser < 1 {
+		return errors.New("max redemptions per user must be at least 1")
+	}
+
+	if dc.ValidFrom != nil && dc.ValidUntil != nil && dc.ValidUntil.Before(*dc.ValidFrom) {
+		return errors.New("valid until must be after valid from")
+	}
+
+	return nil
+}
+
+// AppliesTo returns whether the code can be used for tickets of the ticket type in the ticket release
+func (dc *DiscountCode) AppliesTo(ticketReleaseID uint, ticketTypeID uint) bool {
+	if dc.TicketReleaseID != nil && *dc.TicketReleaseID != ticketReleaseID {
+		return false
+	}
+
+	if dc.TicketTypeID != nil && *dc.TicketTypeID != ticketTypeID {
+		return false
+	}
+
+	return true
+}
+
+// TicketDiscount returns the reduction in öre of the price of one ticket, never more than the price
+func (dc *DiscountCode) TicketDiscount(price int) int {
+	var discount int
+	switch dc.DiscountType {
+	case DiscountFixed:
+		discount = int(math.Round(dc.Amount * 100))
+	case DiscountPercent:
+		discount = int(math.Round(float64(price) * dc.Amount / 100))
+	}
+
+	if discount > price {
+		return price
+	}
+
+	return discount
+}
+
+// redemptions counts the purchases paid with the discount code. Unfinished purchases of tickets
+// that can still be paid for are counted so that a code can not be used more times than allowed.
+func (dc *DiscountCode) redemptions(db *gorm.DB) *gorm.DB {
+	return db.Model(&Transaction{}).
+		Joins("LEFT JOIN tickets ON tickets.id = transactions.ticket_id AND tickets.deleted_at IS NULL").
+		Where("transactions.discount_code_id = ? AND transactions.transaction_type = ?", dc.ID, TypePurchase).
+		Where("(transactions.status = ? AND transactions.refunded = ?) OR (transactions.status = ? AND tickets.id IS NOT NULL)",
+			TransactionStatusCompleted, false, TransactionStatusPending)
+}
+
+// CountRedemptions returns how many times the code has been used, ignoring the purchase of the
+// ticket that is being paid for again
+func (dc *DiscountCode) CountRedemptions(db *gorm.DB, excludeTicketID int) (int64, error) {
+	var count int64
+	err := dc.redemptions(db).Where("transactions.ticket_id <> ?", excludeTicketID).Count(&count).Error
+	return count, err
+}
+
+// CountUserRedemptions returns how many times the user has used the code
+func (dc *DiscountCode) CountUserRedemptions(db *gorm.DB, ugKthID string, excludeTicketID int) (int64, error) {
+	var count int64
+	err := dc.redemptions(db).
+		Where("transactions.user_ug_kth_id = ? AND transactions.ticket_id <> ?", ugKthID, excludeTicketID).
+		Count(&count).Error
+	return count, err
+}
+
+// GetDiscountCode returns the discount code of the event, nil if there is no such code
+func GetDiscountCode(db *gorm.DB, eventID int, code string) (*DiscountCode, error) {
+	var discountCodes []DiscountCode
+	if err := db.Where("event_id = ? AND code = ?", eventID, NormalizeDiscountCode(code)).
+		Limit(1).Find(&discountCodes).Error; err != nil {
+		return nil, err
+	}
+
+	if len(discountCodes) == 0 {
+		return nil, nil
+	}
+
+	return &discountCodes[0], nil
+}
+
+// GetDiscountCodesToEvent returns the discount codes of the event with the number of times they have been used
+func GetDiscountCodesToEvent(db *gorm.DB, eventID int) ([]DiscountCode, error) {
+	var discountCodes []DiscountCode
+	if err := db.Where("event_id = ?", eventID).Order("id ASC").Find(&discountCodes).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range discountCodes {
+		count, err := discountCodes[i].CountRedemptions(db, 0)
+		if err != nil {
+			return nil, err
+		}
+		discountCodes[i].Redemptions = int(count)
+	}
+
+	return discountCodes, nil
+}
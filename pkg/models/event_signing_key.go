@@ -0,0 +1,27 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// EventSigningKey is the Ed25519 key pair that signs the QR codes of the tickets to an event. The
+// private key is encrypted with the secret key, the public key is given to the scanners.
+type EventSigningKey struct {
+	gorm.Model
+	EventID             uint   `gorm:"uniqueIndex" json:"event_id"`
+	PublicKey           string `json:"public_key"` // Base64 encoded
+	EncryptedPrivateKey string `json:"-"`
+}
+
+func GetEventSigningKey(db *gorm.DB, eventID uint) (*EventSigningKey, error) {
+	var keys []EventSigningKey
+	if err := db.Where("event_id = ?", eventID).Limit(1).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return &keys[0], nil
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// LotteryDraw records how the lottery of a FCFS lottery ticket release was drawn.
+// The eligible ticket requests are sorted by ID and shuffled using Seed, anyone
+// can reproduce the draw from the seed and the eligible ticket request IDs.
+type LotteryDraw struct {
+	gorm.Model
+	TicketReleaseID      uint          `gorm:"index" json:"ticket_release_id"`
+	TicketRelease        TicketRelease `json:"-"`
+	Seed                 int64         `json:"seed"`
+	RequestIDsHash       string        `json:"request_ids_hash"`                      // SHA-256 of the sorted eligible ticket request IDs
+	EligibleRequestIDs   string        `gorm:"type:text" json:"eligible_request_ids"` // JSON encoded, sorted by ID
+	WinnerRequestIDs     string        `gorm:"type:text" json:"winner_request_ids"`   // JSON encoded, in draw order
+	ReserveRequestIDs    string        `gorm:"type:text" json:"reserve_request_ids"`  // JSON encoded, in reserve number order
+	AvailableTickets     int           `json:"available_tickets"`
+	NumberOfParticipants int           `json:"number_of_participants"`
+	PriorityMode         string        `json:"priority_mode"`
+	RequestWeights       string        `gorm:"type:text" json:"request_weights"`         // JSON encoded, same order as EligibleRequestIDs
+	RequestTicketTypeIDs string        `gorm:"type:text" json:"request_ticket_type_ids"` // JSON encoded, same order as EligibleRequestIDs
+	TicketTypeCapacities string        `gorm:"type:text" json:"ticket_type_capacities"`  // JSON encoded, remaining tickets of the ticket types with a capacity
+	RequestTicketAmounts string        `gorm:"type:text" json:"request_ticket_amounts"`  // JSON encoded, same order as EligibleRequestIDs
+}
+
+// HashTicketRequestIDs returns the hex encoded SHA-256 hash of the ticket request IDs
+// joined by commas, the IDs are hashed in the order they are given
+func HashTicketRequestIDs(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// SortTicketRequestIDs returns a sorted copy of the ticket request IDs
+func SortTicketRequestIDs(ids []uint) []uint {
+	sorted := make([]uint, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+func encodeRequestIDs(ids []uint) string {
+	if ids == nil {
+		ids = []uint{}
+	}
+
+	encoded, _ := json.Marshal(ids)
+	return string(encoded)
+}
+
+func decodeRequestIDs(encoded string) ([]uint, error) {
+	var ids []uint
+	if encoded == "" {
+		return ids, nil
+	}
+
+	if err := json.Unmarshal([]byte(encoded), &ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// NewLotteryDraw creates a draw for the eligible ticket requests, the IDs are sorted before they are hashed
+func NewLotteryDraw(ticketReleaseID uint, seed int64, eligibleRequestIDs []uint, availableTickets int) *LotteryDraw {
+	sorted := SortTicketRequestIDs(eligibleRequestIDs)
+
+	return &LotteryDraw{
+		TicketReleaseID:      ticketReleaseID,
+		Seed:                 seed,
+		RequestIDsHash:       HashTicketRequestIDs(sorted),
+		EligibleRequestIDs:   encodeRequestIDs(sorted),
+		AvailableTickets:     availableTickets,
+		NumberOfParticipants: len(sorted),
+	}
+}
+
+func (ld *LotteryDraw) GetEligibleRequestIDs() ([]uint, error) {
+	return decodeRequestIDs(ld.EligibleRequestIDs)
+}
+
+func (ld *LotteryDraw) GetWinnerRequestIDs() ([]uint, error) {
+	return decodeRequestIDs(ld.WinnerRequestIDs)
+}
+
+func (ld *LotteryDraw) GetReserveRequestIDs() ([]uint, error) {
+	return decodeRequestIDs(ld.ReserveRequestIDs)
+}
+
+func (ld *LotteryDraw) GetRequestWeights() ([]int, error) {
+	var weights []int
+	if ld.RequestWeights == "" {
+		return weights, nil
+	}
+
+	if err := json.Unmarshal([]byte(ld.RequestWeights), &weights); err != nil {
+		return nil, err
+	}
+
+	return weights, nil
+}
+
+// SetPriority stores the priority mode and the weights of the eligible ticket
+// requests, weights must be in the same order as the sorted eligible IDs
+func (ld *LotteryDraw) SetPriority(mode string, weights []int) {
+	ld.PriorityMode = mode
+	if weights == nil {
+		weights = []int{}
+	}
+
+	encoded, _ := json.Marshal(weights)
+	ld.RequestWeights = string(encoded)
+}
+
+// SetTicketTypes stores the ticket type of every eligible ticket request, in the same order as
+// the sorted eligible IDs, and the remaining tickets of the ticket types that have a capacity
+func (ld *LotteryDraw) SetTicketTypes(requestTicketTypeIDs []uint, capacities map[uint]int) {
+	ld.RequestTicketTypeIDs = encodeRequestIDs(requestTicketTypeIDs)
+
+	if capacities == nil {
+		capacities = map[uint]int{}
+	}
+
+	encoded, _ := json.Marshal(capacities)
+	ld.TicketTypeCapacities = string(encoded)
+}
+
+func (ld *LotteryDraw) GetRequestTicketTypeIDs() ([]uint, error) {
+	return decodeRequestIDs(ld.RequestTicketTypeIDs)
+}
+
+func (ld *LotteryDraw) GetTicketTypeCapacities() (map[uint]int, error) {
+	capacities := map[uint]int{}
+	if ld.TicketTypeCapacities == "" {
+		return capacities, nil
+	}
+
+	if err := json.Unmarshal([]byte(ld.TicketTypeCapacities), &capacities); err != nil {
+		return nil, err
+	}
+
+	return capacities, nil
+}
+
+// SetTicketAmounts stores the number of tickets of every eligible ticket request,
+// in the same order as the sorted eligible IDs
+func (ld *LotteryDraw) SetTicketAmounts(amounts []int) {
+	if amounts == nil {
+		amounts = []int{}
+	}
+
+	encoded, _ := json.Marshal(amounts)
+	ld.RequestTicketAmounts = string(encoded)
+}
+
+func (ld *LotteryDraw) GetRequestTicketAmounts() ([]int, error) {
+	var amounts []int
+	if ld.RequestTicketAmounts == "" {
+		return amounts, nil
+	}
+
+	if err := json.Unmarshal([]byte(ld.RequestTicketAmounts), &amounts); err != nil {
+		return nil, err
+	}
+
+	return amounts, nil
+}
+
+// SetResult stores the outcome of the draw
+func (ld *LotteryDraw) SetResult(winners, reserves []uint) {
+	ld.WinnerRequestIDs = encodeRequestIDs(winners)
+	ld.ReserveRequestIDs = encodeRequestIDs(reserves)
+}
+
+func GetLotteryDrawForTicketRelease(db *gorm.DB, ticketReleaseID uint) (*LotteryDraw, error) {
+	var draw LotteryDraw
+	if err := db.Where("ticket_release_id = ?", ticketReleaseID).Order("created_at DESC").First(&draw).Error; err != nil {
+		return nil, err
+	}
+
+	return &draw, nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusPending  OrderStatus = "pending"  // Waiting for the payment
+	OrderStatusPaid     OrderStatus = "paid"     // All the tickets of the order have been paid for
+	OrderStatusCanceled OrderStatus = "canceled" // The payment was canceled or replaced by another payment
+)
+
+// Order is a checkout of several unpaid tickets of a user that are paid with one payment.
+// Each item is the tickets of a ticket request, which are paid for through the first ticket
+// of the request, and gets its own purchase transaction with the payment of the order.
+type Order struct {
+	gorm.Model
+	UserUGKthID     string              `gorm:"index" json:"user_ug_kth_id"`
+	User            User                `json:"-"`
+	Status          OrderStatus         `json:"status"`
+	Amount          int                 `json:"amount"` // In öre
+	Currency        string              `json:"currency"`
+	PaymentIntentID string              `gorm:"index" json:"payment_intent_id"` // The payment at the payment provider
+	PaymentProvider PaymentProviderName `json:"payment_provider"`
+	DiscountCode    string              `json:"discount_code"`   // The discount code applied to the items it is valid for
+	DiscountAmount  int                 `json:"discount_amount"` // In öre, Amount is what is paid
+	PaidAt          *time.Time          `json:"paid_at"`
+	Items           []OrderItem         `json:"items"`
+}
+
+type OrderItem struct {
+	gorm.Model
+	OrderID         uint   `gorm:"index" json:"order_id"`
+	TicketID        uint   `json:"ticket_id"` // The first ticket of the ticket request
+	Ticket          Ticket `json:"ticket"`
+	TicketRequestID uint   `json:"ticket_request_id"`
+	EventID         int    `json:"event_id"`
+	TicketAmount    int    `json:"ticket_amount"`
+	Amount          int    `json:"amount"` // What is paid for the tickets and their add-ons, in öre
+	DiscountCodeID  *uint  `json:"discount_code_id"`
+	DiscountAmount  int    `json:"discount_amount"`
+}
+
+// GetOrderByPaymentIntentID returns the order paid with the payment, nil if the payment is not for an order
+func GetOrderByPaymentIntentID(db *gorm.DB, paymentIntentID string) (*Order, error) {
+	var orders []Order
+	if err := db.Preload("Items").Where("payment_intent_id = ?", paymentIntentID).Limit(1).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	return &orders[0], nil
+}
+
+// GetOrdersForUser returns the orders of the user, newest first
+func GetOrdersForUser(db *gorm.DB, ugKthID string) ([]Order, error) {
+	var orders []Order
+	if err := db.
+		Preload("Items.Ticket.TicketRequest.TicketType").
+		Preload("Items.Ticket.TicketRequest.TicketRelease.Event").
+		Where("user_ug_kth_id = ?", ugKthID).
+		Order("created_at DESC").
+		Find(&orders).Error; err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
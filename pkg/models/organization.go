@@ -16,6 +16,7 @@ type Organization struct {
 	Users                 []User                 `gorm:"many2many:organization_users;" json:"users"`
 	OrganizationUserRoles []OrganizationUserRole `gorm:"foreignKey:OrganizationID" json:"organization_user_roles"`
 	BankingDetail         BankingDetail          `json:"banking_detail" gorm:"foreignKey:OrganizationID"`
+	PaymentProvider       PaymentProviderName    `json:"payment_provider"` // Used by ticket releases that have not chosen a provider
 }
 
 func CreateOrganizationUniqueIndex(db *gorm.DB) error {
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// PaymentProviderName is the payment provider used to pay for tickets
+type PaymentProviderName string
+
+const (
+	STRIPE PaymentProviderName = "stripe"
+	SWISH  PaymentProviderName = "swish"
+)
+
+// DefaultPaymentProvider is used when neither the ticket release nor the organization has chosen a provider
+const DefaultPaymentProvider = STRIPE
+
+// ValidatePaymentProvider accepts an empty name, which means that the provider is inherited
+func ValidatePaymentProvider(name PaymentProviderName) error {
+	switch name {
+	case "", STRIPE, SWISH:
+		return nil
+	default:
+		return fmt.Errorf("invalid payment provider: %s", name)
+	}
+}
+
+// GetPaymentProviderForTicketRelease returns the provider chosen for the ticket release,
+// otherwise the provider chosen by the organization hosting the event
+func GetPaymentProviderForTicketRelease(db *gorm.DB, ticketRelease *TicketRelease) (PaymentProviderName, error) {
+	if ticketRelease.PaymentProvider != "" {
+		return ticketRelease.PaymentProvider, nil
+	}
+
+	var organization Organization
+	if err := db.Joins("JOIN events ON events.organization_id = organizations.id").
+		Where("events.id = ?", ticketRelease.EventID).
+		First(&organization).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return DefaultPaymentProvider, nil
+		}
+		return "", err
+	}
+
+	if organization.PaymentProvider != "" {
+		return organization.PaymentProvider, nil
+	}
+
+	return DefaultPaymentProvider, nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type PromoCodeAttemptResult string
+
+const (
+	PromoCodeAttemptInvalid PromoCodeAttemptResult = "invalid" // The code did not match any promo code of the event
+	PromoCodeAttemptLocked  PromoCodeAttemptResult = "locked"  // The user or IP address was locked out after too many invalid codes
+)
+
+// PromoCodeAttempt is the audit log of failed promo code activations
+type PromoCodeAttempt struct {
+	gorm.Model
+	EventID     int                    `gorm:"index" json:"event_id"`
+	UserUGKthID string                 `gorm:"index" json:"user_ug_kth_id"`
+	IPAddress   string                 `json:"ip_address"`
+	Result      PromoCodeAttemptResult `json:"result"`
+}
+
+// PromoCodeAttemptStats summarizes the failed promo code activations of an event for the organizers
+type PromoCodeAttemptStats struct {
+	FailedAttempts    int64              `json:"failed_attempts"`
+	LockedOutAttempts int64              `json:"locked_out_attempts"`
+	Users             int64              `json:"users"`
+	IPAddresses       int64              `json:"ip_addresses"`
+	RecentAttempts    []PromoCodeAttempt `json:"recent_attempts"`
+}
+
+func GetPromoCodeAttemptStats(db *gorm.DB, eventID int) (*PromoCodeAttemptStats, error) {
+	var stats PromoCodeAttemptStats
+	attempts := func() *gorm.DB {
+		return db.Model(&PromoCodeAttempt{}).Where("event_id = ?", eventID)
+	}
+
+	if err := attempts().Where("result = ?", PromoCodeAttemptInvalid).Count(&stats.FailedAttempts).Error; err != nil {
+		return nil, err
+	}
+
+	if err := attempts().Where("result = ?", PromoCodeAttemptLocked).Count(&stats.LockedOutAttempts).Error; err != nil {
+		return nil, err
+	}
+
+	if err := attempts().Distinct("user_ug_kth_id").Count(&stats.Users).Error; err != nil {
+		return nil, err
+	}
+
+	if err := attempts().Distinct("ip_address").Count(&stats.IPAddresses).Error; err != nil {
+		return nil, err
+	}
+
+	if err := attempts().Order("created_at DESC").Limit(50).Find(&stats.RecentAttempts).Error; err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
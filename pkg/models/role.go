@@ -37,3 +37,10 @@ func InitializeRoles(db *gorm.DB) error {
 	}
 	return nil
 }
+
+func GetUsersWithRole(db *gorm.DB, name string) (users []User, err error) {
+	err = db.Joins("JOIN roles ON roles.id = users.role_id").
+		Where("roles.name = ?", name).
+		Find(&users).Error
+	return users, err
+}
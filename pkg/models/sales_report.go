@@ -22,6 +22,14 @@ type AddOnRecord struct {
 	ContainsAlcohol bool    `json:"contains_alcohol"`
 }
 
+// DiscountRecord sums what a discount code took off the price of the tickets sold
+type DiscountRecord struct {
+	ID            uint    `json:"id"`
+	Code          string  `json:"code"`
+	Redemptions   int     `json:"redemptions"`
+	TotalDiscount float64 `json:"total_discount"`
+}
+
 type EventSalesReport struct {
 	gorm.Model
 	EventID      int               `json:"event_id"`
@@ -32,6 +40,8 @@ type EventSalesReport struct {
 	Transactions []Transaction     `gorm:"many2many:event_sales_report_transactions;" json:"transactions"`
 	FileName     string            `json:"file_name"`
 	AddOnsSales  []AddOnRecord     `gorm:"-" json:"add_ons_sales"`
+	TotalDiscounts float64          `json:"total_discounts"` // TotalSales is what was paid after the discounts
+	Discounts      []DiscountRecord `gorm:"-" json:"discounts"`
 
 	URL string `gorm:"-" json:"url"` // This field will not be stored in the database
 }
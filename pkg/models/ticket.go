@@ -26,6 +26,7 @@ type Ticket struct {
 	WasReserve      bool          `json:"was_reserve" default:"false"`
 	ReserveNumber   uint          `json:"reserve_number" default:"0"`
 	Refunded        bool          `json:"refunded" default:"false"`
+	Disputed        bool          `json:"disputed" gorm:"default:false"` // The payment of the ticket is disputed by the card holder
 	UserUGKthID     string        `json:"user_ug_kth_id"`
 	User            User          `json:"user"`
 	Transaction     *Transaction  `json:"transaction"`
@@ -33,6 +34,7 @@ type Ticket struct {
 	CheckedIn       bool          `json:"checked_in" default:"false"`
 	CheckedInAt     sql.NullTime  `json:"checked_in_at"`
 	QrCode          string        `json:"qr_code" gorm:"unique;not null"`
+	GuestName       *string       `json:"guest_name"` // Name of the guest when the ticket was requested together with other tickets
 	PurchasableAt   *time.Time    `json:"purchasable_at" gorm:"default:null"`
 	PaymentDeadline *time.Time    `json:"payment_deadline" gorm:"default:null"`
 	TicketAddOns    []TicketAddOn `gorm:"foreignKey:TicketID" json:"ticket_add_ons"`
@@ -61,8 +63,8 @@ func (t *Ticket) Delete(db *gorm.DB) error {
 		return err
 	}
 
-	// Delete the Ticket
-	err := tx.Delete(&t).Error
+	// Delete the Ticket, the other tickets of the ticket request are deleted with it
+	err := tx.Where("ticket_request_id = ?", t.TicketRequestID).Delete(&Ticket{}).Error
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -71,6 +73,17 @@ func (t *Ticket) Delete(db *gorm.DB) error {
 	return tx.Commit().Error
 }
 
+// GetTicketsInRequest returns the tickets that were allocated for the ticket request, ordered by ID.
+// A request for several tickets is paid for and moved between the reserve list and allocated as a group.
+func GetTicketsInRequest(db *gorm.DB, ticketRequestID uint) (tickets []Ticket, err error) {
+	err = db.Where("ticket_request_id = ?", ticketRequestID).Order("id ASC").Find(&tickets).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
 func GetTicketRequestsToEvent(db *gorm.DB, eventID uint) (ticketRequests []TicketRequest, err error) {
 	err = db.
 		Joins("INNER JOIN ticket_releases ON ticket_requests.ticket_release_id = ticket_releases.id").
@@ -167,10 +180,11 @@ func GetAllReserveTicketsToTicketRelease(db *gorm.DB, ticketReleaseID uint) (tic
 	// Get all tickets to a ticket release thats not soft deleted or reserved or refunded
 	err = db.
 		Preload("TicketRequest.User").
+		Preload("TicketRequest.TicketType").
 		Joins("JOIN ticket_requests ON tickets.ticket_request_id = ticket_requests.id").
 		Joins("JOIN ticket_releases ON ticket_requests.ticket_release_id = ticket_releases.id").
 		Where("ticket_releases.id = ? AND tickets.refunded = ? AND tickets.is_reserve = ?", ticketReleaseID, false, true).
-		Order("reserve_number ASC").
+		Order("reserve_number ASC, tickets.id ASC").
 		Find(&tickets).Error
 
 	if err != nil {
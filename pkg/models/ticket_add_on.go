@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"math"
+
+	"gorm.io/gorm"
+)
 
 type TicketAddOn struct {
 	gorm.Model
@@ -9,4 +13,10 @@ type TicketAddOn struct {
 	TicketRequestID *uint `json:"ticket_request_id"`
 	TicketID        *uint `json:"ticket_id"`
 	Quantity        int   `json:"quantity"`
+	Refunded        bool  `json:"refunded" gorm:"default:false"`
+}
+
+// Amount returns what the add-ons cost, in öre
+func (ta *TicketAddOn) Amount() int {
+	return int(math.Round(ta.AddOn.Price*100)) * ta.Quantity
 }
@@ -16,7 +16,12 @@ type TicketRelease struct {
 	Description                 string                        `json:"description" gorm:"type:text"`
 	Open                        int64                         `json:"open"`
 	Close                       int64                         `json:"close"`
-	AllowExternal               bool                          `gorm:"default:false" json:"allow_external"` // Allow external users to buy tickets
+	AllowExternal               bool                          `gorm:"default:false" json:"allow_external"`     // Allow external users to buy tickets
+	AllowTransfers              bool                          `gorm:"default:false" json:"allow_transfers"`    // Allow ticket holders to transfer their tickets to other users
+	TransferDeadline            *int64                        `json:"transfer_deadline"`                       // Transfers must be completed before this time, nil means until the event
+	TransferPaidOnly            bool                          `gorm:"default:false" json:"transfer_paid_only"` // Only paid tickets can be transferred
+	AllowResale                 bool                          `gorm:"default:false" json:"allow_resale"`       // Allow holders of paid tickets to sell them to the reserve list
+	PaymentProvider             PaymentProviderName           `json:"payment_provider"`                        // Empty means the provider of the organization is used
 	TicketTypes                 []TicketType                  `gorm:"foreignKey:TicketReleaseID" json:"ticket_types"`
 	TicketRequests              []TicketRequest               `gorm:"foreignKey:TicketReleaseID" json:"ticket_requests"`
 	TicketsAvailable            int                           `json:"tickets_available"`              // The total number of tickets for the ticket release
@@ -24,12 +29,14 @@ type TicketRelease struct {
 	IsReserved                  bool                          `gorm:"default:false" json:"is_reserved"`
 	PromoCode                   *string                       `gorm:"default:NULL" json:"promo_code"`
 	HasAllocatedTickets         bool                          `json:"has_allocated_tickets"`
+	LotterySeed                 *int64                        `gorm:"default:NULL" json:"-"` // Seed of the lottery, set by the first preview or allocation
 	TicketReleaseMethodDetailID uint                          `gorm:"index" json:"ticket_release_method_detail_id"`
 	TicketReleaseMethodDetail   TicketReleaseMethodDetail     `json:"ticket_release_method_detail"`
 	ReservedUsers               []User                        `gorm:"many2many:user_unlocked_ticket_releases;" json:"-"`
 	UserReminders               []TicketReleaseReminder       `gorm:"foreignKey:TicketReleaseID" json:"user_reminders"`
 	AddOns                      []AddOn                       `gorm:"foreignKey:TicketReleaseID" json:"add_ons"`
 	PaymentDeadline             *TicketReleasePaymentDeadline `gorm:"foreignKey:TicketReleaseID" json:"payment_deadline"`
+	WaitingRoom                 *TicketReleaseWaitingRoom     `gorm:"foreignKey:TicketReleaseID" json:"waiting_room"`
 }
 
 func DeleteTicketRelease(db *gorm.DB, ticketReleaseID uint) error {
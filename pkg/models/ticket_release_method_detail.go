@@ -3,6 +3,9 @@ package models
 import (
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -13,19 +16,49 @@ type TicketReleaseMethodDetail struct {
 	NotificationMethod string `json:"notification_method"`
 	CancellationPolicy string `json:"cancellation_policy"`
 
+	// Refunds, RefundTiers is specific to TIERED_REFUND. The handling fee is kept from
+	// every refund of a ticket, in SEK, and is not kept from refunds of add-ons.
+	RefundTiers       []RefundTier `gorm:"serializer:json" json:"refund_tiers"`
+	RefundHandlingFee float64      `json:"refund_handling_fee"`
+
 	OpenWindowDuration int64  `gorm:"open_window_duration" json:"open_window_duration"` // Specific to FCFS_Lottery
 	MethodDescription  string `json:"method_description"`                               // Specific to Selective
 
+	// Lottery priority groups, specific to FCFS_Lottery. A request gets the highest
+	// weight of the groups its user belongs to, everyone else has weight 1.
+	LotteryPriorityMode    string `json:"lottery_priority_mode"`
+	MemberLotteryWeight    int    `gorm:"default:1" json:"member_lottery_weight"`     // Members of the organization
+	InternalLotteryWeight  int    `gorm:"default:1" json:"internal_lottery_weight"`   // Users that are not external
+	PromoCodeLotteryWeight int    `gorm:"default:1" json:"promo_code_lottery_weight"` // Users that unlocked the release with a promo code
+
 	TicketReleaseMethodID uint                `json:"ticket_release_method_id"`
 	TicketReleaseMethod   TicketReleaseMethod `json:"ticket_release_method"`
 }
 
 // Create enum of cancellation policies
 const (
-	FULL_REFUND = "FULL_REFUND"
-	NO_REFUND   = "NO_REFUND"
+	FULL_REFUND   = "FULL_REFUND"
+	NO_REFUND     = "NO_REFUND"
+	TIERED_REFUND = "TIERED_REFUND"
+)
+
+// RefundTier refunds Percentage of the price of a ticket that is cancelled at least
+// DaysBeforeEvent days before the event
+type RefundTier struct {
+	DaysBeforeEvent int `json:"days_before_event"`
+	Percentage      int `json:"percentage"`
+}
+
+// Lottery priority modes
+const (
+	LOTTERY_PRIORITY_NONE     = ""         // Everyone has the same chance
+	LOTTERY_PRIORITY_TIERED   = "TIERED"   // Higher weights are drawn before lower weights
+	LOTTERY_PRIORITY_WEIGHTED = "WEIGHTED" // The weight is the number of lottery tickets a request gets
 )
 
+// MaxLotteryWeight is the highest weight a priority group can have
+const MaxLotteryWeight = 100
+
 // Notification methods
 const (
 	EMAIL = "EMAIL"
@@ -36,7 +69,11 @@ type TicketReleaseConfig interface {
 }
 
 type FCFSLotteryConfig struct {
-	OpenWindowDuration int64 // In seconds
+	OpenWindowDuration     int64 // In seconds
+	PriorityMode           string
+	MemberLotteryWeight    int
+	InternalLotteryWeight  int
+	PromoCodeLotteryWeight int
 }
 
 type ReservedTicketConfig struct {
@@ -69,19 +106,135 @@ func (f *FCFSLotteryConfig) Validate() error {
 	if f.OpenWindowDuration <= 0 {
 		return errors.New("open window duration must be greater than 0")
 	}
+
+	switch f.PriorityMode {
+	case LOTTERY_PRIORITY_NONE:
+		return nil
+	case LOTTERY_PRIORITY_TIERED, LOTTERY_PRIORITY_WEIGHTED:
+	default:
+		return fmt.Errorf("invalid lottery priority mode: %v", f.PriorityMode)
+	}
+
+	if err := validateLotteryWeight("member", f.MemberLotteryWeight); err != nil {
+		return err
+	}
+
+	if err := validateLotteryWeight("internal", f.InternalLotteryWeight); err != nil {
+		return err
+	}
+
+	if err := validateLotteryWeight("promo code", f.PromoCodeLotteryWeight); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NormalizeLotteryWeight treats a weight that has not been set as 1
+func NormalizeLotteryWeight(weight int) int {
+	if weight == 0 {
+		return 1
+	}
+
+	return weight
+}
+
+func validateLotteryWeight(group string, weight int) error {
+	if weight < 1 || weight > MaxLotteryWeight {
+		return fmt.Errorf("%s lottery weight must be between 1 and %d", group, MaxLotteryWeight)
+	}
+
 	return nil
 }
 
 func (trmd *TicketReleaseMethodDetail) ValidateCancellationPolicy() error {
+	if trmd.RefundHandlingFee < 0 {
+		return errors.New("refund handling fee can not be negative")
+	}
+
 	switch trmd.CancellationPolicy {
 	case FULL_REFUND, NO_REFUND:
 		return nil
+	case TIERED_REFUND:
+		return trmd.ValidateRefundTiers()
 	default:
 		err := fmt.Errorf("invalid CancellationPolicy: %v", trmd.CancellationPolicy)
 		return err
 	}
 }
 
+// ValidateRefundTiers checks that there is at least one tier, and that the refund does not grow
+// as the event gets closer
+func (trmd *TicketReleaseMethodDetail) ValidateRefundTiers() error {
+	if len(trmd.RefundTiers) == 0 {
+		return errors.New("a tiered refund policy needs at least one refund tier")
+	}
+
+	tiers := make([]RefundTier, len(trmd.RefundTiers))
+	copy(tiers, trmd.RefundTiers)
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].DaysBeforeEvent > tiers[j].DaysBeforeEvent
+	})
+
+	for i, tier := range tiers {
+		if tier.DaysBeforeEvent < 0 {
+			return errors.New("refund tiers can not end after the event has started")
+		}
+
+		if tier.Percentage < 0 || tier.Percentage > 100 {
+			return errors.New("refund percentage must be between 0 and 100")
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		if tier.DaysBeforeEvent == tiers[i-1].DaysBeforeEvent {
+			return fmt.Errorf("there are several refund tiers %d days before the event", tier.DaysBeforeEvent)
+		}
+
+		if tier.Percentage > tiers[i-1].Percentage {
+			return errors.New("the refund can not be larger closer to the event")
+		}
+	}
+
+	return nil
+}
+
+// RefundPercentage returns how many percent of the price is refunded for a ticket that is
+// cancelled at the given time
+func (trmd *TicketReleaseMethodDetail) RefundPercentage(eventDate, at time.Time) int {
+	switch trmd.CancellationPolicy {
+	case FULL_REFUND:
+		return 100
+	case TIERED_REFUND:
+		percentage := 0
+		for _, tier := range trmd.RefundTiers {
+			if !at.After(eventDate.AddDate(0, 0, -tier.DaysBeforeEvent)) && tier.Percentage > percentage {
+				percentage = tier.Percentage
+			}
+		}
+		return percentage
+	default:
+		return 0
+	}
+}
+
+// RefundAmount returns how much is refunded of amount, in öre, for a ticket that is cancelled
+// at the given time. The handling fee is kept from the refunds of tickets.
+func (trmd *TicketReleaseMethodDetail) RefundAmount(amount int, eventDate, at time.Time, withHandlingFee bool) int {
+	refund := amount * trmd.RefundPercentage(eventDate, at) / 100
+	if withHandlingFee {
+		refund -= int(math.Round(trmd.RefundHandlingFee * 100))
+	}
+
+	if refund < 0 {
+		return 0
+	}
+
+	return refund
+}
+
 func (trmd *TicketReleaseMethodDetail) ValidateNotificationMethod() error {
 	switch trmd.NotificationMethod {
 	case EMAIL:
@@ -120,22 +273,3 @@ func (trmd *TicketReleaseMethodDetail) Validate() error {
 
 	return nil
 }
-
-func NewTicketReleaseConfig(methodName string, detail *TicketReleaseMethodDetail) (TicketReleaseConfig, error) {
-	switch methodName {
-	case string(FCFS_LOTTERY):
-		return &FCFSLotteryConfig{
-			OpenWindowDuration: detail.OpenWindowDuration,
-		}, nil
-	case string(RESERVED_TICKET_RELEASE): // Handle the reserved method
-		return &ReservedTicketConfig{}, nil
-	case string(FCFS):
-		return &FCFSConfig{}, nil
-	case string(SELECTIVE):
-		return &SelectiveConfig{
-			MethodDescription: detail.MethodDescription,
-		}, nil
-	default:
-		return nil, fmt.Errorf("unknown method: %s", methodName)
-	}
-}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TicketReleasePromoCode unlocks a reserved ticket release for the users that enter it. A ticket release
+// can have many promo codes, e.g. one for a sponsor and one for members. Codes are looked up by a keyed
+// hash of the code, the encrypted code is only kept so that organizers can see it.
+type TicketReleasePromoCode struct {
+	gorm.Model
+	EventID         int        `gorm:"index" json:"event_id"`
+	TicketReleaseID uint       `gorm:"index" json:"ticket_release_id"`
+	Label           string     `json:"label"`
+	CodeHash        string     `gorm:"index" json:"-"`
+	EncryptedCode   string     `json:"-"`
+	IsPrimary       bool       `gorm:"default:false" json:"is_primary"` // The promo code set on the ticket release itself
+	MaxUses         *int       `json:"max_uses"`                        // nil if the code can be used any number of times
+	ExpiresAt       *time.Time `json:"expires_at"`
+
+	Code string `gorm:"-" json:"code"`
+	Uses int    `gorm:"-" json:"uses"`
+}
+
+// TicketReleasePromoCodeUnlock records which user unlocked which ticket release with which promo code
+type TicketReleasePromoCodeUnlock struct {
+	gorm.Model
+	EventID         int                    `gorm:"index" json:"event_id"`
+	TicketReleaseID uint                   `gorm:"index" json:"ticket_release_id"`
+	PromoCodeID     uint                   `gorm:"index" json:"promo_code_id"`
+	PromoCode       TicketReleasePromoCode `json:"promo_code"`
+	UserUGKthID     string                 `gorm:"index" json:"user_ug_kth_id"`
+	User            User                   `json:"user"`
+}
+
+func (pc *TicketReleasePromoCode) IsExpired() bool {
+	return pc.ExpiresAt != nil && time.Now().After(*pc.ExpiresAt)
+}
+
+// CountUses returns how many users have unlocked the ticket release with the code
+func (pc *TicketReleasePromoCode) CountUses(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&TicketReleasePromoCodeUnlock{}).Where("promo_code_id = ?", pc.ID).Count(&count).Error
+	return count, err
+}
+
+// GetPromoCodeByHash returns the promo code of the event with the hash, nil if there is no such code
+func GetPromoCodeByHash(db *gorm.DB, eventID int, codeHash string) (*TicketReleasePromoCode, error) {
+	var promoCodes []TicketReleasePromoCode
+	if err := db.Where("event_id = ? AND code_hash = ?", eventID, codeHash).Limit(1).Find(&promoCodes).Error; err != nil {
+		return nil, err
+	}
+
+	if len(promoCodes) == 0 {
+		return nil, nil
+	}
+
+	return &promoCodes[0], nil
+}
+
+// GetPromoCodesToTicketRelease returns the promo codes of the ticket release with the number of times they have been used
+func GetPromoCodesToTicketRelease(db *gorm.DB, ticketReleaseID uint) ([]TicketReleasePromoCode, error) {
+	var promoCodes []TicketReleasePromoCode
+	if err := db.Where("ticket_release_id = ?", ticketReleaseID).Order("id ASC").Find(&promoCodes).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range promoCodes {
+		count, err := promoCodes[i].CountUses(db)
+		if err != nil {
+			return nil, err
+		}
+		promoCodes[i].Uses = int(count)
+	}
+
+	return promoCodes, nil
+}
+
+// GetPromoCodeUnlocksToTicketRelease returns who unlocked the ticket release with which code, newest first
+func GetPromoCodeUnlocksToTicketRelease(db *gorm.DB, ticketReleaseID uint) ([]TicketReleasePromoCodeUnlock, error) {
+	var unlocks []TicketReleasePromoCodeUnlock
+	if err := db.Preload("PromoCode").Preload("User").
+		Where("ticket_release_id = ?", ticketReleaseID).
+		Order("created_at DESC").
+		Find(&unlocks).Error; err != nil {
+		return nil, err
+	}
+
+	return unlocks, nil
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WaitingRoomOrder string
+
+const (
+	WaitingRoomRandom  WaitingRoomOrder = "random"  // Users that join before the release opens are shuffled, later users are queued by arrival
+	WaitingRoomArrival WaitingRoomOrder = "arrival" // Users are queued by arrival
+)
+
+// TicketReleaseWaitingRoom queues the users of a first come first served ticket release when it opens.
+// Users can join the queue JoinBefore seconds before the release opens, and are admitted BatchSize
+// users every BatchInterval seconds from when it opens. Only admitted users can request tickets.
+type TicketReleaseWaitingRoom struct {
+	gorm.Model
+	TicketReleaseID uint             `gorm:"uniqueIndex" json:"ticket_release_id"`
+	Order           WaitingRoomOrder `json:"order"`
+	JoinBefore      int64            `json:"join_before"` // In seconds
+	BatchSize       int              `json:"batch_size"`
+	BatchInterval   int64            `json:"batch_interval"` // In seconds
+}
+
+func (wr *TicketReleaseWaitingRoom) Validate() error {
+	if wr.Order != WaitingRoomRandom && wr.Order != WaitingRoomArrival {
+		return errors.New("invalid waiting room order")
+	}
+
+	if wr.JoinBefore < 0 {
+		return errors.New("join before must not be negative")
+	}
+
+	if wr.BatchSize < 1 {
+		return errors.New("batch size must be at least 1")
+	}
+
+	if wr.BatchInterval < 1 {
+		return errors.New("batch interval must be at least 1 second")
+	}
+
+	return nil
+}
+
+// OpensAt returns when users can start joining the waiting room
+func (wr *TicketReleaseWaitingRoom) OpensAt(ticketRelease *TicketRelease) time.Time {
+	return time.Unix(ticketRelease.Open, 0).Add(-time.Duration(wr.JoinBefore) * time.Second)
+}
+
+// Admitted returns how many users in the front of the queue have been admitted at now
+func (wr *TicketReleaseWaitingRoom) Admitted(ticketRelease *TicketRelease, now time.Time) int64 {
+	open := time.Unix(ticketRelease.Open, 0)
+	if now.Before(open) {
+		return 0
+	}
+
+	batches := int64(now.Sub(open)/(time.Duration(wr.BatchInterval)*time.Second)) + 1
+	return batches * int64(wr.BatchSize)
+}
+
+// AdmittedAt returns when the user at rank, counted from 0, is admitted
+func (wr *TicketReleaseWaitingRoom) AdmittedAt(ticketRelease *TicketRelease, rank int64) time.Time {
+	batch := rank / int64(wr.BatchSize)
+	return time.Unix(ticketRelease.Open, 0).Add(time.Duration(batch*wr.BatchInterval) * time.Second)
+}
+
+func GetTicketReleaseWaitingRoom(db *gorm.DB, ticketReleaseID uint) (*TicketReleaseWaitingRoom, error) {
+	var waitingRooms []TicketReleaseWaitingRoom
+	if err := db.Where("ticket_release_id = ?", ticketReleaseID).Limit(1).Find(&waitingRooms).Error; err != nil {
+		return nil, err
+	}
+
+	if len(waitingRooms) == 0 {
+		return nil, nil
+	}
+
+	return &waitingRooms[0], nil
+}
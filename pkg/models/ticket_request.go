@@ -20,6 +20,27 @@ type TicketRequest struct {
 	EventFormReponses []EventFormFieldResponse `json:"event_form_responses"`
 	TicketAddOns      []TicketAddOn            `gorm:"foreignKey:TicketRequestID" json:"ticket_add_ons"`
 	HandledAt         *time.Time               `json:"handled_at" gorm:"default:null"`
+	GuestNames        []string                 `json:"guest_names" gorm:"serializer:json"` // Optional name on each of the tickets, in ticket order
+}
+
+// NumberOfTickets returns how many tickets the request is for, requests made
+// before the ticket amount was set are for one ticket
+func (tr *TicketRequest) NumberOfTickets() int {
+	if tr.TicketAmount < 1 {
+		return 1
+	}
+
+	return tr.TicketAmount
+}
+
+// GuestName returns the name on the i:th ticket of the request, or nil if no name was given
+func (tr *TicketRequest) GuestName(i int) *string {
+	if i < 0 || i >= len(tr.GuestNames) || tr.GuestNames[i] == "" {
+		return nil
+	}
+
+	name := tr.GuestNames[i]
+	return &name
 }
 
 func (tr *TicketRequest) BeforeSave(tx *gorm.DB) (err error) {
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TicketResaleStatus string
+
+const (
+	TicketResaleListed    TicketResaleStatus = "listed"    // Waiting for someone on the reserve list
+	TicketResaleOffered   TicketResaleStatus = "offered"   // Offered to a reserve, waiting for them to pay
+	TicketResaleSold      TicketResaleStatus = "sold"      // The reserve has paid and the seller has been refunded
+	TicketResaleCancelled TicketResaleStatus = "cancelled" // The seller kept the tickets
+)
+
+// TicketResale is a listing of the tickets of a paid ticket request that the holder can not use.
+// The tickets are offered to the next reserve of the same ticket type, and the seller is
+// refunded once the reserve has paid for their tickets.
+type TicketResale struct {
+	gorm.Model
+	TicketRequestID      uint               `gorm:"index" json:"ticket_request_id"`
+	TicketRequest        TicketRequest      `json:"ticket_request"`
+	TicketReleaseID      uint               `gorm:"index" json:"ticket_release_id"`
+	SellerUGKthID        string             `gorm:"index" json:"seller_ug_kth_id"`
+	Seller               User               `gorm:"foreignKey:SellerUGKthID" json:"seller"`
+	BuyerTicketRequestID *uint              `gorm:"index" json:"buyer_ticket_request_id"` // The reserve the tickets are offered to
+	Status               TicketResaleStatus `json:"status"`
+	OfferedAt            *time.Time         `json:"offered_at"`
+	SoldAt               *time.Time         `json:"sold_at"`
+	RefundTransactionID  *uint              `json:"refund_transaction_id"` // The refund of the seller's payment
+}
+
+func (tr *TicketResale) IsActive() bool {
+	return tr.Status == TicketResaleListed || tr.Status == TicketResaleOffered
+}
+
+// GetActiveTicketResale returns the listing of the ticket request that has not been sold or cancelled
+func GetActiveTicketResale(db *gorm.DB, ticketRequestID uint) (*TicketResale, error) {
+	var resale TicketResale
+	if err := db.Where("ticket_request_id = ? AND status IN ?", ticketRequestID,
+		[]TicketResaleStatus{TicketResaleListed, TicketResaleOffered}).First(&resale).Error; err != nil {
+		return nil, err
+	}
+
+	return &resale, nil
+}
+
+// GetOfferedTicketResaleToBuyer returns the listing that has been offered to the reserve's ticket request
+func GetOfferedTicketResaleToBuyer(db *gorm.DB, buyerTicketRequestID uint) (*TicketResale, error) {
+	var resale TicketResale
+	if err := db.Where("buyer_ticket_request_id = ? AND status = ?", buyerTicketRequestID, TicketResaleOffered).First(&resale).Error; err != nil {
+		return nil, err
+	}
+
+	return &resale, nil
+}
+
+// GetActiveTicketResalesToTicketRelease returns the listings of the ticket release in the order they were listed
+func GetActiveTicketResalesToTicketRelease(db *gorm.DB, ticketReleaseID uint) (resales []TicketResale, err error) {
+	err = db.Preload("TicketRequest").
+		Where("ticket_release_id = ? AND status IN ?", ticketReleaseID,
+			[]TicketResaleStatus{TicketResaleListed, TicketResaleOffered}).
+		Order("id ASC").Find(&resales).Error
+
+	return resales, err
+}
+
+// GetTicketResalesForUser returns the listings of the user, newest first
+func GetTicketResalesForUser(db *gorm.DB, ugKthID string) ([]TicketResale, error) {
+	var resales []TicketResale
+	if err := db.
+		Preload("TicketRequest.TicketType").
+		Preload("TicketRequest.TicketRelease.Event").
+		Where("seller_ug_kth_id = ?", ugKthID).
+		Order("created_at DESC").
+		Find(&resales).Error; err != nil {
+		return nil, err
+	}
+
+	return resales, nil
+}
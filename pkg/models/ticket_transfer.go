@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TicketTransferStatus string
+
+const (
+	TicketTransferPending   TicketTransferStatus = "pending"
+	TicketTransferAccepted  TicketTransferStatus = "accepted"
+	TicketTransferDeclined  TicketTransferStatus = "declined"
+	TicketTransferCancelled TicketTransferStatus = "cancelled"
+)
+
+// TicketTransfer is a request from a ticket holder to hand over their tickets to another
+// user. The tickets of a ticket request are transferred together, and the ticket request
+// moves to the recipient when the transfer is accepted.
+type TicketTransfer struct {
+	gorm.Model
+	TicketID        uint                 `gorm:"index" json:"ticket_id"` // The ticket the transfer was started from
+	Ticket          Ticket               `json:"ticket"`
+	TicketRequestID uint                 `gorm:"index" json:"ticket_request_id"`
+	FromUGKthID     string               `gorm:"index" json:"from_ug_kth_id"`
+	From            User                 `gorm:"foreignKey:FromUGKthID" json:"from"`
+	ToUGKthID       string               `gorm:"index" json:"to_ug_kth_id"`
+	To              User                 `gorm:"foreignKey:ToUGKthID" json:"to"`
+	Status          TicketTransferStatus `json:"status"`
+	RespondedAt     *time.Time           `json:"responded_at"`
+}
+
+func (tt *TicketTransfer) IsPending() bool {
+	return tt.Status == TicketTransferPending
+}
+
+// GetPendingTicketTransfer returns the pending transfer of the ticket request, if any
+func GetPendingTicketTransfer(db *gorm.DB, ticketRequestID uint) (*TicketTransfer, error) {
+	var transfer TicketTransfer
+	if err := db.Where("ticket_request_id = ? AND status = ?", ticketRequestID, TicketTransferPending).First(&transfer).Error; err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// GetTicketTransfersForUser returns the transfers the user has sent or received, newest first
+func GetTicketTransfersForUser(db *gorm.DB, ugKthID string) ([]TicketTransfer, error) {
+	var transfers []TicketTransfer
+	if err := db.
+		Preload("Ticket.TicketRequest.TicketType").
+		Preload("Ticket.TicketRequest.TicketRelease.Event").
+		Preload("From").
+		Preload("To").
+		Where("from_ug_kth_id = ? OR to_ug_kth_id = ?", ugKthID, ugKthID).
+		Order("created_at DESC").
+		Find(&transfers).Error; err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+
 	"gorm.io/gorm"
 )
 
@@ -12,9 +14,25 @@ type TicketType struct {
 	Description     string  `json:"description" gorm:"type:text"`
 	Price           float64 `json:"price"`
 	TicketReleaseID uint    `json:"ticket_release_id"`
+	// Capacity is the number of tickets of this type that can be allocated, nil means
+	// the type is only limited by TicketsAvailable of the ticket release
+	Capacity *int `json:"capacity"`
 }
 
 // IsFree returns true if the ticket type is free
 func (tt *TicketType) IsFree() bool {
 	return tt.Price == 0
 }
+
+// HasCapacity returns true if the number of tickets of this type is limited
+func (tt *TicketType) HasCapacity() bool {
+	return tt.Capacity != nil
+}
+
+func (tt *TicketType) Validate() error {
+	if tt.Capacity != nil && *tt.Capacity <= 0 {
+		return errors.New("ticket type capacity must be greater than 0")
+	}
+
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 
-	"github.com/stripe/stripe-go"
 	"gorm.io/gorm"
 )
 
@@ -13,6 +12,8 @@ type TransactionStatus string
 const (
 	TransactionStatusPending   TransactionStatus = "pending"
 	TransactionStatusCompleted TransactionStatus = "completed"
+	TransactionStatusFailed    TransactionStatus = "failed"   // The payment failed, the user can try again
+	TransactionStatusCanceled  TransactionStatus = "canceled" // The payment intent was canceled, a new one is needed
 )
 
 type TransactionType string
@@ -26,26 +27,35 @@ const (
 // Transaction
 type Transaction struct {
 	gorm.Model
-	PaymentIntentID   string                    `json:"payment_intent_id"`
-	EventID           int                       `json:"event_id"`
-	TicketID          int                       `json:"ticket_id" gorm:"unique"`
-	UserUGKthID       string                    `json:"user_ug_kth_id"`
-	User              User                      `json:"user"`
-	Amount            int                       `json:"amount"`
-	Currency          string                    `json:"currency"`
-	PayedAt           *int64                    `json:"payed_at"`
-	Refunded          bool                      `json:"refunded" default:"false"`
-	RefundedAt        *int64                    `json:"refunded_at"`
-	Status            TransactionStatus         `json:"status"`
-	PaymentMethod     *stripe.PaymentMethodType `json:"payment_method"`
-	TransactionType   TransactionType           `json:"transaction_type" default:"purchase"`
-	EventSalesReports []EventSalesReport        `gorm:"many2many:event_sales_report_transactions;"`
+	PaymentIntentID   string              `json:"payment_intent_id"`
+	EventID           int                 `json:"event_id"`
+	TicketID          int                 `json:"ticket_id" gorm:"index"` // Refunds are recorded as transactions of the refunded ticket
+	UserUGKthID       string              `json:"user_ug_kth_id"`
+	User              User                `json:"user"`
+	Amount            int                 `json:"amount"`
+	Currency          string              `json:"currency"`
+	PayedAt           *int64              `json:"payed_at"`
+	Refunded          bool                `json:"refunded" default:"false"`
+	RefundedAt        *int64              `json:"refunded_at"`
+	Status            TransactionStatus   `json:"status"`
+	PaymentMethod     *string             `json:"payment_method"`
+	PaymentProvider   PaymentProviderName `json:"payment_provider"` // Empty for payments made before providers could be chosen, which were made with Stripe
+	TransactionType   TransactionType     `json:"transaction_type" default:"purchase"`
+	RefundID          *string             `json:"refund_id"` // The refund at the payment provider of a refund transaction, nil until it has been issued
+	IdempotencyKey    string              `json:"-"`         // The key a refund transaction is issued with, so that it is refunded once
+	FailureMessage    string              `json:"failure_message"`
+	DisputeID         *string             `json:"dispute_id"`
+	DisputeStatus     string              `json:"dispute_status"`
+	OrderID           *uint               `json:"order_id" gorm:"index"` // The order the purchase was paid with, nil if the tickets were paid on their own
+	DiscountCodeID    *uint               `json:"discount_code_id" gorm:"index"`
+	DiscountAmount    int                 `json:"discount_amount"` // What the discount code took off the price, Amount is what was paid
+	EventSalesReports []EventSalesReport  `gorm:"many2many:event_sales_report_transactions;"`
 }
 
 // Validate
 func (trans *Transaction) Validate() error {
 	switch trans.Status {
-	case TransactionStatusPending, TransactionStatusCompleted:
+	case TransactionStatusPending, TransactionStatusCompleted, TransactionStatusFailed, TransactionStatusCanceled:
 		return nil
 	default:
 		err := fmt.Errorf("invalid transaction status: %s", trans.Status)
@@ -56,7 +66,9 @@ func (trans *Transaction) Validate() error {
 func GetEventTotalIncome(db *gorm.DB, eventID int) (float64, error) {
 	var totalIncome sql.NullFloat64
 
-	err := db.Model(&Transaction{}).Where("event_id = ?", eventID).Where("status = ?", "completed").Select("COALESCE(SUM(amount), 0)").Scan(&totalIncome).Error
+	err := db.Model(&Transaction{}).Where("event_id = ?", eventID).Where("status = ?", "completed").
+		Select("COALESCE(SUM(CASE WHEN transaction_type = ? THEN -amount ELSE amount END), 0)", TypeRefund).
+		Scan(&totalIncome).Error
 
 	if err != nil {
 		return 0, err
@@ -64,3 +76,37 @@ func GetEventTotalIncome(db *gorm.DB, eventID int) (float64, error) {
 
 	return totalIncome.Float64, nil
 }
+
+// GetRefundedAmount returns how much of the payment has been refunded, including refunds that are still pending
+func GetRefundedAmount(db *gorm.DB, paymentIntentID string) (int, error) {
+	var refunded sql.NullInt64
+	if err := db.Model(&Transaction{}).
+		Where("payment_intent_id = ? AND transaction_type = ? AND status <> ?", paymentIntentID, TypeRefund, TransactionStatusFailed).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error; err != nil {
+		return 0, err
+	}
+
+	return int(refunded.Int64), nil
+}
+
+// GetPurchaseRefundedAmount returns how much of the purchase has been refunded. A payment of an
+// order pays for several purchases, each of which is refunded on its own.
+func GetPurchaseRefundedAmount(db *gorm.DB, purchase *Transaction) (int, error) {
+	var refunded sql.NullInt64
+	if err := db.Model(&Transaction{}).
+		Where("payment_intent_id = ? AND ticket_id = ? AND transaction_type = ? AND status <> ?",
+			purchase.PaymentIntentID, purchase.TicketID, TypeRefund, TransactionStatusFailed).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error; err != nil {
+		return 0, err
+	}
+
+	return int(refunded.Int64), nil
+}
+
+// Provider returns the payment provider the transaction was made with
+func (trans *Transaction) Provider() PaymentProviderName {
+	if trans.PaymentProvider == "" {
+		return STRIPE
+	}
+	return trans.PaymentProvider
+}
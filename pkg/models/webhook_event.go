@@ -1,13 +1,51 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type WebhookEvent struct {
 	gorm.Model
-	StripeID  string `gorm:"uniqueIndex;type:varchar(255)"` // Stripe Event ID
-	EventType string `json:"event_type" gorm:"index"`       // Type of event
-	LastError string `json:"last_error" gorm:"type:text"`
-	Processed bool   `json:"processed" gorm:"default:false"` // If the event has been processed
+	StripeID      string              `json:"stripe_id" gorm:"uniqueIndex;type:varchar(255)"` // Stripe Event ID, or the callback ID of other providers
+	Provider      PaymentProviderName `json:"provider"`                                       // Empty for Stripe
+	EventType     string              `json:"event_type" gorm:"index"`                        // Type of event
+	LastError     string              `json:"last_error" gorm:"type:text"`
+	Processed     bool                `json:"processed" gorm:"default:false"`     // If the event has been processed
+	Payload       string              `json:"payload,omitempty" gorm:"type:text"` // The raw event as sent by Stripe
+	Attempts      int                 `json:"attempts" gorm:"default:0"`          // Number of times the event has been processed
+	LastAttemptAt *time.Time          `json:"last_attempt_at"`                    // When the event was last processed
+	NextRetryAt   *time.Time          `json:"next_retry_at" gorm:"index"`         // When the event is retried next, nil if it is not retried
+	DeadLettered  bool                `json:"dead_lettered" gorm:"default:false"` // If all retries have failed
+}
+
+const (
+	WebhookEventStatusUnprocessed = "unprocessed"
+	WebhookEventStatusFailed      = "failed"
+	WebhookEventStatusDeadLetter  = "dead_letter"
+)
+
+// GetUnprocessedWebhookEvents returns the events that have not been processed, without their payloads.
+// The status can be used to only get the failed or the dead lettered events.
+func GetUnprocessedWebhookEvents(db *gorm.DB, status string) (events []WebhookEvent, err error) {
+	query := db.Omit("payload").Where("processed = ?", false)
+
+	switch status {
+	case WebhookEventStatusFailed:
+		query = query.Where("last_error <> ''")
+	case WebhookEventStatusDeadLetter:
+		query = query.Where("dead_lettered = ?", true)
+	}
+
+	err = query.Order("id desc").Find(&events).Error
+	return events, err
+}
+
+// GetWebhookEventsDueForRetry returns the failed events that should be retried
+func GetWebhookEventsDueForRetry(db *gorm.DB, now time.Time) (events []WebhookEvent, err error) {
+	err = db.Where("processed = ? AND dead_lettered = ? AND next_retry_at <= ?", false, false, now).
+		Order("next_retry_at").
+		Find(&events).Error
+	return events, err
 }
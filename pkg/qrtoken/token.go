@@ -0,0 +1,136 @@
+// Package qrtoken signs the QR codes of tickets with the key pair of the event, so that scanners
+// with the public key of the event can verify tickets without reaching the server.
+package qrtoken
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Prefix tells signed tokens apart from the random QR codes of the tickets
+const Prefix = "TT1."
+
+var (
+	ErrMalformed   = errors.New("malformed QR token")
+	ErrSignature   = errors.New("invalid QR token signature")
+	ErrNotYetValid = errors.New("QR token is not valid yet")
+	ErrExpired     = errors.New("QR token has expired")
+	errInvalidKey  = errors.New("invalid key")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Claims are the contents of a token. Code is the QR code of the ticket when the token was signed,
+// which is changed when the ticket is transferred, so that tokens of the previous owner are rejected.
+type Claims struct {
+	TicketID     uint   `json:"tid"`
+	EventID      uint   `json:"eid"`
+	TicketTypeID uint   `json:"tt"`
+	Code         string `json:"qr"`
+	NotBefore    int64  `json:"nbf"`
+	ExpiresAt    int64  `json:"exp"`
+}
+
+// ValidAt checks the validity window of the claims
+func (c *Claims) ValidAt(t time.Time) error {
+	if t.Unix() < c.NotBefore {
+		return ErrNotYetValid
+	}
+
+	if t.Unix() > c.ExpiresAt {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Sign returns the token TT1.<payload>.<signature>, where the payload is the JSON encoded claims and
+// the signature is the Ed25519 signature of the payload, both base64url encoded without padding
+func Sign(key ed25519.PrivateKey, claims *Claims) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", errInvalidKey
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encodedPayload := encoding.EncodeToString(payload)
+	signature := ed25519.Sign(key, []byte(encodedPayload))
+
+	return Prefix + encodedPayload + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature of the token and returns its claims, the validity window is checked
+// separately with ValidAt since offline check-ins are verified against the time they were scanned
+func Verify(key ed25519.PublicKey, token string) (*Claims, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errInvalidKey
+	}
+
+	encodedPayload, signature, err := split(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(key, []byte(encodedPayload), signature) {
+		return nil, ErrSignature
+	}
+
+	return decode(encodedPayload)
+}
+
+// Peek returns the claims of the token without verifying it, e.g. to find the key of the event
+func Peek(token string) (*Claims, error) {
+	encodedPayload, _, err := split(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(encodedPayload)
+}
+
+func split(token string) (string, []byte, error) {
+	if !IsToken(token) {
+		return "", nil, ErrMalformed
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, Prefix), ".")
+	if len(parts) != 2 {
+		return "", nil, ErrMalformed
+	}
+
+	signature, err := encoding.DecodeString(parts[1])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return "", nil, ErrMalformed
+	}
+
+	return parts[0], signature, nil
+}
+
+func decode(encodedPayload string) (*Claims, error) {
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if claims.TicketID == 0 || claims.EventID == 0 {
+		return nil, ErrMalformed
+	}
+
+	return &claims, nil
+}
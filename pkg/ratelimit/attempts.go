@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// AttemptState is the failed attempts of a key, e.g. a user or an IP address
+type AttemptState struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// LockoutPolicy decides how long a key is locked out after failed attempts. The first FreeAttempts
+// failures are not locked out, after that every failure doubles the lockout up to MaxLockout.
+// The failures are forgotten after Window without any failure.
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	Window       time.Duration
+}
+
+var DefaultLockoutPolicy = LockoutPolicy{
+	FreeAttempts: 5,
+	BaseLockout:  30 * time.Second,
+	MaxLockout:   1 * time.Hour,
+	Window:       24 * time.Hour,
+}
+
+// Lockout returns how long a key is locked out after the number of failures
+func (p LockoutPolicy) Lockout(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	exponent := float64(failures - p.FreeAttempts - 1)
+	lockout := time.Duration(float64(p.BaseLockout) * math.Pow(2, exponent))
+	if lockout > p.MaxLockout || lockout <= 0 {
+		return p.MaxLockout
+	}
+
+	return lockout
+}
+
+// Fail returns the state after one more failed attempt at now
+func (p LockoutPolicy) Fail(state AttemptState, now time.Time) AttemptState {
+	if !state.LastFailure.IsZero() && now.Sub(state.LastFailure) > p.Window {
+		state = AttemptState{}
+	}
+
+	state.Failures++
+	state.LastFailure = now
+	if lockout := p.Lockout(state.Failures); lockout > 0 {
+		state.LockedUntil = now.Add(lockout)
+	}
+
+	return state
+}
+
+// Forgive returns the state with one failed attempt less, for an attempt that was recorded as
+// failed before it was known to succeed
+func (p LockoutPolicy) Forgive(state AttemptState) AttemptState {
+	if state.Failures > 0 {
+		state.Failures--
+	}
+
+	if p.Lockout(state.Failures) == 0 {
+		state.LockedUntil = time.Time{}
+	}
+
+	return state
+}
+
+// TTL is how long the state has to be kept
+func (p LockoutPolicy) TTL(state AttemptState, now time.Time) time.Duration {
+	ttl := p.Window
+	if locked := state.LockedUntil.Sub(now); locked > ttl {
+		ttl = locked
+	}
+
+	return ttl
+}
+
+// AttemptStore keeps the failed attempts of keys. The memory store only limits the attempts made
+// to one instance of the server, the Redis store shares them between all instances.
+type AttemptStore interface {
+	Get(ctx context.Context, key string) (AttemptState, error)
+	// RecordFailure atomically adds a failed attempt to the key and returns the new state
+	RecordFailure(ctx context.Context, key string, policy LockoutPolicy, now time.Time) (AttemptState, error)
+	// RecordAttempt atomically adds a failed attempt to the key unless it is locked out, and returns
+	// the new state and whether the attempt was recorded
+	RecordAttempt(ctx context.Context, key string, policy LockoutPolicy, now time.Time) (AttemptState, bool, error)
+	// Forgive atomically removes a failed attempt from the key
+	Forgive(ctx context.Context, key string, policy LockoutPolicy, now time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+// LockedError is returned when a key is locked out
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts, try again in %d seconds", e.RetryAfter())
+}
+
+// RetryAfter is the number of seconds until the lockout ends, rounded up
+func (e *LockedError) RetryAfter() int {
+	return int(math.Ceil(time.Until(e.Until).Seconds()))
+}
+
+// AttemptLimiter locks out keys after repeated failed attempts
+type AttemptLimiter struct {
+	store  AttemptStore
+	policy LockoutPolicy
+}
+
+func NewAttemptLimiter(store AttemptStore, policy LockoutPolicy) *AttemptLimiter {
+	return &AttemptLimiter{store: store, policy: policy}
+}
+
+// Check returns a LockedError if any of the keys is locked out
+func (l *AttemptLimiter) Check(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	var until time.Time
+
+	for _, key := range keys {
+		state, err := l.store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if state.LockedUntil.After(now) && state.LockedUntil.After(until) {
+			until = state.LockedUntil
+		}
+	}
+
+	if !until.IsZero() {
+		return &LockedError{Until: until}
+	}
+
+	return nil
+}
+
+// Attempt records an attempt for all the keys, unless any of them is locked out in which case a
+// LockedError is returned. The attempt is counted as failed from the start, so that attempts made
+// at the same time can not all get past the check before any of them has failed. Attempts that
+// succeed are forgiven with Forgive or Reset.
+func (l *AttemptLimiter) Attempt(ctx context.Context, keys ...string) error {
+	now := time.Now()
+
+	for i, key := range keys {
+		state, recorded, err := l.store.RecordAttempt(ctx, key, l.policy, now)
+		if err == nil && recorded {
+			continue
+		}
+
+		// The keys that have already been recorded are not held responsible for the attempt
+		if forgiveErr := l.Forgive(ctx, keys[:i]...); err == nil {
+			err = forgiveErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return &LockedError{Until: state.LockedUntil}
+	}
+
+	return nil
+}
+
+// Forgive removes the failed attempt recorded by Attempt for all the keys
+func (l *AttemptLimiter) Forgive(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	for _, key := range keys {
+		if err := l.store.Forgive(ctx, key, l.policy, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fail records a failed attempt for all the keys
+func (l *AttemptLimiter) Fail(ctx context.Context, keys ...string) error {
+	now := time.Now()
+	for _, key := range keys {
+		if _, err := l.store.RecordFailure(ctx, key, l.policy, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset forgets the failed attempts of the keys
+func (l *AttemptLimiter) Reset(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := l.store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
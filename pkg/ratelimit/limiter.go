@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket, Rate requests per second are allowed with bursts of up to Burst requests
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of a request to a limiter, RetryAfter is set when the request is not allowed
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Limiter limits the rate of requests per key. The memory limiter only limits the requests made to
+// one instance of the server, the Redis limiter shares the limits between all instances.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// idleTimeout is how long a bucket has to be kept after the last request, after that it is full again
+func (l Limit) idleTimeout() time.Duration {
+	if l.Rate <= 0 {
+		return time.Minute
+	}
+
+	timeout := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	if timeout < time.Minute {
+		return time.Minute
+	}
+
+	return timeout
+}
+
+// LimitFromEnv reads the limit of a route group from RATE_LIMIT_<GROUP> formatted as "<rate>:<burst>",
+// e.g. RATE_LIMIT_TICKET_REQUESTS=2:5, and returns the default limit if it is not set
+func LimitFromEnv(group string, defaultLimit Limit) (Limit, error) {
+	name := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(group, "-", "_"))
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("%s must be formatted as <rate>:<burst>", name)
+	}
+
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("%s has an invalid rate", name)
+	}
+
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("%s has an invalid burst", name)
+	}
+
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// NewLimiter returns the Redis limiter if RATE_LIMIT_STORE is "redis", otherwise the memory limiter
+func NewLimiter(prefix string) (Limiter, error) {
+	if os.Getenv("RATE_LIMIT_STORE") != "redis" {
+		return NewMemoryLimiter(), nil
+	}
+
+	client, err := NewRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRedisLimiter(client, prefix), nil
+}
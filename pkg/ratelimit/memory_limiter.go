@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// bucket holds the rate limiter of a key and the last time it was used
+type bucket struct {
+	limiter  *rate.Limiter
+	limit    Limit
+	lastSeen time.Time
+}
+
+// MemoryLimiter keeps the buckets in memory. Buckets that have not been used for long enough to be
+// full again are evicted, so that the map does not grow without bound during a big release.
+type MemoryLimiter struct {
+	buckets   map[string]*bucket
+	mtx       sync.Mutex
+	lastSweep time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket)}
+}
+
+func (m *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.evictIdle(now)
+	}
+
+	b, exists := m.buckets[key]
+	if !exists || b.limit != limit {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst), limit: limit}
+		m.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return Result{Allowed: false, RetryAfter: limit.idleTimeout()}, nil
+	}
+
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return Result{Allowed: false, RetryAfter: delay}, nil
+	}
+
+	return Result{Allowed: true}, nil
+}
+
+// Len returns the number of buckets that are kept
+func (m *MemoryLimiter) Len() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return len(m.buckets)
+}
+
+// EvictIdle evicts the buckets that are full again at now, which is done every minute when the limiter is used
+func (m *MemoryLimiter) EvictIdle(now time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictIdle(now)
+}
+
+// evictIdle must be called with the lock held
+func (m *MemoryLimiter) evictIdle(now time.Time) {
+	for key, b := range m.buckets {
+		if now.Sub(b.lastSeen) > b.limit.idleTimeout() {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryAttemptStore keeps the failed attempts in memory, the same way RateLimiterMiddleware keeps
+// its limiters. Keys that are neither locked out nor within the window are evicted.
+type MemoryAttemptStore struct {
+	attempts  map[string]AttemptState
+	mtx       sync.Mutex
+	lastSweep time.Time
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: make(map[string]AttemptState)}
+}
+
+func (s *MemoryAttemptStore) Get(ctx context.Context, key string) (AttemptState, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *MemoryAttemptStore) RecordFailure(ctx context.Context, key string, policy LockoutPolicy, now time.Time) (AttemptState, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(policy, now)
+	}
+
+	state := policy.Fail(s.attempts[key], now)
+	s.attempts[key] = state
+	return state, nil
+}
+
+func (s *MemoryAttemptStore) RecordAttempt(ctx context.Context, key string, policy LockoutPolicy, now time.Time) (AttemptState, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if state := s.attempts[key]; state.LockedUntil.After(now) {
+		return state, false, nil
+	}
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(policy, now)
+	}
+
+	state := policy.Fail(s.attempts[key], now)
+	s.attempts[key] = state
+	return state, true, nil
+}
+
+func (s *MemoryAttemptStore) Forgive(ctx context.Context, key string, policy LockoutPolicy, now time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if state, ok := s.attempts[key]; ok {
+		s.attempts[key] = policy.Forgive(state)
+	}
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// sweep evicts the keys that no longer have to be kept, must be called with the lock held
+func (s *MemoryAttemptStore) sweep(policy LockoutPolicy, now time.Time) {
+	for key, state := range s.attempts {
+		if now.Sub(state.LastFailure) > policy.TTL(state, state.LastFailure) {
+			delete(s.attempts, key)
+		}
+	}
+	s.lastSweep = now
+}
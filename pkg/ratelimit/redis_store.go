@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisAttemptStore keeps the failed attempts in Redis so that the limits hold across replicas
+type RedisAttemptStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisAttemptStore(client *redis.Client, prefix string) *RedisAttemptStore {
+	return &RedisAttemptStore{client: client, prefix: prefix}
+}
+
+func (s *RedisAttemptStore) key(key string) string {
+	return s.prefix + key
+}
+
+func (s *RedisAttemptStore) Get(ctx context.Context, key string) (AttemptState, error) {
+	return s.get(ctx, s.client, s.key(key))
+}
+
+func (s *RedisAttemptStore) get(ctx context.Context, cmd redis.Cmdable, key string) (AttemptState, error) {
+	var state AttemptState
+
+	value, err := cmd.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(value, &state)
+	return state, err
+}
+
+// RecordFailure updates the state in an optimistic transaction, which is retried if another
+// replica records a failure of the same key at the same time
+func (s *RedisAttemptStore) RecordFailure(ctx context.Context, key string, policy LockoutPolicy, now time.Time) (AttemptState, error) {
+	state, _, err := s.update(ctx, key, policy, now, func(current AttemptState) (AttemptState, bool) {
+		return policy.Fail(current, now), true
+	})
+	return state, err
+}
+
+// RecordAttempt checks and updates the state in the same optimistic transaction as RecordFailure
+func (s *RedisAttemptStore) RecordAttempt(ctx context.Context, key string, policy LockoutPolicy, now time.Time) (AttemptState, bool, error) {
+	return s.update(ctx, key, policy, now, func(current AttemptState) (AttemptState, bool) {
+		if current.LockedUntil.After(now) {
+			return current, false
+		}
+		return policy.Fail(current, now), true
+	})
+}
+
+func (s *RedisAttemptStore) Forgive(ctx context.Context, key string, policy LockoutPolicy, now time.Time) error {
+	_, _, err := s.update(ctx, key, policy, now, func(current AttemptState) (AttemptState, bool) {
+		return policy.Forgive(current), current.Failures > 0
+	})
+	return err
+}
+
+// update replaces the state of the key with the result of fn, unless fn returns false
+func (s *RedisAttemptStore) update(
+	ctx context.Context,
+	key string,
+	policy LockoutPolicy,
+	now time.Time,
+	fn func(current AttemptState) (AttemptState, bool),
+) (AttemptState, bool, error) {
+	key = s.key(key)
+
+	var state AttemptState
+	var updated bool
+	update := func(tx *redis.Tx) error {
+		current, err := s.get(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		state, updated = fn(current)
+		if !updated {
+			return nil
+		}
+
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, policy.TTL(state, now))
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < 10; i++ {
+		err := s.client.Watch(ctx, update, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return state, updated, err
+	}
+
+	return state, false, errors.New("could not record failed attempt")
+}
+
+func (s *RedisAttemptStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.key(key)).Err()
+}
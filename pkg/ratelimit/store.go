@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"net/url"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient connects to the Redis at REDIS_URL, which is an address in development and a URL otherwise
+func NewRedisClient() (*redis.Client, error) {
+	if os.Getenv("ENV") == "dev" {
+		return redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_URL")}), nil
+	}
+
+	redisURL, err := url.Parse(os.Getenv("REDIS_URL"))
+	if err != nil {
+		return nil, err
+	}
+
+	redisPassword, _ := redisURL.User.Password()
+	return redis.NewClient(&redis.Options{Addr: redisURL.Host, Password: redisPassword}), nil
+}
+
+// NewAttemptStore returns the Redis store if RATE_LIMIT_STORE is "redis", otherwise the memory store
+func NewAttemptStore(prefix string) (AttemptStore, error) {
+	if os.Getenv("RATE_LIMIT_STORE") != "redis" {
+		return NewMemoryAttemptStore(), nil
+	}
+
+	client, err := NewRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRedisAttemptStore(client, prefix), nil
+}
@@ -7,12 +7,16 @@ import (
 	"os"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/authentication"
+	"github.com/DowLucas/gin-ticket-release/pkg/checkinfeed"
 	"github.com/DowLucas/gin-ticket-release/pkg/controllers"
 	"github.com/DowLucas/gin-ticket-release/pkg/jobs"
 	"github.com/DowLucas/gin-ticket-release/pkg/middleware"
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
 	"github.com/DowLucas/gin-ticket-release/pkg/services"
 	banking_service "github.com/DowLucas/gin-ticket-release/pkg/services/banking"
+	"github.com/DowLucas/gin-ticket-release/pkg/waitingroom"
+	"github.com/DowLucas/gin-ticket-release/pkg/wallet"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/hibiken/asynq"
@@ -53,6 +57,10 @@ func setupAsynqMon() *asynqmon.HTTPHandler {
 
 func SetupRouter(db *gorm.DB) *gin.Engine {
 	r := gin.Default()
+	if err := middleware.SetTrustedProxies(r); err != nil {
+		panic("Failed to set trusted proxies: " + err.Error())
+	}
+
 	config := cors.DefaultConfig()
 	if os.Getenv("ENV") == "dev" {
 		config.AllowOrigins = []string{"http://localhost:5000", "http://localhost", "http://localhost:8080"}
@@ -100,19 +108,70 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 	sendOutService := services.NewSendOutService(db)
 	organizationService := services.NewOrganizationService(db)
 	allocateTicketsService := services.NewAllocateTicketsService(db)
+	lotteryDrawService := services.NewLotteryDrawService(db)
+	allocationRunService := services.NewAllocationRunService(db)
 	preferredEmailService := services.NewPreferredEmailService(db)
+	ticketTransferService := services.NewTicketTransferService(db)
+	ticketResaleService := services.NewTicketResaleService(db)
 	bankingService := banking_service.NewBankingService(db)
+	paymentService := services.NewPaymentService(db)
+	discountCodeService := services.NewDiscountCodeService(db)
+
+	if _, err := services.AllocationUndoGracePeriod(); err != nil {
+		panic("Failed to load allocation undo grace period: " + err.Error())
+	}
+
+	// Rate limits of the route groups, which can be overridden with RATE_LIMIT_<GROUP>
+	limiter, err := ratelimit.NewLimiter("tessera:rate-limit:")
+	if err != nil {
+		panic("Failed to create rate limiter: " + err.Error())
+	}
+	ticketRequestUserLimiter := middleware.NewRateLimiterMiddleware(limiter, "ticket_requests", ratelimit.Limit{Rate: 2, Burst: 5}, middleware.KeyByUser)
+	ticketRequestEventLimiter := middleware.NewRateLimiterMiddleware(limiter, "ticket_requests_event", ratelimit.Limit{Rate: 100, Burst: 300}, middleware.KeyByEvent)
+	promoCodeLimiter := middleware.NewRateLimiterMiddleware(limiter, "promo_codes", ratelimit.Limit{Rate: 1, Burst: 10}, middleware.KeyByIP)
+	paymentLimiter := middleware.NewRateLimiterMiddleware(limiter, "payments", ratelimit.Limit{Rate: 1, Burst: 5}, middleware.KeyByUser)
+
+	appleWallet, err := wallet.AppleConfigFromEnv()
+	if err != nil {
+		panic("Failed to load Apple Wallet configuration: " + err.Error())
+	}
+	googleWallet, err := wallet.GoogleConfigFromEnv()
+	if err != nil {
+		panic("Failed to load Google Wallet configuration: " + err.Error())
+	}
+	walletPassService := services.NewWalletPassService(db, appleWallet, googleWallet)
+
+	checkInFeed, err := checkinfeed.NewBroker("tessera:check-in:")
+	if err != nil {
+		panic("Failed to create check-in feed: " + err.Error())
+	}
+
+	waitingRoomStore, err := waitingroom.NewStore("tessera:waiting-room:")
+	if err != nil {
+		panic("Failed to create waiting room store: " + err.Error())
+	}
+	waitingRoomService := services.NewWaitingRoomService(db, waitingRoomStore)
+
+	promoCodeAttemptStore, err := ratelimit.NewAttemptStore("tessera:promo-code-attempts:")
+	if err != nil {
+		panic("Failed to create promo code attempt store: " + err.Error())
+	}
+	promoCodeService := services.NewPromoCodeService(db, ratelimit.NewAttemptLimiter(promoCodeAttemptStore, ratelimit.DefaultLockoutPolicy))
 
 	organizationController := controllers.NewOrganizationController(db, organizationService)
 	ticketReleaseMethodsController := controllers.NewTicketReleaseMethodsController(db)
 	ticketReleaseController := controllers.NewTicketReleaseController(db)
-	ticketReleasePromoCodeController := controllers.NewTicketReleasePromoCodeController(db)
+	ticketReleasePromoCodeController := controllers.NewTicketReleasePromoCodeController(db, promoCodeService)
 	ticketTypeController := controllers.NewTicketTypeController(db)
 	organizationUsersController := controllers.NewOrganizationUsersController(db, organizationService)
 	userFoodPreferenceController := controllers.NewUserFoodPreferenceController(db)
-	ticketRequestController := controllers.NewTicketRequestController(db)
+	ticketRequestController := controllers.NewTicketRequestController(db, waitingRoomService)
 	allocateTicketsController := controllers.NewAllocateTicketsController(db, allocateTicketsService)
-	ticketsController := controllers.NewTicketController(db)
+	lotteryDrawController := controllers.NewLotteryDrawController(db, lotteryDrawService)
+	allocationRunController := controllers.NewAllocationRunController(db, allocationRunService)
+	ticketsController := controllers.NewTicketController(db, checkInFeed)
+	ticketTransferController := controllers.NewTicketTransferController(db, ticketTransferService)
+	ticketResaleController := controllers.NewTicketResaleController(db, ticketResaleService)
 	constantOptionsController := controllers.NewConstantOptionsController(db)
 	paymentsController := controllers.NewPaymentController(db)
 	notificationController := controllers.NewNotificationController(db)
@@ -126,9 +185,18 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 	addOnController := controllers.NewAddOnController(db)
 	eventSiteVistsController := controllers.NewSitVisitsController(db)
 	bankingController := controllers.NewBankingController(bankingService)
+	webhookEventController := controllers.NewWebhookEventController(db, paymentService)
+	orderController := controllers.NewOrderController(db, paymentService)
+	discountCodeController := controllers.NewDiscountCodeController(db, discountCodeService)
+	waitingRoomController := controllers.NewWaitingRoomController(db, waitingRoomService)
+	ticketSigningController := controllers.NewTicketSigningController(db, services.NewTicketSigningService(db))
+	walletPassController := controllers.NewWalletPassController(db, walletPassService)
+	ticketPDFController := controllers.NewTicketPDFController(db, services.NewTicketPDFService(db))
+	checkInController := controllers.NewCheckInController(db, services.NewCheckInService(db, checkInFeed))
 
 	r.GET("/ticket-release/constants", constantOptionsController.ListTicketReleaseConstants)
 	r.POST("/tickets/payment-webhook", paymentsController.PaymentWebhook)
+	r.POST("/payments/:provider/callback", paymentsController.PaymentCallback)
 
 	r.POST("/preferred-email/verify", preferredEmailController.Verify)
 
@@ -212,26 +280,61 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		ticketReleaseReminderController.DeleteTicketReleaseReminder)
 
 	// Promo code routes
-	r.GET("/activate-promo-code/:eventID", ticketReleasePromoCodeController.Create)
+	r.GET("/activate-promo-code/:eventID", promoCodeLimiter.MiddlewareFunc(), ticketReleasePromoCodeController.Create)
+	r.GET("/events/:eventID/ticket-release/:ticketReleaseID/promo-codes",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketReleasePromoCodeController.ListPromoCodes)
+	r.POST("/events/:eventID/ticket-release/:ticketReleaseID/promo-codes",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketReleasePromoCodeController.CreatePromoCode)
+	r.PUT("/events/:eventID/ticket-release/:ticketReleaseID/promo-codes/:promoCodeID",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketReleasePromoCodeController.UpdatePromoCode)
+	r.DELETE("/events/:eventID/ticket-release/:ticketReleaseID/promo-codes/:promoCodeID",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketReleasePromoCodeController.DeletePromoCode)
+	r.GET("/events/:eventID/ticket-release/:ticketReleaseID/promo-code-unlocks",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketReleasePromoCodeController.ListPromoCodeUnlocks)
+	r.GET("/events/:eventID/promo-code-attempts",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketReleasePromoCodeController.GetPromoCodeAttempts)
 
 	// Allocate tickets routes
 	r.POST("/events/:eventID/ticket-release/:ticketReleaseID/allocate-tickets", middleware.AuthorizeEventAccess(db, models.OrganizationMember), allocateTicketsController.AllocateTickets)
 	r.GET("/events/:eventID/ticket-release/:ticketReleaseID/allocate-tickets", middleware.AuthorizeEventAccess(db, models.OrganizationMember), allocateTicketsController.ListAllocatedTickets)
+	r.POST("/events/:eventID/ticket-release/:ticketReleaseID/allocate-tickets/preview", middleware.AuthorizeEventAccess(db, models.OrganizationMember), allocateTicketsController.PreviewAllocateTickets)
+	r.GET("/events/:eventID/ticket-release/:ticketReleaseID/allocation-runs", middleware.AuthorizeEventAccess(db, models.OrganizationMember), allocationRunController.ListAllocationRuns)
+	r.POST("/events/:eventID/ticket-release/:ticketReleaseID/allocation-runs/:allocationRunID/undo", middleware.AuthorizeEventAccess(db, models.OrganizationOwner), allocationRunController.UndoAllocationRun)
 	r.POST("/events/:eventID/ticket-requests/:ticketRequestID/allocate",
 		middleware.AuthorizeEventAccess(db, models.OrganizationMember),
 		allocateTicketsController.SelectivelyAllocateTicketRequest)
 
-	rlm := NewRateLimiterMiddleware(2, 5) // For example, 1 request per second with a burst of 5
+	// Lottery draw routes, open to all users so that attendees can verify the draw
+	r.GET("/events/:eventID/ticket-release/:ticketReleaseID/lottery-draw", lotteryDrawController.GetLotteryDraw)
+	r.POST("/events/:eventID/ticket-release/:ticketReleaseID/lottery-draw/verify", lotteryDrawController.VerifyLotteryDraw)
+
+	// Waiting room routes
+	r.PUT("/events/:eventID/ticket-release/:ticketReleaseID/waiting-room",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), waitingRoomController.SetWaitingRoom)
+	r.DELETE("/events/:eventID/ticket-release/:ticketReleaseID/waiting-room",
+		middleware.AuthorizeEventAccess(db, models.OrganizationMember), waitingRoomController.RemoveWaitingRoom)
+	r.POST("/events/:eventID/ticket-release/:ticketReleaseID/waiting-room/join", waitingRoomController.JoinWaitingRoom)
+	r.GET("/events/:eventID/ticket-release/:ticketReleaseID/waiting-room", waitingRoomController.GetWaitingRoomStatus)
 
 	// Ticket request event routes
 	r.GET("/events/:eventID/ticket-requests", ticketRequestController.Get)
-	r.POST("/events/:eventID/ticket-requests", rlm.MiddlewareFunc(), ticketRequestController.Create)
+	r.POST("/events/:eventID/ticket-requests",
+		ticketRequestUserLimiter.MiddlewareFunc(),
+		ticketRequestEventLimiter.MiddlewareFunc(),
+		ticketRequestController.Create)
 	r.DELETE("/events/:eventID/ticket-requests/:ticketRequestID", ticketRequestController.CancelTicketRequest)
 	r.PUT("/ticket-releases/:ticketReleaseID/ticket-requests/:ticketRequestID/add-ons", ticketRequestController.UpdateAddOns)
 
 	// Ticket events routes
 	r.GET("/events/:eventID/tickets", middleware.AuthorizeEventAccess(db, models.OrganizationMember), eventController.ListTickets)
-	r.POST("/events/:eventID/tickets/qr-check-in", middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketsController.QrCodeCheckIn)
+	r.POST("/events/:eventID/tickets/qr-check-in", middleware.AuthorizeEventAccess(db, models.OrganizationMember), checkInController.QrCodeCheckIn)
+
+	// Check-in, scanners verify signed QR codes offline with the public key of the event
+	r.GET("/events/:eventID/check-in/public-key", middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketSigningController.GetPublicKey)
+	r.POST("/events/:eventID/check-in/offline", middleware.AuthorizeEventAccess(db, models.OrganizationMember), checkInController.ReconcileOfflineCheckIns)
+	r.GET("/events/:eventID/check-in/stream", middleware.AuthorizeEventAccess(db, models.OrganizationMember), checkInController.Stream)
+	r.GET("/events/:eventID/check-in/logs", middleware.AuthorizeEventAccess(db, models.OrganizationMember), checkInController.ListCheckInLogs)
+	r.DELETE("/events/:eventID/tickets/:ticketID/check-in", middleware.AuthorizeEventAccess(db, models.OrganizationMember), checkInController.UndoCheckIn)
 
 	// My tickets
 	r.GET("/my-ticket-requests", ticketRequestController.UsersList)
@@ -239,6 +342,23 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 
 	// Ticket routes
 	r.DELETE("/my-tickets/:ticketID", ticketsController.CancelTicket)
+	r.PUT("/my-tickets/:ticketID/guest-name", ticketsController.UpdateGuestName)
+	r.GET("/my-tickets/:ticketID/qr-code", ticketSigningController.SignTicket)
+	r.GET("/my-tickets/:ticketID/pass", walletPassController.GetPass)
+	r.GET("/my-tickets/:ticketID/pdf", ticketPDFController.GetTicketPDF)
+	r.POST("/my-tickets/:ticketID/add-ons/:ticketAddOnID/refund", ticketsController.RefundAddOn)
+
+	// Ticket transfers
+	r.POST("/my-tickets/:ticketID/transfer", ticketTransferController.InitiateTransfer)
+	r.GET("/my-ticket-transfers", ticketTransferController.ListTransfers)
+	r.POST("/my-ticket-transfers/:transferID/accept", ticketTransferController.AcceptTransfer)
+	r.POST("/my-ticket-transfers/:transferID/decline", ticketTransferController.DeclineTransfer)
+	r.POST("/my-ticket-transfers/:transferID/cancel", ticketTransferController.CancelTransfer)
+
+	// Ticket resale
+	r.POST("/my-tickets/:ticketID/resale", ticketResaleController.ListTicket)
+	r.GET("/my-ticket-resales", ticketResaleController.ListResales)
+	r.POST("/my-ticket-resales/:resaleID/cancel", ticketResaleController.CancelResale)
 
 	// send outs
 	r.GET("/events/:eventID/send-outs", middleware.AuthorizeEventAccess(db, models.OrganizationMember), sendOutcontroller.GetEventSendOuts)
@@ -247,9 +367,20 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 	// Ticket routes
 	r.GET("/events/:eventID/tickets/:ticketID", middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketsController.GetTicket)
 	r.PUT("/events/:eventID/tickets/:ticketID", middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketsController.UpdateTicket)
-	r.GET("/tickets/:ticketID/create-payment-intent", paymentsController.CreatePaymentIntent)
+	r.GET("/tickets/:ticketID/create-payment-intent", paymentLimiter.MiddlewareFunc(), paymentsController.CreatePaymentIntent)
+
+	// Orders paying for several tickets at once
+	r.POST("/orders", paymentLimiter.MiddlewareFunc(), orderController.CreateOrder)
+	r.GET("/orders", orderController.ListOrders)
+	r.GET("/orders/:orderID", orderController.GetOrder)
 	r.PUT("/events/:eventID/ticket-requests/:ticketRequestID/change-ticket-type", middleware.AuthorizeEventAccess(db, models.OrganizationMember), ticketsController.UpdateTicketType)
 
+	// Discount codes
+	r.GET("/events/:eventID/discount-codes", middleware.AuthorizeEventAccess(db, models.OrganizationMember), discountCodeController.ListDiscountCodes)
+	r.POST("/events/:eventID/discount-codes", middleware.AuthorizeEventAccess(db, models.OrganizationMember), discountCodeController.CreateDiscountCode)
+	r.PUT("/events/:eventID/discount-codes/:discountCodeID", middleware.AuthorizeEventAccess(db, models.OrganizationMember), discountCodeController.UpdateDiscountCode)
+	r.DELETE("/events/:eventID/discount-codes/:discountCodeID", middleware.AuthorizeEventAccess(db, models.OrganizationMember), discountCodeController.DeleteDiscountCode)
+
 	// Sales report
 	r.POST("/events/:eventID/sales-report", middleware.AuthorizeEventAccess(db, models.OrganizationMember), salesReportController.GenerateSalesReport)
 	r.GET("/events/:eventID/sales-report", middleware.AuthorizeEventAccess(db, models.OrganizationMember), salesReportController.ListSalesReport)
@@ -286,6 +417,12 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 
 	r.POST("/admin/create-user", authentication.RequireRole("super_admin", db), userController.CreateUser)
 
+	// Webhook events
+	r.GET("/admin/webhook-events", authentication.RequireRole("super_admin", db), webhookEventController.ListWebhookEvents)
+	r.POST("/admin/webhook-events/replay", authentication.RequireRole("super_admin", db), webhookEventController.ReplayWebhookEvents)
+	r.GET("/admin/webhook-events/:eventID", authentication.RequireRole("super_admin", db), webhookEventController.GetWebhookEvent)
+	r.POST("/admin/webhook-events/:eventID/replay", authentication.RequireRole("super_admin", db), webhookEventController.ReplayWebhookEvent)
+
 	// Banking details
 	r.GET("/organizations/:organizationID/banking-details", middleware.AuthorizeOrganizationRole(db, models.OrganizationMember), bankingController.GetBankingDetails)
 	r.POST("/organizations/:organizationID/banking-details", middleware.AuthorizeOrganizationRole(db, models.OrganizationOwner), bankingController.SubmitBankingDetails)
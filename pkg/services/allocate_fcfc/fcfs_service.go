@@ -24,7 +24,13 @@ func AllocateFCFSTickets(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*m
 		return nil, errors.New("no ticket requests to allocate")
 	}
 
-	var numberOfTicketsAllocated int = 0
+	// Remaining tickets of the ticket release and of the ticket types with a capacity
+	capacity, err := allocate_service.LoadTicketCapacity(tx, ticketRelease)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	var tickets []*models.Ticket
 
 	for _, ticketRequest := range allTicketRequests {
@@ -33,23 +39,13 @@ func AllocateFCFSTickets(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*m
 			continue
 		}
 
-		var ticket *models.Ticket
-		if numberOfTicketsAllocated >= ticketRelease.TicketsAvailable {
-			ticket, err = allocate_service.AllocateReserveTicket(ticketRequest,
-				uint(numberOfTicketsAllocated-ticketRelease.TicketsAvailable),
-				tx)
-		} else {
-			ticket, err = allocate_service.AllocateTicket(ticketRequest, tx)
-		}
-
+		requestTickets, err := allocate_service.AllocateTicketOrReserve(ticketRequest, capacity, tx)
 		if err != nil {
 			tx.Rollback()
 			return nil, err
 		}
 
-		numberOfTicketsAllocated++
-
-		tickets = append(tickets, ticket)
+		tickets = append(tickets, requestTickets...)
 	}
 
 	return tickets, nil
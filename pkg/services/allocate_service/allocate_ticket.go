@@ -10,19 +10,23 @@ import (
 	"gorm.io/gorm"
 )
 
-func AllocateFreeTicket(ticketRequest models.TicketRequest, tx *gorm.DB) (*models.Ticket, error) {
-	var qrCode string = utils.GenerateRandomString(16)
-
-	ticket := models.Ticket{
-		TicketRequestID: ticketRequest.ID,
-		IsReserve:       false,
-		UserUGKthID:     ticketRequest.UserUGKthID,
-		IsPaid:          true,
-		QrCode:          qrCode,
-	}
+// createTicketsForRequest creates one ticket for every ticket in the request, each with its own
+// QR code and guest name, and marks the request as handled. The tickets are created from the
+// template so that the whole group is allocated or put on the reserve list together.
+func createTicketsForRequest(ticketRequest models.TicketRequest, template models.Ticket, tx *gorm.DB) ([]*models.Ticket, error) {
+	var tickets []*models.Ticket
+	for i := 0; i < ticketRequest.NumberOfTickets(); i++ {
+		ticket := template
+		ticket.TicketRequestID = ticketRequest.ID
+		ticket.UserUGKthID = ticketRequest.UserUGKthID
+		ticket.QrCode = utils.GenerateRandomString(16)
+		ticket.GuestName = ticketRequest.GuestName(i)
+
+		if err := tx.Create(&ticket).Error; err != nil {
+			return nil, err
+		}
 
-	if err := tx.Create(&ticket).Error; err != nil {
-		return nil, err
+		tickets = append(tickets, &ticket)
 	}
 
 	ticketRequest.IsHandled = true
@@ -30,16 +34,22 @@ func AllocateFreeTicket(ticketRequest models.TicketRequest, tx *gorm.DB) (*model
 		return nil, err
 	}
 
-	// Set the TicketID in the ticketRequest.TicketAddOn.TicketID to the ticket.ID
-
-	if err := tx.Model(&models.TicketAddOn{}).Where("ticket_request_id = ?", ticketRequest.ID).Update("ticket_id", ticket.ID).Error; err != nil {
+	// The add-ons are paid for together with the first ticket of the request
+	if err := tx.Model(&models.TicketAddOn{}).Where("ticket_request_id = ?", ticketRequest.ID).Update("ticket_id", tickets[0].ID).Error; err != nil {
 		return nil, err
 	}
 
-	return &ticket, nil
+	return tickets, nil
 }
 
-func AllocateTicket(ticketRequest models.TicketRequest, tx *gorm.DB) (*models.Ticket, error) {
+func AllocateFreeTicket(ticketRequest models.TicketRequest, tx *gorm.DB) ([]*models.Ticket, error) {
+	return createTicketsForRequest(ticketRequest, models.Ticket{
+		IsReserve: false,
+		IsPaid:    true,
+	}, tx)
+}
+
+func AllocateTicket(ticketRequest models.TicketRequest, tx *gorm.DB) ([]*models.Ticket, error) {
 	if ticketRequest.TicketRelease.PaymentDeadline == nil {
 		return nil, errors.New("no payment deadline specified")
 	}
@@ -61,73 +71,31 @@ func AllocateTicket(ticketRequest models.TicketRequest, tx *gorm.DB) (*models.Ti
 
 	// If the price of the ticket is 0, set it to have been paid
 	if ticketRequest.TicketType.Price == 0 && ticketRequest.TicketType.ID != 0 {
-		ticket, err := AllocateFreeTicket(ticketRequest, tx)
-
+		tickets, err := AllocateFreeTicket(ticketRequest, tx)
 		if err != nil {
 			return nil, err
 		}
 
-		return ticket, nil
+		return tickets, nil
 	}
 
-	var qrCode string = utils.GenerateRandomString(16)
 	now := time.Now()
-
-	ticket := models.Ticket{
-		TicketRequestID: ticketRequest.ID,
+	return createTicketsForRequest(ticketRequest, models.Ticket{
 		IsReserve:       false,
-		UserUGKthID:     ticketRequest.UserUGKthID,
 		IsPaid:          false,
-		QrCode:          qrCode,
 		PurchasableAt:   &now,
 		PaymentDeadline: &paymentDeadline.OriginalDeadline,
-	}
-
-	if err := tx.Create(&ticket).Error; err != nil {
-		return nil, err
-	}
-
-	ticketRequest.IsHandled = true
-	if err := tx.Save(&ticketRequest).Error; err != nil {
-		return nil, err
-	}
-
-	// Set the TicketID in the ticketRequest.TicketAddOn.TicketID to the ticket.ID
-
-	if err := tx.Model(&models.TicketAddOn{}).Where("ticket_request_id = ?", ticketRequest.ID).Update("ticket_id", ticket.ID).Error; err != nil {
-		return nil, err
-	}
-
-	return &ticket, nil
+	}, tx)
 }
 
+// AllocateReserveTicket puts the request on the reserve list, all tickets of
+// the request share the reserve number
 func AllocateReserveTicket(
 	ticketRequest models.TicketRequest,
 	reserveNumber uint,
-	tx *gorm.DB) (*models.Ticket, error) {
-	ticketRequest.IsHandled = true
-	if err := tx.Save(&ticketRequest).Error; err != nil {
-		return nil, err
-	}
-
-	qrCode := utils.GenerateRandomString(16)
-
-	ticket := models.Ticket{
-		TicketRequestID: ticketRequest.ID,
-		ReserveNumber:   reserveNumber,
-		IsReserve:       true,
-		UserUGKthID:     ticketRequest.UserUGKthID,
-		QrCode:          qrCode,
-	}
-
-	if err := tx.Create(&ticket).Error; err != nil {
-		return nil, err
-	}
-
-	// S	et the TicketID in the ticketRequest.TicketAddOn.TicketID to the ticket.ID
-	if err := tx.Model(&models.TicketAddOn{}).Where("ticket_request_id = ?", ticketRequest.ID).Update("ticket_id", ticket.ID).Error; err != nil {
-		return nil, err
-	}
-
-	return &ticket, nil
+	tx *gorm.DB) ([]*models.Ticket, error) {
+	return createTicketsForRequest(ticketRequest, models.Ticket{
+		ReserveNumber: reserveNumber,
+		IsReserve:     true,
+	}, tx)
 }
@@ -0,0 +1,155 @@
+package allocate_service
+
+import (
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"gorm.io/gorm"
+)
+
+// TicketCapacity keeps track of how many tickets that can still be allocated in a
+// ticket release, both in total and for the ticket types that have a capacity.
+// Reserve numbers are handed out per ticket type.
+type TicketCapacity struct {
+	Remaining      int          // Tickets left in the ticket release
+	TypeRemaining  map[uint]int // Tickets left of the ticket types that have a capacity
+	reserveNumbers map[uint]uint
+}
+
+// NewTicketCapacity creates a tracker from already known remaining tickets
+func NewTicketCapacity(remaining int, typeRemaining map[uint]int) *TicketCapacity {
+	if typeRemaining == nil {
+		typeRemaining = map[uint]int{}
+	}
+
+	return &TicketCapacity{
+		Remaining:      remaining,
+		TypeRemaining:  typeRemaining,
+		reserveNumbers: map[uint]uint{},
+	}
+}
+
+// LoadTicketCapacity creates a tracker for the ticket release, tickets that have
+// already been allocated and not deleted are subtracted from the capacity
+func LoadTicketCapacity(tx *gorm.DB, ticketRelease *models.TicketRelease) (*TicketCapacity, error) {
+	var ticketTypes []models.TicketType
+	if err := tx.Where("ticket_release_id = ?", ticketRelease.ID).Find(&ticketTypes).Error; err != nil {
+		return nil, err
+	}
+
+	allocatedPerType, err := countAllocatedTicketsPerType(tx, ticketRelease.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var allocated int
+	for _, count := range allocatedPerType {
+		allocated += count
+	}
+
+	typeRemaining := map[uint]int{}
+	for _, ticketType := range ticketTypes {
+		if ticketType.HasCapacity() {
+			typeRemaining[ticketType.ID] = *ticketType.Capacity - allocatedPerType[ticketType.ID]
+		}
+	}
+
+	capacity := NewTicketCapacity(ticketRelease.TicketsAvailable-allocated, typeRemaining)
+
+	// Continue the reserve lists that already exist
+	var reserves []struct {
+		TicketTypeID  uint
+		ReserveNumber uint
+	}
+	if err := tx.Model(&models.Ticket{}).
+		Select("ticket_requests.ticket_type_id, MAX(tickets.reserve_number) AS reserve_number").
+		Joins("JOIN ticket_requests ON tickets.ticket_request_id = ticket_requests.id").
+		Where("ticket_requests.ticket_release_id = ? AND tickets.is_reserve = ?", ticketRelease.ID, true).
+		Group("ticket_requests.ticket_type_id").
+		Scan(&reserves).Error; err != nil {
+		return nil, err
+	}
+
+	for _, reserve := range reserves {
+		capacity.reserveNumbers[reserve.TicketTypeID] = reserve.ReserveNumber
+	}
+
+	return capacity, nil
+}
+
+func countAllocatedTicketsPerType(tx *gorm.DB, ticketReleaseID uint) (map[uint]int, error) {
+	var counts []struct {
+		TicketTypeID uint
+		Count        int
+	}
+	if err := tx.Model(&models.Ticket{}).
+		Select("ticket_requests.ticket_type_id, COUNT(*) AS count").
+		Joins("JOIN ticket_requests ON tickets.ticket_request_id = ticket_requests.id").
+		Where("ticket_requests.ticket_release_id = ? AND tickets.is_reserve = ?", ticketReleaseID, false).
+		Group("ticket_requests.ticket_type_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	allocated := map[uint]int{}
+	for _, count := range counts {
+		allocated[count.TicketTypeID] = count.Count
+	}
+
+	return allocated, nil
+}
+
+// CanAllocate returns true if amount tickets of the ticket type can be allocated
+func (tc *TicketCapacity) CanAllocate(ticketTypeID uint, amount int) bool {
+	if tc.Remaining < amount {
+		return false
+	}
+
+	return tc.CanAllocateType(ticketTypeID, amount)
+}
+
+// CanAllocateType only checks the capacity of the ticket type, not the ticket release
+func (tc *TicketCapacity) CanAllocateType(ticketTypeID uint, amount int) bool {
+	if remaining, ok := tc.TypeRemaining[ticketTypeID]; ok && remaining < amount {
+		return false
+	}
+
+	return true
+}
+
+// Allocate uses amount tickets of the ticket type
+func (tc *TicketCapacity) Allocate(ticketTypeID uint, amount int) {
+	tc.Remaining -= amount
+	if _, ok := tc.TypeRemaining[ticketTypeID]; ok {
+		tc.TypeRemaining[ticketTypeID] -= amount
+	}
+}
+
+// Free gives back amount tickets of the ticket type
+func (tc *TicketCapacity) Free(ticketTypeID uint, amount int) {
+	tc.Remaining += amount
+	if _, ok := tc.TypeRemaining[ticketTypeID]; ok {
+		tc.TypeRemaining[ticketTypeID] += amount
+	}
+}
+
+// NextReserveNumber returns the next reserve number on the reserve list of the ticket type
+func (tc *TicketCapacity) NextReserveNumber(ticketTypeID uint) uint {
+	tc.reserveNumbers[ticketTypeID]++
+	return tc.reserveNumbers[ticketTypeID]
+}
+
+// AllocateTicketOrReserve allocates the tickets of the request if there is capacity left for all of
+// them, otherwise the whole request is put on the reserve list of its ticket type
+func AllocateTicketOrReserve(ticketRequest models.TicketRequest, capacity *TicketCapacity, tx *gorm.DB) ([]*models.Ticket, error) {
+	amount := ticketRequest.NumberOfTickets()
+	if capacity.CanAllocate(ticketRequest.TicketTypeID, amount) {
+		tickets, err := AllocateTicket(ticketRequest, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		capacity.Allocate(ticketRequest.TicketTypeID, amount)
+		return tickets, nil
+	}
+
+	return AllocateReserveTicket(ticketRequest, capacity.NextReserveNumber(ticketRequest.TicketTypeID), tx)
+}
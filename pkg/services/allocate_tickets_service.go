@@ -3,19 +3,15 @@ package services
 import (
 	"errors"
 	"fmt"
-	"math/rand"
-	"time"
+	"sort"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
-	allocate_fcfs "github.com/DowLucas/gin-ticket-release/pkg/services/allocate_fcfc"
 	"github.com/DowLucas/gin-ticket-release/pkg/services/allocate_service"
 	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/DowLucas/gin-ticket-release/utils"
 	"gorm.io/gorm"
 )
 
-var r *rand.Rand
-
 type AllocateTicketsService struct {
 	DB *gorm.DB
 }
@@ -24,21 +20,7 @@ func NewAllocateTicketsService(db *gorm.DB) *AllocateTicketsService {
 	return &AllocateTicketsService{DB: db}
 }
 
-func init() {
-	seed := time.Now().UnixNano()
-	r = rand.New(rand.NewSource(seed))
-}
-
 func (ats *AllocateTicketsService) AllocateTickets(ticketRelease *models.TicketRelease, allocateTicketsRequest *types.AllocateTicketsRequest) error {
-	method := ticketRelease.TicketReleaseMethodDetail.TicketReleaseMethod
-	var tickets []*models.Ticket
-	var err error
-
-	if method.MethodName == "" {
-		// Raise error
-		return errors.New("no method name specified")
-	}
-
 	// Check if allocation has already been done
 	if ticketRelease.HasAllocatedTickets {
 		return errors.New("tickets already allocated")
@@ -55,124 +37,177 @@ func (ats *AllocateTicketsService) AllocateTickets(ticketRelease *models.TicketR
 		}
 	}()
 
-	if allocateTicketsRequest != nil {
-		var paymentDeadline models.TicketReleasePaymentDeadline = models.TicketReleasePaymentDeadline{
-			TicketReleaseID:        ticketRelease.ID,
-			OriginalDeadline:       allocateTicketsRequest.OriginalDeadline,
-			ReservePaymentDuration: &allocateTicketsRequest.CalculatedDuration,
-		}
+	previousClose := ticketRelease.Close
 
-		if !paymentDeadline.Validate(ticketRelease) {
-			tx.Rollback()
-			return errors.New("invalid payment deadline")
-		}
+	allocator, tickets, err := runAllocation(tx, ticketRelease, allocateTicketsRequest)
+	if err != nil {
+		fmt.Println(err)
+		tx.Rollback()
+		return err
+	}
 
-		if err := ats.DB.Create(&paymentDeadline).Error; err != nil {
+	run, err := createAllocationRun(tx, ticketRelease, tickets, previousClose)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(tickets) > 0 {
+		// Notify the users that the tickets have been allocated
+		if err := allocator.Notify(tx, ticketRelease, tickets, run); err != nil {
 			tx.Rollback()
 			return err
 		}
+	}
 
-		ticketRelease.PaymentDeadline = &paymentDeadline
+	return tx.Commit().Error
+}
+
+// PreviewAllocateTickets runs the allocator of the ticket release in a transaction
+// that is always rolled back, nothing is stored and no one is notified.
+// The lottery seed is the exception, it is stored so that the allocation draws
+// the same lottery as the preview as long as the ticket requests are unchanged
+func (ats *AllocateTicketsService) PreviewAllocateTickets(ticketRelease *models.TicketRelease, allocateTicketsRequest *types.AllocateTicketsRequest) (*types.AllocationPreview, error) {
+	if ticketRelease.HasAllocatedTickets {
+		return nil, errors.New("tickets already allocated")
 	}
 
-	if err := tx.Model(ticketRelease).Update("has_allocated_tickets", true).Error; err != nil {
-		tx.Rollback()
-		return err
+	// Work on a copy, the allocation sets fields on the ticket release
+	release := *ticketRelease
+
+	if release.TicketReleaseMethodDetail.TicketReleaseMethod.MethodName == string(models.FCFS_LOTTERY) {
+		if _, err := lotterySeed(ats.DB, &release); err != nil {
+			return nil, err
+		}
 	}
 
-	switch method.MethodName {
-	case string(models.FCFS_LOTTERY):
-		tickets, err = ats.allocateFCFSLotteryTickets(ticketRelease, tx)
+	tx := ats.DB.Begin()
+	defer tx.Rollback()
 
-		if err != nil {
-			tx.Rollback()
-			return err
+	_, tickets, err := runAllocation(tx, &release, allocateTicketsRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &types.AllocationPreview{
+		Tickets: make([]types.AllocationPreviewTicket, 0, len(tickets)),
+	}
+
+	if release.PaymentDeadline != nil {
+		preview.PaymentDeadline = &release.PaymentDeadline.OriginalDeadline
+	}
+
+	for _, ticket := range tickets {
+		var ticketRequest models.TicketRequest
+		if err := tx.Preload("TicketType").
+			Preload("TicketAddOns.AddOn").
+			First(&ticketRequest, ticket.TicketRequestID).Error; err != nil {
+			return nil, err
 		}
 
-		if len(tickets) > 0 {
-			// Notify the users that the tickets have been allocated
-			for _, ticket := range tickets {
-				var err error
-				if !ticket.IsReserve {
-					err = Notify_TicketAllocationCreated(tx,
-						int(ticket.ID),
-						&ticketRelease.PaymentDeadline.OriginalDeadline)
-				} else {
-					err = Notify_ReserveTicketAllocationCreated(tx, int(ticket.ID))
-				}
-
-				if err != nil {
-					tx.Rollback()
-					fmt.Println(err)
-					return err
-				}
+		// The add-ons are paid for together with the first ticket of the request
+		price := ticketRequest.TicketType.Price
+		for _, ticketAddOn := range ticketRequest.TicketAddOns {
+			if ticketAddOn.TicketID != nil && *ticketAddOn.TicketID == ticket.ID {
+				price += ticketAddOn.AddOn.Price * float64(ticketAddOn.Quantity)
 			}
 		}
-	case string(models.RESERVED_TICKET_RELEASE):
-		tickets, err = ats.allocateReservedTickets(ticketRelease, tx)
 
-		if err != nil {
-			tx.Rollback()
-			return err
+		preview.Tickets = append(preview.Tickets, types.AllocationPreviewTicket{
+			TicketRequestID: ticketRequest.ID,
+			UserUGKthID:     ticket.UserUGKthID,
+			TicketTypeID:    ticketRequest.TicketTypeID,
+			TicketTypeName:  ticketRequest.TicketType.Name,
+			IsReserve:       ticket.IsReserve,
+			ReserveNumber:   ticket.ReserveNumber,
+			Price:           price,
+		})
+
+		if ticket.IsReserve {
+			preview.NumberOfReserves++
+		} else {
+			preview.NumberOfTickets++
+			preview.ProjectedRevenue += price
 		}
+	}
 
-		if len(tickets) > 0 {
-			// Notify the users that the tickets have been allocated
-			for _, ticket := range tickets {
-				var err error
-				if !ticket.IsReserve {
-					err = Notify_TicketAllocationCreated(tx, int(ticket.ID), nil)
-				} else {
-					err = Notify_ReserveTicketAllocationCreated(tx, int(ticket.ID))
-				}
-
-				if err != nil {
-					tx.Rollback()
-					fmt.Println(err)
-					return err
-				}
-			}
+	return preview, nil
+}
+
+// runAllocation creates the payment deadline, marks the ticket release as allocated and
+// runs its allocator in tx, the caller decides whether to commit and notify
+func runAllocation(tx *gorm.DB, ticketRelease *models.TicketRelease, allocateTicketsRequest *types.AllocateTicketsRequest) (Allocator, []*models.Ticket, error) {
+	method := ticketRelease.TicketReleaseMethodDetail.TicketReleaseMethod
+
+	allocator, err := GetAllocator(method.MethodName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if allocateTicketsRequest != nil {
+		var paymentDeadline models.TicketReleasePaymentDeadline = models.TicketReleasePaymentDeadline{
+			TicketReleaseID:        ticketRelease.ID,
+			OriginalDeadline:       allocateTicketsRequest.OriginalDeadline,
+			ReservePaymentDuration: &allocateTicketsRequest.CalculatedDuration,
 		}
-	case string(models.FCFS):
-		tickets, err := allocate_fcfs.AllocateFCFSTickets(ticketRelease, tx)
 
-		if err != nil {
-			fmt.Println(err)
-			tx.Rollback()
-			return err
+		if !paymentDeadline.Validate(ticketRelease) {
+			return nil, nil, errors.New("invalid payment deadline")
 		}
 
-		if len(tickets) > 0 {
-			// Notify the users that the tickets have been allocated
-			for _, ticket := range tickets {
-				var err error
-				if !ticket.IsReserve {
-					err = Notify_TicketAllocationCreated(tx, int(ticket.ID), &ticketRelease.PaymentDeadline.OriginalDeadline)
-				} else {
-					err = Notify_ReserveTicketAllocationCreated(tx, int(ticket.ID))
-				}
-
-				if err != nil {
-					tx.Rollback()
-					fmt.Println(err)
-					return err
-				}
-			}
+		if err := tx.Create(&paymentDeadline).Error; err != nil {
+			return nil, nil, err
 		}
 
-	default:
-		tx.Rollback()
-		return errors.New("unknown ticket release method")
+		ticketRelease.PaymentDeadline = &paymentDeadline
 	}
 
-	return tx.Commit().Error
+	if err := tx.Model(ticketRelease).Update("has_allocated_tickets", true).Error; err != nil {
+		return nil, nil, err
+	}
+
+	tickets, err := allocator.Allocate(ticketRelease, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return allocator, tickets, nil
+}
+
+// lotterySeed returns the lottery seed of the ticket release and draws one if it has none.
+// Two callers without a seed can race, the conditional update keeps the first seed
+func lotterySeed(db *gorm.DB, ticketRelease *models.TicketRelease) (int64, error) {
+	if ticketRelease.LotterySeed != nil {
+		return *ticketRelease.LotterySeed, nil
+	}
+
+	seed, err := newLotteryDrawSeed()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.Model(&models.TicketRelease{}).
+		Where("id = ? AND lottery_seed IS NULL", ticketRelease.ID).
+		Update("lottery_seed", seed).Error; err != nil {
+		return 0, err
+	}
+
+	var stored models.TicketRelease
+	if err := db.Select("id", "lottery_seed").First(&stored, ticketRelease.ID).Error; err != nil {
+		return 0, err
+	}
+
+	if stored.LotterySeed == nil {
+		return 0, errors.New("lottery seed was not stored")
+	}
+
+	ticketRelease.LotterySeed = stored.LotterySeed
+	return *stored.LotterySeed, nil
 }
 
-func (ats *AllocateTicketsService) allocateFCFSLotteryTickets(
+func allocateFCFSLotteryTickets(
 	ticketRelease *models.TicketRelease,
 	tx *gorm.DB) (allTickets []*models.Ticket, err error) {
-	var reserveNumber uint
-
 	methodDetail := ticketRelease.TicketReleaseMethodDetail
 
 	// Calculate the deadline for eligible requests
@@ -182,7 +217,6 @@ func (ats *AllocateTicketsService) allocateFCFSLotteryTickets(
 	allTicketRequests, err := models.GetAllValidTicketRequestsToTicketRelease(tx, ticketRelease.ID)
 
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
@@ -202,66 +236,85 @@ func (ats *AllocateTicketsService) allocateFCFSLotteryTickets(
 		}
 	}
 
-	// Fetch total available tickets directly
-	var availableTickets int = ticketRelease.TicketsAvailable
+	// Remaining tickets of the ticket release and of the ticket types with a capacity
+	capacity, err := allocate_service.LoadTicketCapacity(tx, ticketRelease)
+	if err != nil {
+		return nil, err
+	}
+
+	// The draw is made on the eligible ticket requests sorted by ID so that it
+	// can be reproduced from the seed that is stored on the draw
+	sort.Slice(eligibleTicketRequestsForLottery, func(i, j int) bool {
+		return eligibleTicketRequestsForLottery[i].ID < eligibleTicketRequestsForLottery[j].ID
+	})
+
+	eligibleRequestIDs := make([]uint, len(eligibleTicketRequestsForLottery))
+	requestTicketTypeIDs := make([]uint, len(eligibleTicketRequestsForLottery))
+	requestTicketAmounts := make([]int, len(eligibleTicketRequestsForLottery))
+	for i, ticketRequest := range eligibleTicketRequestsForLottery {
+		eligibleRequestIDs[i] = ticketRequest.ID
+		requestTicketTypeIDs[i] = ticketRequest.TicketTypeID
+		requestTicketAmounts[i] = ticketRequest.NumberOfTickets()
+	}
 
-	if len(eligibleTicketRequestsForLottery) > availableTickets {
-		rand.Shuffle(len(eligibleTicketRequestsForLottery), func(i, j int) {
-			eligibleTicketRequestsForLottery[i], eligibleTicketRequestsForLottery[j] = eligibleTicketRequestsForLottery[j], eligibleTicketRequestsForLottery[i]
-		})
-		reserveNumber = 1
-		for i := 0; i < len(eligibleTicketRequestsForLottery); i++ {
-			if i < availableTickets {
-				ticket, err := allocate_service.AllocateTicket(eligibleTicketRequestsForLottery[i], tx)
-				if err != nil {
-					return nil, err
-				}
-				allTickets = append(allTickets, ticket)
-			} else {
-				ticket, err := allocate_service.AllocateReserveTicket(eligibleTicketRequestsForLottery[i], reserveNumber, tx)
-				if err != nil {
-					return nil, err
-				}
-				reserveNumber++
-				allTickets = append(allTickets, ticket)
-			}
+	weights, err := lotteryRequestWeights(tx, ticketRelease, eligibleTicketRequestsForLottery)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := lotterySeed(tx, ticketRelease)
+	if err != nil {
+		return nil, err
+	}
+
+	draw := models.NewLotteryDraw(ticketRelease.ID, seed, eligibleRequestIDs, capacity.Remaining)
+	draw.SetPriority(methodDetail.LotteryPriorityMode, weights)
+	draw.SetTicketTypes(requestTicketTypeIDs, capacity.TypeRemaining)
+	draw.SetTicketAmounts(requestTicketAmounts)
+
+	ticketRequestByID := make(map[uint]models.TicketRequest, len(eligibleTicketRequestsForLottery))
+	for _, ticketRequest := range eligibleTicketRequestsForLottery {
+		ticketRequestByID[ticketRequest.ID] = ticketRequest
+	}
+
+	drawnRequestIDs := DrawLotteryWithPriority(seed, methodDetail.LotteryPriorityMode, eligibleRequestIDs, weights)
+
+	var winnerRequestIDs, reserveRequestIDs []uint
+	for _, ticketRequestID := range drawnRequestIDs {
+		ticketRequest := ticketRequestByID[ticketRequestID]
+		tickets, err := allocate_service.AllocateTicketOrReserve(ticketRequest, capacity, tx)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		for _, ticketRequest := range eligibleTicketRequestsForLottery {
-			ticket, err := allocate_service.AllocateTicket(ticketRequest, tx)
-			if err != nil {
-				return nil, err
-			}
-			allTickets = append(allTickets, ticket)
+		allTickets = append(allTickets, tickets...)
+
+		if tickets[0].IsReserve {
+			reserveRequestIDs = append(reserveRequestIDs, ticketRequest.ID)
+		} else {
+			winnerRequestIDs = append(winnerRequestIDs, ticketRequest.ID)
 		}
 	}
 
-	remainingTickets := availableTickets - len(eligibleTicketRequestsForLottery)
-	reserveNumber = 1
+	draw.SetResult(winnerRequestIDs, reserveRequestIDs)
+	if err := tx.Create(draw).Error; err != nil {
+		return nil, err
+	}
+
+	// Requests made after the open window get the remaining tickets or are
+	// placed after the lottery reserves
 	for _, ticketRequest := range notEligibleTicketRequests {
-		if remainingTickets > 0 {
-			ticket, err := allocate_service.AllocateTicket(ticketRequest, tx)
-			if err != nil {
-				return nil, err
-			}
-			allTickets = append(allTickets, ticket)
-			remainingTickets--
-		} else {
-			ticket, err := allocate_service.AllocateReserveTicket(ticketRequest, reserveNumber, tx)
-			if err != nil {
-				return nil, err
-			}
-			allTickets = append(allTickets, ticket)
-			reserveNumber++
+		tickets, err := allocate_service.AllocateTicketOrReserve(ticketRequest, capacity, tx)
+		if err != nil {
+			return nil, err
 		}
+		allTickets = append(allTickets, tickets...)
 	}
 
 	return allTickets, nil
 }
 
-func (ats *AllocateTicketsService) allocateReservedTickets(ticketRelease *models.TicketRelease, tx *gorm.DB) (tickets []*models.Ticket, err error) {
+func allocateReservedTickets(ticketRelease *models.TicketRelease, tx *gorm.DB) (tickets []*models.Ticket, err error) {
 	// Fetch all ticket requests directly from the database
-	var reserveNumber uint = 1
 	var allTicketRequests []models.TicketRequest
 	if err := tx.Preload("TicketType").
 		Preload("TicketRelease.Event").
@@ -270,25 +323,19 @@ func (ats *AllocateTicketsService) allocateReservedTickets(ticketRelease *models
 		return nil, err
 	}
 
-	// Fetch total available tickets directly
-	var availableTickets int = ticketRelease.TicketsAvailable
+	// Remaining tickets of the ticket release and of the ticket types with a capacity
+	capacity, err := allocate_service.LoadTicketCapacity(tx, ticketRelease)
+	if err != nil {
+		return nil, err
+	}
 
 	// Give all users tickets up to the available tickets, give the rest reserve tickets
-	for i, ticketRequest := range allTicketRequests {
-		if i < availableTickets {
-			ticket, err := allocate_service.AllocateTicket(ticketRequest, tx)
-			if err != nil {
-				return nil, err
-			}
-			tickets = append(tickets, ticket)
-		} else {
-			ticket, err := allocate_service.AllocateReserveTicket(ticketRequest, reserveNumber, tx)
-			if err != nil {
-				return nil, err
-			}
-			tickets = append(tickets, ticket)
-			reserveNumber++
+	for _, ticketRequest := range allTicketRequests {
+		requestTickets, err := allocate_service.AllocateTicketOrReserve(ticketRequest, capacity, tx)
+		if err != nil {
+			return nil, err
 		}
+		tickets = append(tickets, requestTickets...)
 	}
 
 	return tickets, nil
@@ -328,7 +375,12 @@ func (ats *AllocateTicketsService) SelectivelyAllocateTicketRequest(ticketReques
 		}
 	}
 
-	if ticketRequest.TicketRelease.TicketReleaseMethodDetail.TicketReleaseMethod.MethodName != string(models.SELECTIVE) {
+	allocator, err := GetAllocator(ticketRequest.TicketRelease.TicketReleaseMethodDetail.TicketReleaseMethod.MethodName)
+	if err != nil {
+		return err
+	}
+
+	if allocator.Method() != models.SELECTIVE {
 		return errors.New("ticket release method is not selective")
 	}
 
@@ -338,13 +390,24 @@ func (ats *AllocateTicketsService) SelectivelyAllocateTicketRequest(ticketReques
 		return errors.New("ticket request is already handled")
 	}
 
+	// The organizer picks who gets a ticket, but the capacity of the ticket type still applies
+	capacity, err := allocate_service.LoadTicketCapacity(tx, &ticketRequest.TicketRelease)
+	if err != nil {
+		return err
+	}
+
+	if !capacity.CanAllocateType(ticketRequest.TicketTypeID, ticketRequest.NumberOfTickets()) {
+		tx.Rollback()
+		return errors.New("there are no tickets left of this ticket type")
+	}
+
 	// Alocate the ticket
-	ticket, err := allocate_service.AllocateTicket(ticketRequest, tx)
+	tickets, err := allocate_service.AllocateTicket(ticketRequest, tx)
 	if err != nil {
 		return err
 	}
 
-	err = Notify_TicketAllocationCreated(tx, int(ticket.ID), &ticketRequest.TicketRelease.PaymentDeadline.OriginalDeadline)
+	err = allocator.Notify(tx, &ticketRequest.TicketRelease, tickets, nil)
 
 	if err != nil {
 		return err
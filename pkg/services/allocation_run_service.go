@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/jobs"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"gorm.io/gorm"
+)
+
+// defaultAllocationUndoGracePeriod is used when ALLOCATION_UNDO_GRACE_PERIOD is not set
+const defaultAllocationUndoGracePeriod = 15 * time.Minute
+
+type AllocationRunService struct {
+	DB *gorm.DB
+}
+
+func NewAllocationRunService(db *gorm.DB) *AllocationRunService {
+	return &AllocationRunService{DB: db}
+}
+
+// AllocationUndoGracePeriod returns how long an allocation run can be undone, configured with
+// ALLOCATION_UNDO_GRACE_PERIOD as a duration, e.g. "30m". The allocation emails are held back
+// for the grace period so that users never get emails about a run that is undone, "0" sends
+// them right away and turns off undo.
+func AllocationUndoGracePeriod() (time.Duration, error) {
+	value := os.Getenv("ALLOCATION_UNDO_GRACE_PERIOD")
+	if value == "" {
+		return defaultAllocationUndoGracePeriod, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ALLOCATION_UNDO_GRACE_PERIOD: %w", err)
+	}
+
+	if duration < 0 {
+		return 0, errors.New("invalid ALLOCATION_UNDO_GRACE_PERIOD: must not be negative")
+	}
+
+	return duration, nil
+}
+
+// createAllocationRun records the tickets created by an allocation of the ticket release
+func createAllocationRun(tx *gorm.DB, ticketRelease *models.TicketRelease, tickets []*models.Ticket, previousClose int64) (*models.AllocationRun, error) {
+	gracePeriod, err := AllocationUndoGracePeriod()
+	if err != nil {
+		return nil, err
+	}
+
+	run := models.AllocationRun{
+		TicketReleaseID: ticketRelease.ID,
+		PreviousClose:   previousClose,
+		UndoDeadline:    time.Now().Add(gracePeriod),
+	}
+
+	if ticketRelease.PaymentDeadline != nil {
+		run.PaymentDeadlineID = &ticketRelease.PaymentDeadline.ID
+	}
+
+	for _, ticket := range tickets {
+		run.Tickets = append(run.Tickets, models.AllocationRunTicket{TicketID: ticket.ID})
+	}
+
+	if err := tx.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+func (ars *AllocationRunService) ListAllocationRuns(ticketReleaseID uint) ([]models.AllocationRun, *types.ErrorResponse) {
+	runs, err := models.GetAllocationRunsForTicketRelease(ars.DB, ticketReleaseID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting allocation runs"}
+	}
+
+	return runs, nil
+}
+
+// UndoAllocationRun reverts an allocation run within its grace period. The tickets of the run
+// are removed, the ticket requests can be allocated again and the queued emails are cancelled.
+func (ars *AllocationRunService) UndoAllocationRun(ticketReleaseID, runID uint, revertedBy string) *types.ErrorResponse {
+	tx := ars.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var run models.AllocationRun
+	if err := tx.Preload("Tickets").Where("id = ? AND ticket_release_id = ?", runID, ticketReleaseID).First(&run).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Allocation run not found"}
+		}
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting allocation run"}
+	}
+
+	if run.IsReverted() {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Allocation run has already been undone"}
+	}
+
+	if !run.CanUndo(time.Now()) {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The grace period for undoing the allocation has passed"}
+	}
+
+	ticketIDs := run.TicketIDs()
+
+	if len(ticketIDs) > 0 {
+		// Tickets that have been paid for can not be removed without a refund, started or failed payments do not count
+		var paidTickets int64
+		if err := tx.Model(&models.Transaction{}).
+			Where("ticket_id IN ? AND transaction_type = ? AND status = ?", ticketIDs, models.TypePurchase, models.TransactionStatusCompleted).
+			Count(&paidTickets).Error; err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking payments"}
+		}
+
+		if paidTickets > 0 {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Tickets in this allocation have already been paid for"}
+		}
+
+		var ticketRequestIDs []uint
+		if err := tx.Model(&models.Ticket{}).Where("id IN ?", ticketIDs).Pluck("ticket_request_id", &ticketRequestIDs).Error; err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket requests"}
+		}
+
+		if err := tx.Model(&models.TicketAddOn{}).Where("ticket_id IN ?", ticketIDs).Update("ticket_id", nil).Error; err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error updating ticket add-ons"}
+		}
+
+		if err := tx.Where("id IN ?", ticketIDs).Delete(&models.Ticket{}).Error; err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error deleting tickets"}
+		}
+
+		if err := tx.Model(&models.TicketRequest{}).Where("id IN ?", ticketRequestIDs).Updates(map[string]interface{}{
+			"is_handled": false,
+			"handled_at": nil,
+		}).Error; err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error updating ticket requests"}
+		}
+	}
+
+	if err := tx.Model(&models.TicketRelease{}).Where("id = ?", run.TicketReleaseID).Updates(map[string]interface{}{
+		"has_allocated_tickets": false,
+		"close":                 run.PreviousClose,
+	}).Error; err != nil {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error updating ticket release"}
+	}
+
+	if run.PaymentDeadlineID != nil {
+		// The payment deadline is unique per ticket release, it is removed for good so that a new one can be created
+		if err := tx.Unscoped().Delete(&models.TicketReleasePaymentDeadline{}, *run.PaymentDeadlineID).Error; err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error deleting payment deadline"}
+		}
+	}
+
+	// Only one allocation of a ticket release can be active, so any draw belongs to this run
+	if err := tx.Where("ticket_release_id = ?", run.TicketReleaseID).Delete(&models.LotteryDraw{}).Error; err != nil {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error deleting lottery draw"}
+	}
+
+	now := time.Now()
+	run.RevertedAt = &now
+	run.RevertedBy = &revertedBy
+	if err := tx.Omit("Tickets").Save(&run).Error; err != nil {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving allocation run"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error undoing allocation"}
+	}
+
+	if os.Getenv("ENV") != "test" {
+		taskIDs := make([]string, len(ticketIDs))
+		for i, ticketID := range ticketIDs {
+			taskIDs[i] = run.EmailTaskID(ticketID)
+		}
+
+		// The allocation has been undone even if some emails could not be cancelled
+		if err := jobs.CancelEmailJobs(taskIDs); err != nil {
+			log.Printf("Error cancelling the emails of allocation run %d: %v", run.ID, err)
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "The allocation was undone but some emails could not be cancelled"}
+		}
+	}
+
+	return nil
+}
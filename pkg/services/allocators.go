@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/jobs"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	allocate_fcfs "github.com/DowLucas/gin-ticket-release/pkg/services/allocate_fcfc"
+	"gorm.io/gorm"
+)
+
+// Allocator describes a ticket release method. Every method that can be
+// chosen for a ticket release is implemented as an Allocator and registered
+// with RegisterAllocator, the rest of the application looks them up by the
+// method name stored in TicketReleaseMethod.
+type Allocator interface {
+	// Method returns the name of the ticket release method
+	Method() models.TRM
+	// Description is stored on the TicketReleaseMethod when it is seeded
+	Description() string
+	// Validate validates the method specific settings of a ticket release
+	Validate(detail *models.TicketReleaseMethodDetail) error
+	// Allocate allocates tickets to the ticket requests of the ticket release
+	Allocate(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*models.Ticket, error)
+	// Notify notifies the users that received a ticket or a reserve ticket, run is
+	// nil when the tickets were not allocated as part of an allocation run
+	Notify(tx *gorm.DB, ticketRelease *models.TicketRelease, tickets []*models.Ticket, run *models.AllocationRun) error
+}
+
+var (
+	allocators     = map[string]Allocator{}
+	allocatorOrder []Allocator
+)
+
+// RegisterAllocator makes an allocator available under its method name
+func RegisterAllocator(allocator Allocator) {
+	name := string(allocator.Method())
+	if _, exists := allocators[name]; exists {
+		panic(fmt.Sprintf("allocator already registered: %s", name))
+	}
+
+	allocators[name] = allocator
+	allocatorOrder = append(allocatorOrder, allocator)
+}
+
+// GetAllocator returns the allocator registered for the method name
+func GetAllocator(methodName string) (Allocator, error) {
+	if methodName == "" {
+		return nil, errors.New("no method name specified")
+	}
+
+	allocator, ok := allocators[methodName]
+	if !ok {
+		return nil, fmt.Errorf("unknown method: %s", methodName)
+	}
+
+	return allocator, nil
+}
+
+// ListAllocators returns all registered allocators in registration order
+func ListAllocators() []Allocator {
+	return allocatorOrder
+}
+
+// ValidateTicketReleaseMethod validates the method specific settings of a ticket release
+func ValidateTicketReleaseMethod(methodName string, detail *models.TicketReleaseMethodDetail) error {
+	allocator, err := GetAllocator(methodName)
+	if err != nil {
+		return err
+	}
+
+	return allocator.Validate(detail)
+}
+
+// InitializeTicketReleaseMethods seeds a TicketReleaseMethod for every registered allocator
+func InitializeTicketReleaseMethods(db *gorm.DB) error {
+	for _, allocator := range ListAllocators() {
+		method := models.TicketReleaseMethod{
+			MethodName:  string(allocator.Method()),
+			Description: allocator.Description(),
+		}
+
+		if err := models.CreateTicketReleaseMethodIfNotExist(db, &method); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	RegisterAllocator(&FCFSLotteryAllocator{})
+	RegisterAllocator(&ReservedTicketReleaseAllocator{})
+	RegisterAllocator(&FCFSAllocator{})
+	RegisterAllocator(&SelectiveAllocator{})
+}
+
+// notifyAllocatedTickets notifies the owners of the tickets, paymentDeadline is
+// included in the email sent to the users that received a ticket. The emails of
+// an allocation run are held back until the run can no longer be undone.
+func notifyAllocatedTickets(tx *gorm.DB, tickets []*models.Ticket, paymentDeadline *time.Time, run *models.AllocationRun) error {
+	notifiedTicketRequests := map[uint]bool{}
+	for _, ticket := range tickets {
+		// The tickets of a ticket request are allocated together, the user is notified once
+		if notifiedTicketRequests[ticket.TicketRequestID] {
+			continue
+		}
+		notifiedTicketRequests[ticket.TicketRequestID] = true
+
+		var options jobs.EmailJobOptions
+		if run != nil {
+			options.TaskID = run.EmailTaskID(ticket.ID)
+			options.ProcessAt = &run.UndoDeadline
+		}
+
+		var err error
+		if !ticket.IsReserve {
+			err = notifyTicketAllocationCreated(tx, int(ticket.ID), paymentDeadline, options)
+		} else {
+			err = notifyReserveTicketAllocationCreated(tx, int(ticket.ID), options)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func originalPaymentDeadline(ticketRelease *models.TicketRelease) *time.Time {
+	if ticketRelease.PaymentDeadline == nil {
+		return nil
+	}
+
+	return &ticketRelease.PaymentDeadline.OriginalDeadline
+}
+
+// FCFSLotteryAllocator draws a lottery among the requests made within the open window
+type FCFSLotteryAllocator struct{}
+
+func (a *FCFSLotteryAllocator) Method() models.TRM {
+	return models.FCFS_LOTTERY
+}
+
+func (a *FCFSLotteryAllocator) Description() string {
+	return "First Come First Serve Lottery is a ticket release method where the people who requests a ticket within a specified time frame will be entered into a lottery. When tickets are allocated, all the ticket requests are entered into a lottery and the winners are selected randomly. The winners will be given a ticket and the rest will be put on the waitlist. Everyone who requested a ticket after the specified time frame will be put on the waitlist, unless the lottery isn't full. If the lottery isn't full, the remaining tickets will be given to the people on the waitlist, in the order they requested the ticket."
+}
+
+func (a *FCFSLotteryAllocator) Validate(detail *models.TicketReleaseMethodDetail) error {
+	config := models.FCFSLotteryConfig{
+		OpenWindowDuration:     detail.OpenWindowDuration,
+		PriorityMode:           detail.LotteryPriorityMode,
+		MemberLotteryWeight:    models.NormalizeLotteryWeight(detail.MemberLotteryWeight),
+		InternalLotteryWeight:  models.NormalizeLotteryWeight(detail.InternalLotteryWeight),
+		PromoCodeLotteryWeight: models.NormalizeLotteryWeight(detail.PromoCodeLotteryWeight),
+	}
+
+	return config.Validate()
+}
+
+func (a *FCFSLotteryAllocator) Allocate(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*models.Ticket, error) {
+	return allocateFCFSLotteryTickets(ticketRelease, tx)
+}
+
+func (a *FCFSLotteryAllocator) Notify(tx *gorm.DB, ticketRelease *models.TicketRelease, tickets []*models.Ticket, run *models.AllocationRun) error {
+	return notifyAllocatedTickets(tx, tickets, originalPaymentDeadline(ticketRelease), run)
+}
+
+// ReservedTicketReleaseAllocator gives everyone in the ticket release a ticket
+type ReservedTicketReleaseAllocator struct{}
+
+func (a *ReservedTicketReleaseAllocator) Method() models.TRM {
+	return models.RESERVED_TICKET_RELEASE
+}
+
+func (a *ReservedTicketReleaseAllocator) Description() string {
+	return "Gives everyone in the ticket release a ticket"
+}
+
+func (a *ReservedTicketReleaseAllocator) Validate(detail *models.TicketReleaseMethodDetail) error {
+	config := models.ReservedTicketConfig{}
+
+	return config.Validate()
+}
+
+func (a *ReservedTicketReleaseAllocator) Allocate(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*models.Ticket, error) {
+	return allocateReservedTickets(ticketRelease, tx)
+}
+
+func (a *ReservedTicketReleaseAllocator) Notify(tx *gorm.DB, ticketRelease *models.TicketRelease, tickets []*models.Ticket, run *models.AllocationRun) error {
+	return notifyAllocatedTickets(tx, tickets, nil, run)
+}
+
+// FCFSAllocator allocates tickets in the order they were requested
+type FCFSAllocator struct{}
+
+func (a *FCFSAllocator) Method() models.TRM {
+	return models.FCFS
+}
+
+func (a *FCFSAllocator) Description() string {
+	return "First Come First Serve will allocate tickets to the first people who requests a ticket. If the ticket release is full, the rest will be put on the waitlist."
+}
+
+func (a *FCFSAllocator) Validate(detail *models.TicketReleaseMethodDetail) error {
+	config := models.FCFSConfig{}
+
+	return config.Validate()
+}
+
+func (a *FCFSAllocator) Allocate(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*models.Ticket, error) {
+	return allocate_fcfs.AllocateFCFSTickets(ticketRelease, tx)
+}
+
+func (a *FCFSAllocator) Notify(tx *gorm.DB, ticketRelease *models.TicketRelease, tickets []*models.Ticket, run *models.AllocationRun) error {
+	return notifyAllocatedTickets(tx, tickets, originalPaymentDeadline(ticketRelease), run)
+}
+
+// SelectiveAllocator lets the organizer pick the ticket requests, see
+// AllocateTicketsService.SelectivelyAllocateTicketRequest
+type SelectiveAllocator struct{}
+
+func (a *SelectiveAllocator) Method() models.TRM {
+	return models.SELECTIVE
+}
+
+func (a *SelectiveAllocator) Description() string {
+	return "Selective will allocate tickets to the people the organizer selects"
+}
+
+func (a *SelectiveAllocator) Validate(detail *models.TicketReleaseMethodDetail) error {
+	config := models.SelectiveConfig{
+		MethodDescription: detail.MethodDescription,
+	}
+
+	return config.Validate()
+}
+
+func (a *SelectiveAllocator) Allocate(ticketRelease *models.TicketRelease, tx *gorm.DB) ([]*models.Ticket, error) {
+	return nil, errors.New("selective ticket releases are allocated one ticket request at a time")
+}
+
+func (a *SelectiveAllocator) Notify(tx *gorm.DB, ticketRelease *models.TicketRelease, tickets []*models.Ticket, run *models.AllocationRun) error {
+	return notifyAllocatedTickets(tx, tickets, originalPaymentDeadline(ticketRelease), run)
+}
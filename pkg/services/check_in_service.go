@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/checkinfeed"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/qrtoken"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"gorm.io/gorm"
+)
+
+// The arrival rate of the check-in dashboard is averaged over this window
+const arrivalRateWindow = 15 * time.Minute
+
+type CheckInService struct {
+	DB      *gorm.DB
+	signing *TicketSigningService
+	feed    checkinfeed.Broker
+}
+
+func NewCheckInService(db *gorm.DB, feed checkinfeed.Broker) *CheckInService {
+	return &CheckInService{DB: db, signing: NewTicketSigningService(db), feed: feed}
+}
+
+// CheckIn checks in the ticket of the QR code at the event. Scanning a ticket that is already checked in
+// is not an error, the result has the time it was first checked in. Every scan is logged.
+func (cis *CheckInService) CheckIn(eventID int, organizer string, req *types.CheckInRequest) (*types.CheckInResult, *types.ErrorResponse) {
+	now := time.Now()
+	ticket, rerr := cis.findTicket(uint(eventID), req.QrCode, now)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return cis.checkIn(uint(eventID), ticket, organizer, req.Gate, now, false)
+}
+
+// UndoCheckIn lets an organizer revert a check-in, e.g. when the wrong ticket was scanned
+func (cis *CheckInService) UndoCheckIn(eventID int, ticketID int, organizer string, gate string) (*models.Ticket, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := cis.DB.Preload("User").Preload("TicketRequest.TicketRelease").First(&ticket, ticketID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	if ticket.TicketRequest.TicketRelease.EventID != eventID {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+	}
+
+	if !ticket.CheckedIn {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket is not checked in"}
+	}
+
+	err := cis.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ticket).Updates(map[string]interface{}{"checked_in": false, "checked_in_at": nil}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.CheckInLog{
+			EventID:          uint(eventID),
+			TicketID:         ticket.ID,
+			OrganizerUGKthID: organizer,
+			Gate:             gate,
+			Action:           models.CheckInActionUndone,
+			ScannedAt:        time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error undoing check-in"}
+	}
+
+	ticket.CheckedIn = false
+	ticket.CheckedInAt = sql.NullTime{}
+	publishCheckIn(cis.feed, uint(eventID), &ticket, models.CheckInActionUndone, organizer, gate)
+	return &ticket, nil
+}
+
+func (cis *CheckInService) GetCheckInLogs(eventID int, ticketID int) ([]models.CheckInLog, *types.ErrorResponse) {
+	logs, err := models.GetCheckInLogs(cis.DB, uint(eventID), uint(ticketID), 500)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting check-in logs"}
+	}
+
+	return logs, nil
+}
+
+// GetDashboard returns the checked in tickets per ticket type, the arrival rate and the latest scans of the event
+func (cis *CheckInService) GetDashboard(eventID int) (*types.CheckInDashboard, *types.ErrorResponse) {
+	ticketTypes, err := models.GetTicketTypeCheckIns(cis.DB, uint(eventID))
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting check-ins"}
+	}
+
+	arrivals, err := models.CountCheckInsSince(cis.DB, uint(eventID), time.Now().Add(-arrivalRateWindow))
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting check-ins"}
+	}
+
+	recentScans, err := models.GetCheckInLogs(cis.DB, uint(eventID), 0, 20)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting check-in logs"}
+	}
+
+	dashboard := &types.CheckInDashboard{
+		TicketTypes: ticketTypes,
+		ArrivalRate: float64(arrivals) / arrivalRateWindow.Minutes(),
+		RecentScans: recentScans,
+	}
+	for _, ticketType := range ticketTypes {
+		dashboard.CheckedIn += ticketType.CheckedIn
+		dashboard.Total += ticketType.Total
+	}
+
+	return dashboard, nil
+}
+
+// Subscribe returns the check-ins of the event as they happen, until cancel is called
+func (cis *CheckInService) Subscribe(eventID int) (<-chan checkinfeed.Update, func()) {
+	return cis.feed.Subscribe(uint(eventID))
+}
+
+// ReconcileOfflineCheckIns checks in the tickets that scanners verified while they were offline. The
+// scans are applied in the order they were made, so when a ticket was scanned more than once, on one or
+// several scanners, the first scan checks it in and the others are reported as duplicates.
+func (cis *CheckInService) ReconcileOfflineCheckIns(eventID int, organizer string, req *types.OfflineCheckInBatchRequest) ([]types.OfflineCheckInResult, *types.ErrorResponse) {
+	checkIns := req.CheckIns
+	order := make([]int, len(checkIns))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return checkIns[order[a]].CheckedInAt.Before(checkIns[order[b]].CheckedInAt)
+	})
+
+	results := make([]types.OfflineCheckInResult, len(checkIns))
+	for _, i := range order {
+		result, rerr := cis.reconcile(uint(eventID), organizer, req.Gate, &checkIns[i])
+		if rerr != nil {
+			return nil, rerr
+		}
+		results[i] = *result
+	}
+
+	return results, nil
+}
+
+func (cis *CheckInService) reconcile(eventID uint, organizer string, gate string, checkIn *types.OfflineCheckIn) (*types.OfflineCheckInResult, *types.ErrorResponse) {
+	if !qrtoken.IsToken(checkIn.Token) {
+		return &types.OfflineCheckInResult{Status: types.OfflineCheckInRejected, Error: "Only signed QR codes can be checked in offline"}, nil
+	}
+
+	ticket, rerr := cis.findTicket(eventID, checkIn.Token, checkIn.CheckedInAt)
+	if rerr == nil {
+		var result *types.CheckInResult
+		if result, rerr = cis.checkIn(eventID, ticket, organizer, gate, checkIn.CheckedInAt, true); rerr == nil {
+			checkedInAt := result.CheckedInAt
+			if result.AlreadyCheckedIn {
+				return &types.OfflineCheckInResult{TicketID: ticket.ID, Status: types.OfflineCheckInDuplicate, CheckedInAt: &checkedInAt, Error: "Ticket is already checked in"}, nil
+			}
+			return &types.OfflineCheckInResult{TicketID: ticket.ID, Status: types.OfflineCheckInAccepted, CheckedInAt: &checkedInAt}, nil
+		}
+	}
+
+	if rerr.StatusCode == http.StatusInternalServerError {
+		return nil, rerr
+	}
+
+	result := &types.OfflineCheckInResult{Status: types.OfflineCheckInRejected, Error: rerr.Message}
+	if ticket != nil {
+		result.TicketID = ticket.ID
+	}
+
+	return result, nil
+}
+
+// findTicket returns the ticket of a random or signed QR code, signed QR codes are verified at the time of the scan
+func (cis *CheckInService) findTicket(eventID uint, qrCode string, scannedAt time.Time) (*models.Ticket, *types.ErrorResponse) {
+	if qrtoken.IsToken(qrCode) {
+		claims, rerr := cis.signing.VerifyQrToken(eventID, qrCode, scannedAt)
+		if rerr != nil {
+			return nil, rerr
+		}
+
+		return cis.signing.GetTicketByQrToken(claims)
+	}
+
+	var ticket models.Ticket
+	if err := cis.DB.Preload("User").Preload("TicketRequest.TicketRelease").
+		Where("qr_code = ?", qrCode).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Invalid QR code"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	return &ticket, nil
+}
+
+// entryError returns why the ticket can not be checked in at the event, or an empty string if it can
+func entryError(eventID uint, ticket *models.Ticket) string {
+	switch {
+	case uint(ticket.TicketRequest.TicketRelease.EventID) != eventID:
+		return "Ticket is for another event"
+	case ticket.Refunded:
+		return "Ticket has been refunded"
+	case ticket.Status == models.Cancelled:
+		return "Ticket has been cancelled"
+	case ticket.IsReserve:
+		return "Ticket is on the reserve list"
+	case !ticket.IsPaid:
+		return "Ticket has not been paid"
+	}
+
+	return ""
+}
+
+func (cis *CheckInService) checkIn(eventID uint, ticket *models.Ticket, organizer string, gate string, scannedAt time.Time, offline bool) (*types.CheckInResult, *types.ErrorResponse) {
+	entry := models.CheckInLog{
+		EventID:          eventID,
+		TicketID:         ticket.ID,
+		OrganizerUGKthID: organizer,
+		Gate:             gate,
+		Offline:          offline,
+		ScannedAt:        scannedAt,
+	}
+
+	if reason := entryError(eventID, ticket); reason != "" {
+		entry.Action = models.CheckInActionRejected
+		entry.Reason = reason
+		if err := cis.DB.Create(&entry).Error; err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error logging check-in"}
+		}
+		publishCheckIn(cis.feed, eventID, ticket, entry.Action, organizer, gate)
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: reason}
+	}
+
+	result := &types.CheckInResult{Ticket: ticket}
+	err := cis.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the first scan checks in the ticket, also when scanners race each other
+		update := tx.Model(&models.Ticket{}).
+			Where("id = ? AND checked_in = ?", ticket.ID, false).
+			Updates(map[string]interface{}{"checked_in": true, "checked_in_at": scannedAt})
+		if update.Error != nil {
+			return update.Error
+		}
+
+		if update.RowsAffected == 1 {
+			entry.Action = models.CheckInActionCheckedIn
+			ticket.CheckedInAt = sql.NullTime{Time: scannedAt, Valid: true}
+		} else {
+			entry.Action = models.CheckInActionRepeatScan
+			result.AlreadyCheckedIn = true
+			if err := tx.Model(&models.Ticket{}).Select("checked_in_at").Where("id = ?", ticket.ID).
+				Scan(&ticket.CheckedInAt).Error; err != nil {
+				return err
+			}
+		}
+
+		ticket.CheckedIn = true
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking in ticket"}
+	}
+
+	result.CheckedInAt = ticket.CheckedInAt.Time
+	publishCheckIn(cis.feed, eventID, ticket, entry.Action, organizer, gate)
+	return result, nil
+}
+
+// publishCheckIn tells the check-in dashboards of the event that the ticket was scanned or changed. The
+// dashboards are best effort, so a failure to publish does not fail the check-in.
+func publishCheckIn(feed checkinfeed.Broker, eventID uint, ticket *models.Ticket, action models.CheckInAction, organizer string, gate string) {
+	if feed == nil {
+		return
+	}
+
+	err := feed.Publish(context.Background(), checkinfeed.Update{
+		EventID:      eventID,
+		TicketID:     ticket.ID,
+		TicketTypeID: ticket.TicketRequest.TicketTypeID,
+		CheckedIn:    ticket.CheckedIn,
+		Action:       string(action),
+		Gate:         gate,
+		Organizer:    organizer,
+		At:           time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish check-in of ticket %d: %v", ticket.ID, err)
+	}
+}
@@ -70,8 +70,15 @@ func (es *CompleteEventWorkflowService) CreateEvent(data types.EventFullWorkflow
 		OpenWindowDuration:    int64(data.TicketRelease.OpenWindowDuration),
 		NotificationMethod:    data.TicketRelease.NotificationMethod,
 		CancellationPolicy:    data.TicketRelease.CancellationPolicy,
+		RefundTiers:           data.TicketRelease.RefundTiers,
+		RefundHandlingFee:     data.TicketRelease.RefundHandlingFee,
 		MaxTicketsPerUser:     uint(data.TicketRelease.MaxTicketsPerUser),
 		MethodDescription:     data.TicketRelease.MethodDescription,
+
+		LotteryPriorityMode:    data.TicketRelease.LotteryPriorityMode,
+		MemberLotteryWeight:    models.NormalizeLotteryWeight(data.TicketRelease.MemberLotteryWeight),
+		InternalLotteryWeight:  models.NormalizeLotteryWeight(data.TicketRelease.InternalLotteryWeight),
+		PromoCodeLotteryWeight: models.NormalizeLotteryWeight(data.TicketRelease.PromoCodeLotteryWeight),
 	}
 
 	if err := ticketReleaseMethodDetails.Validate(); err != nil {
@@ -84,13 +91,7 @@ func (es *CompleteEventWorkflowService) CreateEvent(data types.EventFullWorkflow
 		return errors.New("could not create ticket release method details")
 	}
 
-	method, err := models.NewTicketReleaseConfig(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	if err := method.Validate(); err != nil {
+	if err := ValidateTicketReleaseMethod(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails); err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -122,6 +123,16 @@ func (es *CompleteEventWorkflowService) CreateEvent(data types.EventFullWorkflow
 		IsReserved:                  data.TicketRelease.IsReserved,
 		PromoCode:                   &promoCode,
 		AllowExternal:               data.TicketRelease.AllowExternal,
+		AllowTransfers:              data.TicketRelease.AllowTransfers,
+		TransferDeadline:            data.TicketRelease.TransferDeadline,
+		TransferPaidOnly:            data.TicketRelease.TransferPaidOnly,
+		AllowResale:                 data.TicketRelease.AllowResale,
+		PaymentProvider:             models.PaymentProviderName(data.TicketRelease.PaymentProvider),
+	}
+
+	if err := models.ValidatePaymentProvider(ticketRelease.PaymentProvider); err != nil {
+		tx.Rollback()
+		return err
 	}
 
 	if err := tx.Create(&ticketRelease).Error; err != nil {
@@ -129,6 +140,13 @@ func (es *CompleteEventWorkflowService) CreateEvent(data types.EventFullWorkflow
 		return err
 	}
 
+	if data.TicketRelease.IsReserved {
+		if err := SetPrimaryPromoCode(tx, &ticketRelease, data.TicketRelease.PromoCode); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	// Create TicketTypes
 	for _, tt := range data.TicketTypes {
 		ticketType := models.TicketType{
@@ -137,6 +155,12 @@ func (es *CompleteEventWorkflowService) CreateEvent(data types.EventFullWorkflow
 			Description:     tt.Description,
 			Price:           tt.Price,
 			TicketReleaseID: ticketRelease.ID,
+			Capacity:        tt.Capacity,
+		}
+
+		if err := ticketType.Validate(); err != nil {
+			tx.Rollback()
+			return err
 		}
 
 		if err := tx.Create(&ticketType).Error; err != nil {
@@ -181,7 +205,14 @@ func (es *CompleteEventWorkflowService) CreateTicketRelease(data types.TicketRel
 		OpenWindowDuration:    int64(data.TicketRelease.OpenWindowDuration),
 		NotificationMethod:    data.TicketRelease.NotificationMethod,
 		CancellationPolicy:    data.TicketRelease.CancellationPolicy,
+		RefundTiers:           data.TicketRelease.RefundTiers,
+		RefundHandlingFee:     data.TicketRelease.RefundHandlingFee,
 		MaxTicketsPerUser:     uint(data.TicketRelease.MaxTicketsPerUser),
+
+		LotteryPriorityMode:    data.TicketRelease.LotteryPriorityMode,
+		MemberLotteryWeight:    models.NormalizeLotteryWeight(data.TicketRelease.MemberLotteryWeight),
+		InternalLotteryWeight:  models.NormalizeLotteryWeight(data.TicketRelease.InternalLotteryWeight),
+		PromoCodeLotteryWeight: models.NormalizeLotteryWeight(data.TicketRelease.PromoCodeLotteryWeight),
 	}
 
 	if err := ticketReleaseMethodDetails.Validate(); err != nil {
@@ -194,13 +225,7 @@ func (es *CompleteEventWorkflowService) CreateTicketRelease(data types.TicketRel
 		return errors.New("could not create ticket release method details")
 	}
 
-	method, err := models.NewTicketReleaseConfig(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	if err := method.Validate(); err != nil {
+	if err := ValidateTicketReleaseMethod(ticketReleaseMethod.MethodName, &ticketReleaseMethodDetails); err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -212,6 +237,7 @@ func (es *CompleteEventWorkflowService) CreateTicketRelease(data types.TicketRel
 			return errors.New("promo code is required for reserved ticket releases")
 		}
 
+		var err error
 		promoCode, err = utils.EncryptString(data.TicketRelease.PromoCode)
 		if err != nil {
 			tx.Rollback()
@@ -232,6 +258,16 @@ func (es *CompleteEventWorkflowService) CreateTicketRelease(data types.TicketRel
 		IsReserved:                  data.TicketRelease.IsReserved,
 		PromoCode:                   &promoCode,
 		AllowExternal:               data.TicketRelease.AllowExternal,
+		AllowTransfers:              data.TicketRelease.AllowTransfers,
+		TransferDeadline:            data.TicketRelease.TransferDeadline,
+		TransferPaidOnly:            data.TicketRelease.TransferPaidOnly,
+		AllowResale:                 data.TicketRelease.AllowResale,
+		PaymentProvider:             models.PaymentProviderName(data.TicketRelease.PaymentProvider),
+	}
+
+	if err := models.ValidatePaymentProvider(ticketRelease.PaymentProvider); err != nil {
+		tx.Rollback()
+		return err
 	}
 
 	if err := tx.Create(&ticketRelease).Error; err != nil {
@@ -239,6 +275,13 @@ func (es *CompleteEventWorkflowService) CreateTicketRelease(data types.TicketRel
 		return err
 	}
 
+	if data.TicketRelease.IsReserved {
+		if err := SetPrimaryPromoCode(tx, &ticketRelease, data.TicketRelease.PromoCode); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	// Create TicketTypes
 	for _, tt := range data.TicketTypes {
 		ticketType := models.TicketType{
@@ -247,6 +290,12 @@ func (es *CompleteEventWorkflowService) CreateTicketRelease(data types.TicketRel
 			Description:     tt.Description,
 			Price:           tt.Price,
 			TicketReleaseID: ticketRelease.ID,
+			Capacity:        tt.Capacity,
+		}
+
+		if err := ticketType.Validate(); err != nil {
+			tx.Rollback()
+			return err
 		}
 
 		if err := tx.Create(&ticketType).Error; err != nil {
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"gorm.io/gorm"
+)
+
+type DiscountCodeService struct {
+	DB *gorm.DB
+}
+
+func NewDiscountCodeService(db *gorm.DB) *DiscountCodeService {
+	return &DiscountCodeService{DB: db}
+}
+
+func (dcs *DiscountCodeService) ListDiscountCodes(eventID int) ([]models.DiscountCode, *types.ErrorResponse) {
+	discountCodes, err := models.GetDiscountCodesToEvent(dcs.DB, eventID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting discount codes"}
+	}
+
+	return discountCodes, nil
+}
+
+func (dcs *DiscountCodeService) CreateDiscountCode(eventID int, req *types.DiscountCodeRequest) (*models.DiscountCode, *types.ErrorResponse) {
+	discountCode := models.DiscountCode{EventID: eventID}
+	if rerr := dcs.applyRequest(&discountCode, req); rerr != nil {
+		return nil, rerr
+	}
+
+	if err := dcs.DB.Create(&discountCode).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating discount code"}
+	}
+
+	return &discountCode, nil
+}
+
+func (dcs *DiscountCodeService) UpdateDiscountCode(eventID int, discountCodeID int, req *types.DiscountCodeRequest) (*models.DiscountCode, *types.ErrorResponse) {
+	discountCode, rerr := dcs.getDiscountCode(eventID, discountCodeID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	if rerr := dcs.applyRequest(discountCode, req); rerr != nil {
+		return nil, rerr
+	}
+
+	if err := dcs.DB.Save(discountCode).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error updating discount code"}
+	}
+
+	return discountCode, nil
+}
+
+// DeleteDiscountCode removes the discount code, purchases already paid with it keep their discount
+func (dcs *DiscountCodeService) DeleteDiscountCode(eventID int, discountCodeID int) *types.ErrorResponse {
+	discountCode, rerr := dcs.getDiscountCode(eventID, discountCodeID)
+	if rerr != nil {
+		return rerr
+	}
+
+	if err := dcs.DB.Delete(discountCode).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error deleting discount code"}
+	}
+
+	return nil
+}
+
+func (dcs *DiscountCodeService) getDiscountCode(eventID int, discountCodeID int) (*models.DiscountCode, *types.ErrorResponse) {
+	var discountCode models.DiscountCode
+	if err := dcs.DB.Where("id = ? AND event_id = ?", discountCodeID, eventID).First(&discountCode).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Discount code not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting discount code"}
+	}
+
+	return &discountCode, nil
+}
+
+func (dcs *DiscountCodeService) applyRequest(discountCode *models.DiscountCode, req *types.DiscountCodeRequest) *types.ErrorResponse {
+	discountCode.Code = models.NormalizeDiscountCode(req.Code)
+	discountCode.DiscountType = req.DiscountType
+	discountCode.Amount = req.Amount
+	discountCode.TicketReleaseID = req.TicketReleaseID
+	discountCode.TicketTypeID = req.TicketTypeID
+	discountCode.MaxRedemptions = req.MaxRedemptions
+	discountCode.MaxRedemptionsPerUser = req.MaxRedemptionsPerUser
+	discountCode.ValidFrom = req.ValidFrom
+	discountCode.ValidUntil = req.ValidUntil
+
+	if err := discountCode.Validate(); err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	existing, err := models.GetDiscountCode(dcs.DB, discountCode.EventID, discountCode.Code)
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking discount codes"}
+	}
+
+	if existing != nil && existing.ID != discountCode.ID {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The event already has a discount code with that code"}
+	}
+
+	if discountCode.TicketReleaseID != nil {
+		var count int64
+		if err := dcs.DB.Model(&models.TicketRelease{}).
+			Where("id = ? AND event_id = ?", *discountCode.TicketReleaseID, discountCode.EventID).Count(&count).Error; err != nil || count == 0 {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket release does not belong to the event"}
+		}
+	}
+
+	if discountCode.TicketTypeID != nil {
+		var count int64
+		if err := dcs.DB.Model(&models.TicketType{}).
+			Where("id = ? AND event_id = ?", *discountCode.TicketTypeID, discountCode.EventID).Count(&count).Error; err != nil || count == 0 {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket type does not belong to the event"}
+		}
+	}
+
+	return nil
+}
+
+// findTicketDiscountCode returns the discount code of the ticket's event if it can be used for the
+// ticket, nil if there is no such code or it is for other tickets
+func findTicketDiscountCode(db *gorm.DB, ticket *models.Ticket, code string) (*models.DiscountCode, *types.ErrorResponse) {
+	ticketRequest := ticket.TicketRequest
+
+	discountCode, err := models.GetDiscountCode(db, ticketRequest.TicketRelease.EventID, code)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting discount code"}
+	}
+
+	if discountCode == nil || !discountCode.AppliesTo(ticketRequest.TicketReleaseID, ticketRequest.TicketTypeID) {
+		return nil, nil
+	}
+
+	return discountCode, nil
+}
+
+// checkDiscountCode checks that the discount code can be redeemed once more by the user for the
+// ticket. applied is the number of times the user has used the code for other tickets that are
+// being paid for in the same payment.
+func checkDiscountCode(db *gorm.DB, discountCode *models.DiscountCode, ugkthid string, ticketID int, applied int) *types.ErrorResponse {
+	now := time.Now()
+	if discountCode.ValidFrom != nil && now.Before(*discountCode.ValidFrom) {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The discount code is not valid yet"}
+	}
+
+	if discountCode.ValidUntil != nil && now.After(*discountCode.ValidUntil) {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The discount code has expired"}
+	}
+
+	if discountCode.MaxRedemptions != nil {
+		count, err := discountCode.CountRedemptions(db, ticketID)
+		if err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking discount code"}
+		}
+
+		if int(count)+applied >= *discountCode.MaxRedemptions {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The discount code has been used the maximum number of times"}
+		}
+	}
+
+	if discountCode.MaxRedemptionsPerUser != nil {
+		count, err := discountCode.CountUserRedemptions(db, ugkthid, ticketID)
+		if err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking discount code"}
+		}
+
+		if int(count)+applied >= *discountCode.MaxRedemptionsPerUser {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("You can use the discount code %s at most %d times", discountCode.Code, *discountCode.MaxRedemptionsPerUser)}
+		}
+	}
+
+	return nil
+}
+
+// ticketGroupDiscount returns the reduction in öre of the price of the tickets of the ticket request
+// that the ticket is the first of
+func ticketGroupDiscount(discountCode *models.DiscountCode, ticket *models.Ticket, ticketAmount int) int {
+	price := int(ticket.TicketRequest.TicketType.Price * 100)
+	return discountCode.TicketDiscount(price) * ticketAmount
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	mrand "math/rand"
+	"net/http"
+	"sort"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/allocate_service"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/utils"
+	"gorm.io/gorm"
+)
+
+type LotteryDrawService struct {
+	DB *gorm.DB
+}
+
+func NewLotteryDrawService(db *gorm.DB) *LotteryDrawService {
+	return &LotteryDrawService{DB: db}
+}
+
+// newLotteryDrawSeed returns a random seed from a cryptographically secure source
+func newLotteryDrawSeed() (int64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+// shuffleWithSeed shuffles n elements using a source seeded with seed,
+// the same seed always gives the same order
+func shuffleWithSeed(n int, seed int64, swap func(i, j int)) {
+	mrand.New(mrand.NewSource(seed)).Shuffle(n, swap)
+}
+
+// DrawLottery returns the ticket request IDs in the order they are drawn,
+// the IDs are sorted before they are shuffled
+func DrawLottery(seed int64, ticketRequestIDs []uint) []uint {
+	drawn := models.SortTicketRequestIDs(ticketRequestIDs)
+	shuffleWithSeed(len(drawn), seed, func(i, j int) {
+		drawn[i], drawn[j] = drawn[j], drawn[i]
+	})
+
+	return drawn
+}
+
+// DrawLotteryWithPriority returns the ticket request IDs in the order they are drawn
+// using the priority mode of the ticket release. ticketRequestIDs must be sorted
+// by ID and weights must be in the same order.
+//
+// TIERED: the requests are shuffled and then ordered by weight, so every request
+// with a higher weight is drawn before the requests with a lower weight.
+// WEIGHTED: every request gets as many lottery tickets as its weight, the lottery
+// tickets are shuffled and a request is drawn at its first lottery ticket.
+// Weights are capped to between 1 and models.MaxLotteryWeight.
+func DrawLotteryWithPriority(seed int64, mode string, ticketRequestIDs []uint, weights []int) []uint {
+	capped := make([]int, len(weights))
+	for i, weight := range weights {
+		capped[i] = capLotteryWeight(weight)
+	}
+	weights = capped
+
+	switch mode {
+	case models.LOTTERY_PRIORITY_TIERED:
+		weightOf := make(map[uint]int, len(ticketRequestIDs))
+		for i, id := range ticketRequestIDs {
+			weightOf[id] = weights[i]
+		}
+
+		drawn := DrawLottery(seed, ticketRequestIDs)
+		sort.SliceStable(drawn, func(i, j int) bool {
+			return weightOf[drawn[i]] > weightOf[drawn[j]]
+		})
+
+		return drawn
+	case models.LOTTERY_PRIORITY_WEIGHTED:
+		var entries []uint
+		for i, id := range ticketRequestIDs {
+			for w := 0; w < weights[i]; w++ {
+				entries = append(entries, id)
+			}
+		}
+
+		shuffleWithSeed(len(entries), seed, func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+
+		drawn := make([]uint, 0, len(ticketRequestIDs))
+		seen := make(map[uint]bool, len(ticketRequestIDs))
+		for _, id := range entries {
+			if !seen[id] {
+				seen[id] = true
+				drawn = append(drawn, id)
+			}
+		}
+
+		return drawn
+	default:
+		return DrawLottery(seed, ticketRequestIDs)
+	}
+}
+
+func capLotteryWeight(weight int) int {
+	if weight < 1 {
+		return 1
+	}
+	if weight > models.MaxLotteryWeight {
+		return models.MaxLotteryWeight
+	}
+	return weight
+}
+
+// lotteryRequestWeights returns the lottery weight of every ticket request, a request
+// gets the highest weight of the priority groups its user belongs to and 1 otherwise
+func lotteryRequestWeights(tx *gorm.DB, ticketRelease *models.TicketRelease, ticketRequests []models.TicketRequest) ([]int, error) {
+	weights := make([]int, len(ticketRequests))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	methodDetail := ticketRelease.TicketReleaseMethodDetail
+	if methodDetail.LotteryPriorityMode == models.LOTTERY_PRIORITY_NONE || len(ticketRequests) == 0 {
+		return weights, nil
+	}
+
+	userIDs := make([]string, len(ticketRequests))
+	for i, ticketRequest := range ticketRequests {
+		userIDs[i] = ticketRequest.UserUGKthID
+	}
+
+	var event models.Event
+	if err := tx.Select("id", "organization_id").First(&event, ticketRelease.EventID).Error; err != nil {
+		return nil, err
+	}
+
+	var memberIDs []string
+	if err := tx.Table("organization_users").
+		Where("organization_id = ? AND user_ug_kth_id IN ?", event.OrganizationID, userIDs).
+		Pluck("user_ug_kth_id", &memberIDs).Error; err != nil {
+		return nil, err
+	}
+
+	var internalIDs []string
+	if err := tx.Model(&models.User{}).
+		Where("is_external = ? AND ug_kth_id IN ?", false, userIDs).
+		Pluck("ug_kth_id", &internalIDs).Error; err != nil {
+		return nil, err
+	}
+
+	var promoCodeIDs []string
+	if err := tx.Table("user_unlocked_ticket_releases").
+		Where("ticket_release_id = ? AND user_ug_kth_id IN ?", ticketRelease.ID, userIDs).
+		Pluck("user_ug_kth_id", &promoCodeIDs).Error; err != nil {
+		return nil, err
+	}
+
+	groups := []struct {
+		users  []string
+		weight int
+	}{
+		{memberIDs, models.NormalizeLotteryWeight(methodDetail.MemberLotteryWeight)},
+		{internalIDs, models.NormalizeLotteryWeight(methodDetail.InternalLotteryWeight)},
+		{promoCodeIDs, models.NormalizeLotteryWeight(methodDetail.PromoCodeLotteryWeight)},
+	}
+
+	for _, group := range groups {
+		inGroup := make(map[string]bool, len(group.users))
+		for _, id := range group.users {
+			inGroup[id] = true
+		}
+
+		for i, ticketRequest := range ticketRequests {
+			if inGroup[ticketRequest.UserUGKthID] && group.weight > weights[i] {
+				weights[i] = group.weight
+			}
+		}
+	}
+
+	return weights, nil
+}
+
+// SplitLotteryDraw splits the drawn ticket requests into winners and reserves the same way as
+// the allocation, a request only wins if there are tickets left of its ticket type for all of its
+// tickets. Requests missing from requestAmounts are for one ticket.
+func SplitLotteryDraw(drawn []uint, availableTickets int, requestTicketTypes map[uint]uint, requestAmounts map[uint]int, capacities map[uint]int) (winners, reserves []uint) {
+	typeRemaining := make(map[uint]int, len(capacities))
+	for ticketTypeID, remaining := range capacities {
+		typeRemaining[ticketTypeID] = remaining
+	}
+
+	capacity := allocate_service.NewTicketCapacity(availableTickets, typeRemaining)
+
+	winners = make([]uint, 0)
+	reserves = make([]uint, 0)
+	for _, id := range drawn {
+		ticketTypeID := requestTicketTypes[id]
+		amount, ok := requestAmounts[id]
+		if !ok {
+			amount = 1
+		}
+
+		if capacity.CanAllocate(ticketTypeID, amount) {
+			capacity.Allocate(ticketTypeID, amount)
+			winners = append(winners, id)
+		} else {
+			reserves = append(reserves, id)
+		}
+	}
+
+	return winners, reserves
+}
+
+func (lds *LotteryDrawService) GetLotteryDraw(ticketReleaseID uint) (*models.LotteryDraw, *types.ErrorResponse) {
+	draw, err := models.GetLotteryDrawForTicketRelease(lds.DB, ticketReleaseID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "No lottery has been drawn for this ticket release"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting lottery draw"}
+	}
+
+	return draw, nil
+}
+
+// VerifyLotteryDraw re-runs the draw of the ticket release without allocating any
+// tickets and compares the outcome with what was recorded when tickets were allocated
+func (lds *LotteryDrawService) VerifyLotteryDraw(ticketReleaseID uint) (*types.LotteryDrawVerification, *types.ErrorResponse) {
+	draw, rerr := lds.GetLotteryDraw(ticketReleaseID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	eligible, err := draw.GetEligibleRequestIDs()
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading eligible ticket requests"}
+	}
+
+	// The eligible ticket requests are derived again from the database, so that a draw
+	// that was recorded with other requests than the ones that were allocated is caught
+	derived, err := lds.lotteryEligibleRequestIDs(ticketReleaseID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting eligible ticket requests"}
+	}
+
+	recordedWinners, err := draw.GetWinnerRequestIDs()
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading lottery winners"}
+	}
+
+	recordedReserves, err := draw.GetReserveRequestIDs()
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading lottery reserves"}
+	}
+
+	weights, err := draw.GetRequestWeights()
+	if err != nil || (draw.PriorityMode != models.LOTTERY_PRIORITY_NONE && len(weights) != len(eligible)) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading lottery weights"}
+	}
+
+	drawn := DrawLotteryWithPriority(draw.Seed, draw.PriorityMode, eligible, weights)
+
+	ticketTypeIDs, err := draw.GetRequestTicketTypeIDs()
+	if err != nil || (len(ticketTypeIDs) > 0 && len(ticketTypeIDs) != len(eligible)) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading ticket types of the lottery"}
+	}
+
+	capacities, err := draw.GetTicketTypeCapacities()
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading ticket type capacities of the lottery"}
+	}
+
+	requestTicketTypes := make(map[uint]uint, len(ticketTypeIDs))
+	for i, ticketTypeID := range ticketTypeIDs {
+		requestTicketTypes[eligible[i]] = ticketTypeID
+	}
+
+	amounts, err := draw.GetRequestTicketAmounts()
+	if err != nil || (len(amounts) > 0 && len(amounts) != len(eligible)) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error reading ticket amounts of the lottery"}
+	}
+
+	requestAmounts := make(map[uint]int, len(amounts))
+	for i, amount := range amounts {
+		requestAmounts[eligible[i]] = amount
+	}
+
+	winners, reserves := SplitLotteryDraw(drawn, draw.AvailableTickets, requestTicketTypes, requestAmounts, capacities)
+
+	verification := &types.LotteryDrawVerification{
+		Seed:             draw.Seed,
+		RequestIDsHash:   draw.RequestIDsHash,
+		HashMatches:      models.HashTicketRequestIDs(derived) == draw.RequestIDsHash && models.HashTicketRequestIDs(eligible) == draw.RequestIDsHash,
+		WinnersMatch:     equalRequestIDs(winners, recordedWinners),
+		ReserveMatches:   equalRequestIDs(reserves, recordedReserves),
+		WinnerRequestIDs: winners,
+		ReserveOrder:     reserves,
+	}
+
+	verification.Verified = verification.HashMatches && verification.WinnersMatch && verification.ReserveMatches
+
+	return verification, nil
+}
+
+// lotteryEligibleRequestIDs returns the IDs of the ticket requests that took part in the lottery of
+// the ticket release, sorted by ID. These are the requests made during the open window that were
+// allocated a ticket or a reserve ticket, including requests and tickets that have since been deleted.
+func (lds *LotteryDrawService) lotteryEligibleRequestIDs(ticketReleaseID uint) ([]uint, error) {
+	var ticketRelease models.TicketRelease
+	if err := lds.DB.Preload("TicketReleaseMethodDetail").First(&ticketRelease, ticketReleaseID).Error; err != nil {
+		return nil, err
+	}
+
+	deadline := utils.ConvertUNIXTimeToDateTime(int64(ticketRelease.Open + ticketRelease.TicketReleaseMethodDetail.OpenWindowDuration))
+
+	var ids []uint
+	if err := lds.DB.Unscoped().Model(&models.TicketRequest{}).
+		Where("ticket_release_id = ? AND created_at <= ?", ticketReleaseID, deadline).
+		Where("id IN (?)", lds.DB.Unscoped().Model(&models.Ticket{}).Select("ticket_request_id")).
+		Order("id").
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func equalRequestIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,14 +3,17 @@ package services
 import (
 	"fmt"
 	"html/template" // Use this for HTML templates
+	"log"
 	"math"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/jobs"
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/DowLucas/gin-ticket-release/utils"
+	"github.com/stripe/stripe-go/v72"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +21,10 @@ func AddEmailJob(db *gorm.DB, user *models.User, subject, htmlContent string) {
 	jobs.AddEmailJobToQueue(db, user, subject, htmlContent, nil)
 }
 
+func addEmailJobWithOptions(db *gorm.DB, user *models.User, subject, htmlContent string, options jobs.EmailJobOptions) {
+	jobs.AddEmailJobToQueueWithOptions(db, user, subject, htmlContent, nil, options)
+}
+
 func Notify_TicketRequestCancelled(db *gorm.DB, user *models.User, organization *models.Organization, eventName string) error {
 	if os.Getenv("ENV") == "test" {
 		return nil
@@ -61,6 +68,10 @@ func Notify_TicketCancelled(db *gorm.DB, user *models.User, organization *models
 }
 
 func Notify_TicketAllocationCreated(db *gorm.DB, ticketId int, paymentDeadline *time.Time) error {
+	return notifyTicketAllocationCreated(db, ticketId, paymentDeadline, jobs.EmailJobOptions{})
+}
+
+func notifyTicketAllocationCreated(db *gorm.DB, ticketId int, paymentDeadline *time.Time, options jobs.EmailJobOptions) error {
 	if os.Getenv("ENV") == "test" {
 		return nil
 	}
@@ -108,12 +119,16 @@ func Notify_TicketAllocationCreated(db *gorm.DB, ticketId int, paymentDeadline *
 		return err
 	}
 
-	AddEmailJob(db, &user, fmt.Sprintf("Your ticket to %s!", event.Name), htmlContent)
+	addEmailJobWithOptions(db, &user, fmt.Sprintf("Your ticket to %s!", event.Name), htmlContent, options)
 
 	return nil
 }
 
 func Notify_ReserveTicketAllocationCreated(db *gorm.DB, ticketId int) error {
+	return notifyReserveTicketAllocationCreated(db, ticketId, jobs.EmailJobOptions{})
+}
+
+func notifyReserveTicketAllocationCreated(db *gorm.DB, ticketId int, options jobs.EmailJobOptions) error {
 	if os.Getenv("ENV") == "test" {
 		return nil
 	}
@@ -152,7 +167,7 @@ func Notify_ReserveTicketAllocationCreated(db *gorm.DB, ticketId int) error {
 		return err
 	}
 
-	AddEmailJob(db, &user, fmt.Sprintf("Your reserve ticket to %s", event.Name), htmlContent)
+	addEmailJobWithOptions(db, &user, fmt.Sprintf("Your reserve ticket to %s", event.Name), htmlContent, options)
 
 	return nil
 }
@@ -254,7 +269,99 @@ func Notify_TicketPaymentConfirmation(db *gorm.DB, ticketId int) error {
 		return err
 	}
 
-	AddEmailJob(db, &user, fmt.Sprintf("Ticket payment confirmation to %s!", event.Name), htmlContent)
+	// Every ticket of the request is attached. The email is sent without the printable tickets if
+	// they can't be generated, the tickets are still in the profile.
+	var options jobs.EmailJobOptions
+	if options.Attachments, err = TicketPDFAttachments(db, ticket.TicketRequestID); err != nil {
+		log.Printf("Failed to generate PDFs of ticket request %d: %v", ticket.TicketRequestID, err)
+	}
+
+	addEmailJobWithOptions(db, &user, fmt.Sprintf("Ticket payment confirmation to %s!", event.Name), htmlContent, options)
+
+	return nil
+}
+
+// Notify_OrderPaymentConfirmation sends one receipt for all the tickets and add-ons paid with the order
+func Notify_OrderPaymentConfirmation(db *gorm.DB, orderID uint) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	var order models.Order
+	err := db.
+		Preload("User").
+		Preload("Items.Ticket.TicketRequest.TicketRelease.Event.Organization").
+		Preload("Items.Ticket.TicketRequest.TicketType").
+		Preload("Items.Ticket.TicketAddOns.AddOn").
+		First(&order, orderID).Error
+	if err != nil {
+		return err
+	}
+
+	user := order.User
+	if user.Email == "" {
+		return fmt.Errorf("user email is empty")
+	}
+
+	var tickets []types.EmailTicket
+	var events []types.EmailOrderEvent
+	addedEvents := map[uint]bool{}
+
+	for _, item := range order.Items {
+		ticketRequest := item.Ticket.TicketRequest
+		event := ticketRequest.TicketRelease.Event
+
+		tickets = append(tickets, types.EmailTicket{
+			Name:  fmt.Sprintf("%s: %d x %s", event.Name, item.TicketAmount, ticketRequest.TicketType.Name),
+			Price: fmt.Sprintf("%.2f", math.Round(100*ticketRequest.TicketType.Price*float64(item.TicketAmount))/100),
+		})
+
+		for _, addOn := range item.Ticket.TicketAddOns {
+			tickets = append(tickets, types.EmailTicket{
+				Name:  fmt.Sprintf("%s: %d x %s", event.Name, addOn.Quantity, addOn.AddOn.Name),
+				Price: fmt.Sprintf("%.2f", math.Round(100*addOn.AddOn.Price*float64(addOn.Quantity))/100),
+			})
+		}
+
+		if !addedEvents[event.ID] {
+			addedEvents[event.ID] = true
+			events = append(events, types.EmailOrderEvent{Name: event.Name, OrganizationEmail: event.Organization.Email})
+		}
+	}
+
+	if order.DiscountAmount > 0 {
+		tickets = append(tickets, types.EmailTicket{
+			Name:  fmt.Sprintf("Discount code %s", order.DiscountCode),
+			Price: fmt.Sprintf("-%.2f", float64(order.DiscountAmount)/100),
+		})
+	}
+
+	emailTicketString, _ := utils.GenerateEmailTable(tickets)
+
+	data := types.EmailOrderPaymentConfirmation{
+		FullName:    user.FullName(),
+		OrderID:     order.ID,
+		TicketsHTML: template.HTML(emailTicketString),
+		Total:       fmt.Sprintf("%.2f", float64(order.Amount)/100),
+		Events:      events,
+	}
+
+	htmlContent, err := utils.ParseTemplate("templates/emails/order_payment_confirmation.html", data)
+	if err != nil {
+		return err
+	}
+
+	ticketRequestIDs := make([]uint, len(order.Items))
+	for i, item := range order.Items {
+		ticketRequestIDs[i] = item.TicketRequestID
+	}
+
+	var options jobs.EmailJobOptions
+	if options.Attachments, err = TicketPDFAttachments(db, ticketRequestIDs...); err != nil {
+		log.Printf("Failed to generate PDFs of order %d: %v", order.ID, err)
+	}
+
+	addEmailJobWithOptions(db, &user, fmt.Sprintf("Payment confirmation for order %d", order.ID), htmlContent, options)
 
 	return nil
 }
@@ -448,3 +555,225 @@ func Notify_UpdatedPaymentDeadlineEmail(db *gorm.DB, ticketId int, paymentDeadli
 
 	return nil
 }
+
+// Notify_TicketTransferInitiated notifies the recipient that someone wants to transfer their ticket to them
+func Notify_TicketTransferInitiated(db *gorm.DB, sender, recipient *models.User, event *models.Event) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	data := types.EmailTicketTransferInitiated{
+		FullName:          recipient.FullName(),
+		SenderName:        sender.FullName(),
+		EventName:         event.Name,
+		TransfersURL:      os.Getenv("FRONTEND_BASE_URL") + "/profile/tickets",
+		OrganizationEmail: event.Organization.Email,
+	}
+
+	htmlContent, err := utils.ParseTemplate("templates/emails/ticket_transfer_initiated.html", data)
+	if err != nil {
+		return err
+	}
+
+	AddEmailJob(db, recipient, fmt.Sprintf("%s wants to transfer a ticket to %s to you", sender.FullName(), event.Name), htmlContent)
+
+	return nil
+}
+
+// Notify_TicketTransferCompleted notifies the sender, the recipient and the organization that a ticket has been transferred
+func Notify_TicketTransferCompleted(db *gorm.DB, sender, recipient *models.User, event *models.Event, numberOfTickets int) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	for _, received := range []bool{false, true} {
+		user, other := sender, recipient
+		subject := fmt.Sprintf("Your ticket to %s has been transferred", event.Name)
+		if received {
+			user, other = recipient, sender
+			subject = fmt.Sprintf("You have received a ticket to %s", event.Name)
+		}
+
+		data := types.EmailTicketTransferCompleted{
+			FullName:          user.FullName(),
+			OtherName:         other.FullName(),
+			EventName:         event.Name,
+			NumberOfTickets:   numberOfTickets,
+			Received:          received,
+			TicketURL:         os.Getenv("FRONTEND_BASE_URL") + "/profile/tickets",
+			OrganizationEmail: event.Organization.Email,
+		}
+
+		htmlContent, err := utils.ParseTemplate("templates/emails/ticket_transfer_completed.html", data)
+		if err != nil {
+			return err
+		}
+
+		AddEmailJob(db, user, subject, htmlContent)
+	}
+
+	data := types.EmailTicketTransferOrganization{
+		OrganizationName: event.Organization.Name,
+		EventName:        event.Name,
+		SenderName:       sender.FullName(),
+		SenderEmail:      sender.Email,
+		RecipientName:    recipient.FullName(),
+		RecipientEmail:   recipient.Email,
+		NumberOfTickets:  numberOfTickets,
+	}
+
+	htmlContent, err := utils.ParseTemplate("templates/emails/ticket_transfer_organization.html", data)
+	if err != nil {
+		return err
+	}
+
+	return jobs.SendContactEmail(event.Organization.Name, event.Organization.Email, sender.Email,
+		fmt.Sprintf("Ticket transferred for %s", event.Name), htmlContent)
+}
+
+// Notify_TicketResold tells the seller that their tickets have been sold, refundAmount is in öre
+func Notify_TicketResold(db *gorm.DB, seller *models.User, event *models.Event, numberOfTickets int, refundAmount int) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	var refundAmountString string
+	if refundAmount > 0 {
+		refundAmountString = fmt.Sprintf("%.2f SEK", float64(refundAmount)/100)
+	}
+
+	data := types.EmailTicketResold{
+		FullName:          seller.FullName(),
+		EventName:         event.Name,
+		NumberOfTickets:   numberOfTickets,
+		RefundAmount:      refundAmountString,
+		OrganizationEmail: event.Organization.Email,
+	}
+
+	htmlContent, err := utils.ParseTemplate("templates/emails/ticket_resold.html", data)
+	if err != nil {
+		return err
+	}
+
+	AddEmailJob(db, seller, fmt.Sprintf("Your ticket to %s has been sold", event.Name), htmlContent)
+
+	return nil
+}
+
+// Notify_TicketPaymentFailed tells the user that their payment failed, as long as they can still pay for the ticket
+func Notify_TicketPaymentFailed(db *gorm.DB, payment *models.Transaction) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	var ticket models.Ticket
+	err := db.
+		Preload("TicketRequest.User").
+		Preload("TicketRequest.TicketRelease.Event.Organization").
+		First(&ticket, payment.TicketID).Error
+	if err != nil {
+		// The ticket has been removed, there is nothing to retry
+		return nil
+	}
+
+	if ticket.IsPaid || (ticket.PaymentDeadline != nil && time.Now().After(*ticket.PaymentDeadline)) {
+		return nil
+	}
+
+	user := ticket.TicketRequest.User
+	event := ticket.TicketRequest.TicketRelease.Event
+
+	var payBeforeString string
+	if ticket.PaymentDeadline != nil {
+		payBeforeString = ticket.PaymentDeadline.Format("2006-01-02 15:04:05")
+	}
+
+	data := types.EmailTicketPaymentFailed{
+		FullName:          user.FullName(),
+		EventName:         event.Name,
+		FailureMessage:    payment.FailureMessage,
+		PayBefore:         payBeforeString,
+		TicketURL:         os.Getenv("FRONTEND_BASE_URL") + "/profile/tickets",
+		OrganizationEmail: event.Organization.Email,
+	}
+
+	htmlContent, err := utils.ParseTemplate("templates/emails/ticket_payment_failed.html", data)
+	if err != nil {
+		return err
+	}
+
+	AddEmailJob(db, &user, fmt.Sprintf("Your payment for %s failed", event.Name), htmlContent)
+
+	return nil
+}
+
+// Notify_PaymentDisputed tells the organization that a payment has been disputed, or that the dispute has been closed
+func Notify_PaymentDisputed(db *gorm.DB, payment *models.Transaction, dispute *stripe.Dispute, closed bool) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	var event models.Event
+	if err := db.Preload("Organization").First(&event, payment.EventID).Error; err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := db.Where("ug_kth_id = ?", payment.UserUGKthID).First(&user).Error; err != nil {
+		return err
+	}
+
+	data := types.EmailPaymentDisputeOrganization{
+		OrganizationName: event.Organization.Name,
+		EventName:        event.Name,
+		UserName:         user.FullName(),
+		UserEmail:        user.Email,
+		Amount:           fmt.Sprintf("%.2f %s", float64(dispute.Amount)/100, strings.ToUpper(string(dispute.Currency))),
+		Reason:           string(dispute.Reason),
+		Status:           string(dispute.Status),
+		Closed:           closed,
+	}
+
+	htmlContent, err := utils.ParseTemplate("templates/emails/payment_dispute_organization.html", data)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Payment disputed for %s", event.Name)
+	if closed {
+		subject = fmt.Sprintf("Payment dispute closed for %s", event.Name)
+	}
+
+	return jobs.SendContactEmail(event.Organization.Name, event.Organization.Email, user.Email, subject, htmlContent)
+}
+
+// Notify_WebhookEventDeadLettered alerts the super admins that a webhook event will no longer be retried
+func Notify_WebhookEventDeadLettered(db *gorm.DB, webhookEvent *models.WebhookEvent) error {
+	if os.Getenv("ENV") == "test" {
+		return nil
+	}
+
+	admins, err := models.GetUsersWithRole(db, "super_admin")
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range admins {
+		data := types.EmailWebhookEventDeadLetter{
+			FullName:  admin.FullName(),
+			StripeID:  webhookEvent.StripeID,
+			EventType: webhookEvent.EventType,
+			Attempts:  webhookEvent.Attempts,
+			LastError: webhookEvent.LastError,
+		}
+
+		htmlContent, err := utils.ParseTemplate("templates/emails/webhook_event_dead_letter.html", data)
+		if err != nil {
+			return err
+		}
+
+		AddEmailJob(db, &admin, fmt.Sprintf("Webhook event %s failed", webhookEvent.StripeID), htmlContent)
+	}
+
+	return nil
+}
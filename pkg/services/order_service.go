@@ -0,0 +1,284 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"gorm.io/gorm"
+)
+
+// CreateOrder starts one payment of the unpaid tickets of several ticket requests of the user,
+// together with their add-ons. The tickets of a ticket request are paid for together, so any
+// ticket of a request adds the whole request. The discount code, if not empty, reduces the price
+// of the tickets it is valid for. An unfinished order of the same tickets and discount code is
+// reused, other unfinished payments of the tickets are canceled so that they can not be paid twice.
+func (ps *PaymentService) CreateOrder(ugkthid string, ticketIds []int, discountCode string) (*models.Order, *payment_provider.Payment, *types.ErrorResponse) {
+	if len(ticketIds) == 0 {
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "No tickets to pay for"}
+	}
+
+	var tickets []*models.Ticket
+	var ticketAmounts []int
+	var providerName models.PaymentProviderName
+	added := map[uint]bool{}
+
+	for _, ticketId := range ticketIds {
+		ticket, ticketAmount, rerr := getPayableTicketGroup(ps.DB, ugkthid, ticketId)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+
+		if added[ticket.ID] {
+			continue
+		}
+		added[ticket.ID] = true
+
+		name, err := models.GetPaymentProviderForTicketRelease(ps.DB, &ticket.TicketRequest.TicketRelease)
+		if err != nil {
+			return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting payment provider"}
+		}
+
+		if providerName != "" && name != providerName {
+			return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The tickets are paid with different payment providers and can not be paid together"}
+		}
+		providerName = name
+
+		tickets = append(tickets, ticket)
+		ticketAmounts = append(ticketAmounts, ticketAmount)
+	}
+
+	provider, err := payment_provider.Get(providerName)
+	if err != nil {
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	discountCode = models.NormalizeDiscountCode(discountCode)
+
+	// The discount code is for one event, it is used for the tickets it is valid for
+	discounts := make([]*models.DiscountCode, len(tickets))
+	if discountCode != "" {
+		applied := map[uint]int{}
+		for i, ticket := range tickets {
+			discount, rerr := findTicketDiscountCode(ps.DB, ticket, discountCode)
+			if rerr != nil {
+				return nil, nil, rerr
+			}
+
+			if discount == nil {
+				continue
+			}
+
+			if rerr := checkDiscountCode(ps.DB, discount, ugkthid, int(ticket.ID), applied[discount.ID]); rerr != nil {
+				return nil, nil, rerr
+			}
+
+			applied[discount.ID]++
+			discounts[i] = discount
+		}
+
+		if len(applied) == 0 {
+			return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid discount code"}
+		}
+	}
+
+	order, payment, rerr := ps.getUnfinishedOrder(tickets, provider, discountCode)
+	if rerr != nil {
+		return nil, nil, rerr
+	}
+
+	if order != nil {
+		return order, payment, nil
+	}
+
+	// Payments of the tickets that have not been completed are replaced by the payment of the order
+	canceled := map[string]bool{}
+	for _, ticket := range tickets {
+		purchase := ticket.Transaction
+		if purchase == nil || purchase.PaymentIntentID == "" || canceled[purchase.PaymentIntentID] {
+			continue
+		}
+
+		if purchase.Status == models.TransactionStatusPending || purchase.Status == models.TransactionStatusFailed {
+			if rerr := cancelUnfinishedPayment(ps.DB, purchase); rerr != nil {
+				return nil, nil, rerr
+			}
+			canceled[purchase.PaymentIntentID] = true
+		}
+	}
+
+	user := tickets[0].TicketRequest.User
+
+	order = &models.Order{
+		UserUGKthID:     ugkthid,
+		Status:          models.OrderStatusPending,
+		Currency:        "sek",
+		PaymentProvider: providerName,
+		DiscountCode:    discountCode,
+	}
+
+	var descriptions []string
+	for i, ticket := range tickets {
+		amount, _ := ticketGroupAmount(ticket, ticketAmounts[i])
+		item := models.OrderItem{
+			TicketID:        ticket.ID,
+			TicketRequestID: ticket.TicketRequestID,
+			EventID:         ticket.TicketRequest.TicketRelease.EventID,
+			TicketAmount:    ticketAmounts[i],
+		}
+
+		if discount := discounts[i]; discount != nil {
+			item.DiscountCodeID = &discount.ID
+			item.DiscountAmount = ticketGroupDiscount(discount, ticket, ticketAmounts[i])
+			amount -= item.DiscountAmount
+		}
+
+		item.Amount = amount
+		order.Amount += amount
+		order.DiscountAmount += item.DiscountAmount
+		order.Items = append(order.Items, item)
+
+		descriptions = append(descriptions, fmt.Sprintf("%d x %s, %s",
+			ticketAmounts[i], ticket.TicketRequest.TicketType.Name, ticket.TicketRequest.TicketRelease.Event.Name))
+	}
+
+	if err := ps.DB.Create(order).Error; err != nil {
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating order"}
+	}
+
+	metadata := map[string]string{
+		"tessera_order_id":        strconv.Itoa(int(order.ID)),
+		"tessera_user_id":         user.UGKthID,
+		"tessera_recipient_email": user.Email,
+		"tessera_order_items":     strings.Join(descriptions, "; "),
+	}
+
+	if order.DiscountAmount > 0 {
+		metadata["tessera_discount_code"] = discountCode
+		metadata["tessera_discount_amount"] = fmt.Sprintf("%.2f", float64(order.DiscountAmount)/100)
+	}
+
+	payment, err = provider.CreatePayment(&payment_provider.PaymentParams{
+		TicketID:       int(tickets[0].ID),
+		EventID:        tickets[0].TicketRequest.TicketRelease.EventID,
+		UserUGKthID:    user.UGKthID,
+		Email:          user.Email,
+		FullName:       user.FullName(),
+		Amount:         order.Amount,
+		Currency:       order.Currency,
+		Description:    fmt.Sprintf("Order %d: %s", order.ID, strings.Join(descriptions, "; ")),
+		Metadata:       metadata,
+		IdempotencyKey: fmt.Sprintf("order-%d", order.ID),
+	})
+	if err != nil {
+		ps.DB.Model(order).Update("status", models.OrderStatusCanceled)
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	tx := ps.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	order.PaymentIntentID = payment.ID
+	if err := tx.Model(order).Update("payment_intent_id", payment.ID).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating order"}
+	}
+
+	for _, item := range order.Items {
+		if err := createPendingTransaction(tx, &models.Transaction{
+			PaymentIntentID: payment.ID,
+			TicketID:        int(item.TicketID),
+			EventID:         item.EventID,
+			UserUGKthID:     user.UGKthID,
+			Amount:          item.Amount,
+			Currency:        payment.Currency,
+			PaymentProvider: providerName,
+			OrderID:         &order.ID,
+			DiscountCodeID:  item.DiscountCodeID,
+			DiscountAmount:  item.DiscountAmount,
+		}); err != nil {
+			tx.Rollback()
+			return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("Error creating pending transaction: %v", err)}
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating order"}
+	}
+
+	return order, payment, nil
+}
+
+// getUnfinishedOrder returns the order that all the tickets are being paid with, if it contains
+// exactly these tickets with the same discount code and its payment can still be completed
+func (ps *PaymentService) getUnfinishedOrder(tickets []*models.Ticket, provider payment_provider.PaymentProvider, discountCode string) (*models.Order, *payment_provider.Payment, *types.ErrorResponse) {
+	var orderID uint
+	for _, ticket := range tickets {
+		purchase := ticket.Transaction
+		if purchase == nil || purchase.OrderID == nil || (orderID != 0 && *purchase.OrderID != orderID) {
+			return nil, nil, nil
+		}
+		orderID = *purchase.OrderID
+	}
+
+	var order models.Order
+	if err := ps.DB.Preload("Items").Where("id = ? AND status = ?", orderID, models.OrderStatusPending).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting order"}
+	}
+
+	if len(order.Items) != len(tickets) || order.PaymentProvider != provider.Name() || order.DiscountCode != discountCode {
+		return nil, nil, nil
+	}
+
+	payment, err := provider.GetPayment(order.PaymentIntentID)
+	if err != nil {
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	switch payment.Status {
+	case payment_provider.PaymentPending:
+		return &order, payment, nil
+	case payment_provider.PaymentSucceeded:
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The payment is already being processed"}
+	default:
+		return nil, nil, nil
+	}
+}
+
+// GetOrders returns the orders of the user, newest first
+func (ps *PaymentService) GetOrders(ugkthid string) ([]models.Order, *types.ErrorResponse) {
+	orders, err := models.GetOrdersForUser(ps.DB, ugkthid)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting orders"}
+	}
+
+	return orders, nil
+}
+
+func (ps *PaymentService) GetOrder(ugkthid string, orderID uint) (*models.Order, *types.ErrorResponse) {
+	var order models.Order
+	if err := ps.DB.
+		Preload("Items.Ticket.TicketRequest.TicketType").
+		Preload("Items.Ticket.TicketRequest.TicketRelease.Event").
+		Where("id = ? AND user_ug_kth_id = ?", orderID, ugkthid).
+		First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Order not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting order"}
+	}
+
+	return &order, nil
+}
@@ -0,0 +1,135 @@
+package payment_provider
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentPending   PaymentStatus = "pending"   // The payer has not completed the payment yet
+	PaymentSucceeded PaymentStatus = "succeeded" // The money has been received
+	PaymentFailed    PaymentStatus = "failed"    // The payment failed and a new payment is needed
+	PaymentCanceled  PaymentStatus = "canceled"  // The payment was canceled and a new payment is needed
+)
+
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// PaymentParams describes a payment of one or more tickets, amounts are in öre
+type PaymentParams struct {
+	TicketID       int
+	EventID        int
+	UserUGKthID    string
+	Email          string
+	FullName       string
+	Amount         int
+	Currency       string
+	Description    string
+	Metadata       map[string]string
+	IdempotencyKey string // Creating a payment twice with the same key returns the same payment
+}
+
+// Payment is a payment at the provider. ClientSecret is what the frontend needs to complete
+// the payment, the Stripe client secret or the Swish payment request token.
+type Payment struct {
+	ID             string
+	Status         PaymentStatus
+	Amount         int
+	Currency       string
+	ClientSecret   string
+	FailureMessage string
+}
+
+type RefundParams struct {
+	PaymentID      string
+	Amount         int
+	Currency       string
+	IdempotencyKey string // Refunding twice with the same key refunds the payment once
+}
+
+type Refund struct {
+	ID     string
+	Status RefundStatus
+}
+
+type CallbackType string
+
+const (
+	CallbackPaymentSucceeded CallbackType = "payment.succeeded"
+	CallbackPaymentFailed    CallbackType = "payment.failed"
+	CallbackPaymentCanceled  CallbackType = "payment.canceled"
+	CallbackRefundSucceeded  CallbackType = "refund.succeeded"
+	CallbackRefundFailed     CallbackType = "refund.failed"
+	CallbackIgnored          CallbackType = "ignored" // The callback is not about a payment or refund
+)
+
+// Callback is a notification from the provider that a payment or refund has changed.
+// ID is unique for each notification so that a repeated notification is handled once.
+type Callback struct {
+	ID             string
+	Type           CallbackType
+	PaymentID      string
+	RefundID       string
+	TicketID       int // 0 if the provider does not tell which ticket the payment is for
+	Amount         int
+	FailureMessage string
+}
+
+type PaymentProvider interface {
+	Name() models.PaymentProviderName
+	CreatePayment(params *PaymentParams) (*Payment, error)
+	GetPayment(paymentID string) (*Payment, error)
+	// CancelPayment cancels a pending payment so that it can no longer be completed
+	CancelPayment(paymentID string) error
+	Refund(params *RefundParams) (*Refund, error)
+	// ParseCallback verifies and parses a notification sent by the provider
+	ParseCallback(payload []byte, header http.Header) (*Callback, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[models.PaymentProviderName]PaymentProvider{}
+)
+
+func init() {
+	Register(NewStripeProvider())
+	Register(NewSwishProvider())
+}
+
+// Register makes the provider available under its name and returns the provider it replaced,
+// which lets tests swap in a fake provider
+func Register(provider PaymentProvider) (previous PaymentProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	previous = providers[provider.Name()]
+	providers[provider.Name()] = provider
+	return previous
+}
+
+// Get returns the provider with the name, the default provider if the name is empty
+func Get(name models.PaymentProviderName) (PaymentProvider, error) {
+	if name == "" {
+		name = models.DefaultPaymentProvider
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("payment provider %s is not available", name)
+	}
+
+	return provider, nil
+}
@@ -0,0 +1,193 @@
+package payment_provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/customer"
+	"github.com/stripe/stripe-go/v72/paymentintent"
+	"github.com/stripe/stripe-go/v72/refund"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// StripeProvider takes card payments through Stripe payment intents
+type StripeProvider struct{}
+
+func NewStripeProvider() *StripeProvider {
+	return &StripeProvider{}
+}
+
+func (sp *StripeProvider) Name() models.PaymentProviderName {
+	return models.STRIPE
+}
+
+func (sp *StripeProvider) CreatePayment(params *PaymentParams) (*Payment, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	cust, err := sp.getOrCreateCustomer(params.Email, params.FullName)
+	if err != nil {
+		return nil, err
+	}
+
+	piParams := &stripe.PaymentIntentParams{
+		Params: stripe.Params{
+			Metadata: params.Metadata,
+		},
+		Customer:           stripe.String(cust.ID),
+		Amount:             stripe.Int64(int64(params.Amount)),
+		Currency:           stripe.String(params.Currency),
+		PaymentMethodTypes: []*string{stripe.String("card")},
+		ReceiptEmail:       stripe.String(params.Email),
+		Description:        stripe.String(params.Description),
+	}
+	piParams.IdempotencyKey = stripe.String(params.IdempotencyKey)
+
+	pi, err := paymentintent.New(piParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return stripePayment(pi), nil
+}
+
+func (sp *StripeProvider) getOrCreateCustomer(email, name string) (*stripe.Customer, error) {
+	customerParams := &stripe.CustomerListParams{}
+	customerParams.Filters.AddFilter("email", "", email)
+	customerParams.Single = true
+
+	// Try to find existing customer
+	existingCustomerIter := customer.List(customerParams)
+	var cust *stripe.Customer
+	for existingCustomerIter.Next() {
+		cust = existingCustomerIter.Customer()
+	}
+
+	if cust != nil {
+		return cust, nil
+	}
+
+	// No customer found, creating a new one
+	return customer.New(&stripe.CustomerParams{
+		Email: stripe.String(email),
+		Name:  stripe.String(name),
+	})
+}
+
+func (sp *StripeProvider) GetPayment(paymentID string) (*Payment, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	pi, err := paymentintent.Get(paymentID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return stripePayment(pi), nil
+}
+
+func (sp *StripeProvider) CancelPayment(paymentID string) error {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	_, err := paymentintent.Cancel(paymentID, nil)
+	return err
+}
+
+func (sp *StripeProvider) Refund(params *RefundParams) (*Refund, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	refundParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.PaymentID),
+		Amount:        stripe.Int64(int64(params.Amount)),
+	}
+	refundParams.IdempotencyKey = stripe.String(params.IdempotencyKey)
+
+	r, err := refund.New(refundParams)
+	if err != nil {
+		return nil, err
+	}
+
+	status := RefundPending
+	switch r.Status {
+	case stripe.RefundStatusSucceeded:
+		status = RefundSucceeded
+	case stripe.RefundStatusFailed, stripe.RefundStatusCanceled:
+		status = RefundFailed
+	}
+
+	return &Refund{ID: r.ID, Status: status}, nil
+}
+
+func (sp *StripeProvider) ParseCallback(payload []byte, header http.Header) (*Callback, error) {
+	event, err := webhook.ConstructEvent(payload, header.Get("Stripe-Signature"), os.Getenv("STRIPE_WEBHOOK_SECRET"))
+	if err != nil {
+		return nil, err
+	}
+
+	return StripeEventCallback(&event)
+}
+
+// StripeEventCallback returns the callback for payment intent events. Other Stripe events,
+// such as refunds and disputes of charges, are handled by the payment webhook.
+func StripeEventCallback(event *stripe.Event) (*Callback, error) {
+	var callbackType CallbackType
+	switch event.Type {
+	case "payment_intent.succeeded":
+		callbackType = CallbackPaymentSucceeded
+	case "payment_intent.payment_failed":
+		callbackType = CallbackPaymentFailed
+	case "payment_intent.canceled":
+		callbackType = CallbackPaymentCanceled
+	default:
+		return &Callback{ID: event.ID, Type: CallbackIgnored}, nil
+	}
+
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return nil, fmt.Errorf("error parsing webhook JSON: %w", err)
+	}
+
+	callback := &Callback{
+		ID:        event.ID,
+		Type:      callbackType,
+		PaymentID: pi.ID,
+		Amount:    int(pi.Amount),
+	}
+
+	if ticketID, err := strconv.Atoi(pi.Metadata["tessera_ticket_id"]); err == nil {
+		callback.TicketID = ticketID
+	}
+
+	if pi.LastPaymentError != nil {
+		callback.FailureMessage = pi.LastPaymentError.Msg
+	}
+
+	return callback, nil
+}
+
+func stripePayment(pi *stripe.PaymentIntent) *Payment {
+	payment := &Payment{
+		ID:           pi.ID,
+		Status:       PaymentPending,
+		Amount:       int(pi.Amount),
+		Currency:     string(pi.Currency),
+		ClientSecret: pi.ClientSecret,
+	}
+
+	// A payment intent whose payment failed can be confirmed again, so it is still pending
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		payment.Status = PaymentSucceeded
+	case stripe.PaymentIntentStatusCanceled:
+		payment.Status = PaymentCanceled
+	}
+
+	if pi.LastPaymentError != nil {
+		payment.FailureMessage = pi.LastPaymentError.Msg
+	}
+
+	return payment
+}
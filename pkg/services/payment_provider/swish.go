@@ -0,0 +1,417 @@
+package payment_provider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+)
+
+const defaultSwishAPIURL = "https://cpc.getswish.net/swish-cpcapi"
+
+// SwishProvider takes payments through the Swish Commerce API. The merchant certificate is
+// read from SWISH_CERT_FILE and SWISH_KEY_FILE, and Swish sends callbacks to SWISH_CALLBACK_URL.
+// The fields are read from the environment the first time they are needed if they are empty.
+type SwishProvider struct {
+	BaseURL     string
+	PayeeAlias  string // The Swish number of the merchant
+	CallbackURL string
+	Client      *http.Client
+
+	mu sync.Mutex
+}
+
+func NewSwishProvider() *SwishProvider {
+	return &SwishProvider{}
+}
+
+func (sp *SwishProvider) Name() models.PaymentProviderName {
+	return models.SWISH
+}
+
+type swishPaymentRequest struct {
+	PayeePaymentReference string `json:"payeePaymentReference"`
+	CallbackURL           string `json:"callbackUrl"`
+	PayeeAlias            string `json:"payeeAlias"`
+	Amount                string `json:"amount"`
+	Currency              string `json:"currency"`
+	Message               string `json:"message"`
+}
+
+type swishRefundRequest struct {
+	OriginalPaymentReference string `json:"originalPaymentReference"`
+	CallbackURL              string `json:"callbackUrl"`
+	PayerAlias               string `json:"payerAlias"`
+	Amount                   string `json:"amount"`
+	Currency                 string `json:"currency"`
+	Message                  string `json:"message"`
+}
+
+// swishObject is a payment request or a refund as returned by Swish and sent in callbacks
+type swishObject struct {
+	ID                       string  `json:"id"`
+	PayeePaymentReference    string  `json:"payeePaymentReference"`
+	PaymentReference         string  `json:"paymentReference"`
+	OriginalPaymentReference string  `json:"originalPaymentReference"`
+	Status                   string  `json:"status"`
+	Amount                   float64 `json:"amount"`
+	Currency                 string  `json:"currency"`
+	ErrorCode                string  `json:"errorCode"`
+	ErrorMessage             string  `json:"errorMessage"`
+}
+
+type swishError struct {
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (sp *SwishProvider) CreatePayment(params *PaymentParams) (*Payment, error) {
+	if err := sp.configure(); err != nil {
+		return nil, err
+	}
+
+	instructionID := swishInstructionID(params.IdempotencyKey)
+	body := swishPaymentRequest{
+		PayeePaymentReference: strconv.Itoa(params.TicketID),
+		CallbackURL:           sp.CallbackURL,
+		PayeeAlias:            sp.PayeeAlias,
+		Amount:                swishAmount(params.Amount),
+		Currency:              strings.ToUpper(params.Currency),
+		Message:               swishMessage(params.Description),
+	}
+
+	resp, err := sp.do(http.MethodPut, "/api/v2/paymentrequests/"+instructionID, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, swishResponseError(resp)
+	}
+
+	return &Payment{
+		ID:           instructionID,
+		Status:       PaymentPending,
+		Amount:       params.Amount,
+		Currency:     body.Currency,
+		ClientSecret: resp.Header.Get("PaymentRequestToken"),
+	}, nil
+}
+
+func (sp *SwishProvider) GetPayment(paymentID string) (*Payment, error) {
+	object, err := sp.getPaymentRequest(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &Payment{
+		ID:             object.ID,
+		Status:         swishPaymentStatus(object.Status),
+		Amount:         swishAmountToOre(object.Amount),
+		Currency:       object.Currency,
+		FailureMessage: object.ErrorMessage,
+	}
+
+	return payment, nil
+}
+
+type swishPatch struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+func (sp *SwishProvider) CancelPayment(paymentID string) error {
+	if err := sp.configure(); err != nil {
+		return err
+	}
+
+	patch := []swishPatch{{Op: "replace", Path: "/status", Value: "cancelled"}}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, sp.BaseURL+"/api/v1/paymentrequests/"+paymentID, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json-patch+json")
+
+	resp, err := sp.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return swishResponseError(resp)
+	}
+
+	return nil
+}
+
+func (sp *SwishProvider) Refund(params *RefundParams) (*Refund, error) {
+	payment, err := sp.getPaymentRequest(params.PaymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.PaymentReference == "" {
+		return nil, fmt.Errorf("swish payment %s has not been paid", params.PaymentID)
+	}
+
+	instructionID := swishInstructionID(params.IdempotencyKey)
+	body := swishRefundRequest{
+		OriginalPaymentReference: payment.PaymentReference,
+		CallbackURL:              sp.CallbackURL,
+		PayerAlias:               sp.PayeeAlias,
+		Amount:                   swishAmount(params.Amount),
+		Currency:                 strings.ToUpper(params.Currency),
+		Message:                  "Refund",
+	}
+
+	resp, err := sp.do(http.MethodPut, "/api/v2/refunds/"+instructionID, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, swishResponseError(resp)
+	}
+
+	// Swish tells us through a callback when the money has been paid back
+	return &Refund{ID: instructionID, Status: RefundPending}, nil
+}
+
+// ParseCallback does not trust the status in the callback, the payment or refund is fetched
+// from Swish so that a forged callback can not mark a ticket as paid
+func (sp *SwishProvider) ParseCallback(payload []byte, header http.Header) (*Callback, error) {
+	var received swishObject
+	if err := json.Unmarshal(payload, &received); err != nil {
+		return nil, fmt.Errorf("error parsing swish callback: %w", err)
+	}
+
+	if received.ID == "" {
+		return nil, errors.New("swish callback has no id")
+	}
+
+	if received.OriginalPaymentReference != "" {
+		object, err := sp.getObject("/api/v1/refunds/" + received.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		callback := &Callback{
+			ID:             fmt.Sprintf("swish-%s-%s", object.ID, object.Status),
+			Type:           CallbackIgnored,
+			RefundID:       object.ID,
+			Amount:         swishAmountToOre(object.Amount),
+			FailureMessage: object.ErrorMessage,
+		}
+
+		switch object.Status {
+		case "PAID":
+			callback.Type = CallbackRefundSucceeded
+		case "ERROR":
+			callback.Type = CallbackRefundFailed
+		}
+
+		return callback, nil
+	}
+
+	object, err := sp.getPaymentRequest(received.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	callback := &Callback{
+		ID:             fmt.Sprintf("swish-%s-%s", object.ID, object.Status),
+		Type:           CallbackIgnored,
+		PaymentID:      object.ID,
+		Amount:         swishAmountToOre(object.Amount),
+		FailureMessage: object.ErrorMessage,
+	}
+
+	if ticketID, err := strconv.Atoi(object.PayeePaymentReference); err == nil {
+		callback.TicketID = ticketID
+	}
+
+	switch swishPaymentStatus(object.Status) {
+	case PaymentSucceeded:
+		callback.Type = CallbackPaymentSucceeded
+	case PaymentFailed:
+		callback.Type = CallbackPaymentFailed
+	case PaymentCanceled:
+		callback.Type = CallbackPaymentCanceled
+	}
+
+	return callback, nil
+}
+
+func (sp *SwishProvider) getPaymentRequest(paymentID string) (*swishObject, error) {
+	return sp.getObject("/api/v1/paymentrequests/" + paymentID)
+}
+
+func (sp *SwishProvider) getObject(path string) (*swishObject, error) {
+	if err := sp.configure(); err != nil {
+		return nil, err
+	}
+
+	resp, err := sp.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, swishResponseError(resp)
+	}
+
+	var object swishObject
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return nil, err
+	}
+
+	return &object, nil
+}
+
+func (sp *SwishProvider) do(method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, sp.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return sp.Client.Do(req)
+}
+
+// configure reads the configuration that has not been set from the environment
+func (sp *SwishProvider) configure() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.BaseURL == "" {
+		sp.BaseURL = os.Getenv("SWISH_API_URL")
+		if sp.BaseURL == "" {
+			sp.BaseURL = defaultSwishAPIURL
+		}
+	}
+
+	if sp.PayeeAlias == "" {
+		sp.PayeeAlias = os.Getenv("SWISH_PAYEE_ALIAS")
+	}
+
+	if sp.CallbackURL == "" {
+		sp.CallbackURL = os.Getenv("SWISH_CALLBACK_URL")
+	}
+
+	if sp.PayeeAlias == "" || sp.CallbackURL == "" {
+		return errors.New("swish is not configured")
+	}
+
+	if sp.Client != nil {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(os.Getenv("SWISH_CERT_FILE"), os.Getenv("SWISH_KEY_FILE"))
+	if err != nil {
+		return fmt.Errorf("error loading swish certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if caFile := os.Getenv("SWISH_CA_FILE"); caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("error loading swish CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = pool
+	}
+
+	sp.Client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	return nil
+}
+
+func swishResponseError(resp *http.Response) error {
+	var errs []swishError
+	data, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &errs); err == nil && len(errs) > 0 {
+		return fmt.Errorf("swish error %s: %s", errs[0].ErrorCode, errs[0].ErrorMessage)
+	}
+
+	return fmt.Errorf("swish returned status %d", resp.StatusCode)
+}
+
+// swishInstructionID turns the idempotency key into the 32 character instruction UUID used by
+// Swish, creating a payment request or refund with the same UUID twice creates it once
+func swishInstructionID(idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(idempotencyKey))
+	return strings.ToUpper(hex.EncodeToString(sum[:16]))
+}
+
+func swishPaymentStatus(status string) PaymentStatus {
+	switch status {
+	case "PAID":
+		return PaymentSucceeded
+	case "DECLINED", "ERROR":
+		return PaymentFailed
+	case "CANCELLED":
+		return PaymentCanceled
+	default:
+		return PaymentPending
+	}
+}
+
+// swishAmount formats an amount in öre the way Swish expects it
+func swishAmount(amount int) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
+
+func swishAmountToOre(amount float64) int {
+	return int(math.Round(amount * 100))
+}
+
+// swishMessage shortens the message to the 50 characters shown in the Swish app
+func swishMessage(message string) string {
+	runes := []rune(message)
+	if len(runes) > 50 {
+		return string(runes[:50])
+	}
+	return message
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
 	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/stripe/stripe-go/v72"
 	"gorm.io/gorm"
@@ -21,15 +24,16 @@ func NewPaymentService(db *gorm.DB) *PaymentService {
 	return &PaymentService{DB: db}
 }
 
-func (ps *PaymentService) createPendingTransaction(
-	ticketID int,
-	eventID int,
-	pi *stripe.PaymentIntent,
-	user *models.User,
+// createPendingTransaction records the purchase of the tickets of the ticket request that the
+// purchase's ticket is the first of, replacing an unfinished purchase of the same tickets
+func createPendingTransaction(
+	db *gorm.DB, // Allows transaction to be passed in
+	transaction *models.Transaction,
 ) error {
 	// We check if a pending transaction with ticket_id, event_id and user_ug_kth_id already exists
 	var existingTransaction models.Transaction
-	if err := ps.DB.Where("ticket_id = ? AND event_id = ? AND user_ug_kth_id = ?", ticketID, eventID, user.UGKthID).First(&existingTransaction).Error; err != nil {
+	if err := db.Where("ticket_id = ? AND event_id = ? AND user_ug_kth_id = ? AND transaction_type = ?",
+		transaction.TicketID, transaction.EventID, transaction.UserUGKthID, models.TypePurchase).First(&existingTransaction).Error; err != nil {
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
 			return err
 		}
@@ -40,94 +44,435 @@ func (ps *PaymentService) createPendingTransaction(
 			return errors.New("ticket already paid")
 		}
 		// Delete the existing transaction
-		if err := ps.DB.Unscoped().Delete(&existingTransaction).Error; err != nil {
+		if err := db.Unscoped().Delete(&existingTransaction).Error; err != nil {
 			return err
 		}
 	}
 
-	// Create the Transaction instance
-	transaction := models.Transaction{
-		PaymentIntentID: pi.ID,
-		TicketID:        ticketID,
-		EventID:         eventID,
-		Amount:          int(pi.Amount),
-		Currency:        pi.Currency,
-		Status:          models.TransactionStatusPending,
-		UserUGKthID:     user.UGKthID,
-		TransactionType: models.TypePurchase,
-	}
+	transaction.Status = models.TransactionStatusPending
+	transaction.TransactionType = models.TypePurchase
 
-	if err := ps.DB.Create(&transaction).Error; err != nil {
+	if err := db.Create(transaction).Error; err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (ps *PaymentService) ProcessEvent(
-	event *stripe.Event,
-) *types.ErrorResponse {
-	// Implement this function to actually process the event
-	switch event.Type {
-	case "payment_intent.succeeded":
-		var paymentIntent stripe.PaymentIntent
-		err := json.Unmarshal(event.Data.Raw, &paymentIntent)
+// getPayableTicketGroup returns the first ticket of the ticket request that the ticket belongs to,
+// through which the tickets of the request are paid for together, and the number of tickets
+func getPayableTicketGroup(db *gorm.DB, ugkthid string, ticketId int) (*models.Ticket, int, *types.ErrorResponse) {
+	var requestedTicket models.Ticket
+	if err := db.Where("id = ? AND user_ug_kth_id = ?", ticketId, ugkthid).First(&requestedTicket).Error; err != nil {
+		return nil, 0, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	var groupTickets []models.Ticket
+	if err := db.Where("ticket_request_id = ? AND user_ug_kth_id = ?", requestedTicket.TicketRequestID, ugkthid).
+		Order("id ASC").Find(&groupTickets).Error; err != nil {
+		return nil, 0, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	var ticket models.Ticket
+	if err := db.
+		Preload("TicketRequest.TicketType").
+		Preload("TicketRequest.User").
+		Preload("TicketRequest.TicketRelease.Event").
+		Preload("TicketRequest.TicketRelease.PaymentDeadline").
+		Preload("TicketAddOns.AddOn").
+		Preload("Transaction", "transaction_type = ?", models.TypePurchase).
+		Where("id = ? AND user_ug_kth_id = ?", groupTickets[0].ID, ugkthid).First(&ticket).Error; err != nil {
+		return nil, 0, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	// Check if the ticket can be paid for
+	if ticket.IsPaid {
+		return nil, 0, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket is already paid for"}
+	}
+
+	// Check the due date for when the ticket needs to be paid
+	if ticket.PaymentDeadline != nil {
+		if time.Now().After(*ticket.PaymentDeadline) {
+			return nil, 0, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Payment window has expired"}
+		}
+	} else if time.Now().After(ticket.TicketRequest.TicketRelease.Event.Date) {
+		return nil, 0, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Event has already started"}
+	}
+
+	return &ticket, len(groupTickets), nil
+}
+
+// ticketGroupAmount returns the price in öre of the tickets of the ticket request that the ticket
+// is the first of, together with their add-ons, and a description of the add-ons
+func ticketGroupAmount(ticket *models.Ticket, ticketAmount int) (int, string) {
+	var totalPrice float64
+	totalPrice += (float64)(ticket.TicketRequest.TicketType.Price*100) * (float64)(ticketAmount)
+
+	var addonInfo string
+
+	for _, addOn := range ticket.TicketAddOns {
+		totalPrice += (float64)(addOn.AddOn.Price*100) * (float64)(addOn.Quantity)
+
+		addonInfo += fmt.Sprintf("%d x %s ", addOn.Quantity, addOn.AddOn.Name)
+		if addOn.AddOn.ContainsAlcohol {
+			addonInfo += "(Contains alcohol) "
+		}
+
+		addonInfo += fmt.Sprintf("= %.2f SEK, ", (float64)(addOn.AddOn.Price)*(float64)(addOn.Quantity))
+	}
+
+	return int(totalPrice), addonInfo
+}
+
+// cancelUnfinishedPayment cancels the payment of the purchase at the payment provider so that it
+// can be replaced by a new payment. The purchases and the order paid with it are canceled too.
+func cancelUnfinishedPayment(db *gorm.DB, purchase *models.Transaction) *types.ErrorResponse {
+	provider, err := payment_provider.Get(purchase.Provider())
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	payment, err := provider.GetPayment(purchase.PaymentIntentID)
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	switch payment.Status {
+	case payment_provider.PaymentSucceeded:
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The payment is already being processed"}
+	case payment_provider.PaymentPending:
+		if err := provider.CancelPayment(purchase.PaymentIntentID); err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error canceling the previous payment"}
+		}
+	}
+
+	if _, err := PaymentCanceled(db, purchase.PaymentIntentID); err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error canceling the previous payment"}
+	}
+
+	return nil
+}
+
+// CreatePayment starts the payment of the tickets of the ticket request that the ticket belongs to,
+// with the payment provider chosen for the ticket release. The discount code, if not empty, reduces
+// the price of the tickets. An unfinished payment with the same discount is reused.
+func (ps *PaymentService) CreatePayment(ugkthid string, ticketId int, discountCode string) (*payment_provider.Payment, models.PaymentProviderName, *types.ErrorResponse) {
+	// The tickets of a ticket request are paid for together through the first ticket of the request
+	ticket, ticketAmount, rerr := getPayableTicketGroup(ps.DB, ugkthid, ticketId)
+	if rerr != nil {
+		return nil, "", rerr
+	}
+
+	ticketId = int(ticket.ID)
+	ticketRelease := ticket.TicketRequest.TicketRelease
+
+	var discount *models.DiscountCode
+	if discountCode != "" {
+		discount, rerr = findTicketDiscountCode(ps.DB, ticket, discountCode)
+		if rerr != nil {
+			return nil, "", rerr
+		}
+
+		if discount == nil {
+			return nil, "", &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid discount code"}
+		}
+
+		if rerr := checkDiscountCode(ps.DB, discount, ugkthid, ticketId, 0); rerr != nil {
+			return nil, "", rerr
+		}
+	}
+
+	providerName, err := models.GetPaymentProviderForTicketRelease(ps.DB, &ticketRelease)
+	if err != nil {
+		return nil, "", &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting payment provider"}
+	}
+
+	idempotencyKey := fmt.Sprintf("payment-intent-%d-%s-%s", ticketId, ugkthid, ticketRelease.Event.Name)
+	if discount != nil {
+		idempotencyKey = fmt.Sprintf("%s-discount-%d", idempotencyKey, discount.ID)
+	}
+
+	if transaction := ticket.Transaction; transaction != nil && transaction.PaymentIntentID != "" {
+		switch {
+		case transaction.Status == models.TransactionStatusFailed, transaction.Status == models.TransactionStatusCanceled:
+			// The previous payment can not be used again, a new payment needs a new key
+			// since the provider would otherwise return the previous payment
+			idempotencyKey = fmt.Sprintf("%s-retry-%d", idempotencyKey, transaction.ID)
+		case transaction.OrderID != nil || !sameDiscount(transaction.DiscountCodeID, discount):
+			// The tickets are paid on their own instead of with the rest of the order,
+			// or the price has changed since another discount code is used
+			if rerr := cancelUnfinishedPayment(ps.DB, transaction); rerr != nil {
+				return nil, "", rerr
+			}
+			idempotencyKey = fmt.Sprintf("%s-retry-%d", idempotencyKey, transaction.ID)
+		case transaction.Provider() == providerName:
+			provider, err := payment_provider.Get(providerName)
+			if err != nil {
+				return nil, "", &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+			}
+
+			payment, err := provider.GetPayment(transaction.PaymentIntentID)
+			if err != nil {
+				return nil, "", &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+			}
+
+			switch payment.Status {
+			case payment_provider.PaymentPending:
+				// The payment exists and is not completed, it can still be used
+				return payment, providerName, nil
+			case payment_provider.PaymentSucceeded:
+				return nil, "", &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The payment is already being processed"}
+			default:
+				idempotencyKey = fmt.Sprintf("%s-retry-%d", idempotencyKey, transaction.ID)
+			}
+		}
+	}
+
+	provider, err := payment_provider.Get(providerName)
+	if err != nil {
+		return nil, "", &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	user := ticket.TicketRequest.User
+
+	// Sum price
+	totalPrice, addonInfo := ticketGroupAmount(ticket, ticketAmount)
+
+	var discountAmount int
+	var discountCodeID *uint
+	if discount != nil {
+		discountAmount = ticketGroupDiscount(discount, ticket, ticketAmount)
+		discountCodeID = &discount.ID
+		totalPrice -= discountAmount
+	}
+
+	metadata := map[string]string{
+		"tessera_ticket_id":       strconv.Itoa(ticketId),
+		"tessera_event_id":        strconv.Itoa(ticketRelease.EventID),
+		"tessera_event_date":      ticketRelease.Event.Date.Format("2006-01-02"),
+		"tessera_ticket_type_id":  strconv.Itoa(int(ticket.TicketRequest.TicketTypeID)),
+		"tessera_user_id":         user.UGKthID,
+		"tessera_recipient_email": user.Email,
+		"tessera_event_name":      ticketRelease.Event.Name,
+		"tessera_ticket_release":  ticketRelease.Name,
+		"tessera_ticket_type":     ticket.TicketRequest.TicketType.Name,
+		"tessera_ticket_amount":   strconv.Itoa(ticketAmount),
+		"tessera_ticket_price":    fmt.Sprintf("%f", ticket.TicketRequest.TicketType.Price),
+		"tessera_addons_info":     addonInfo,
+	}
+
+	if discount != nil {
+		metadata["tessera_discount_code"] = discount.Code
+		metadata["tessera_discount_amount"] = fmt.Sprintf("%.2f", float64(discountAmount)/100)
+	}
+
+	payment, err := provider.CreatePayment(&payment_provider.PaymentParams{
+		TicketID:    ticketId,
+		EventID:     ticketRelease.EventID,
+		UserUGKthID: user.UGKthID,
+		Email:       user.Email,
+		FullName:    user.FullName(),
+		Amount:      totalPrice,
+		Currency:    "sek",
+		Description: fmt.Sprintf("Event Name: %s, Ticket Type: %s",
+			ticketRelease.Event.Name,
+			ticket.TicketRequest.TicketType.Name),
+		Metadata:       metadata,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return nil, "", &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if err := createPendingTransaction(ps.DB, &models.Transaction{
+		PaymentIntentID: payment.ID,
+		TicketID:        ticketId,
+		EventID:         ticketRelease.EventID,
+		UserUGKthID:     user.UGKthID,
+		Amount:          payment.Amount,
+		Currency:        payment.Currency,
+		PaymentProvider: providerName,
+		DiscountCodeID:  discountCodeID,
+		DiscountAmount:  discountAmount,
+	}); err != nil {
+		return nil, "", &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("Error creating pending transaction: %v", err)}
+	}
+
+	return payment, providerName, nil
+}
+
+// sameDiscount returns whether the purchase was made with the discount code
+func sameDiscount(discountCodeID *uint, discount *models.DiscountCode) bool {
+	if discountCodeID == nil || discount == nil {
+		return discountCodeID == nil && discount == nil
+	}
+
+	return *discountCodeID == discount.ID
+}
+
+// ProcessCallback handles a notification from a payment provider about a payment or refund
+func (ps *PaymentService) ProcessCallback(callback *payment_provider.Callback) *types.ErrorResponse {
+	switch callback.Type {
+	case payment_provider.CallbackPaymentSucceeded:
+		return ps.paymentSucceeded(callback)
+	case payment_provider.CallbackPaymentFailed:
+		payment, err := PaymentFailed(ps.DB, callback.PaymentID, callback.FailureMessage)
 		if err != nil {
-			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing webhook JSON: %v", err.Error())}
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error handling failed payment"}
 		}
 
-		ticketIdstring, ok := paymentIntent.Metadata["tessera_ticket_id"]
-		if !ok {
-			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket ID not found in payment intent metadata"}
+		if payment != nil {
+			if err := Notify_TicketPaymentFailed(ps.DB, payment); err != nil {
+				return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying user about failed payment"}
+			}
+		}
+	case payment_provider.CallbackPaymentCanceled:
+		if _, err := PaymentCanceled(ps.DB, callback.PaymentID); err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error handling canceled payment"}
 		}
+	case payment_provider.CallbackRefundSucceeded, payment_provider.CallbackRefundFailed:
+		if err := RefundCompleted(ps.DB, callback.RefundID, callback.Type == payment_provider.CallbackRefundSucceeded); err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error handling refund"}
+		}
+	}
+
+	return nil
+}
+
+func (ps *PaymentService) paymentSucceeded(callback *payment_provider.Callback) *types.ErrorResponse {
+	order, err := models.GetOrderByPaymentIntentID(ps.DB, callback.PaymentID)
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "An unexpected error occurred, contact event organizers"}
+	}
 
-		ticketId, err := strconv.Atoi(ticketIdstring)
+	// An order pays for the tickets of all its items, other payments for the tickets of one ticket request
+	var ticketIds []int
+	var alreadyPaid bool
+	if order != nil {
+		for _, item := range order.Items {
+			ticketIds = append(ticketIds, int(item.TicketID))
+		}
+		alreadyPaid = order.Status == models.OrderStatusPaid
+	} else {
+		purchase, err := getPurchase(ps.DB, callback.PaymentID)
 		if err != nil {
-			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid ticket ID"}
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "An unexpected error occurred, contact event organizers"}
 		}
 
-		// Start a new transaction
-		tx := ps.DB.Begin()
+		ticketId := callback.TicketID
+		if purchase != nil {
+			ticketId = purchase.TicketID
+			alreadyPaid = purchase.Status == models.TransactionStatusCompleted
+		}
 
-		// If the function returns an error, rollback the transaction
-		defer func() {
-			if r := recover(); r != nil {
-				tx.Rollback()
-			}
-		}()
+		if ticketId == 0 {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket ID not found in payment"}
+		}
+
+		ticketIds = append(ticketIds, ticketId)
+	}
 
+	// The payment is handled again when the webhook is retried because the sellers could not be
+	// refunded, the tickets have been paid for and the buyer notified, only the refunds are left
+	if alreadyPaid {
+		return ps.issueResaleRefunds(ticketIds)
+	}
+
+	// Start a new transaction
+	tx := ps.DB.Begin()
+
+	// If the function returns an error, rollback the transaction
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, ticketId := range ticketIds {
 		ticket, err := HandleSuccessfulTicketPayment(tx, ticketId)
 		if err != nil {
 			tx.Rollback()
 			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error handling ticket payment"}
 		}
 
-		// Check if existing transaction exists
-		var transaction models.Transaction
-		if err := tx.Where("ticket_id = ?", ticket.ID).First(&transaction).Error; err != nil {
-			if !errors.Is(err, gorm.ErrRecordNotFound) {
-				tx.Rollback()
-				return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "An unexpected error occurred, contact event organizers"}
-			}
+		// If the tickets were bought from another user, the other user gets their money back
+		if _, err := CompleteTicketResale(tx, ticket.TicketRequestID); err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error completing ticket resale"}
 		}
+	}
+
+	if err := SuccessfulPayment(tx, callback.PaymentID); err != nil {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error handling successful payment"}
+	}
 
-		if err := SuccessfulPayment(tx, paymentIntent); err != nil {
+	if order != nil {
+		now := time.Now()
+		order.Status = models.OrderStatusPaid
+		order.PaidAt = &now
+		if err := tx.Omit("Items").Save(order).Error; err != nil {
 			tx.Rollback()
 			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error handling successful payment"}
 		}
 
-		err = Notify_TicketPaymentConfirmation(tx, int(ticket.ID))
-		if err != nil {
-			fmt.Println(err)
-			tx.Rollback()
-			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying user, but ticket payment was successful"}
-		}
+		err = Notify_OrderPaymentConfirmation(tx, order.ID)
+	} else {
+		err = Notify_TicketPaymentConfirmation(tx, ticketIds[0])
+	}
 
-		// If everything went well, commit the transaction
-		txerr := tx.Commit().Error
-		if txerr != nil {
-			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error committing transaction"}
+	if err != nil {
+		log.Printf("Failed to notify the user of payment %s: %v", callback.PaymentID, err)
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying user, but ticket payment was successful"}
+	}
+
+	// If everything went well, commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error committing transaction"}
+	}
+
+	// The sellers are refunded once the sale can no longer be rolled back
+	return ps.issueResaleRefunds(ticketIds)
+}
+
+// issueResaleRefunds refunds the sellers whose tickets were resold to the buyer of the tickets.
+// An error is returned so that the webhook is retried, refunds that have been issued are skipped
+func (ps *PaymentService) issueResaleRefunds(ticketIds []int) *types.ErrorResponse {
+	var refunds []*models.Transaction
+	if err := ps.DB.Where("id IN (?)", ps.DB.Model(&models.TicketResale{}).
+		Select("refund_transaction_id").
+		Where("status = ? AND buyer_ticket_request_id IN (?)", models.TicketResaleSold,
+			ps.DB.Model(&models.Ticket{}).Select("ticket_request_id").Where("id IN ?", ticketIds))).
+		Find(&refunds).Error; err != nil {
+		log.Printf("Failed to get resale refunds: %v", err)
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting resale refunds"}
+	}
+
+	for _, refund := range refunds {
+		if err := IssueRefund(ps.DB, refund); err != nil {
+			log.Printf("Failed to issue resale refund %d: %v", refund.ID, err)
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error refunding the seller of the tickets"}
 		}
+	}
+
+	return nil
+}
+
+func (ps *PaymentService) ProcessEvent(
+	event *stripe.Event,
+) *types.ErrorResponse {
+	// Payment intent events are handled like the callbacks of the other payment providers
+	callback, err := payment_provider.StripeEventCallback(event)
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if callback.Type != payment_provider.CallbackIgnored {
+		return ps.ProcessCallback(callback)
+	}
+
+	switch event.Type {
 	case "payment_intent.created":
 		var paymentIntent stripe.PaymentIntent
 		err := json.Unmarshal(event.Data.Raw, &paymentIntent)
@@ -135,6 +480,11 @@ func (ps *PaymentService) ProcessEvent(
 			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing webhook JSON: %v", err.Error())}
 		}
 
+		// The purchases of an order are created together with its payment
+		if _, ok := paymentIntent.Metadata["tessera_order_id"]; ok {
+			return nil
+		}
+
 		userID := paymentIntent.Metadata["tessera_user_id"]
 		var user models.User
 		if err := ps.DB.Where("ug_kth_id = ?", userID).First(&user).Error; err != nil {
@@ -161,17 +511,93 @@ func (ps *PaymentService) ProcessEvent(
 			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid event ID"}
 		}
 
-		// Implement the logic to create a pending transaction record.
-		// It's a good practice to encapsulate the logic in a method for clarity and reuse.
-		err = ps.createPendingTransaction(ticketID, eventID, &paymentIntent, &user)
+		// The pending transaction is normally created together with the payment intent
+		var count int64
+		if err := ps.DB.Model(&models.Transaction{}).Where("payment_intent_id = ?", paymentIntent.ID).Count(&count).Error; err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking for existing transaction"}
+		}
+
+		if count > 0 {
+			return nil
+		}
+
+		err = createPendingTransaction(ps.DB, &models.Transaction{
+			PaymentIntentID: paymentIntent.ID,
+			TicketID:        ticketID,
+			EventID:         eventID,
+			UserUGKthID:     user.UGKthID,
+			Amount:          int(paymentIntent.Amount),
+			Currency:        string(paymentIntent.Currency),
+			PaymentProvider: models.STRIPE,
+		})
 		if err != nil {
 			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("Error creating pending transaction: %v", err)}
 		}
+	case "charge.refunded":
+		var charge stripe.Charge
+		err := json.Unmarshal(event.Data.Raw, &charge)
+		if err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing webhook JSON: %v", err.Error())}
+		}
+
+		tx := ps.DB.Begin()
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+			}
+		}()
+
+		if err := ChargeRefunded(tx, &charge); err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("Error handling refund: %v", err)}
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error committing transaction"}
+		}
+	case "charge.dispute.created", "charge.dispute.closed":
+		var dispute stripe.Dispute
+		err := json.Unmarshal(event.Data.Raw, &dispute)
+		if err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing webhook JSON: %v", err.Error())}
+		}
+
+		closed := event.Type == "charge.dispute.closed"
+
+		tx := ps.DB.Begin()
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+			}
+		}()
+
+		var payment *models.Transaction
+		if closed {
+			payment, err = DisputeClosed(tx, &dispute)
+		} else {
+			payment, err = DisputeCreated(tx, &dispute)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("Error handling dispute: %v", err)}
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error committing transaction"}
+		}
+
+		if payment != nil {
+			if err := Notify_PaymentDisputed(ps.DB, payment, &dispute, closed); err != nil {
+				return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying organization about dispute"}
+			}
+		}
 	case "charge.succeeded":
 		// Implement the logic to handle a successful charge event
 		return nil
 	default:
-		return &types.ErrorResponse{StatusCode: http.StatusOK, Message: fmt.Sprintf("Unhandled event type: %s", event.Type)}
+		// Stripe sends event types that are not used, they are recorded as processed and never retried
+		return nil
 	}
 
 	return nil
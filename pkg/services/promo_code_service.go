@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PromoCodeService struct {
+	DB       *gorm.DB
+	attempts *ratelimit.AttemptLimiter
+}
+
+// NewPromoCodeService returns the service, attempts locks out users and IP addresses that guess promo codes
+func NewPromoCodeService(db *gorm.DB, attempts *ratelimit.AttemptLimiter) *PromoCodeService {
+	return &PromoCodeService{DB: db, attempts: attempts}
+}
+
+// ActivatePromoCode unlocks the reserved ticket release of the event that the promo code belongs to.
+// Invalid codes are counted for both the user and the IP address, which are locked out for
+// exponentially longer after too many invalid codes. Every activation is counted as invalid until
+// the code has been found, so that codes guessed at the same time are all counted.
+func (pcs *PromoCodeService) ActivatePromoCode(ugkthid string, ipAddress string, eventID int, code string) (*models.TicketRelease, *types.ErrorResponse) {
+	var user models.User
+	if err := pcs.DB.Where("ug_kth_id = ?", ugkthid).First(&user).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid user"}
+	}
+
+	ctx := context.Background()
+	keys := []string{"user:" + user.UGKthID, "ip:" + ipAddress}
+
+	if err := pcs.attempts.Attempt(ctx, keys...); err != nil {
+		var locked *ratelimit.LockedError
+		if !errors.As(err, &locked) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking promo code attempts"}
+		}
+
+		pcs.logAttempt(eventID, user.UGKthID, ipAddress, models.PromoCodeAttemptLocked)
+		return nil, &types.ErrorResponse{StatusCode: http.StatusTooManyRequests,
+			Message: fmt.Sprintf("Too many invalid promo codes, try again in %d seconds", locked.RetryAfter())}
+	}
+
+	promoCode, err := models.GetPromoCodeByHash(pcs.DB, eventID, utils.HashPromoCode(code))
+	if err != nil {
+		pcs.attempts.Forgive(ctx, keys...)
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting promo code"}
+	}
+
+	if promoCode == nil {
+		pcs.logAttempt(eventID, user.UGKthID, ipAddress, models.PromoCodeAttemptInvalid)
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid promo code"}
+	}
+
+	// Only the attempts of the user are forgotten, other users can be guessing from the same IP address
+	if err := pcs.attempts.Reset(ctx, keys[0]); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error recording promo code attempt"}
+	}
+
+	if err := pcs.attempts.Forgive(ctx, keys[1:]...); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error recording promo code attempt"}
+	}
+
+	var ticketRelease models.TicketRelease
+	if err := pcs.DB.Preload("ReservedUsers").First(&ticketRelease, promoCode.TicketReleaseID).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid promo code"}
+	}
+
+	// Entering the code again after unlocking the ticket release does not use it again
+	for _, reservedUser := range ticketRelease.ReservedUsers {
+		if reservedUser.UGKthID == user.UGKthID {
+			return &ticketRelease, nil
+		}
+	}
+
+	if promoCode.IsExpired() {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The promo code has expired"}
+	}
+
+	tx := pcs.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if promoCode.MaxUses != nil {
+		// The promo code is locked so that users activating it at the same time are counted one at a time
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(promoCode, promoCode.ID).Error; err != nil {
+			tx.Rollback()
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking promo code"}
+		}
+
+		uses, err := promoCode.CountUses(tx)
+		if err != nil {
+			tx.Rollback()
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking promo code"}
+		}
+
+		if int(uses) >= *promoCode.MaxUses {
+			tx.Rollback()
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The promo code has been used the maximum number of times"}
+		}
+	}
+
+	if err := tx.Model(&ticketRelease).Association("ReservedUsers").Append(&user); err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving ticket release"}
+	}
+
+	if err := tx.Create(&models.TicketReleasePromoCodeUnlock{
+		EventID:         eventID,
+		TicketReleaseID: ticketRelease.ID,
+		PromoCodeID:     promoCode.ID,
+		UserUGKthID:     user.UGKthID,
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving promo code use"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving ticket release"}
+	}
+
+	return &ticketRelease, nil
+}
+
+// logAttempt adds the failed attempt to the audit log, the attempt has already failed so errors are ignored
+func (pcs *PromoCodeService) logAttempt(eventID int, ugkthid string, ipAddress string, result models.PromoCodeAttemptResult) {
+	pcs.DB.Create(&models.PromoCodeAttempt{
+		EventID:     eventID,
+		UserUGKthID: ugkthid,
+		IPAddress:   ipAddress,
+		Result:      result,
+	})
+}
+
+// GetPromoCodeAttemptStats returns the failed promo code activations of the event
+func (pcs *PromoCodeService) GetPromoCodeAttemptStats(eventID int) (*models.PromoCodeAttemptStats, *types.ErrorResponse) {
+	stats, err := models.GetPromoCodeAttemptStats(pcs.DB, eventID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting promo code attempts"}
+	}
+
+	return stats, nil
+}
+
+// CheckPromoCode returns whether the promo code unlocks the ticket release
+func CheckPromoCode(db *gorm.DB, ticketRelease *models.TicketRelease, code string) (bool, error) {
+	promoCode, err := models.GetPromoCodeByHash(db, ticketRelease.EventID, utils.HashPromoCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	return promoCode != nil && promoCode.TicketReleaseID == ticketRelease.ID && !promoCode.IsExpired(), nil
+}
+
+// ListPromoCodes returns the promo codes of the ticket release in clear text for the organizers
+func (pcs *PromoCodeService) ListPromoCodes(eventID int, ticketReleaseID int) ([]models.TicketReleasePromoCode, *types.ErrorResponse) {
+	ticketRelease, rerr := pcs.getTicketRelease(eventID, ticketReleaseID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	promoCodes, err := models.GetPromoCodesToTicketRelease(pcs.DB, ticketRelease.ID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting promo codes"}
+	}
+
+	for i := range promoCodes {
+		code, err := utils.DecryptString(promoCodes[i].EncryptedCode)
+		if err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error decrypting promo code"}
+		}
+		promoCodes[i].Code = code
+	}
+
+	return promoCodes, nil
+}
+
+func (pcs *PromoCodeService) ListPromoCodeUnlocks(eventID int, ticketReleaseID int) ([]models.TicketReleasePromoCodeUnlock, *types.ErrorResponse) {
+	ticketRelease, rerr := pcs.getTicketRelease(eventID, ticketReleaseID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	unlocks, err := models.GetPromoCodeUnlocksToTicketRelease(pcs.DB, ticketRelease.ID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting promo code uses"}
+	}
+
+	return unlocks, nil
+}
+
+func (pcs *PromoCodeService) CreatePromoCode(eventID int, ticketReleaseID int, req *types.TicketReleasePromoCodeRequest) (*models.TicketReleasePromoCode, *types.ErrorResponse) {
+	ticketRelease, rerr := pcs.getTicketRelease(eventID, ticketReleaseID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	if !ticketRelease.IsReserved {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Only reserved ticket releases have promo codes"}
+	}
+
+	if strings.TrimSpace(req.Code) == "" {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Promo code is required"}
+	}
+
+	promoCode := models.TicketReleasePromoCode{EventID: ticketRelease.EventID, TicketReleaseID: ticketRelease.ID}
+	if err := applyPromoCodeRequest(pcs.DB, &promoCode, req); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if err := pcs.DB.Create(&promoCode).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating promo code"}
+	}
+
+	return &promoCode, nil
+}
+
+func (pcs *PromoCodeService) UpdatePromoCode(eventID int, ticketReleaseID int, promoCodeID int, req *types.TicketReleasePromoCodeRequest) (*models.TicketReleasePromoCode, *types.ErrorResponse) {
+	promoCode, rerr := pcs.getPromoCode(eventID, ticketReleaseID, promoCodeID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	if err := applyPromoCodeRequest(pcs.DB, promoCode, req); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if err := pcs.DB.Save(promoCode).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error updating promo code"}
+	}
+
+	// The primary promo code is also kept on the ticket release
+	if promoCode.IsPrimary {
+		if err := pcs.DB.Model(&models.TicketRelease{}).Where("id = ?", promoCode.TicketReleaseID).
+			Update("promo_code", promoCode.EncryptedCode).Error; err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error updating ticket release"}
+		}
+	}
+
+	return promoCode, nil
+}
+
+// DeletePromoCode removes the promo code, users that have unlocked the ticket release with it keep their access
+func (pcs *PromoCodeService) DeletePromoCode(eventID int, ticketReleaseID int, promoCodeID int) *types.ErrorResponse {
+	promoCode, rerr := pcs.getPromoCode(eventID, ticketReleaseID, promoCodeID)
+	if rerr != nil {
+		return rerr
+	}
+
+	if promoCode.IsPrimary {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The promo code of the ticket release can not be deleted, update the ticket release instead"}
+	}
+
+	if err := pcs.DB.Delete(promoCode).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error deleting promo code"}
+	}
+
+	return nil
+}
+
+func (pcs *PromoCodeService) getTicketRelease(eventID int, ticketReleaseID int) (*models.TicketRelease, *types.ErrorResponse) {
+	var ticketRelease models.TicketRelease
+	if err := pcs.DB.Where("id = ? AND event_id = ?", ticketReleaseID, eventID).First(&ticketRelease).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket release not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release"}
+	}
+
+	return &ticketRelease, nil
+}
+
+func (pcs *PromoCodeService) getPromoCode(eventID int, ticketReleaseID int, promoCodeID int) (*models.TicketReleasePromoCode, *types.ErrorResponse) {
+	var promoCode models.TicketReleasePromoCode
+	if err := pcs.DB.Where("id = ? AND event_id = ? AND ticket_release_id = ?", promoCodeID, eventID, ticketReleaseID).
+		First(&promoCode).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Promo code not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting promo code"}
+	}
+
+	return &promoCode, nil
+}
+
+func applyPromoCodeRequest(db *gorm.DB, promoCode *models.TicketReleasePromoCode, req *types.TicketReleasePromoCodeRequest) error {
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		return errors.New("max uses must be at least 1")
+	}
+
+	promoCode.Label = req.Label
+	promoCode.MaxUses = req.MaxUses
+	promoCode.ExpiresAt = req.ExpiresAt
+
+	if strings.TrimSpace(req.Code) == "" {
+		return nil
+	}
+
+	return setPromoCode(db, promoCode, req.Code)
+}
+
+// setPromoCode sets the code of the promo code, codes must be unique within the event since the
+// code decides which ticket release is unlocked
+func setPromoCode(db *gorm.DB, promoCode *models.TicketReleasePromoCode, code string) error {
+	codeHash := utils.HashPromoCode(code)
+
+	existing, err := models.GetPromoCodeByHash(db, promoCode.EventID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil && existing.ID != promoCode.ID {
+		return errors.New("the event already has a promo code with that code")
+	}
+
+	encryptedCode, err := utils.EncryptString(code)
+	if err != nil {
+		return errors.New("could not hash promo code")
+	}
+
+	promoCode.CodeHash = codeHash
+	promoCode.EncryptedCode = encryptedCode
+	return nil
+}
+
+// SetPrimaryPromoCode sets the promo code given when creating or updating a reserved ticket release.
+// The code is stored encrypted on the ticket release and as the primary promo code of the ticket
+// release, an empty code removes the primary promo code.
+func SetPrimaryPromoCode(tx *gorm.DB, ticketRelease *models.TicketRelease, code string) error {
+	var promoCodes []models.TicketReleasePromoCode
+	if err := tx.Where("ticket_release_id = ? AND is_primary = ?", ticketRelease.ID, true).Limit(1).Find(&promoCodes).Error; err != nil {
+		return err
+	}
+
+	if code == "" {
+		ticketRelease.PromoCode = nil
+		if len(promoCodes) == 0 {
+			return nil
+		}
+		return tx.Delete(&promoCodes[0]).Error
+	}
+
+	promoCode := models.TicketReleasePromoCode{
+		EventID:         ticketRelease.EventID,
+		TicketReleaseID: ticketRelease.ID,
+		IsPrimary:       true,
+	}
+	if len(promoCodes) > 0 {
+		promoCode = promoCodes[0]
+	}
+
+	if err := setPromoCode(tx, &promoCode, code); err != nil {
+		return err
+	}
+
+	if err := tx.Save(&promoCode).Error; err != nil {
+		return err
+	}
+
+	ticketRelease.PromoCode = &promoCode.EncryptedCode
+	return tx.Model(ticketRelease).Update("promo_code", promoCode.EncryptedCode).Error
+}
+
+// MigrateTicketReleasePromoCodes creates the primary promo codes of reserved ticket releases that
+// only have the promo code stored on the ticket release
+func MigrateTicketReleasePromoCodes(db *gorm.DB) error {
+	var ticketReleases []models.TicketRelease
+	if err := db.
+		Where("promo_code IS NOT NULL AND promo_code <> ''").
+		Where("id NOT IN (?)", db.Model(&models.TicketReleasePromoCode{}).Select("ticket_release_id").Where("is_primary = ?", true)).
+		Find(&ticketReleases).Error; err != nil {
+		return err
+	}
+
+	for _, ticketRelease := range ticketReleases {
+		code, err := utils.DecryptString(*ticketRelease.PromoCode)
+		if err != nil {
+			return err
+		}
+
+		if err := SetPrimaryPromoCode(db, &ticketRelease, code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -28,5 +28,12 @@ func HandleSuccessfulTicketPayment(
 		return nil, err
 	}
 
+	// The payment covers all the tickets of the ticket request that belong to the user
+	if err := db.Model(&models.Ticket{}).
+		Where("ticket_request_id = ? AND user_ug_kth_id = ?", ticket.TicketRequestID, ticket.UserUGKthID).
+		Update("is_paid", true).Error; err != nil {
+		return nil, err
+	}
+
 	return ticket, nil
 }
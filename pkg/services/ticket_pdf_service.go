@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/jobs/tasks"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/jung-kurt/gofpdf"
+	qrcode "github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+type TicketPDFService struct {
+	DB *gorm.DB
+}
+
+func NewTicketPDFService(db *gorm.DB) *TicketPDFService {
+	return &TicketPDFService{DB: db}
+}
+
+// TicketPDF returns the printable ticket of one of the user's paid tickets
+func (tps *TicketPDFService) TicketPDF(ugkthid string, ticketID int) ([]byte, *types.ErrorResponse) {
+	ticket, rerr := getPaidTicket(tps.DB, ugkthid, ticketID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	data, err := GenerateTicketPDF(ticket)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error generating ticket PDF"}
+	}
+
+	return data, nil
+}
+
+// TicketPDFAttachments returns the printable tickets of the paid tickets of the ticket requests,
+// one PDF per ticket, to be attached to the payment confirmation
+func TicketPDFAttachments(db *gorm.DB, ticketRequestIDs ...uint) ([]tasks.EmailAttachment, error) {
+	var tickets []models.Ticket
+	if err := db.
+		Preload("User").
+		Preload("TicketRequest.TicketType").
+		Preload("TicketRequest.TicketRelease.Event.Organization").
+		Preload("TicketAddOns.AddOn").
+		Where("ticket_request_id IN ? AND is_paid = ? AND is_reserve = ? AND refunded = ?", ticketRequestIDs, true, false, false).
+		Order("id").
+		Find(&tickets).Error; err != nil {
+		return nil, err
+	}
+
+	attachments := make([]tasks.EmailAttachment, 0, len(tickets))
+	for i := range tickets {
+		pdf, err := GenerateTicketPDF(&tickets[i])
+		if err != nil {
+			return nil, fmt.Errorf("generating PDF of ticket %d: %w", tickets[i].ID, err)
+		}
+
+		attachments = append(attachments, tasks.EmailAttachment{
+			Name:        fmt.Sprintf("ticket-%d.pdf", tickets[i].ID),
+			ContentType: "application/pdf",
+			Content:     pdf,
+		})
+	}
+
+	return attachments, nil
+}
+
+// GenerateTicketPDF renders a one page A4 ticket with the event details and the QR code of the ticket.
+// The ticket must have its user, ticket type, event with organization and add-ons loaded.
+func GenerateTicketPDF(ticket *models.Ticket) ([]byte, error) {
+	qr, err := qrcode.Encode(ticket.QrCode, qrcode.Medium, 512)
+	if err != nil {
+		return nil, fmt.Errorf("rendering QR code: %w", err)
+	}
+
+	event := ticket.TicketRequest.TicketRelease.Event
+
+	marginX := 20.0
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(marginX, 20, marginX)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	// The core fonts are encoded in cp1252, which covers the Swedish characters in names and events
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 2*marginX
+
+	// Header
+	pdf.SetFillColor(155, 28, 46)
+	pdf.Rect(0, 0, pageWidth, 30, "F")
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetXY(marginX, 10)
+	pdf.CellFormat(contentWidth/2, 10, tr(event.Organization.Name), "", 0, "L", false, 0, "")
+	pdf.CellFormat(contentWidth/2, 10, "TICKET", "", 0, "R", false, 0, "")
+
+	// Event
+	pdf.SetTextColor(30, 30, 30)
+	pdf.SetFont("Arial", "B", 22)
+	pdf.SetXY(marginX, 42)
+	pdf.MultiCell(contentWidth, 10, tr(event.Name), "", "L", false)
+
+	date := event.Date.Format("2006-01-02 15:04")
+	if event.EndDate != nil {
+		date += " - " + event.EndDate.Format("2006-01-02 15:04")
+	}
+
+	details := [][2]string{
+		{"Date", date},
+		{"Location", event.Location},
+		{"Ticket", ticket.TicketRequest.TicketType.Name},
+		{"Name", ticketHolderName(ticket)},
+	}
+	if addOns := ticketAddOnNames(ticket); len(addOns) > 0 {
+		details = append(details, [2]string{"Add-ons", strings.Join(addOns, "\n")})
+	}
+
+	detailsY := pdf.GetY() + 6
+	detailsWidth := contentWidth - 80
+	pdf.SetY(detailsY)
+	for _, detail := range details {
+		pdf.SetX(marginX)
+		pdf.SetFont("Arial", "", 9)
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(detailsWidth, 5, strings.ToUpper(detail[0]), "", 1, "L", false, 0, "")
+
+		pdf.SetX(marginX)
+		pdf.SetFont("Arial", "", 12)
+		pdf.SetTextColor(30, 30, 30)
+		pdf.MultiCell(detailsWidth, 6, tr(detail[1]), "", "L", false)
+		pdf.Ln(3)
+	}
+
+	// QR code, to the right of the details
+	qrSize := 70.0
+	qrX := pageWidth - marginX - qrSize
+	pdf.RegisterImageOptionsReader("qr", gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+	pdf.ImageOptions("qr", qrX, detailsY, qrSize, qrSize, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	pdf.SetXY(qrX, detailsY+qrSize+2)
+	pdf.SetFont("Courier", "", 10)
+	pdf.CellFormat(qrSize, 5, ticket.QrCode, "", 0, "C", false, 0, "")
+
+	// Footer
+	pdf.SetXY(marginX, 250)
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(marginX, 248, pageWidth-marginX, 248)
+	pdf.SetFont("Arial", "", 9)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.MultiCell(contentWidth, 5, tr(fmt.Sprintf(
+		"Show the QR code at the entrance, printed or on your phone. The ticket is personal and can only be scanned once. "+
+			"Questions about the event can be sent to %s.", event.Organization.Email)), "", "L", false)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -7,9 +7,11 @@ import (
 	"time"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/allocate_service"
 	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type TicketRequestService struct {
@@ -43,7 +45,12 @@ func (trs *TicketRequestService) CreateTicketRequests(ticketRequests []models.Ti
 		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release"}
 	}
 
-	if len(ticketRequests) > int(ticketRelease.TicketReleaseMethodDetail.MaxTicketsPerUser) {
+	var numberOfTickets int
+	for _, ticketRequest := range ticketRequests {
+		numberOfTickets += ticketRequest.NumberOfTickets()
+	}
+
+	if numberOfTickets > int(ticketRelease.TicketReleaseMethodDetail.MaxTicketsPerUser) {
 		trx.Rollback()
 		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Too many tickets requested"}
 	}
@@ -60,7 +67,7 @@ func (trs *TicketRequestService) CreateTicketRequests(ticketRequests []models.Ti
 	}
 
 	for _, selectedAddOn := range *selectedAddOns {
-		// TODO: needs to change if multiple ticket requests are allowed in the future
+		// The add-ons belong to the first ticket request and are paid for together with its tickets
 		trId := modelTicketRequests[0].ID
 
 		ticketAddon := models.TicketAddOn{
@@ -86,6 +93,14 @@ func (trs *TicketRequestService) CreateTicketRequest(
 ) (mTicketRequest *models.TicketRequest, err *types.ErrorResponse) {
 	var user models.User
 
+	if ticketRequest.TicketAmount < 0 {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid ticket amount"}
+	}
+
+	if len(ticketRequest.GuestNames) > ticketRequest.NumberOfTickets() {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "More guest names than tickets requested"}
+	}
+
 	if err := transaction.Where("ug_kth_id = ?", ticketRequest.UserUGKthID).First(&user).Error; err != nil {
 		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting user"}
 	}
@@ -123,7 +138,7 @@ func (trs *TicketRequestService) CreateTicketRequest(
 		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release method detail"}
 	}
 
-	if trs.userAlreadyHasATicketToEvent(&user, &ticketRelease, &ticketReleaseMethodDetail, ticketRequest.TicketAmount) {
+	if trs.userAlreadyHasATicketToEvent(&user, &ticketRelease, &ticketReleaseMethodDetail, ticketRequest.NumberOfTickets()) {
 		log.Println("User cannot request more tickets to this event")
 		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "User cannot request more tickets to this event"}
 	}
@@ -137,7 +152,8 @@ func (trs *TicketRequestService) CreateTicketRequest(
 		UserUGKthID:     user.UGKthID,
 		TicketTypeID:    ticketRequest.TicketTypeID,
 		TicketReleaseID: ticketRelease.ID,
-		TicketAmount:    ticketRequest.TicketAmount,
+		TicketAmount:    ticketRequest.NumberOfTickets(),
+		GuestNames:      ticketRequest.GuestNames,
 	}
 
 	if err := transaction.Create(mTicketRequest).Error; err != nil {
@@ -149,21 +165,56 @@ func (trs *TicketRequestService) CreateTicketRequest(
 	if ticketReleaseMethodDetail.TicketReleaseMethod.MethodName == string(models.RESERVED_TICKET_RELEASE) {
 		// We can allocated the ticket to the user directly if there are tickets_available
 		// Otherwise fail the request
-		var ticketCount int64
-		if err := transaction.Model(&models.TicketRequest{}).Where("ticket_release_id = ? AND is_handled = ?", ticketRelease.ID, true).Count(&ticketCount).Error; err != nil {
+		if err := trs.checkReservedCapacity(transaction, &ticketRelease, mTicketRequest.TicketTypeID); err != nil {
 			transaction.Rollback()
-			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket count"}
-		}
-
-		if int64(ticketRelease.TicketsAvailable) < ticketCount+int64(ticketRequest.TicketAmount) {
-			transaction.Rollback()
-			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Not enough tickets available"}
+			return nil, err
 		}
 	}
 
 	return mTicketRequest, nil
 }
 
+// checkReservedCapacity checks that the requests of a reserved ticket release fit in its capacity and in the
+// capacity of the ticket type. Everyone in a reserved ticket release gets a ticket, so the requests that are not
+// yet allocated count together with the tickets. The ticket release is locked so that requests made at the same
+// time are counted one after the other
+func (trs *TicketRequestService) checkReservedCapacity(transaction *gorm.DB, ticketRelease *models.TicketRelease, ticketTypeID uint) *types.ErrorResponse {
+	if err := transaction.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.TicketRelease{}, ticketRelease.ID).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release"}
+	}
+
+	capacity, err := allocate_service.LoadTicketCapacity(transaction, ticketRelease)
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket count"}
+	}
+
+	var pending []struct {
+		TicketTypeID uint
+		Amount       int
+	}
+	if err := transaction.Model(&models.TicketRequest{}).
+		Select("ticket_type_id, COALESCE(SUM(CASE WHEN ticket_amount > 0 THEN ticket_amount ELSE 1 END), 0) AS amount").
+		Where("ticket_release_id = ? AND is_handled = ?", ticketRelease.ID, false).
+		Group("ticket_type_id").
+		Scan(&pending).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket count"}
+	}
+
+	for _, requested := range pending {
+		capacity.Allocate(requested.TicketTypeID, requested.Amount)
+	}
+
+	if capacity.Remaining < 0 {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Not enough tickets available"}
+	}
+
+	if !capacity.CanAllocateType(ticketTypeID, 0) {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Not enough tickets of this ticket type available"}
+	}
+
+	return nil
+}
+
 func (trs *TicketRequestService) GetTicketRequestsForUser(UGKthID string, ids *[]int) ([]models.TicketRequest, *types.ErrorResponse) {
 	ticketRequests, err := models.GetAllValidUsersTicketRequests(trs.DB, UGKthID, ids)
 
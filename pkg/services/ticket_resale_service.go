@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"gorm.io/gorm"
+)
+
+type TicketResaleService struct {
+	DB *gorm.DB
+}
+
+func NewTicketResaleService(db *gorm.DB) *TicketResaleService {
+	return &TicketResaleService{DB: db}
+}
+
+// ListTicket lists the seller's ticket, and the other tickets of its ticket request, for resale.
+// The tickets are offered to the reserve list by the reserve allocation job.
+func (trs *TicketResaleService) ListTicket(sellerUGKthID string, ticketID int) (*models.TicketResale, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := trs.DB.Where("id = ? AND user_ug_kth_id = ?", ticketID, sellerUGKthID).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	var ticketRequest models.TicketRequest
+	if err := trs.DB.Preload("TicketRelease.Event").Where("id = ?", ticket.TicketRequestID).First(&ticketRequest).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket request"}
+	}
+
+	if ticketRequest.UserUGKthID != sellerUGKthID {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusForbidden, Message: "You are not the owner of this ticket"}
+	}
+
+	ticketRelease := ticketRequest.TicketRelease
+	if !ticketRelease.AllowResale {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Tickets in this ticket release can not be resold"}
+	}
+
+	if time.Now().After(ticketRelease.Event.Date) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The event has already started"}
+	}
+
+	tickets, err := models.GetTicketsInRequest(trs.DB, ticketRequest.ID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting tickets"}
+	}
+
+	for _, ticket := range tickets {
+		if !ticket.IsPaid || ticket.IsReserve {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Only paid tickets can be resold"}
+		}
+
+		if ticket.Refunded {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Refunded tickets can not be resold"}
+		}
+
+		if ticket.CheckedIn {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Checked in tickets can not be resold"}
+		}
+	}
+
+	if _, err := models.GetActiveTicketResale(trs.DB, ticketRequest.ID); err == nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket is already listed for resale"}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking resales"}
+	}
+
+	if _, err := models.GetPendingTicketTransfer(trs.DB, ticketRequest.ID); err == nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket has a pending transfer"}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking transfers"}
+	}
+
+	resale := models.TicketResale{
+		TicketRequestID: ticketRequest.ID,
+		TicketReleaseID: ticketRelease.ID,
+		SellerUGKthID:   sellerUGKthID,
+		Status:          models.TicketResaleListed,
+	}
+
+	if err := trs.DB.Create(&resale).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error listing ticket for resale"}
+	}
+
+	return &resale, nil
+}
+
+// CancelResale lets the seller keep their tickets, as long as they have not been offered to a reserve
+func (trs *TicketResaleService) CancelResale(sellerUGKthID string, resaleID uint) (*models.TicketResale, *types.ErrorResponse) {
+	var resale models.TicketResale
+	if err := trs.DB.Where("id = ? AND seller_ug_kth_id = ?", resaleID, sellerUGKthID).First(&resale).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Resale not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting resale"}
+	}
+
+	if resale.Status != models.TicketResaleListed {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket has already been offered to someone on the reserve list"}
+	}
+
+	resale.Status = models.TicketResaleCancelled
+	if err := trs.DB.Save(&resale).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving resale"}
+	}
+
+	return &resale, nil
+}
+
+func (trs *TicketResaleService) ListResalesForUser(ugKthID string) ([]models.TicketResale, *types.ErrorResponse) {
+	resales, err := models.GetTicketResalesForUser(trs.DB, ugKthID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting resales"}
+	}
+
+	return resales, nil
+}
+
+// CompleteTicketResale is called when a reserve has paid for their tickets. If the tickets were
+// offered to the reserve from a resale, the seller's tickets are removed and a refund of the
+// seller's payment is recorded, which the caller issues with IssueRefund once db has been
+// committed. It returns nil if the ticket request did not get its tickets from a resale.
+func CompleteTicketResale(
+	db *gorm.DB, // Allows transaction to be passed in
+	buyerTicketRequestID uint,
+) (*models.TicketResale, error) {
+	resale, err := models.GetOfferedTicketResaleToBuyer(db, buyerTicketRequestID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sellerTickets, err := models.GetTicketsInRequest(db, resale.TicketRequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	ticketIDs := make([]uint, len(sellerTickets))
+	for i, ticket := range sellerTickets {
+		ticketIDs[i] = ticket.ID
+	}
+
+	var refundAmount int
+	if len(ticketIDs) > 0 {
+		var payment models.Transaction
+		err := db.Where("ticket_id IN ? AND status = ? AND refunded = ?", ticketIDs, models.TransactionStatusCompleted, false).First(&payment).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		if err == nil {
+			// The refund is issued by the caller once the transaction has been committed
+			refundTransaction, err := RecordRefund(db, &payment, -1, fmt.Sprintf("tessera-resale-%d", resale.ID))
+			if err != nil {
+				return nil, err
+			}
+
+			if refundTransaction != nil {
+				resale.RefundTransactionID = &refundTransaction.ID
+				refundAmount = refundTransaction.Amount
+			}
+		}
+
+		if err := db.Model(&models.Ticket{}).Where("id IN ?", ticketIDs).Update("refunded", true).Error; err != nil {
+			return nil, err
+		}
+
+		if err := db.Where("id IN ?", ticketIDs).Delete(&models.Ticket{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	resale.Status = models.TicketResaleSold
+	resale.SoldAt = &now
+	if err := db.Save(resale).Error; err != nil {
+		return nil, err
+	}
+
+	var seller models.User
+	if err := db.Where("ug_kth_id = ?", resale.SellerUGKthID).First(&seller).Error; err != nil {
+		return nil, err
+	}
+
+	var ticketRelease models.TicketRelease
+	if err := db.Preload("Event.Organization").Where("id = ?", resale.TicketReleaseID).First(&ticketRelease).Error; err != nil {
+		return nil, err
+	}
+
+	if err := Notify_TicketResold(db, &seller, &ticketRelease.Event, len(sellerTickets), refundAmount); err != nil {
+		return nil, err
+	}
+
+	return resale, nil
+}
@@ -1,10 +1,14 @@
 package services
 
 import (
-	"database/sql"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/DowLucas/gin-ticket-release/pkg/checkinfeed"
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/DowLucas/gin-ticket-release/utils"
@@ -12,7 +16,8 @@ import (
 )
 
 type TicketService struct {
-	DB *gorm.DB
+	DB   *gorm.DB
+	Feed checkinfeed.Broker // Tells the check-in dashboards when a ticket is checked in, optional
 }
 
 func NewTicketService(db *gorm.DB) *TicketService {
@@ -56,6 +61,7 @@ func (ts *TicketService) CancelTicket(ugKthID string, ticketID int) *types.Error
 	if err := ts.DB.
 		Preload("User").
 		Preload("TicketRequest.TicketRelease.Event.Organization").
+		Preload("TicketRequest.TicketRelease.TicketReleaseMethodDetail").
 		Where("id = ?", ticketID).First(&ticket).Error; err != nil {
 		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
 	}
@@ -70,9 +76,23 @@ func (ts *TicketService) CancelTicket(ugKthID string, ticketID int) *types.Error
 		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket is already refunded"}
 	}
 
-	// Check if ticket is paid
+	if _, err := models.GetActiveTicketResale(ts.DB, ticket.TicketRequestID); err == nil {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket is listed for resale"}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking resales"}
+	}
+
+	// Paid tickets are refunded according to the cancellation policy of the ticket release
 	if ticket.IsPaid {
-		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket is already paid"}
+		if errResponse := ts.refundTicket(&ticket); errResponse != nil {
+			return errResponse
+		}
+
+		if err := Notify_TicketCancelled(ts.DB, &ticket.User, &ticket.TicketRequest.TicketRelease.Event.Organization, ticket.TicketRequest.TicketRelease.Event.Name); err != nil {
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying user"}
+		}
+
+		return nil
 	}
 
 	// Delete ticket
@@ -89,32 +109,316 @@ func (ts *TicketService) CancelTicket(ugKthID string, ticketID int) *types.Error
 	return nil
 }
 
-func (ts *TicketService) CheckInViaQrCode(qrCode string) (ticket *models.Ticket, err *types.ErrorResponse) {
-	// Get ticket
+// refundTicket refunds the tickets of the ticket request according to the cancellation policy,
+// and gives the seats back to the reserve list. The tickets and the add-ons are refunded as
+// separate refunds.
+func (ts *TicketService) refundTicket(ticket *models.Ticket) *types.ErrorResponse {
+	ticketRelease := ticket.TicketRequest.TicketRelease
+	methodDetail := ticketRelease.TicketReleaseMethodDetail
+	if methodDetail.CancellationPolicy == models.NO_REFUND || methodDetail.CancellationPolicy == "" {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Paid tickets in this ticket release can not be refunded"}
+	}
+
+	now := time.Now()
+	if now.After(ticketRelease.Event.Date) {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The event has already started"}
+	}
+
+	if methodDetail.RefundPercentage(ticketRelease.Event.Date, now) == 0 {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket can no longer be refunded"}
+	}
+
+	tickets, err := models.GetTicketsInRequest(ts.DB, ticket.TicketRequestID)
+	if err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting tickets"}
+	}
+
+	ticketIDs := make([]uint, len(tickets))
+	for i, t := range tickets {
+		if t.CheckedIn {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket is already checked in"}
+		}
+		ticketIDs[i] = t.ID
+	}
+
+	var ticketType models.TicketType
+	if err := ts.DB.Where("id = ?", ticket.TicketRequest.TicketTypeID).First(&ticketType).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket type"}
+	}
+
+	var ticketAddOns []models.TicketAddOn
+	if err := ts.DB.Preload("AddOn").
+		Where("ticket_request_id = ? AND refunded = ?", ticket.TicketRequestID, false).
+		Find(&ticketAddOns).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting add-ons"}
+	}
+
+	tx := ts.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Free tickets have no payment to refund
+	var payment models.Transaction
+	err = tx.Where("ticket_id IN ? AND status = ? AND transaction_type <> ? AND refunded = ?",
+		ticketIDs, models.TransactionStatusCompleted, models.TypeRefund, false).First(&payment).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting payment"}
+	}
+
+	// The refunds are recorded in the transaction and issued once it has been committed
+	var refunds []*models.Transaction
+	if err == nil {
+		paid, discount, err := purchaseShare(tx, &payment, ticket.TicketRequestID)
+		if err != nil {
+			tx.Rollback()
+			return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting payment"}
+		}
+
+		ticketsAmount, addOnsPaid := paidAmounts(paid, discount, int(math.Round(ticketType.Price*100))*len(tickets))
+		ticketsRefund := methodDetail.RefundAmount(ticketsAmount, ticketRelease.Event.Date, now, true)
+		refund, errResponse := refundPart(tx, &payment, paid, ticketsRefund, fmt.Sprintf("tessera-cancel-%d-tickets", payment.ID))
+		if errResponse != nil {
+			tx.Rollback()
+			return errResponse
+		}
+		if refund != nil {
+			refunds = append(refunds, refund)
+		}
+
+		var addOnsAmount int
+		for _, ticketAddOn := range ticketAddOns {
+			addOnsAmount += ticketAddOn.Amount()
+		}
+		if addOnsAmount > addOnsPaid {
+			addOnsAmount = addOnsPaid
+		}
+
+		addOnsRefund := methodDetail.RefundAmount(addOnsAmount, ticketRelease.Event.Date, now, false)
+		refund, errResponse = refundPart(tx, &payment, paid, addOnsRefund, fmt.Sprintf("tessera-cancel-%d-add-ons", payment.ID))
+		if errResponse != nil {
+			tx.Rollback()
+			return errResponse
+		}
+		if refund != nil {
+			refunds = append(refunds, refund)
+		}
+	}
+
+	if err := tx.Model(&models.TicketAddOn{}).Where("ticket_request_id = ?", ticket.TicketRequestID).Update("refunded", true).Error; err != nil {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error refunding add-ons"}
+	}
+
+	if err := RefundTickets(tx, ticket.TicketRequestID); err != nil {
+		tx.Rollback()
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error cancelling ticket"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error cancelling ticket"}
+	}
+
+	issueRefunds(ts.DB, refunds)
+
+	return nil
+}
+
+// purchaseShare returns what was paid for the ticket request with the payment and what the discount
+// code took off. An order pays for several ticket requests, each of which has its share in its order item.
+func purchaseShare(db *gorm.DB, payment *models.Transaction, ticketRequestID uint) (paid int, discount int, err error) {
+	if payment.OrderID == nil {
+		return payment.Amount, payment.DiscountAmount, nil
+	}
+
+	var item models.OrderItem
+	if err := db.Where("order_id = ? AND ticket_request_id = ?", *payment.OrderID, ticketRequestID).First(&item).Error; err != nil {
+		return 0, 0, err
+	}
+
+	return item.Amount, item.DiscountAmount, nil
+}
+
+// paidAmounts splits paid, what was paid for a ticket request, between the tickets, whose list price
+// is ticketsListAmount, and the add-ons. Discount codes only reduce the price of the tickets.
+func paidAmounts(paid int, discount int, ticketsListAmount int) (tickets int, addOns int) {
+	tickets = ticketsListAmount - discount
+	if tickets < 0 {
+		tickets = 0
+	}
+
+	if tickets > paid {
+		tickets = paid
+	}
+
+	return tickets, paid - tickets
+}
+
+// refundPart records a refund of part of the payment, never more than what is left of paid, the
+// share of the payment of the ticket request. Returns nil if there is nothing to refund.
+func refundPart(tx *gorm.DB, payment *models.Transaction, paid int, amount int, idempotencyKey string) (*models.Transaction, *types.ErrorResponse) {
+	refunded, err := models.GetPurchaseRefundedAmount(tx, payment)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting refunds"}
+	}
+
+	if paid > payment.Amount {
+		paid = payment.Amount
+	}
+
+	if remaining := paid - refunded; amount > remaining {
+		amount = remaining
+	}
+
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	refund, err := RecordRefund(tx, payment, amount, idempotencyKey)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error refunding ticket"}
+	}
+
+	return refund, nil
+}
+
+// RefundAddOn refunds add-ons the user has paid for without cancelling the ticket, according
+// to the cancellation policy of the ticket release
+func (ts *TicketService) RefundAddOn(ugKthID string, ticketID int, ticketAddOnID int) (*models.TicketAddOn, *types.ErrorResponse) {
+	var ticket models.Ticket
 	if err := ts.DB.
-		Preload("User").
-		Where("qr_code = ?", qrCode).First(&ticket).Error; err != nil {
+		Preload("TicketRequest.TicketType").
+		Preload("TicketRequest.TicketRelease.Event").
+		Preload("TicketRequest.TicketRelease.TicketReleaseMethodDetail").
+		Where("id = ? AND user_ug_kth_id = ?", ticketID, ugKthID).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
 		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
 	}
 
-	if ticket.QrCode != qrCode {
-		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid QR code"}
+	var ticketAddOn models.TicketAddOn
+	if err := ts.DB.Preload("AddOn").
+		Where("id = ? AND ticket_request_id = ?", ticketAddOnID, ticket.TicketRequestID).
+		First(&ticketAddOn).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Add-on not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting add-on"}
+	}
+
+	if ticketAddOn.Refunded {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Add-on is already refunded"}
+	}
+
+	if !ticket.IsPaid {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket has not been paid for"}
+	}
+
+	ticketRelease := ticket.TicketRequest.TicketRelease
+	methodDetail := ticketRelease.TicketReleaseMethodDetail
+	now := time.Now()
+	if now.After(ticketRelease.Event.Date) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The event has already started"}
+	}
+
+	if methodDetail.RefundPercentage(ticketRelease.Event.Date, now) == 0 {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The add-on can no longer be refunded"}
+	}
+
+	tx := ts.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// The add-ons are paid for together with the first ticket of the ticket request
+	var payment models.Transaction
+	if err := tx.Where("ticket_id = ? AND status = ? AND transaction_type <> ? AND refunded = ?",
+		ticketAddOn.TicketID, models.TransactionStatusCompleted, models.TypeRefund, false).First(&payment).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "There is no payment to refund"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting payment"}
+	}
+
+	tickets, err := models.GetTicketsInRequest(tx, ticket.TicketRequestID)
+	if err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting tickets"}
+	}
+
+	paid, discount, err := purchaseShare(tx, &payment, ticket.TicketRequestID)
+	if err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting payment"}
+	}
+
+	_, addOnsPaid := paidAmounts(paid, discount, int(math.Round(ticket.TicketRequest.TicketType.Price*100))*len(tickets))
+	addOnAmount := ticketAddOn.Amount()
+	if addOnAmount > addOnsPaid {
+		addOnAmount = addOnsPaid
+	}
+
+	amount := methodDetail.RefundAmount(addOnAmount, ticketRelease.Event.Date, now, false)
+	refund, errResponse := refundPart(tx, &payment, paid, amount, fmt.Sprintf("tessera-add-on-%d", ticketAddOn.ID))
+	if errResponse != nil {
+		tx.Rollback()
+		return nil, errResponse
+	}
+
+	ticketAddOn.Refunded = true
+	if err := tx.Model(&ticketAddOn).Update("refunded", true).Error; err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving add-on"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error refunding add-on"}
+	}
+
+	if refund != nil {
+		issueRefunds(ts.DB, []*models.Transaction{refund})
+	}
+
+	return &ticketAddOn, nil
+}
+
+// UpdateGuestName sets the name of the guest on one of the user's tickets, an empty name removes it
+func (ts *TicketService) UpdateGuestName(ugKthID string, ticketID int, guestName string) (*models.Ticket, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := ts.DB.Where("id = ? AND user_ug_kth_id = ?", ticketID, ugKthID).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
 	}
 
 	if ticket.CheckedIn {
 		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Ticket is already checked in"}
 	}
 
-	// Check in ticket
-	ticket.CheckedIn = true
-	ticket.CheckedInAt = sql.NullTime{Time: time.Now(), Valid: true}
+	guestName = strings.TrimSpace(guestName)
+	if len(guestName) > 100 {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Guest name is too long"}
+	}
+
+	if guestName == "" {
+		ticket.GuestName = nil
+	} else {
+		ticket.GuestName = &guestName
+	}
 
-	// Save ticket
-	if err := ts.DB.Save(&ticket).Error; err != nil {
+	if err := ts.DB.Model(&ticket).Update("guest_name", ticket.GuestName).Error; err != nil {
 		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving ticket"}
 	}
 
-	return ticket, nil
+	return &ticket, nil
 }
 
 func (tc *TicketService) UpdateTicket(ticket *models.Ticket, body *types.UpdateTicketBody) (*models.Ticket, error) {
@@ -137,7 +441,9 @@ func (tc *TicketService) UpdateTicket(ticket *models.Ticket, body *types.UpdateT
 		return nil, err
 	}
 
+	checkedInChanged := false
 	if body.CheckedIn != nil {
+		checkedInChanged = ticket.CheckedIn != *body.CheckedIn
 		ticket.CheckedIn = *body.CheckedIn
 	}
 
@@ -145,6 +451,14 @@ func (tc *TicketService) UpdateTicket(ticket *models.Ticket, body *types.UpdateT
 		return nil, err
 	}
 
+	if checkedInChanged {
+		action := models.CheckInActionCheckedIn
+		if !ticket.CheckedIn {
+			action = models.CheckInActionUndone
+		}
+		publishCheckIn(tc.Feed, uint(ticket.TicketRequest.TicketRelease.EventID), ticket, action, "", "")
+	}
+
 	if shouldNotifyUser {
 		if err := Notify_UpdatedPaymentDeadlineEmail(tc.DB, int(ticket.ID), ticket.PaymentDeadline); err != nil {
 			return nil, err
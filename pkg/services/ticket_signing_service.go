@@ -0,0 +1,179 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/qrtoken"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/utils"
+	"gorm.io/gorm"
+)
+
+// Signed QR codes are valid from a day before the event starts until a day after it ends
+const qrTokenLeeway = 24 * time.Hour
+
+type TicketSigningService struct {
+	DB *gorm.DB
+}
+
+func NewTicketSigningService(db *gorm.DB) *TicketSigningService {
+	return &TicketSigningService{DB: db}
+}
+
+// GetPublicKey returns the public key of the event, the key pair is created the first time it is needed
+func (tss *TicketSigningService) GetPublicKey(eventID int) (*types.EventPublicKey, *types.ErrorResponse) {
+	key, err := tss.getOrCreateKey(uint(eventID))
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting event key"}
+	}
+
+	return &types.EventPublicKey{EventID: key.EventID, Algorithm: "Ed25519", PublicKey: key.PublicKey}, nil
+}
+
+// SignTicket returns the signed QR code of one of the user's tickets
+func (tss *TicketSigningService) SignTicket(ugkthid string, ticketID int) (*types.SignedQrCode, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := tss.DB.Preload("TicketRequest.TicketRelease.Event").
+		Where("id = ? AND user_ug_kth_id = ?", ticketID, ugkthid).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	if ticket.IsReserve || ticket.Refunded {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket is not valid for entry"}
+	}
+
+	event := ticket.TicketRequest.TicketRelease.Event
+	key, err := tss.getOrCreateKey(event.ID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting event key"}
+	}
+
+	privateKey, err := decryptPrivateKey(key)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting event key"}
+	}
+
+	end := event.Date
+	if event.EndDate != nil {
+		end = *event.EndDate
+	}
+
+	validFrom := event.Date.Add(-qrTokenLeeway)
+	validUntil := end.Add(qrTokenLeeway)
+
+	token, err := qrtoken.Sign(privateKey, &qrtoken.Claims{
+		TicketID:     ticket.ID,
+		EventID:      event.ID,
+		TicketTypeID: ticket.TicketRequest.TicketTypeID,
+		Code:         ticket.QrCode,
+		NotBefore:    validFrom.Unix(),
+		ExpiresAt:    validUntil.Unix(),
+	})
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error signing QR code"}
+	}
+
+	return &types.SignedQrCode{Token: token, ValidFrom: validFrom, ValidUntil: validUntil}, nil
+}
+
+// VerifyQrToken checks that the token was signed for the event and was valid at the given time
+func (tss *TicketSigningService) VerifyQrToken(eventID uint, token string, at time.Time) (*qrtoken.Claims, *types.ErrorResponse) {
+	key, err := models.GetEventSigningKey(tss.DB, eventID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting event key"}
+	}
+
+	if key == nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid QR code"}
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting event key"}
+	}
+
+	claims, err := qrtoken.Verify(ed25519.PublicKey(publicKey), token)
+	if err != nil || claims.EventID != eventID {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid QR code"}
+	}
+
+	if err := claims.ValidAt(at); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	return claims, nil
+}
+
+// GetTicketByQrToken returns the ticket of a verified token, tokens signed before the ticket was
+// transferred are rejected since the QR code of the ticket has changed
+func (tss *TicketSigningService) GetTicketByQrToken(claims *qrtoken.Claims) (*models.Ticket, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := tss.DB.Preload("User").Preload("TicketRequest.TicketRelease").First(&ticket, claims.TicketID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	if ticket.QrCode != claims.Code {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid QR code"}
+	}
+
+	return &ticket, nil
+}
+
+func (tss *TicketSigningService) getOrCreateKey(eventID uint) (*models.EventSigningKey, error) {
+	key, err := models.GetEventSigningKey(tss.DB, eventID)
+	if err != nil || key != nil {
+		return key, err
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedPrivateKey, err := utils.EncryptString(base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	if err != nil {
+		return nil, err
+	}
+
+	key = &models.EventSigningKey{
+		EventID:             eventID,
+		PublicKey:           base64.StdEncoding.EncodeToString(publicKey),
+		EncryptedPrivateKey: encryptedPrivateKey,
+	}
+
+	if err := tss.DB.Create(key).Error; err != nil {
+		// Another request created the key pair of the event first
+		if existing, getErr := models.GetEventSigningKey(tss.DB, eventID); getErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func decryptPrivateKey(key *models.EventSigningKey) (ed25519.PrivateKey, error) {
+	encodedSeed, err := utils.DecryptString(key.EncryptedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(encodedSeed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid private key")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
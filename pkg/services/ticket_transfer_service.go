@@ -0,0 +1,333 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/utils"
+	"gorm.io/gorm"
+)
+
+type TicketTransferService struct {
+	DB *gorm.DB
+}
+
+func NewTicketTransferService(db *gorm.DB) *TicketTransferService {
+	return &TicketTransferService{DB: db}
+}
+
+// findTransferRecipient finds the recipient by username or email
+func findTransferRecipient(db *gorm.DB, recipient string) (*models.User, *types.ErrorResponse) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "A recipient is required"}
+	}
+
+	var user models.User
+	if err := db.Where("username = ? OR LOWER(email) = ?", recipient, strings.ToLower(recipient)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "No user with that username or email"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting recipient"}
+	}
+
+	return &user, nil
+}
+
+// checkTicketTransfer checks that the tickets of the ticket request can be transferred to the
+// recipient, it is checked both when the transfer is started and when it is accepted
+func checkTicketTransfer(db *gorm.DB, ticketRequest *models.TicketRequest, tickets []models.Ticket, recipient *models.User) *types.ErrorResponse {
+	ticketRelease := ticketRequest.TicketRelease
+
+	if !ticketRelease.AllowTransfers {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Tickets in this ticket release can not be transferred"}
+	}
+
+	now := time.Now()
+	if ticketRelease.TransferDeadline != nil && now.Unix() > *ticketRelease.TransferDeadline {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The deadline for transferring tickets has passed"}
+	}
+
+	if now.After(ticketRelease.Event.Date) {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The event has already started"}
+	}
+
+	if len(tickets) == 0 {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "There are no tickets to transfer"}
+	}
+
+	ticketIDs := make([]uint, len(tickets))
+	for i, ticket := range tickets {
+		if ticket.IsReserve {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Reserve tickets can not be transferred"}
+		}
+
+		if ticket.Refunded {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Refunded tickets can not be transferred"}
+		}
+
+		if ticket.CheckedIn {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Checked in tickets can not be transferred"}
+		}
+
+		if ticketRelease.TransferPaidOnly && !ticket.IsPaid {
+			return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Only paid tickets can be transferred"}
+		}
+
+		ticketIDs[i] = ticket.ID
+	}
+
+	// A payment that has been started by the holder must not end up paying for the recipient's tickets
+	var pendingPayments int64
+	if err := db.Model(&models.Transaction{}).
+		Where("ticket_id IN ? AND status = ?", ticketIDs, models.TransactionStatusPending).
+		Count(&pendingPayments).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking payments"}
+	}
+
+	if pendingPayments > 0 {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "A payment has been started for the ticket, wait until it has completed"}
+	}
+
+	if _, err := models.GetActiveTicketResale(db, ticketRequest.ID); err == nil {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket is listed for resale"}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking resales"}
+	}
+
+	if recipient.UGKthID == ticketRequest.UserUGKthID {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "You can not transfer a ticket to yourself"}
+	}
+
+	if recipient.IsExternal && !ticketRelease.AllowExternal {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The recipient can not have tickets to this ticket release"}
+	}
+
+	var recipientTickets int64
+	if err := db.Model(&models.TicketRequest{}).
+		Where("user_ug_kth_id = ? AND ticket_release_id = ?", recipient.UGKthID, ticketRelease.ID).
+		Select("COALESCE(SUM(CASE WHEN ticket_amount > 0 THEN ticket_amount ELSE 1 END), 0)").
+		Row().Scan(&recipientTickets); err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting the recipient's tickets"}
+	}
+
+	if recipientTickets+int64(len(tickets)) > int64(ticketRelease.TicketReleaseMethodDetail.MaxTicketsPerUser) {
+		return &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The recipient can not have more tickets to this ticket release"}
+	}
+
+	return nil
+}
+
+// loadTransferTicketRequest loads the ticket request with everything needed to check a transfer
+func loadTransferTicketRequest(db *gorm.DB, ticketRequestID uint) (*models.TicketRequest, []models.Ticket, error) {
+	var ticketRequest models.TicketRequest
+	if err := db.
+		Preload("User").
+		Preload("TicketRelease.Event.Organization").
+		Preload("TicketRelease.TicketReleaseMethodDetail").
+		Where("id = ?", ticketRequestID).First(&ticketRequest).Error; err != nil {
+		return nil, nil, err
+	}
+
+	tickets, err := models.GetTicketsInRequest(db, ticketRequest.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &ticketRequest, tickets, nil
+}
+
+// InitiateTransfer starts a transfer of the holder's ticket, and the other tickets of its
+// ticket request, to the user with the given username or email
+func (tts *TicketTransferService) InitiateTransfer(holderUGKthID string, ticketID int, recipientIdentifier string) (*models.TicketTransfer, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := tts.DB.Where("id = ? AND user_ug_kth_id = ?", ticketID, holderUGKthID).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	recipient, errResponse := findTransferRecipient(tts.DB, recipientIdentifier)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	ticketRequest, tickets, err := loadTransferTicketRequest(tts.DB, ticket.TicketRequestID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket request"}
+	}
+
+	if ticketRequest.UserUGKthID != holderUGKthID {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusForbidden, Message: "You are not the owner of this ticket"}
+	}
+
+	if errResponse := checkTicketTransfer(tts.DB, ticketRequest, tickets, recipient); errResponse != nil {
+		return nil, errResponse
+	}
+
+	if _, err := models.GetPendingTicketTransfer(tts.DB, ticketRequest.ID); err == nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket already has a pending transfer"}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking transfers"}
+	}
+
+	transfer := models.TicketTransfer{
+		TicketID:        ticket.ID,
+		TicketRequestID: ticketRequest.ID,
+		FromUGKthID:     holderUGKthID,
+		ToUGKthID:       recipient.UGKthID,
+		Status:          models.TicketTransferPending,
+	}
+
+	if err := tts.DB.Create(&transfer).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating transfer"}
+	}
+
+	if err := Notify_TicketTransferInitiated(tts.DB, &ticketRequest.User, recipient, &ticketRequest.TicketRelease.Event); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying the recipient, but the transfer was started"}
+	}
+
+	return &transfer, nil
+}
+
+func (tts *TicketTransferService) getPendingTransfer(tx *gorm.DB, transferID uint) (*models.TicketTransfer, *types.ErrorResponse) {
+	var transfer models.TicketTransfer
+	if err := tx.Where("id = ?", transferID).First(&transfer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Transfer not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting transfer"}
+	}
+
+	if !transfer.IsPending() {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The transfer is no longer pending"}
+	}
+
+	return &transfer, nil
+}
+
+// AcceptTransfer moves the tickets and the ticket request to the recipient, the tickets
+// get new QR codes so that the QR codes the holder has seen can no longer be used
+func (tts *TicketTransferService) AcceptTransfer(recipientUGKthID string, transferID uint) (*models.TicketTransfer, *types.ErrorResponse) {
+	tx := tts.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	transfer, errResponse := tts.getPendingTransfer(tx, transferID)
+	if errResponse != nil {
+		tx.Rollback()
+		return nil, errResponse
+	}
+
+	if transfer.ToUGKthID != recipientUGKthID {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusForbidden, Message: "The transfer is not to you"}
+	}
+
+	var recipient models.User
+	if err := tx.Where("ug_kth_id = ?", recipientUGKthID).First(&recipient).Error; err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting user"}
+	}
+
+	ticketRequest, tickets, err := loadTransferTicketRequest(tx, transfer.TicketRequestID)
+	if err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket request"}
+	}
+
+	// The holder may have cancelled the ticket since the transfer was started
+	if ticketRequest.UserUGKthID != transfer.FromUGKthID {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket is no longer held by the sender"}
+	}
+
+	if errResponse := checkTicketTransfer(tx, ticketRequest, tickets, &recipient); errResponse != nil {
+		tx.Rollback()
+		return nil, errResponse
+	}
+
+	for _, ticket := range tickets {
+		if err := tx.Model(&ticket).Updates(map[string]interface{}{
+			"user_ug_kth_id": recipient.UGKthID,
+			"qr_code":        utils.GenerateRandomString(16),
+		}).Error; err != nil {
+			tx.Rollback()
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error transferring ticket"}
+		}
+	}
+
+	if err := tx.Model(&models.TicketRequest{}).Where("id = ?", ticketRequest.ID).Update("user_ug_kth_id", recipient.UGKthID).Error; err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error transferring ticket request"}
+	}
+
+	now := time.Now()
+	transfer.Status = models.TicketTransferAccepted
+	transfer.RespondedAt = &now
+	if err := tx.Save(transfer).Error; err != nil {
+		tx.Rollback()
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving transfer"}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error transferring ticket"}
+	}
+
+	if err := Notify_TicketTransferCompleted(tts.DB, &ticketRequest.User, &recipient, &ticketRequest.TicketRelease.Event, len(tickets)); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error notifying users, but the ticket was transferred"}
+	}
+
+	return transfer, nil
+}
+
+// DeclineTransfer lets the recipient decline a pending transfer
+func (tts *TicketTransferService) DeclineTransfer(recipientUGKthID string, transferID uint) (*models.TicketTransfer, *types.ErrorResponse) {
+	return tts.closeTransfer(transferID, func(transfer *models.TicketTransfer) bool {
+		return transfer.ToUGKthID == recipientUGKthID
+	}, models.TicketTransferDeclined)
+}
+
+// CancelTransfer lets the holder cancel a pending transfer
+func (tts *TicketTransferService) CancelTransfer(holderUGKthID string, transferID uint) (*models.TicketTransfer, *types.ErrorResponse) {
+	return tts.closeTransfer(transferID, func(transfer *models.TicketTransfer) bool {
+		return transfer.FromUGKthID == holderUGKthID
+	}, models.TicketTransferCancelled)
+}
+
+func (tts *TicketTransferService) closeTransfer(transferID uint, canClose func(*models.TicketTransfer) bool, status models.TicketTransferStatus) (*models.TicketTransfer, *types.ErrorResponse) {
+	transfer, errResponse := tts.getPendingTransfer(tts.DB, transferID)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	if !canClose(transfer) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusForbidden, Message: "You can not change this transfer"}
+	}
+
+	now := time.Now()
+	transfer.Status = status
+	transfer.RespondedAt = &now
+	if err := tts.DB.Save(transfer).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving transfer"}
+	}
+
+	return transfer, nil
+}
+
+func (tts *TicketTransferService) ListTransfersForUser(ugKthID string) ([]models.TicketTransfer, *types.ErrorResponse) {
+	transfers, err := models.GetTicketTransfersForUser(tts.DB, ugKthID)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting transfers"}
+	}
+
+	return transfers, nil
+}
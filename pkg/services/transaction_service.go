@@ -1,11 +1,14 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
 	"github.com/stripe/stripe-go/v72"
 	"gorm.io/gorm"
 )
@@ -58,24 +61,516 @@ func (ts *TransactionService) CreateTransaction(
 	return nil
 }
 
+// SuccessfulPayment completes the purchases paid with the payment, an order pays for several purchases
 func SuccessfulPayment(
 	db *gorm.DB, // Allows transaction to be passed in
-	pi stripe.PaymentIntent) error {
-	var transaction models.Transaction
-	if err := db.Where("payment_intent_id = ?", pi.ID).Find(&transaction).Error; err != nil {
+	paymentID string) error {
+	now := time.Now().Unix()
+
+	return db.Model(&models.Transaction{}).
+		Where("payment_intent_id = ? AND transaction_type = ?", paymentID, models.TypePurchase).
+		Updates(map[string]interface{}{
+			"status":          models.TransactionStatusCompleted,
+			"failure_message": "",
+			"payed_at":        now,
+		}).Error
+}
+
+// RefundPayment records a refund of the payment and issues it right away, see RecordRefund and
+// IssueRefund. db must not be a transaction that can still be rolled back, since the money can not
+// be taken back once the refund has been issued.
+func RefundPayment(
+	db *gorm.DB,
+	payment *models.Transaction,
+	amount int,
+	idempotencyKey string,
+) (*models.Transaction, error) {
+	refund, err := RecordRefund(db, payment, amount, idempotencyKey)
+	if err != nil || refund == nil {
+		return refund, err
+	}
+
+	return refund, IssueRefund(db, refund)
+}
+
+// RecordRefund records a pending refund of amount of the payment as a transaction of the same
+// ticket, without refunding anything at the payment provider. A negative amount refunds what is
+// left of the payment. The payment is marked as refunded once all of it has been refunded.
+// The refund is issued with IssueRefund once db has been committed, refunds that are never
+// issued are retried by IssuePendingRefunds. Returns nil if there is nothing to refund.
+func RecordRefund(
+	db *gorm.DB, // Allows transaction to be passed in
+	payment *models.Transaction,
+	amount int,
+	idempotencyKey string,
+) (*models.Transaction, error) {
+	refunded, err := models.GetPurchaseRefundedAmount(db, payment)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := payment.Amount - refunded
+	if amount < 0 {
+		amount = remaining
+	}
+
+	if amount > remaining {
+		return nil, errors.New("the refund is larger than what is left of the payment")
+	}
+
+	if amount == 0 {
+		return nil, nil
+	}
+
+	refundTransaction := models.Transaction{
+		PaymentIntentID: payment.PaymentIntentID,
+		EventID:         payment.EventID,
+		TicketID:        payment.TicketID,
+		UserUGKthID:     payment.UserUGKthID,
+		Amount:          amount,
+		Currency:        payment.Currency,
+		Status:          models.TransactionStatusPending,
+		PaymentProvider: payment.PaymentProvider,
+		TransactionType: models.TypeRefund,
+		IdempotencyKey:  idempotencyKey,
+	}
+
+	if err := db.Create(&refundTransaction).Error; err != nil {
+		return nil, err
+	}
+
+	if amount == remaining {
+		now := time.Now().Unix()
+		payment.Refunded = true
+		payment.RefundedAt = &now
+		if err := db.Save(payment).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &refundTransaction, nil
+}
+
+// IssueRefund refunds a recorded refund at the payment provider. The idempotency key of the
+// refund makes sure that it is refunded once however many times it is issued. A refund the
+// provider could not be reached for stays pending and is retried by IssuePendingRefunds.
+func IssueRefund(db *gorm.DB, refundTransaction *models.Transaction) error {
+	if refundTransaction.RefundID != nil || refundTransaction.Status != models.TransactionStatusPending {
+		return nil
+	}
+
+	provider, err := payment_provider.Get(refundTransaction.Provider())
+	if err != nil {
 		return err
 	}
 
-	now := time.Now().Unix()
+	r, err := provider.Refund(&payment_provider.RefundParams{
+		PaymentID:      refundTransaction.PaymentIntentID,
+		Amount:         refundTransaction.Amount,
+		Currency:       refundTransaction.Currency,
+		IdempotencyKey: refundTransaction.IdempotencyKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	refundTransaction.RefundID = &r.ID
+
+	switch r.Status {
+	case payment_provider.RefundFailed:
+		if err := db.Save(refundTransaction).Error; err != nil {
+			return err
+		}
+
+		if err := RefundCompleted(db, r.ID, false); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("refund %s failed", r.ID)
+	case payment_provider.RefundSucceeded:
+		now := time.Now().Unix()
+		refundTransaction.Status = models.TransactionStatusCompleted
+		refundTransaction.RefundedAt = &now
+	}
+
+	return db.Save(refundTransaction).Error
+}
+
+// issueRefunds issues refunds that were recorded in a transaction that has been committed.
+// Refunds that can not be issued are left to IssuePendingRefunds.
+func issueRefunds(db *gorm.DB, refunds []*models.Transaction) {
+	for _, refund := range refunds {
+		if err := IssueRefund(db, refund); err != nil {
+			log.Printf("Failed to issue refund %d, it will be retried: %v", refund.ID, err)
+		}
+	}
+}
 
-	transaction.Status = models.TransactionStatusCompleted
-	transaction.PayedAt = &now
-	// transaction.PaymentMethod = &pm.Type // TODO Implement
-	transaction.TransactionType = models.TypePurchase
+// pendingRefundRetryDelay gives the request that recorded a refund time to issue it first
+const pendingRefundRetryDelay = time.Minute
 
-	if err := db.Save(&transaction).Error; err != nil {
+// IssuePendingRefunds issues the refunds that have been recorded but not issued at the payment
+// provider, because the provider could not be reached or the process stopped before it was done
+func IssuePendingRefunds(db *gorm.DB) error {
+	var refunds []models.Transaction
+	if err := db.Where("transaction_type = ? AND status = ? AND refund_id IS NULL AND created_at < ?",
+		models.TypeRefund, models.TransactionStatusPending, time.Now().Add(-pendingRefundRetryDelay)).
+		Order("id").Find(&refunds).Error; err != nil {
 		return err
 	}
 
+	for i := range refunds {
+		if err := IssueRefund(db, &refunds[i]); err != nil {
+			log.Printf("Failed to issue refund %d, it will be retried: %v", refunds[i].ID, err)
+		}
+	}
+
+	return nil
+}
+
+// RefundTickets marks the tickets of the ticket request as refunded and removes them together
+// with the ticket request, which gives the seats back to the reserve list
+func RefundTickets(
+	db *gorm.DB, // Allows transaction to be passed in
+	ticketRequestID uint,
+) error {
+	if err := db.Model(&models.Ticket{}).Where("ticket_request_id = ?", ticketRequestID).Update("refunded", true).Error; err != nil {
+		return err
+	}
+
+	if err := db.Where("ticket_request_id = ?", ticketRequestID).Delete(&models.Ticket{}).Error; err != nil {
+		return err
+	}
+
+	return db.Where("id = ?", ticketRequestID).Delete(&models.TicketRequest{}).Error
+}
+
+// ChargeRefunded records the refunds of a charge. Refunds made by us are completed, refunds
+// made in the Stripe dashboard are recorded, and if the whole charge has been refunded the
+// tickets that were paid for are refunded as well. Handling the same charge again does nothing.
+func ChargeRefunded(
+	db *gorm.DB, // Allows transaction to be passed in
+	charge *stripe.Charge,
+) error {
+	if charge.PaymentIntent == nil {
+		return errors.New("charge has no payment intent")
+	}
+
+	purchases, err := getPurchases(db, charge.PaymentIntent.ID)
+	if err != nil || len(purchases) == 0 {
+		return err
+	}
+
+	payment := &purchases[0]
+
+	now := time.Now().Unix()
+	if err := db.Model(&models.Transaction{}).
+		Where("payment_intent_id = ? AND transaction_type = ? AND status = ?", payment.PaymentIntentID, models.TypeRefund, models.TransactionStatusPending).
+		Updates(map[string]interface{}{"status": models.TransactionStatusCompleted, "refunded_at": now}).Error; err != nil {
+		return err
+	}
+
+	refunded, err := models.GetRefundedAmount(db, payment.PaymentIntentID)
+	if err != nil {
+		return err
+	}
+
+	if int(charge.AmountRefunded) > refunded {
+		refundTransaction := models.Transaction{
+			PaymentIntentID: payment.PaymentIntentID,
+			EventID:         payment.EventID,
+			TicketID:        payment.TicketID,
+			UserUGKthID:     payment.UserUGKthID,
+			Amount:          int(charge.AmountRefunded) - refunded,
+			Currency:        payment.Currency,
+			Status:          models.TransactionStatusCompleted,
+			TransactionType: models.TypeRefund,
+			RefundedAt:      &now,
+		}
+
+		if err := db.Create(&refundTransaction).Error; err != nil {
+			return err
+		}
+	}
+
+	if !charge.Refunded {
+		return nil
+	}
+
+	for i := range purchases {
+		purchase := &purchases[i]
+		if purchase.Refunded {
+			continue
+		}
+
+		purchase.Refunded = true
+		purchase.RefundedAt = &now
+		if err := db.Save(purchase).Error; err != nil {
+			return err
+		}
+
+		ticketRequestID, err := getPaidTicketRequestID(db, purchase)
+		if err != nil {
+			return err
+		}
+
+		if ticketRequestID != 0 {
+			if err := RefundTickets(db, ticketRequestID); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
+
+// getPurchase returns the ticket purchase paid with the payment intent, nil if the payment
+// intent is not for a ticket. The payment of an order pays for several purchases, of which
+// the first is returned.
+func getPurchase(db *gorm.DB, paymentIntentID string) (*models.Transaction, error) {
+	purchases, err := getPurchases(db, paymentIntentID)
+	if err != nil || len(purchases) == 0 {
+		return nil, err
+	}
+
+	return &purchases[0], nil
+}
+
+// getPurchases returns the ticket purchases paid with the payment intent
+func getPurchases(db *gorm.DB, paymentIntentID string) ([]models.Transaction, error) {
+	var purchases []models.Transaction
+	if err := db.Where("payment_intent_id = ? AND transaction_type = ?", paymentIntentID, models.TypePurchase).
+		Order("id ASC").Find(&purchases).Error; err != nil {
+		return nil, err
+	}
+
+	return purchases, nil
+}
+
+// getPaidTicketRequestID returns the ticket request the payment is for, 0 if the ticket no longer exists
+func getPaidTicketRequestID(db *gorm.DB, payment *models.Transaction) (uint, error) {
+	var ticket models.Ticket
+	if err := db.Unscoped().Where("id = ?", payment.TicketID).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return ticket.TicketRequestID, nil
+}
+
+// PaymentFailed records that a payment attempt failed. The payment intent can be confirmed
+// again, so the user can retry with the same payment as long as the ticket can be paid for.
+func PaymentFailed(
+	db *gorm.DB, // Allows transaction to be passed in
+	paymentID string,
+	failureMessage string,
+) (*models.Transaction, error) {
+	return updateUnpaidPurchase(db, paymentID, models.TransactionStatusFailed, failureMessage)
+}
+
+// PaymentCanceled records that the payment intent was canceled, a new payment intent is
+// created the next time the user pays for the ticket
+func PaymentCanceled(
+	db *gorm.DB, // Allows transaction to be passed in
+	paymentID string,
+) (*models.Transaction, error) {
+	return updateUnpaidPurchase(db, paymentID, models.TransactionStatusCanceled, "")
+}
+
+func updateUnpaidPurchase(db *gorm.DB, paymentID string, status models.TransactionStatus, failureMessage string) (*models.Transaction, error) {
+	payment, err := getPurchase(db, paymentID)
+	if err != nil || payment == nil {
+		return nil, err
+	}
+
+	// The events can arrive after the payment succeeded
+	if payment.Status == models.TransactionStatusCompleted {
+		return nil, nil
+	}
+
+	updates := map[string]interface{}{"status": status}
+	if failureMessage != "" {
+		updates["failure_message"] = failureMessage
+	}
+
+	if err := db.Model(&models.Transaction{}).
+		Where("payment_intent_id = ? AND transaction_type = ? AND status <> ?", paymentID, models.TypePurchase, models.TransactionStatusCompleted).
+		Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	if status == models.TransactionStatusCanceled {
+		if err := db.Model(&models.Order{}).Where("payment_intent_id = ? AND status = ?", paymentID, models.OrderStatusPending).
+			Update("status", models.OrderStatusCanceled).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	payment.Status = status
+	if failureMessage != "" {
+		payment.FailureMessage = failureMessage
+	}
+
+	return payment, nil
+}
+
+// DisputeCreated marks the payment and its tickets as disputed
+func DisputeCreated(
+	db *gorm.DB, // Allows transaction to be passed in
+	dispute *stripe.Dispute,
+) (*models.Transaction, error) {
+	purchases, err := getDisputedPurchases(db, dispute)
+	if err != nil || len(purchases) == 0 {
+		return nil, err
+	}
+
+	for i := range purchases {
+		ticketRequestID, err := getPaidTicketRequestID(db, &purchases[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if err := db.Model(&models.Ticket{}).Where("ticket_request_id = ?", ticketRequestID).Update("disputed", true).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &purchases[0], nil
+}
+
+// DisputeClosed records the outcome of a dispute. If the dispute was lost the money has been
+// returned to the card holder, it is recorded as an adjustment and the tickets are removed.
+func DisputeClosed(
+	db *gorm.DB, // Allows transaction to be passed in
+	dispute *stripe.Dispute,
+) (*models.Transaction, error) {
+	purchases, err := getDisputedPurchases(db, dispute)
+	if err != nil || len(purchases) == 0 {
+		return nil, err
+	}
+
+	payment := &purchases[0]
+
+	var ticketRequestIDs []uint
+	for i := range purchases {
+		ticketRequestID, err := getPaidTicketRequestID(db, &purchases[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if ticketRequestID != 0 {
+			ticketRequestIDs = append(ticketRequestIDs, ticketRequestID)
+		}
+	}
+
+	if dispute.Status != stripe.DisputeStatusLost {
+		if err := db.Model(&models.Ticket{}).Where("ticket_request_id IN ?", ticketRequestIDs).Update("disputed", false).Error; err != nil {
+			return nil, err
+		}
+
+		return payment, nil
+	}
+
+	var adjustments int64
+	if err := db.Model(&models.Transaction{}).
+		Where("dispute_id = ? AND transaction_type = ?", dispute.ID, models.TypeAdjustment).
+		Count(&adjustments).Error; err != nil {
+		return nil, err
+	}
+
+	if adjustments == 0 {
+		now := time.Now().Unix()
+		adjustment := models.Transaction{
+			PaymentIntentID: payment.PaymentIntentID,
+			EventID:         payment.EventID,
+			TicketID:        payment.TicketID,
+			UserUGKthID:     payment.UserUGKthID,
+			Amount:          -int(dispute.Amount),
+			Currency:        payment.Currency,
+			PayedAt:         &now,
+			Status:          models.TransactionStatusCompleted,
+			TransactionType: models.TypeAdjustment,
+			DisputeID:       &dispute.ID,
+			DisputeStatus:   string(dispute.Status),
+		}
+
+		if err := db.Create(&adjustment).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ticketRequestID := range ticketRequestIDs {
+		if err := RefundTickets(db, ticketRequestID); err != nil {
+			return nil, err
+		}
+	}
+
+	return payment, nil
+}
+
+// getDisputedPurchases records the dispute on the purchases paid with the disputed payment
+func getDisputedPurchases(db *gorm.DB, dispute *stripe.Dispute) ([]models.Transaction, error) {
+	var paymentIntentID string
+	if dispute.PaymentIntent != nil {
+		paymentIntentID = dispute.PaymentIntent.ID
+	} else if dispute.Charge != nil && dispute.Charge.PaymentIntent != nil {
+		paymentIntentID = dispute.Charge.PaymentIntent.ID
+	} else {
+		return nil, errors.New("dispute has no payment intent")
+	}
+
+	purchases, err := getPurchases(db, paymentIntentID)
+	if err != nil || len(purchases) == 0 {
+		return nil, err
+	}
+
+	for i := range purchases {
+		purchases[i].DisputeID = &dispute.ID
+		purchases[i].DisputeStatus = string(dispute.Status)
+		if err := db.Save(&purchases[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return purchases, nil
+}
+
+// RefundCompleted records the outcome of a refund that the payment provider finished after it
+// was requested. A failed refund is removed so that it no longer counts as refunded.
+func RefundCompleted(
+	db *gorm.DB, // Allows transaction to be passed in
+	refundID string,
+	succeeded bool,
+) error {
+	var refundTransaction models.Transaction
+	if err := db.Where("refund_id = ? AND transaction_type = ?", refundID, models.TypeRefund).
+		First(&refundTransaction).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if refundTransaction.Status != models.TransactionStatusPending {
+		return nil
+	}
+
+	if !succeeded {
+		refundTransaction.Status = models.TransactionStatusFailed
+		if err := db.Save(&refundTransaction).Error; err != nil {
+			return err
+		}
+
+		return db.Model(&models.Transaction{}).
+			Where("payment_intent_id = ? AND ticket_id = ? AND transaction_type = ?",
+				refundTransaction.PaymentIntentID, refundTransaction.TicketID, models.TypePurchase).
+			Updates(map[string]interface{}{"refunded": false, "refunded_at": nil}).Error
+	}
+
+	now := time.Now().Unix()
+	refundTransaction.Status = models.TransactionStatusCompleted
+	refundTransaction.RefundedAt = &now
+	return db.Save(&refundTransaction).Error
+}
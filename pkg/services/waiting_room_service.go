@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/pkg/waitingroom"
+	"gorm.io/gorm"
+)
+
+type WaitingRoomService struct {
+	DB    *gorm.DB
+	store waitingroom.Store
+}
+
+func NewWaitingRoomService(db *gorm.DB, store waitingroom.Store) *WaitingRoomService {
+	return &WaitingRoomService{DB: db, store: store}
+}
+
+func waitingRoomQueue(ticketReleaseID uint) string {
+	return fmt.Sprintf("ticket-release:%d", ticketReleaseID)
+}
+
+// SetWaitingRoom adds a waiting room to the first come first served ticket release or updates it
+func (wrs *WaitingRoomService) SetWaitingRoom(eventID int, ticketReleaseID int, req *types.WaitingRoomRequest) (*models.TicketReleaseWaitingRoom, *types.ErrorResponse) {
+	var ticketRelease models.TicketRelease
+	if err := wrs.DB.Preload("TicketReleaseMethodDetail.TicketReleaseMethod").Preload("WaitingRoom").
+		Where("id = ? AND event_id = ?", ticketReleaseID, eventID).First(&ticketRelease).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket release not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release"}
+	}
+
+	if ticketRelease.TicketReleaseMethodDetail.TicketReleaseMethod.MethodName != string(models.FCFS) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Only first come first served ticket releases can have a waiting room"}
+	}
+
+	waitingRoom := ticketRelease.WaitingRoom
+	if waitingRoom == nil {
+		waitingRoom = &models.TicketReleaseWaitingRoom{TicketReleaseID: ticketRelease.ID}
+	} else if time.Now().After(waitingRoom.OpensAt(&ticketRelease)) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The waiting room can not be changed after it has opened"}
+	}
+
+	waitingRoom.Order = req.Order
+	waitingRoom.JoinBefore = req.JoinBefore
+	waitingRoom.BatchSize = req.BatchSize
+	waitingRoom.BatchInterval = req.BatchInterval
+
+	if err := waitingRoom.Validate(); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if err := wrs.DB.Save(waitingRoom).Error; err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving waiting room"}
+	}
+
+	return waitingRoom, nil
+}
+
+// RemoveWaitingRoom lets all users request tickets to the ticket release again
+func (wrs *WaitingRoomService) RemoveWaitingRoom(eventID int, ticketReleaseID int) *types.ErrorResponse {
+	ticketRelease, waitingRoom, rerr := wrs.getWaitingRoom(eventID, ticketReleaseID)
+	if rerr != nil {
+		return rerr
+	}
+
+	if err := wrs.DB.Unscoped().Delete(waitingRoom).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error removing waiting room"}
+	}
+
+	ticketRelease.WaitingRoom = nil
+	return nil
+}
+
+// JoinWaitingRoom puts the user in the queue of the ticket release. With random order, the users that
+// join before the release opens are shuffled and queued ahead of the users that join after it has opened.
+func (wrs *WaitingRoomService) JoinWaitingRoom(ugkthid string, eventID int, ticketReleaseID int) (*types.WaitingRoomStatus, *types.ErrorResponse) {
+	ticketRelease, waitingRoom, rerr := wrs.getWaitingRoom(eventID, ticketReleaseID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	now := time.Now()
+	if now.Before(waitingRoom.OpensAt(ticketRelease)) {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The waiting room has not opened yet"}
+	}
+
+	if !ticketRelease.IsOpen() && !ticketRelease.HasNotOpenedYet() {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket release has closed"}
+	}
+
+	ctx := context.Background()
+	queue := waitingRoomQueue(ticketRelease.ID)
+
+	// The user keeps their place if they have already joined
+	if _, joined, err := wrs.store.Rank(ctx, queue, ugkthid); err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error joining waiting room"}
+	} else if !joined {
+		var score float64
+		if waitingRoom.Order == models.WaitingRoomRandom && now.Before(time.Unix(ticketRelease.Open, 0)) {
+			score = rand.Float64()
+		} else {
+			arrival, err := wrs.store.NextArrival(ctx, queue)
+			if err != nil {
+				return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error joining waiting room"}
+			}
+			score = float64(arrival)
+		}
+
+		if err := wrs.store.Join(ctx, queue, ugkthid, score); err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error joining waiting room"}
+		}
+	}
+
+	return wrs.status(ugkthid, ticketRelease, waitingRoom)
+}
+
+// GetWaitingRoomStatus returns the position of the user in the queue and the estimated wait
+func (wrs *WaitingRoomService) GetWaitingRoomStatus(ugkthid string, eventID int, ticketReleaseID int) (*types.WaitingRoomStatus, *types.ErrorResponse) {
+	ticketRelease, waitingRoom, rerr := wrs.getWaitingRoom(eventID, ticketReleaseID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return wrs.status(ugkthid, ticketRelease, waitingRoom)
+}
+
+// CheckAdmitted returns an error unless the ticket release has no waiting room or the user has been admitted
+func (wrs *WaitingRoomService) CheckAdmitted(ugkthid string, ticketReleaseID uint) *types.ErrorResponse {
+	var ticketRelease models.TicketRelease
+	if err := wrs.DB.Preload("WaitingRoom").First(&ticketRelease, ticketReleaseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket release not found"}
+		}
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release"}
+	}
+
+	if ticketRelease.WaitingRoom == nil {
+		return nil
+	}
+
+	status, rerr := wrs.status(ugkthid, &ticketRelease, ticketRelease.WaitingRoom)
+	if rerr != nil {
+		if rerr.StatusCode == http.StatusNotFound {
+			return &types.ErrorResponse{StatusCode: http.StatusForbidden, Message: "Join the waiting room to request tickets"}
+		}
+		return rerr
+	}
+
+	if !status.Admitted {
+		return &types.ErrorResponse{StatusCode: http.StatusForbidden,
+			Message: fmt.Sprintf("You are in the waiting room, your position is %d", status.Position)}
+	}
+
+	return nil
+}
+
+func (wrs *WaitingRoomService) status(ugkthid string, ticketRelease *models.TicketRelease, waitingRoom *models.TicketReleaseWaitingRoom) (*types.WaitingRoomStatus, *types.ErrorResponse) {
+	ctx := context.Background()
+	queue := waitingRoomQueue(ticketRelease.ID)
+
+	rank, joined, err := wrs.store.Rank(ctx, queue, ugkthid)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting waiting room position"}
+	}
+
+	if !joined {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "You are not in the waiting room"}
+	}
+
+	size, err := wrs.store.Size(ctx, queue)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting waiting room size"}
+	}
+
+	now := time.Now()
+	admitted := waitingRoom.Admitted(ticketRelease, now)
+	admittedAt := waitingRoom.AdmittedAt(ticketRelease, rank)
+
+	status := &types.WaitingRoomStatus{
+		Admitted:   rank < admitted,
+		QueueSize:  size,
+		AdmittedAt: admittedAt,
+	}
+
+	if !status.Admitted {
+		status.Position = rank - admitted + 1
+		status.EstimatedWait = int64(admittedAt.Sub(now).Seconds())
+		if status.EstimatedWait < 0 {
+			status.EstimatedWait = 0
+		}
+	}
+
+	return status, nil
+}
+
+func (wrs *WaitingRoomService) getWaitingRoom(eventID int, ticketReleaseID int) (*models.TicketRelease, *models.TicketReleaseWaitingRoom, *types.ErrorResponse) {
+	var ticketRelease models.TicketRelease
+	if err := wrs.DB.Preload("WaitingRoom").Where("id = ? AND event_id = ?", ticketReleaseID, eventID).First(&ticketRelease).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket release not found"}
+		}
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket release"}
+	}
+
+	if ticketRelease.WaitingRoom == nil {
+		return nil, nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket release has no waiting room"}
+	}
+
+	return &ticketRelease, ticketRelease.WaitingRoom, nil
+}
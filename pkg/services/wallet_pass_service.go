@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/pkg/wallet"
+	"gorm.io/gorm"
+)
+
+type WalletPassService struct {
+	DB     *gorm.DB
+	apple  *wallet.AppleConfig
+	google *wallet.GoogleConfig
+}
+
+// NewWalletPassService takes the configuration of the wallets, a wallet without configuration is disabled
+func NewWalletPassService(db *gorm.DB, apple *wallet.AppleConfig, google *wallet.GoogleConfig) *WalletPassService {
+	return &WalletPassService{DB: db, apple: apple, google: google}
+}
+
+// ApplePass returns the .pkpass bundle of one of the user's tickets
+func (wps *WalletPassService) ApplePass(ugkthid string, ticketID int) ([]byte, *types.ErrorResponse) {
+	if wps.apple == nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Apple Wallet passes are not available"}
+	}
+
+	pass, rerr := wps.GetTicketPass(ugkthid, ticketID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	data, err := wallet.ApplePass(wps.apple, pass)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error generating Apple Wallet pass"}
+	}
+
+	return data, nil
+}
+
+// GoogleSaveLink returns the link that adds one of the user's tickets to Google Wallet
+func (wps *WalletPassService) GoogleSaveLink(ugkthid string, ticketID int) (string, *types.ErrorResponse) {
+	if wps.google == nil {
+		return "", &types.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Google Wallet passes are not available"}
+	}
+
+	pass, rerr := wps.GetTicketPass(ugkthid, ticketID)
+	if rerr != nil {
+		return "", rerr
+	}
+
+	link, err := wallet.GoogleSaveLink(wps.google, pass)
+	if err != nil {
+		return "", &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error generating Google Wallet pass"}
+	}
+
+	return link, nil
+}
+
+// GetTicketPass returns what the wallet passes of the ticket show, only paid tickets get passes
+func (wps *WalletPassService) GetTicketPass(ugkthid string, ticketID int) (*wallet.TicketPass, *types.ErrorResponse) {
+	ticket, rerr := getPaidTicket(wps.DB, ugkthid, ticketID)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	event := ticket.TicketRequest.TicketRelease.Event
+	pass := &wallet.TicketPass{
+		SerialNumber: fmt.Sprintf("tessera-ticket-%d", ticket.ID),
+		EventID:      event.ID,
+		EventName:    event.Name,
+		Organization: event.Organization.Name,
+		Date:         event.Date,
+		EndDate:      event.EndDate,
+		Location:     event.Location,
+		TicketType:   ticket.TicketRequest.TicketType.Name,
+		AddOns:       ticketAddOnNames(ticket),
+		HolderName:   ticketHolderName(ticket),
+		QrCode:       ticket.QrCode,
+	}
+
+	return pass, nil
+}
+
+// getPaidTicket returns one of the user's tickets with everything that is printed on it, only paid
+// tickets are valid for entry
+func getPaidTicket(db *gorm.DB, ugkthid string, ticketID int) (*models.Ticket, *types.ErrorResponse) {
+	var ticket models.Ticket
+	if err := db.
+		Preload("User").
+		Preload("TicketRequest.TicketType").
+		Preload("TicketRequest.TicketRelease.Event.Organization").
+		Preload("TicketAddOns.AddOn").
+		Where("id = ? AND user_ug_kth_id = ?", ticketID, ugkthid).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Ticket not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting ticket"}
+	}
+
+	if ticket.IsReserve || ticket.Refunded || !ticket.IsPaid {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The ticket is not valid for entry"}
+	}
+
+	return &ticket, nil
+}
+
+// ticketHolderName is the guest name of the ticket if it has one, otherwise the name of the user
+func ticketHolderName(ticket *models.Ticket) string {
+	if ticket.GuestName != nil {
+		return *ticket.GuestName
+	}
+	return ticket.User.FullName()
+}
+
+func ticketAddOnNames(ticket *models.Ticket) []string {
+	var names []string
+	for _, ticketAddOn := range ticket.TicketAddOns {
+		if ticketAddOn.Refunded || ticketAddOn.Quantity == 0 {
+			continue
+		}
+		names = append(names, fmt.Sprintf("%d x %s", ticketAddOn.Quantity, ticketAddOn.AddOn.Name))
+	}
+	return names
+}
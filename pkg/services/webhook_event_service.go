@@ -0,0 +1,274 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+)
+
+const (
+	// Number of times a failed webhook event is processed before it is dead lettered
+	webhookEventMaxAttempts = 6
+	// Time before the first retry, doubled for every failed attempt
+	webhookEventRetryBackoff = 5 * time.Minute
+)
+
+var webhook_logger = logrus.New()
+
+// HandleWebhookEvent processes the event once. Stripe can send the same event several times,
+// so events that have already been processed are skipped and alreadyProcessed is true.
+// The raw payload is stored so that failed events can be replayed.
+func (ps *PaymentService) HandleWebhookEvent(event *stripe.Event, payload []byte) (alreadyProcessed bool, rerr *types.ErrorResponse) {
+	return ps.handleWebhookEvent(event.ID, event.Type, "", payload, func() *types.ErrorResponse {
+		return ps.ProcessEvent(event)
+	})
+}
+
+// HandleCallback processes a callback from a payment provider once, the same way as HandleWebhookEvent
+func (ps *PaymentService) HandleCallback(provider models.PaymentProviderName, callback *payment_provider.Callback, payload []byte) (alreadyProcessed bool, rerr *types.ErrorResponse) {
+	return ps.handleWebhookEvent(callback.ID, string(callback.Type), provider, payload, func() *types.ErrorResponse {
+		return ps.ProcessCallback(callback)
+	})
+}
+
+func (ps *PaymentService) handleWebhookEvent(
+	id string,
+	eventType string,
+	provider models.PaymentProviderName,
+	payload []byte,
+	process func() *types.ErrorResponse,
+) (alreadyProcessed bool, rerr *types.ErrorResponse) {
+	var webhookEvent models.WebhookEvent
+	if err := ps.DB.Where("stripe_id = ?", id).First(&webhookEvent).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error checking for existing webhook event"}
+		}
+		// If the webhook event does not exist, create a new one
+		webhookEvent = models.WebhookEvent{
+			StripeID:  id,
+			EventType: eventType,
+			Provider:  provider,
+			Payload:   string(payload),
+			Processed: false, // Initially false, will be set to true once processed
+		}
+		if err := ps.DB.Create(&webhookEvent).Error; err != nil {
+			return false, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error creating webhook event"}
+		}
+	} else if webhookEvent.Processed {
+		return true, nil
+	} else if webhookEvent.Payload == "" {
+		webhookEvent.Payload = string(payload)
+	}
+
+	if rerr := ps.claimWebhookEvent(&webhookEvent); rerr != nil {
+		return false, rerr
+	}
+
+	return false, ps.processWebhookEvent(&webhookEvent, process)
+}
+
+// webhookEventBackoff is the time to wait before retrying an event that has been attempted attempts times
+func webhookEventBackoff(attempts int) time.Duration {
+	return time.Duration(math.Pow(2, float64(attempts-1))) * webhookEventRetryBackoff
+}
+
+// claimWebhookEvent records an attempt to process the event, unless the event has been attempted
+// since it was read. A live delivery and the retry of the same event can then not be processed at
+// the same time. The retry is scheduled as if the attempt failed, in case it is never finished.
+func (ps *PaymentService) claimWebhookEvent(webhookEvent *models.WebhookEvent) *types.ErrorResponse {
+	now := time.Now()
+	attempts := webhookEvent.Attempts + 1
+
+	var nextRetryAt *time.Time
+	if attempts < webhookEventMaxAttempts && !webhookEvent.DeadLettered {
+		retryAt := now.Add(webhookEventBackoff(attempts))
+		nextRetryAt = &retryAt
+	}
+
+	result := ps.DB.Model(&models.WebhookEvent{}).
+		Where("id = ? AND attempts = ? AND processed = ?", webhookEvent.ID, webhookEvent.Attempts, false).
+		Updates(map[string]interface{}{
+			"attempts":        attempts,
+			"last_attempt_at": now,
+			"next_retry_at":   nextRetryAt,
+		})
+	if result.Error != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error claiming webhook event"}
+	}
+
+	if result.RowsAffected == 0 {
+		return &types.ErrorResponse{StatusCode: http.StatusConflict, Message: "The webhook event is already being processed"}
+	}
+
+	webhookEvent.Attempts = attempts
+	webhookEvent.LastAttemptAt = &now
+	webhookEvent.NextRetryAt = nextRetryAt
+	return nil
+}
+
+// processWebhookEvent runs ProcessEvent on an event claimed with claimWebhookEvent and records the
+// outcome. A failed event is retried with an exponential backoff until it has been attempted
+// webhookEventMaxAttempts times, then it is dead lettered and the super admins are alerted.
+func (ps *PaymentService) processWebhookEvent(webhookEvent *models.WebhookEvent, process func() *types.ErrorResponse) *types.ErrorResponse {
+	deadLettered := false
+	peErr := process()
+	if peErr != nil {
+		webhookEvent.LastError = peErr.Message
+
+		if webhookEvent.Attempts >= webhookEventMaxAttempts {
+			deadLettered = !webhookEvent.DeadLettered
+			webhookEvent.DeadLettered = true
+		}
+	} else {
+		webhookEvent.LastError = ""
+		webhookEvent.Processed = true
+		webhookEvent.NextRetryAt = nil
+	}
+
+	// Save the processed event
+	if err := ps.DB.Save(webhookEvent).Error; err != nil {
+		return &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error saving webhook event"}
+	}
+
+	if deadLettered {
+		if err := Notify_WebhookEventDeadLettered(ps.DB, webhookEvent); err != nil {
+			webhook_logger.WithFields(logrus.Fields{
+				"webhook_event": webhookEvent.StripeID,
+				"error":         err,
+			}).Error("Error alerting about dead lettered webhook event")
+		}
+	}
+
+	return peErr
+}
+
+func (ps *PaymentService) ListWebhookEvents(status string) ([]models.WebhookEvent, *types.ErrorResponse) {
+	switch status {
+	case "", models.WebhookEventStatusUnprocessed, models.WebhookEventStatusFailed, models.WebhookEventStatusDeadLetter:
+	default:
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Invalid status"}
+	}
+
+	events, err := models.GetUnprocessedWebhookEvents(ps.DB, status)
+	if err != nil {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting webhook events"}
+	}
+
+	return events, nil
+}
+
+func (ps *PaymentService) GetWebhookEvent(id uint) (*models.WebhookEvent, *types.ErrorResponse) {
+	var webhookEvent models.WebhookEvent
+	if err := ps.DB.First(&webhookEvent, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Webhook event not found"}
+		}
+		return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting webhook event"}
+	}
+
+	return &webhookEvent, nil
+}
+
+// ReplayWebhookEvent processes an unprocessed event again from its stored payload
+func (ps *PaymentService) ReplayWebhookEvent(id uint) (*models.WebhookEvent, *types.ErrorResponse) {
+	webhookEvent, rerr := ps.GetWebhookEvent(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	if webhookEvent.Processed {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The webhook event has already been processed"}
+	}
+
+	if webhookEvent.Payload == "" {
+		return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "The payload of the webhook event was not stored"}
+	}
+
+	var process func() *types.ErrorResponse
+	if webhookEvent.Provider == "" || webhookEvent.Provider == models.STRIPE {
+		var event stripe.Event
+		if err := json.Unmarshal([]byte(webhookEvent.Payload), &event); err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing stored payload: %v", err)}
+		}
+
+		process = func() *types.ErrorResponse { return ps.ProcessEvent(&event) }
+	} else {
+		provider, err := payment_provider.Get(webhookEvent.Provider)
+		if err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+		}
+
+		// The payload has already been verified when it was received
+		callback, err := provider.ParseCallback([]byte(webhookEvent.Payload), nil)
+		if err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing stored payload: %v", err)}
+		}
+
+		process = func() *types.ErrorResponse { return ps.ProcessCallback(callback) }
+	}
+
+	if rerr := ps.claimWebhookEvent(webhookEvent); rerr != nil {
+		return nil, rerr
+	}
+
+	// The outcome is stored on the event
+	ps.processWebhookEvent(webhookEvent, process)
+
+	return webhookEvent, nil
+}
+
+// ReplayWebhookEvents replays each of the events, or all failed events if no ids are given
+func (ps *PaymentService) ReplayWebhookEvents(ids []uint) ([]models.WebhookEvent, *types.ErrorResponse) {
+	if len(ids) == 0 {
+		events, err := models.GetUnprocessedWebhookEvents(ps.DB, models.WebhookEventStatusFailed)
+		if err != nil {
+			return nil, &types.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Error getting webhook events"}
+		}
+
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+	}
+
+	var replayed []models.WebhookEvent
+	for _, id := range ids {
+		webhookEvent, rerr := ps.ReplayWebhookEvent(id)
+		if rerr != nil {
+			return nil, &types.ErrorResponse{StatusCode: rerr.StatusCode, Message: fmt.Sprintf("Webhook event %d: %s", id, rerr.Message)}
+		}
+
+		webhookEvent.Payload = ""
+		replayed = append(replayed, *webhookEvent)
+	}
+
+	return replayed, nil
+}
+
+// RetryFailedWebhookEvents replays the failed events whose backoff has passed
+func (ps *PaymentService) RetryFailedWebhookEvents() error {
+	events, err := models.GetWebhookEventsDueForRetry(ps.DB, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if _, rerr := ps.ReplayWebhookEvent(event.ID); rerr != nil {
+			webhook_logger.WithFields(logrus.Fields{
+				"webhook_event": event.StripeID,
+				"error":         rerr.Message,
+			}).Error("Error retrying webhook event")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func tieredRefundPolicy() models.TicketReleaseMethodDetail {
+	return models.TicketReleaseMethodDetail{
+		CancellationPolicy: models.TIERED_REFUND,
+		NotificationMethod: models.EMAIL,
+		MaxTicketsPerUser:  1,
+		RefundTiers: []models.RefundTier{
+			{DaysBeforeEvent: 3, Percentage: 50},
+			{DaysBeforeEvent: 14, Percentage: 100},
+		},
+		RefundHandlingFee: 10,
+	}
+}
+
+func TestRefundTiersValidation(t *testing.T) {
+	policy := tieredRefundPolicy()
+	assert.NoError(t, policy.Validate())
+
+	policy.RefundTiers = nil
+	assert.Error(t, policy.Validate())
+
+	policy = tieredRefundPolicy()
+	policy.RefundTiers[0].Percentage = 101
+	assert.Error(t, policy.Validate())
+
+	// The refund can not grow closer to the event
+	policy = tieredRefundPolicy()
+	policy.RefundTiers[0].Percentage = 100
+	policy.RefundTiers[1].Percentage = 50
+	assert.Error(t, policy.Validate())
+
+	policy = tieredRefundPolicy()
+	policy.RefundTiers[0].DaysBeforeEvent = 14
+	assert.Error(t, policy.Validate())
+
+	policy = tieredRefundPolicy()
+	policy.RefundHandlingFee = -1
+	assert.Error(t, policy.Validate())
+}
+
+func TestRefundAmount(t *testing.T) {
+	policy := tieredRefundPolicy()
+	event := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 100, policy.RefundPercentage(event, event.AddDate(0, 0, -20)))
+	assert.Equal(t, 100, policy.RefundPercentage(event, event.AddDate(0, 0, -14)))
+	assert.Equal(t, 50, policy.RefundPercentage(event, event.AddDate(0, 0, -10)))
+	assert.Equal(t, 0, policy.RefundPercentage(event, event.AddDate(0, 0, -1)))
+
+	// The handling fee is kept from tickets but not from add-ons
+	assert.Equal(t, 19000, policy.RefundAmount(20000, event, event.AddDate(0, 0, -20), true))
+	assert.Equal(t, 9000, policy.RefundAmount(20000, event, event.AddDate(0, 0, -10), true))
+	assert.Equal(t, 10000, policy.RefundAmount(20000, event, event.AddDate(0, 0, -10), false))
+	assert.Equal(t, 0, policy.RefundAmount(500, event, event.AddDate(0, 0, -10), true))
+
+	policy.CancellationPolicy = models.NO_REFUND
+	assert.Equal(t, 0, policy.RefundAmount(20000, event, event.AddDate(0, 0, -20), false))
+}
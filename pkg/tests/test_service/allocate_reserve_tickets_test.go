@@ -459,6 +459,49 @@ func (suite *AllocateReserveTicketsTestSuite) TestAllocateTicketsWithMultipleTic
 	suite.Equal(tr1.HasAllocatedTickets, true)
 	suite.Equal(tr2.HasAllocatedTickets, true)
 }
+func (suite *AllocateReserveTicketsTestSuite) TestAllocateReservedTicketsDirectlyKeepsGroupsWithinCapacity() {
+	event := models.Event{Name: "Reserved", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	tr := suite.createTicketRelease(3, models.RESERVED_TICKET_RELEASE, 0, time.Now().Unix()-1000)
+	tr.EventID = int(event.ID)
+	tr.Close = time.Now().Unix() + 1000
+	tr.TicketTypes[0].EventID = event.ID
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+
+	// The second group does not fit once the first has been allocated, the single request after it does
+	var requests []models.TicketRequest
+	for i, amount := range []int{2, 2, 1} {
+		req := models.TicketRequest{
+			TicketReleaseID: tr.ID,
+			TicketTypeID:    tr.TicketTypes[0].ID,
+			TicketAmount:    amount,
+			Model:           gorm.Model{CreatedAt: time.Now().Add(time.Duration(i) * time.Second)},
+		}
+		suite.Require().NoError(suite.db.Create(&req).Error)
+		requests = append(requests, req)
+	}
+
+	suite.Require().NoError(jobs.AllocateReservedTicketsDirectlyJob(suite.db))
+
+	var allocated int64
+	suite.db.Model(&models.Ticket{}).Where("is_reserve = ?", false).Count(&allocated)
+	suite.Equal(int64(3), allocated)
+
+	tickets, err := models.GetTicketsInRequest(suite.db, requests[1].ID)
+	suite.Require().NoError(err)
+	suite.Empty(tickets)
+
+	tickets, err = models.GetTicketsInRequest(suite.db, requests[2].ID)
+	suite.Require().NoError(err)
+	suite.Len(tickets, 1)
+
+	// Nothing more is allocated once the ticket release is full
+	suite.Require().NoError(jobs.AllocateReservedTicketsDirectlyJob(suite.db))
+	suite.db.Model(&models.Ticket{}).Where("is_reserve = ?", false).Count(&allocated)
+	suite.Equal(int64(3), allocated)
+}
+
 func TestAllocateReserveTicketsTestSuite(t *testing.T) {
 	suite.Run(t, new(AllocateReserveTicketsTestSuite))
 }
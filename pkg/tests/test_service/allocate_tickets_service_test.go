@@ -8,6 +8,7 @@ import (
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"github.com/DowLucas/gin-ticket-release/pkg/services"
 	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
 )
@@ -210,6 +211,256 @@ func (suite *AllocateTicketsTestSuite) TestCannotAllocateTicketsTwice() {
 	suite.Error(err)
 }
 
+func (suite *AllocateTicketsTestSuite) TestPreviewAllocateTickets() {
+	event := models.Event{Name: "Preview", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	tr := suite.createTicketRelease(2, models.FCFS, 0, time.Now().Unix()-1000)
+	tr.EventID = int(event.ID)
+	tr.TicketTypes = []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+	tr.Event = event
+
+	for i := 0; i < 3; i++ {
+		req := models.TicketRequest{
+			TicketReleaseID: tr.ID,
+			TicketTypeID:    tr.TicketTypes[0].ID,
+			Model: gorm.Model{
+				CreatedAt: time.Now().Add(time.Duration(i) * time.Second),
+			},
+		}
+		suite.Require().NoError(suite.db.Create(&req).Error)
+	}
+
+	preview, err := suite.service.PreviewAllocateTickets(&tr, &types.AllocateTicketsRequest{
+		OriginalDeadline:   time.Now().Add(7 * 24 * time.Hour),
+		CalculatedDuration: 24 * time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	suite.Equal(2, preview.NumberOfTickets)
+	suite.Equal(1, preview.NumberOfReserves)
+	suite.Equal(float64(200), preview.ProjectedRevenue)
+	suite.Equal(uint(1), preview.Tickets[2].ReserveNumber)
+
+	// Nothing should have been stored
+	var tickets []models.Ticket
+	suite.db.Find(&tickets)
+	suite.Empty(tickets)
+
+	var unhandled int64
+	suite.db.Model(&models.TicketRequest{}).Where("is_handled = ?", false).Count(&unhandled)
+	suite.Equal(int64(3), unhandled)
+
+	var fromDB models.TicketRelease
+	suite.db.First(&fromDB, tr.ID)
+	suite.False(fromDB.HasAllocatedTickets)
+	suite.False(tr.HasAllocatedTickets)
+}
+
+func (suite *AllocateTicketsTestSuite) TestLotteryPreviewMatchesAllocation() {
+	event := models.Event{Name: "Lottery preview", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	tr := suite.createTicketRelease(3, models.FCFS_LOTTERY, 1000, time.Now().Unix()-100)
+	tr.EventID = int(event.ID)
+	tr.TicketTypes = []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+	tr.Event = event
+
+	for i := 0; i < 10; i++ {
+		req := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID}
+		suite.Require().NoError(suite.db.Create(&req).Error)
+	}
+
+	allocateRequest := &types.AllocateTicketsRequest{
+		OriginalDeadline:   time.Now().Add(7 * 24 * time.Hour),
+		CalculatedDuration: 24 * time.Hour,
+	}
+
+	preview, err := suite.service.PreviewAllocateTickets(&tr, allocateRequest)
+	suite.Require().NoError(err)
+
+	// A second preview draws the same lottery
+	again, err := suite.service.PreviewAllocateTickets(&tr, allocateRequest)
+	suite.Require().NoError(err)
+	suite.Equal(preview.Tickets, again.Tickets)
+
+	var release models.TicketRelease
+	suite.Require().NoError(suite.db.Preload("TicketReleaseMethodDetail.TicketReleaseMethod").First(&release, tr.ID).Error)
+	suite.Require().NotNil(release.LotterySeed)
+	release.Event = event
+
+	suite.Require().NoError(suite.service.AllocateTickets(&release, allocateRequest))
+
+	for _, previewed := range preview.Tickets {
+		var ticket models.Ticket
+		suite.Require().NoError(suite.db.Where("ticket_request_id = ?", previewed.TicketRequestID).First(&ticket).Error)
+		suite.Equal(previewed.IsReserve, ticket.IsReserve, "ticket request %d", previewed.TicketRequestID)
+		suite.Equal(previewed.ReserveNumber, ticket.ReserveNumber, "ticket request %d", previewed.TicketRequestID)
+	}
+
+	var draw models.LotteryDraw
+	suite.Require().NoError(suite.db.Where("ticket_release_id = ?", tr.ID).First(&draw).Error)
+	suite.Equal(*release.LotterySeed, draw.Seed)
+}
+
+func (suite *AllocateTicketsTestSuite) TestAllocateTicketsTicketTypeCapacity() {
+	event := models.Event{Name: "Capacity", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	capacity := 2
+	tr := suite.createTicketRelease(10, models.FCFS_LOTTERY, 1000, time.Now().Unix()-100)
+	tr.EventID = int(event.ID)
+	tr.TicketTypes = []models.TicketType{
+		{Name: "Limited", Price: 100, EventID: event.ID, Capacity: &capacity},
+		{Name: "Standard", Price: 100, EventID: event.ID},
+	}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+	tr.Event = event
+
+	limited, standard := tr.TicketTypes[0].ID, tr.TicketTypes[1].ID
+	for i := 0; i < 8; i++ {
+		ticketTypeID := limited
+		if i >= 5 {
+			ticketTypeID = standard
+		}
+
+		req := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketTypeID}
+		suite.Require().NoError(suite.db.Create(&req).Error)
+	}
+
+	err := suite.service.AllocateTickets(&tr, &types.AllocateTicketsRequest{
+		OriginalDeadline:   time.Now().Add(7 * 24 * time.Hour),
+		CalculatedDuration: 24 * time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	countTickets := func(ticketTypeID uint, isReserve bool) int64 {
+		var count int64
+		suite.db.Model(&models.Ticket{}).
+			Joins("JOIN ticket_requests ON tickets.ticket_request_id = ticket_requests.id").
+			Where("ticket_requests.ticket_type_id = ? AND tickets.is_reserve = ?", ticketTypeID, isReserve).
+			Count(&count)
+		return count
+	}
+
+	// The limited ticket type is full even though the ticket release has tickets left
+	suite.Equal(int64(2), countTickets(limited, false))
+	suite.Equal(int64(3), countTickets(limited, true))
+	suite.Equal(int64(3), countTickets(standard, false))
+	suite.Equal(int64(0), countTickets(standard, true))
+
+	var reserveNumbers []uint
+	suite.db.Model(&models.Ticket{}).Where("is_reserve = ?", true).Order("reserve_number").Pluck("reserve_number", &reserveNumbers)
+	suite.Equal([]uint{1, 2, 3}, reserveNumbers)
+}
+
+func (suite *AllocateTicketsTestSuite) TestAllocateTicketsForGroupRequests() {
+	event := models.Event{Name: "Group", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	tr := suite.createTicketRelease(4, models.FCFS, 0, time.Now().Unix()-1000)
+	tr.EventID = int(event.ID)
+	tr.TicketTypes = []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+	tr.Event = event
+
+	group := models.TicketRequest{
+		TicketReleaseID: tr.ID,
+		TicketTypeID:    tr.TicketTypes[0].ID,
+		TicketAmount:    3,
+		GuestNames:      []string{"", "Guest One"},
+		Model:           gorm.Model{CreatedAt: time.Now()},
+	}
+	suite.Require().NoError(suite.db.Create(&group).Error)
+
+	// Only one ticket is left, so the whole second request is put on the reserve list
+	late := models.TicketRequest{
+		TicketReleaseID: tr.ID,
+		TicketTypeID:    tr.TicketTypes[0].ID,
+		TicketAmount:    2,
+		Model:           gorm.Model{CreatedAt: time.Now().Add(time.Second)},
+	}
+	suite.Require().NoError(suite.db.Create(&late).Error)
+
+	err := suite.service.AllocateTickets(&tr, &types.AllocateTicketsRequest{
+		OriginalDeadline:   time.Now().Add(7 * 24 * time.Hour),
+		CalculatedDuration: 24 * time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	groupTickets, err := models.GetTicketsInRequest(suite.db, group.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(groupTickets, 3)
+
+	qrCodes := map[string]bool{}
+	for _, ticket := range groupTickets {
+		suite.False(ticket.IsReserve)
+		qrCodes[ticket.QrCode] = true
+	}
+	suite.Len(qrCodes, 3)
+
+	suite.Nil(groupTickets[0].GuestName)
+	suite.Require().NotNil(groupTickets[1].GuestName)
+	suite.Equal("Guest One", *groupTickets[1].GuestName)
+
+	lateTickets, err := models.GetTicketsInRequest(suite.db, late.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(lateTickets, 2)
+	for _, ticket := range lateTickets {
+		suite.True(ticket.IsReserve)
+		suite.Equal(uint(1), ticket.ReserveNumber)
+	}
+}
+
+func (suite *AllocateTicketsTestSuite) TestVerifyLotteryDrawAgainstTicketRequests() {
+	event := models.Event{Name: "Lottery", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	tr := suite.createTicketRelease(3, models.FCFS_LOTTERY, 1000, time.Now().Unix()-100)
+	tr.EventID = int(event.ID)
+	tr.TicketTypes = []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+	tr.Event = event
+
+	for i := 0; i < 5; i++ {
+		req := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID}
+		suite.Require().NoError(suite.db.Create(&req).Error)
+	}
+
+	suite.Require().NoError(suite.service.AllocateTickets(&tr, &types.AllocateTicketsRequest{
+		OriginalDeadline:   time.Now().Add(7 * 24 * time.Hour),
+		CalculatedDuration: 24 * time.Hour,
+	}))
+
+	lotteryDrawService := services.NewLotteryDrawService(suite.db)
+	verification, rerr := lotteryDrawService.VerifyLotteryDraw(tr.ID)
+	suite.Require().Nil(rerr)
+	suite.True(verification.Verified)
+
+	// Cancelling a ticket after the draw does not change who took part in it
+	var ticket models.Ticket
+	suite.Require().NoError(suite.db.First(&ticket).Error)
+	suite.Require().NoError(suite.db.Delete(&ticket).Error)
+	suite.Require().NoError(suite.db.Delete(&models.TicketRequest{}, ticket.TicketRequestID).Error)
+
+	verification, rerr = lotteryDrawService.VerifyLotteryDraw(tr.ID)
+	suite.Require().Nil(rerr)
+	suite.True(verification.Verified)
+
+	// A request that was given a ticket in the open window without taking part in the draw is
+	// caught, even though the recorded IDs and hash of the draw agree with each other
+	sneaked := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID, IsHandled: true}
+	suite.Require().NoError(suite.db.Create(&sneaked).Error)
+	suite.Require().NoError(suite.db.Create(&models.Ticket{TicketRequestID: sneaked.ID, QrCode: "sneaked"}).Error)
+
+	verification, rerr = lotteryDrawService.VerifyLotteryDraw(tr.ID)
+	suite.Require().Nil(rerr)
+	suite.False(verification.HashMatches)
+	suite.False(verification.Verified)
+}
+
 func TestAllocateTicketsTestSuite(t *testing.T) {
 	suite.Run(t, new(AllocateTicketsTestSuite))
 }
@@ -0,0 +1,174 @@
+package test_service
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type AllocationRunTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	service       *services.AllocationRunService
+	ticketRelease models.TicketRelease
+}
+
+func (suite *AllocationRunTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+
+	suite.db = db
+	suite.service = services.NewAllocationRunService(db)
+
+	event := models.Event{Name: "Allocation run", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&event).Error)
+
+	tr := models.TicketRelease{
+		EventID:          int(event.ID),
+		Open:             time.Now().Unix() - 1000,
+		Close:            time.Now().Unix() + 1000,
+		TicketsAvailable: 2,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&tr).Error)
+	tr.Event = event
+
+	for i := 0; i < 3; i++ {
+		req := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID}
+		suite.Require().NoError(db.Create(&req).Error)
+	}
+
+	suite.ticketRelease = tr
+}
+
+func (suite *AllocationRunTestSuite) TearDownTest() {
+	os.Unsetenv("ALLOCATION_UNDO_GRACE_PERIOD")
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *AllocationRunTestSuite) allocate() models.AllocationRun {
+	tr := suite.ticketRelease
+	err := services.NewAllocateTicketsService(suite.db).AllocateTickets(&tr, &types.AllocateTicketsRequest{
+		OriginalDeadline:   time.Now().Add(7 * 24 * time.Hour),
+		CalculatedDuration: 24 * time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	runs, rerr := suite.service.ListAllocationRuns(tr.ID)
+	suite.Require().Nil(rerr)
+	suite.Require().NotEmpty(runs)
+
+	return runs[0]
+}
+
+func (suite *AllocationRunTestSuite) TestUndoAllocationRun() {
+	run := suite.allocate()
+	suite.Len(run.Tickets, 3)
+
+	rerr := suite.service.UndoAllocationRun(suite.ticketRelease.ID, run.ID, "validUserUGKthID")
+	suite.Require().Nil(rerr)
+
+	var tickets []models.Ticket
+	suite.db.Find(&tickets)
+	suite.Empty(tickets)
+
+	var unhandled int64
+	suite.db.Model(&models.TicketRequest{}).Where("is_handled = ?", false).Count(&unhandled)
+	suite.Equal(int64(3), unhandled)
+
+	var tr models.TicketRelease
+	suite.db.First(&tr, suite.ticketRelease.ID)
+	suite.False(tr.HasAllocatedTickets)
+
+	var deadlines int64
+	suite.db.Unscoped().Model(&models.TicketReleasePaymentDeadline{}).Count(&deadlines)
+	suite.Equal(int64(0), deadlines)
+
+	// A run can only be undone once
+	rerr = suite.service.UndoAllocationRun(suite.ticketRelease.ID, run.ID, "validUserUGKthID")
+	suite.NotNil(rerr)
+
+	// The ticket release can be allocated again
+	suite.allocate()
+}
+
+func (suite *AllocationRunTestSuite) TestUndoAfterGracePeriod() {
+	os.Setenv("ALLOCATION_UNDO_GRACE_PERIOD", "0s")
+	run := suite.allocate()
+
+	rerr := suite.service.UndoAllocationRun(suite.ticketRelease.ID, run.ID, "validUserUGKthID")
+	suite.Require().NotNil(rerr)
+
+	var tickets []models.Ticket
+	suite.db.Find(&tickets)
+	suite.Len(tickets, 3)
+}
+
+func (suite *AllocationRunTestSuite) TestUndoOnlyBlockedByCompletedPayments() {
+	run := suite.allocate()
+	suite.Require().NotEmpty(run.Tickets)
+
+	// A payment that was started but never completed does not stop the undo
+	pending := models.Transaction{
+		PaymentIntentID: "pi_started",
+		TicketID:        int(run.Tickets[0].ID),
+		Amount:          10000,
+		Status:          models.TransactionStatusPending,
+		TransactionType: models.TypePurchase,
+	}
+	suite.Require().NoError(suite.db.Create(&pending).Error)
+
+	rerr := suite.service.UndoAllocationRun(suite.ticketRelease.ID, run.ID, "validUserUGKthID")
+	suite.Require().Nil(rerr)
+
+	run = suite.allocate()
+	suite.Require().Nil(run.RevertedAt)
+	suite.Require().NotEmpty(run.Tickets)
+
+	paidAt := time.Now().Unix()
+	completed := models.Transaction{
+		PaymentIntentID: "pi_paid",
+		TicketID:        int(run.Tickets[0].ID),
+		Amount:          10000,
+		PayedAt:         &paidAt,
+		Status:          models.TransactionStatusCompleted,
+		TransactionType: models.TypePurchase,
+	}
+	suite.Require().NoError(suite.db.Create(&completed).Error)
+
+	rerr = suite.service.UndoAllocationRun(suite.ticketRelease.ID, run.ID, "validUserUGKthID")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Tickets in this allocation have already been paid for", rerr.Message)
+
+	var tickets int64
+	suite.db.Model(&models.Ticket{}).Count(&tickets)
+	suite.Equal(int64(len(run.Tickets)), tickets)
+}
+
+func (suite *AllocationRunTestSuite) TestInvalidGracePeriod() {
+	for _, value := range []string{"fifteen minutes", "-5m"} {
+		os.Setenv("ALLOCATION_UNDO_GRACE_PERIOD", value)
+		_, err := services.AllocationUndoGracePeriod()
+		suite.Error(err, value)
+	}
+
+	os.Setenv("ALLOCATION_UNDO_GRACE_PERIOD", "30m")
+	gracePeriod, err := services.AllocationUndoGracePeriod()
+	suite.Require().NoError(err)
+	suite.Equal(30*time.Minute, gracePeriod)
+}
+
+func TestAllocationRunTestSuite(t *testing.T) {
+	suite.Run(t, new(AllocationRunTestSuite))
+}
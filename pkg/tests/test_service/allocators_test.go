@@ -0,0 +1,71 @@
+package test_service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type AllocatorsTestSuite struct {
+	suite.Suite
+	db *gorm.DB
+}
+
+func (suite *AllocatorsTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+
+	suite.db = db
+}
+
+func (suite *AllocatorsTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *AllocatorsTestSuite) TestAllMethodsAreRegistered() {
+	for _, method := range []models.TRM{models.FCFS_LOTTERY, models.RESERVED_TICKET_RELEASE, models.FCFS, models.SELECTIVE} {
+		allocator, err := services.GetAllocator(string(method))
+		suite.NoError(err)
+		suite.Equal(method, allocator.Method())
+	}
+}
+
+func (suite *AllocatorsTestSuite) TestUnknownMethod() {
+	_, err := services.GetAllocator("Unknown Method")
+	suite.Error(err)
+
+	_, err = services.GetAllocator("")
+	suite.Error(err)
+}
+
+func (suite *AllocatorsTestSuite) TestValidateTicketReleaseMethod() {
+	err := services.ValidateTicketReleaseMethod(string(models.FCFS_LOTTERY), &models.TicketReleaseMethodDetail{OpenWindowDuration: 0})
+	suite.Error(err)
+
+	err = services.ValidateTicketReleaseMethod(string(models.FCFS_LOTTERY), &models.TicketReleaseMethodDetail{OpenWindowDuration: 3600})
+	suite.NoError(err)
+
+	err = services.ValidateTicketReleaseMethod(string(models.SELECTIVE), &models.TicketReleaseMethodDetail{})
+	suite.Error(err)
+}
+
+func (suite *AllocatorsTestSuite) TestInitializeTicketReleaseMethods() {
+	suite.NoError(services.InitializeTicketReleaseMethods(suite.db))
+	// Running it twice should not create duplicates
+	suite.NoError(services.InitializeTicketReleaseMethods(suite.db))
+
+	var methods []models.TicketReleaseMethod
+	suite.db.Find(&methods)
+
+	suite.Equal(len(services.ListAllocators()), len(methods))
+}
+
+func TestAllocatorsTestSuite(t *testing.T) {
+	suite.Run(t, new(AllocatorsTestSuite))
+}
@@ -0,0 +1,214 @@
+package test_service
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/checkinfeed"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type CheckInTestSuite struct {
+	suite.Suite
+	db      *gorm.DB
+	service *services.CheckInService
+	feed    *checkinfeed.MemoryBroker
+	events  []models.Event
+}
+
+func (suite *CheckInTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.feed = checkinfeed.NewMemoryBroker()
+	suite.service = services.NewCheckInService(db, suite.feed)
+
+	for _, id := range []string{"guest", "organizer"} {
+		suite.Require().NoError(db.Create(&models.User{UGKthID: id, Username: id, Email: id + "@kth.se", FirstName: id}).Error)
+	}
+
+	suite.events = nil
+	for _, name := range []string{"Gasque", "Pub"} {
+		event := models.Event{Name: name, Date: time.Now().Add(time.Hour), OrganizationID: 1}
+		suite.Require().NoError(db.Create(&event).Error)
+		suite.events = append(suite.events, event)
+	}
+}
+
+func (suite *CheckInTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *CheckInTestSuite) createTicket(event models.Event, qrCode string, ticket models.Ticket) models.Ticket {
+	tr := models.TicketRelease{EventID: int(event.ID), TicketsAvailable: 10}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+
+	ticketType := models.TicketType{Name: "Standard", EventID: event.ID, TicketReleaseID: tr.ID}
+	suite.Require().NoError(suite.db.Create(&ticketType).Error)
+
+	request := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketType.ID, TicketAmount: 1, UserUGKthID: "guest", IsHandled: true}
+	suite.Require().NoError(suite.db.Create(&request).Error)
+
+	ticket.TicketRequestID = request.ID
+	ticket.UserUGKthID = "guest"
+	ticket.QrCode = qrCode
+	suite.Require().NoError(suite.db.Create(&ticket).Error)
+	return ticket
+}
+
+func (suite *CheckInTestSuite) checkIn(event models.Event, qrCode string, gate string) (*types.CheckInResult, *types.ErrorResponse) {
+	return suite.service.CheckIn(int(event.ID), "organizer", &types.CheckInRequest{QrCode: qrCode, Gate: gate})
+}
+
+func (suite *CheckInTestSuite) TestRejectsTicketsThatAreNotValidForEntry() {
+	suite.createTicket(suite.events[0], "paid", models.Ticket{IsPaid: true})
+	suite.createTicket(suite.events[0], "unpaid", models.Ticket{})
+	suite.createTicket(suite.events[0], "reserve", models.Ticket{IsPaid: true, IsReserve: true})
+	suite.createTicket(suite.events[0], "refunded", models.Ticket{IsPaid: true, Refunded: true})
+
+	for qrCode, reason := range map[string]string{
+		"unpaid":   "Ticket has not been paid",
+		"reserve":  "Ticket is on the reserve list",
+		"refunded": "Ticket has been refunded",
+	} {
+		_, rerr := suite.checkIn(suite.events[0], qrCode, "Main entrance")
+		suite.Require().NotNil(rerr, qrCode)
+		suite.Equal(http.StatusBadRequest, rerr.StatusCode)
+		suite.Equal(reason, rerr.Message)
+	}
+
+	// A ticket to one event can not be checked in at another
+	_, rerr := suite.checkIn(suite.events[1], "paid", "Main entrance")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Ticket is for another event", rerr.Message)
+
+	_, rerr = suite.checkIn(suite.events[0], "unknown", "Main entrance")
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusNotFound, rerr.StatusCode)
+
+	var checkedIn int64
+	suite.db.Model(&models.Ticket{}).Where("checked_in = ?", true).Count(&checkedIn)
+	suite.Zero(checkedIn)
+
+	var rejected int64
+	suite.db.Model(&models.CheckInLog{}).Where("action = ?", models.CheckInActionRejected).Count(&rejected)
+	suite.Equal(int64(4), rejected)
+}
+
+func (suite *CheckInTestSuite) TestRepeatScansReturnTheOriginalCheckIn() {
+	ticket := suite.createTicket(suite.events[0], "paid", models.Ticket{IsPaid: true})
+
+	first, rerr := suite.checkIn(suite.events[0], "paid", "Main entrance")
+	suite.Require().Nil(rerr)
+	suite.False(first.AlreadyCheckedIn)
+
+	again, rerr := suite.checkIn(suite.events[0], "paid", "Side entrance")
+	suite.Require().Nil(rerr)
+	suite.True(again.AlreadyCheckedIn)
+	suite.True(first.CheckedInAt.Equal(again.CheckedInAt))
+
+	logs, rerr := suite.service.GetCheckInLogs(int(suite.events[0].ID), int(ticket.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().Len(logs, 2)
+	suite.Equal(models.CheckInActionRepeatScan, logs[0].Action)
+	suite.Equal("Side entrance", logs[0].Gate)
+	suite.Equal(models.CheckInActionCheckedIn, logs[1].Action)
+	suite.Equal("organizer", logs[1].Organizer.UGKthID)
+}
+
+func (suite *CheckInTestSuite) TestUndoCheckIn() {
+	ticket := suite.createTicket(suite.events[0], "paid", models.Ticket{IsPaid: true})
+
+	_, rerr := suite.service.UndoCheckIn(int(suite.events[0].ID), int(ticket.ID), "organizer", "Main entrance")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Ticket is not checked in", rerr.Message)
+
+	_, rerr = suite.checkIn(suite.events[0], "paid", "Main entrance")
+	suite.Require().Nil(rerr)
+
+	// Only organizers of the event of the ticket can undo the check-in
+	_, rerr = suite.service.UndoCheckIn(int(suite.events[1].ID), int(ticket.ID), "organizer", "Main entrance")
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusNotFound, rerr.StatusCode)
+
+	undone, rerr := suite.service.UndoCheckIn(int(suite.events[0].ID), int(ticket.ID), "organizer", "Main entrance")
+	suite.Require().Nil(rerr)
+	suite.False(undone.CheckedIn)
+
+	var reloaded models.Ticket
+	suite.Require().NoError(suite.db.First(&reloaded, ticket.ID).Error)
+	suite.False(reloaded.CheckedIn)
+	suite.False(reloaded.CheckedInAt.Valid)
+
+	// The ticket can be checked in again
+	result, rerr := suite.checkIn(suite.events[0], "paid", "Main entrance")
+	suite.Require().Nil(rerr)
+	suite.False(result.AlreadyCheckedIn)
+
+	logs, rerr := suite.service.GetCheckInLogs(int(suite.events[0].ID), int(ticket.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().Len(logs, 3)
+	suite.Equal(models.CheckInActionUndone, logs[1].Action)
+}
+
+func (suite *CheckInTestSuite) TestDashboardFollowsCheckIns() {
+	suite.createTicket(suite.events[0], "first", models.Ticket{IsPaid: true})
+	suite.createTicket(suite.events[0], "second", models.Ticket{IsPaid: true})
+	suite.createTicket(suite.events[0], "reserve", models.Ticket{IsPaid: true, IsReserve: true})
+
+	updates, cancel := suite.service.Subscribe(int(suite.events[0].ID))
+	defer cancel()
+	otherEvent, cancelOther := suite.service.Subscribe(int(suite.events[1].ID))
+	defer cancelOther()
+
+	_, rerr := suite.checkIn(suite.events[0], "first", "Main entrance")
+	suite.Require().Nil(rerr)
+
+	select {
+	case update := <-updates:
+		suite.True(update.CheckedIn)
+		suite.Equal(string(models.CheckInActionCheckedIn), update.Action)
+		suite.Equal("Main entrance", update.Gate)
+	case <-time.After(time.Second):
+		suite.Fail("No check-in update was published")
+	}
+	suite.Empty(otherEvent)
+
+	dashboard, rerr := suite.service.GetDashboard(int(suite.events[0].ID))
+	suite.Require().Nil(rerr)
+	suite.Equal(int64(1), dashboard.CheckedIn)
+	suite.Equal(int64(2), dashboard.Total)
+	suite.Len(dashboard.TicketTypes, 2)
+	suite.InDelta(1.0/15, dashboard.ArrivalRate, 0.001)
+	suite.Len(dashboard.RecentScans, 1)
+
+	// Organizers checking in tickets by hand also reach the dashboards
+	ticketService := services.NewTicketService(suite.db)
+	ticketService.Feed = suite.feed
+
+	var ticket models.Ticket
+	suite.Require().NoError(suite.db.Preload("TicketRequest.TicketRelease.Event").Where("qr_code = ?", "second").First(&ticket).Error)
+	checkedIn := true
+	_, err := ticketService.UpdateTicket(&ticket, &types.UpdateTicketBody{CheckedIn: &checkedIn})
+	suite.Require().NoError(err)
+
+	select {
+	case update := <-updates:
+		suite.Equal(ticket.ID, update.TicketID)
+		suite.True(update.CheckedIn)
+	case <-time.After(time.Second):
+		suite.Fail("No check-in update was published")
+	}
+}
+
+func TestCheckInTestSuite(t *testing.T) {
+	suite.Run(t, new(CheckInTestSuite))
+}
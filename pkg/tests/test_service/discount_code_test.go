@@ -0,0 +1,185 @@
+package test_service
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type DiscountCodeTestSuite struct {
+	suite.Suite
+	db        *gorm.DB
+	service   *services.PaymentService
+	discounts *services.DiscountCodeService
+	stripe    *testutils.FakePaymentProvider
+	previous  payment_provider.PaymentProvider
+	event     models.Event
+	release   models.TicketRelease
+}
+
+func (suite *DiscountCodeTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewPaymentService(db)
+	suite.discounts = services.NewDiscountCodeService(db)
+
+	suite.stripe = testutils.NewFakePaymentProvider(models.STRIPE)
+	suite.previous = payment_provider.Register(suite.stripe)
+
+	suite.event = models.Event{Name: "Gasque", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&suite.event).Error)
+
+	suite.release = models.TicketRelease{
+		EventID:          int(suite.event.ID),
+		TicketsAvailable: 10,
+		TicketTypes: []models.TicketType{
+			{Name: "Student", Price: 200, EventID: suite.event.ID},
+			{Name: "Alumni", Price: 300, EventID: suite.event.ID},
+		},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&suite.release).Error)
+}
+
+func (suite *DiscountCodeTestSuite) TearDownTest() {
+	payment_provider.Register(suite.previous)
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *DiscountCodeTestSuite) createTicket(ugkthid string, ticketType models.TicketType) models.Ticket {
+	suite.Require().NoError(suite.db.FirstOrCreate(&models.User{}, models.User{UGKthID: ugkthid, Username: ugkthid, Email: ugkthid + "@kth.se"}).Error)
+
+	request := models.TicketRequest{TicketReleaseID: suite.release.ID, TicketTypeID: ticketType.ID, TicketAmount: 1, UserUGKthID: ugkthid, IsHandled: true}
+	suite.Require().NoError(suite.db.Create(&request).Error)
+
+	deadline := time.Now().Add(24 * time.Hour)
+	ticket := models.Ticket{TicketRequestID: request.ID, UserUGKthID: ugkthid, QrCode: fmt.Sprintf("discount-%d", request.ID), PaymentDeadline: &deadline}
+	suite.Require().NoError(suite.db.Create(&ticket).Error)
+
+	return ticket
+}
+
+func (suite *DiscountCodeTestSuite) createDiscountCode(req types.DiscountCodeRequest) *models.DiscountCode {
+	discountCode, rerr := suite.discounts.CreateDiscountCode(int(suite.event.ID), &req)
+	suite.Require().Nil(rerr)
+	return discountCode
+}
+
+func (suite *DiscountCodeTestSuite) TestDiscountReducesPayment() {
+	suite.createDiscountCode(types.DiscountCodeRequest{Code: "sektion", DiscountType: models.DiscountPercent, Amount: 25})
+
+	ticket := suite.createTicket("buyer", suite.release.TicketTypes[0])
+	payment, _, rerr := suite.service.CreatePayment("buyer", int(ticket.ID), " Sektion ")
+	suite.Require().Nil(rerr)
+	suite.Equal(15000, payment.Amount)
+
+	var purchase models.Transaction
+	suite.Require().NoError(suite.db.Where("payment_intent_id = ?", payment.ID).First(&purchase).Error)
+	suite.Equal(5000, purchase.DiscountAmount)
+	suite.Equal(15000, purchase.Amount)
+
+	_, _, rerr = suite.service.CreatePayment("buyer", int(ticket.ID), "UNKNOWN")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Invalid discount code", rerr.Message)
+}
+
+func (suite *DiscountCodeTestSuite) TestRedemptionLimits() {
+	maxRedemptions := 2
+	perUser := 1
+	suite.createDiscountCode(types.DiscountCodeRequest{Code: "EARLY", DiscountType: models.DiscountFixed, Amount: 50,
+		MaxRedemptions: &maxRedemptions, MaxRedemptionsPerUser: &perUser})
+
+	first := suite.createTicket("alice", suite.release.TicketTypes[0])
+	second := suite.createTicket("alice", suite.release.TicketTypes[1])
+	third := suite.createTicket("bob", suite.release.TicketTypes[0])
+	fourth := suite.createTicket("carol", suite.release.TicketTypes[0])
+
+	_, _, rerr := suite.service.CreatePayment("alice", int(first.ID), "early")
+	suite.Require().Nil(rerr)
+
+	// Paying for the same ticket again does not use the code twice
+	_, _, rerr = suite.service.CreatePayment("alice", int(first.ID), "early")
+	suite.Require().Nil(rerr)
+
+	_, _, rerr = suite.service.CreatePayment("alice", int(second.ID), "early")
+	suite.Require().NotNil(rerr)
+	suite.Equal("You can use the discount code EARLY at most 1 times", rerr.Message)
+
+	_, _, rerr = suite.service.CreatePayment("bob", int(third.ID), "early")
+	suite.Require().Nil(rerr)
+
+	_, _, rerr = suite.service.CreatePayment("carol", int(fourth.ID), "early")
+	suite.Require().NotNil(rerr)
+	suite.Equal("The discount code has been used the maximum number of times", rerr.Message)
+
+	discountCodes, rerr := suite.discounts.ListDiscountCodes(int(suite.event.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().Len(discountCodes, 1)
+	suite.Equal(2, discountCodes[0].Redemptions)
+}
+
+func (suite *DiscountCodeTestSuite) TestValidityWindow() {
+	from := time.Now().Add(time.Hour)
+	suite.createDiscountCode(types.DiscountCodeRequest{Code: "LATER", DiscountType: models.DiscountFixed, Amount: 50, ValidFrom: &from})
+
+	until := time.Now().Add(-time.Hour)
+	suite.createDiscountCode(types.DiscountCodeRequest{Code: "OVER", DiscountType: models.DiscountFixed, Amount: 50, ValidUntil: &until})
+
+	ticket := suite.createTicket("buyer", suite.release.TicketTypes[0])
+
+	_, _, rerr := suite.service.CreatePayment("buyer", int(ticket.ID), "LATER")
+	suite.Require().NotNil(rerr)
+	suite.Equal("The discount code is not valid yet", rerr.Message)
+
+	_, _, rerr = suite.service.CreatePayment("buyer", int(ticket.ID), "OVER")
+	suite.Require().NotNil(rerr)
+	suite.Equal("The discount code has expired", rerr.Message)
+
+	_, rerr = suite.discounts.CreateDiscountCode(int(suite.event.ID), &types.DiscountCodeRequest{Code: "later", DiscountType: models.DiscountPercent, Amount: 10})
+	suite.Require().NotNil(rerr)
+}
+
+func (suite *DiscountCodeTestSuite) TestOrderDiscountsMatchingTickets() {
+	suite.createDiscountCode(types.DiscountCodeRequest{Code: "ALUMNI", DiscountType: models.DiscountFixed, Amount: 100,
+		TicketTypeID: &suite.release.TicketTypes[1].ID})
+
+	student := suite.createTicket("buyer", suite.release.TicketTypes[0])
+	alumni := suite.createTicket("buyer", suite.release.TicketTypes[1])
+
+	_, _, rerr := suite.service.CreatePayment("buyer", int(student.ID), "ALUMNI")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Invalid discount code", rerr.Message)
+
+	order, payment, rerr := suite.service.CreateOrder("buyer", []int{int(student.ID), int(alumni.ID)}, "alumni")
+	suite.Require().Nil(rerr)
+	suite.Equal(40000, payment.Amount)
+	suite.Equal(10000, order.DiscountAmount)
+
+	for _, item := range order.Items {
+		if item.TicketID == alumni.ID {
+			suite.Equal(10000, item.DiscountAmount)
+			suite.Equal(20000, item.Amount)
+		} else {
+			suite.Nil(item.DiscountCodeID)
+			suite.Equal(20000, item.Amount)
+		}
+	}
+}
+
+func TestDiscountCodeTestSuite(t *testing.T) {
+	suite.Run(t, new(DiscountCodeTestSuite))
+}
@@ -0,0 +1,97 @@
+package test_service
+
+import (
+	"testing"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrawLotteryIsReproducible(t *testing.T) {
+	ids := []uint{5, 3, 9, 1, 7, 2, 8, 4, 6, 10}
+	reordered := []uint{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	first := services.DrawLottery(42, ids)
+	second := services.DrawLottery(42, reordered)
+
+	assert.Equal(t, first, second)
+	assert.ElementsMatch(t, ids, first)
+
+	// The input should not be modified
+	assert.Equal(t, []uint{5, 3, 9, 1, 7, 2, 8, 4, 6, 10}, ids)
+}
+
+func TestNewLotteryDrawHashesSortedIDs(t *testing.T) {
+	draw := models.NewLotteryDraw(1, 42, []uint{3, 1, 2}, 2)
+
+	assert.Equal(t, models.HashTicketRequestIDs([]uint{1, 2, 3}), draw.RequestIDsHash)
+	assert.NotEqual(t, models.HashTicketRequestIDs([]uint{3, 1, 2}), draw.RequestIDsHash)
+	assert.Equal(t, 3, draw.NumberOfParticipants)
+
+	eligible, err := draw.GetEligibleRequestIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{1, 2, 3}, eligible)
+}
+
+func TestTieredLotteryDrawsHigherWeightsFirst(t *testing.T) {
+	ids := []uint{1, 2, 3, 4, 5, 6}
+	weights := []int{1, 3, 1, 2, 3, 1}
+
+	drawn := services.DrawLotteryWithPriority(42, models.LOTTERY_PRIORITY_TIERED, ids, weights)
+
+	assert.ElementsMatch(t, ids, drawn)
+	assert.ElementsMatch(t, []uint{2, 5}, drawn[:2])
+	assert.Equal(t, uint(4), drawn[2])
+	assert.ElementsMatch(t, []uint{1, 3, 6}, drawn[3:])
+}
+
+func TestWeightedLotteryIsReproducible(t *testing.T) {
+	ids := []uint{1, 2, 3, 4, 5}
+	weights := []int{1, 5, 1, 2, 1}
+
+	first := services.DrawLotteryWithPriority(7, models.LOTTERY_PRIORITY_WEIGHTED, ids, weights)
+	second := services.DrawLotteryWithPriority(7, models.LOTTERY_PRIORITY_WEIGHTED, ids, weights)
+
+	assert.Equal(t, first, second)
+	assert.ElementsMatch(t, ids, first)
+}
+
+func TestFCFSLotteryConfigValidatesPriority(t *testing.T) {
+	config := models.FCFSLotteryConfig{OpenWindowDuration: 3600, PriorityMode: "UNKNOWN"}
+	assert.Error(t, config.Validate())
+
+	config = models.FCFSLotteryConfig{
+		OpenWindowDuration:     3600,
+		PriorityMode:           models.LOTTERY_PRIORITY_WEIGHTED,
+		MemberLotteryWeight:    models.MaxLotteryWeight + 1,
+		InternalLotteryWeight:  1,
+		PromoCodeLotteryWeight: 1,
+	}
+	assert.Error(t, config.Validate())
+
+	config.MemberLotteryWeight = 3
+	assert.NoError(t, config.Validate())
+}
+
+func TestWeightedLotteryCapsWeights(t *testing.T) {
+	ids := []uint{1, 2, 3}
+
+	// A weight far above the maximum is drawn as the maximum instead of getting a lottery ticket per unit
+	huge := services.DrawLotteryWithPriority(7, models.LOTTERY_PRIORITY_WEIGHTED, ids, []int{1, 1 << 40, 1})
+	capped := services.DrawLotteryWithPriority(7, models.LOTTERY_PRIORITY_WEIGHTED, ids, []int{1, models.MaxLotteryWeight, 1})
+
+	assert.Equal(t, capped, huge)
+}
+
+func TestFCFSLotteryMethodValidatesWeights(t *testing.T) {
+	detail := models.TicketReleaseMethodDetail{
+		OpenWindowDuration:  3600,
+		LotteryPriorityMode: models.LOTTERY_PRIORITY_WEIGHTED,
+		MemberLotteryWeight: models.MaxLotteryWeight + 1,
+	}
+	assert.Error(t, services.ValidateTicketReleaseMethod(string(models.FCFS_LOTTERY), &detail))
+
+	detail.MemberLotteryWeight = models.MaxLotteryWeight
+	assert.NoError(t, services.ValidateTicketReleaseMethod(string(models.FCFS_LOTTERY), &detail))
+}
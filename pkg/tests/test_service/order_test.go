@@ -0,0 +1,215 @@
+package test_service
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type OrderTestSuite struct {
+	suite.Suite
+	db         *gorm.DB
+	service    *services.PaymentService
+	stripe     *testutils.FakePaymentProvider
+	previous   payment_provider.PaymentProvider
+	dinner     []models.Ticket
+	afterparty []models.Ticket
+}
+
+func (suite *OrderTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewPaymentService(db)
+
+	suite.stripe = testutils.NewFakePaymentProvider(models.STRIPE)
+	suite.previous = payment_provider.Register(suite.stripe)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "buyer", Username: "buyer", Email: "buyer@kth.se"}).Error)
+
+	suite.dinner = suite.createTickets("Dinner", 100, 2)
+	suite.afterparty = suite.createTickets("Afterparty", 50, 1)
+}
+
+func (suite *OrderTestSuite) TearDownTest() {
+	payment_provider.Register(suite.previous)
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *OrderTestSuite) createTickets(eventName string, price float64, amount int) []models.Ticket {
+	event := models.Event{Name: eventName, Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(suite.db.Create(&event).Error)
+
+	tr := models.TicketRelease{
+		EventID:          int(event.ID),
+		TicketsAvailable: 10,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: price, EventID: event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(suite.db.Create(&tr).Error)
+
+	request := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID, TicketAmount: amount, UserUGKthID: "buyer", IsHandled: true}
+	suite.Require().NoError(suite.db.Create(&request).Error)
+
+	deadline := time.Now().Add(24 * time.Hour)
+	var tickets []models.Ticket
+	for i := 0; i < amount; i++ {
+		ticket := models.Ticket{TicketRequestID: request.ID, UserUGKthID: "buyer", QrCode: fmt.Sprintf("%s-%d", eventName, i), PaymentDeadline: &deadline}
+		suite.Require().NoError(suite.db.Create(&ticket).Error)
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets
+}
+
+func (suite *OrderTestSuite) getOrder(id uint) models.Order {
+	var order models.Order
+	suite.Require().NoError(suite.db.First(&order, id).Error)
+	return order
+}
+
+func (suite *OrderTestSuite) TestCheckoutPaysAllTickets() {
+	order, payment, rerr := suite.service.CreateOrder("buyer", []int{int(suite.dinner[1].ID), int(suite.afterparty[0].ID)}, "")
+	suite.Require().Nil(rerr)
+	suite.Equal(25000, order.Amount)
+	suite.Equal(25000, payment.Amount)
+	suite.Len(order.Items, 2)
+
+	// Each ticket request gets its own purchase, paid with the payment of the order
+	var purchases []models.Transaction
+	suite.Require().NoError(suite.db.Where("payment_intent_id = ? AND transaction_type = ?", payment.ID, models.TypePurchase).
+		Order("amount DESC").Find(&purchases).Error)
+	suite.Require().Len(purchases, 2)
+	suite.Equal(int(suite.dinner[0].ID), purchases[0].TicketID)
+	suite.Equal(20000, purchases[0].Amount)
+	suite.Equal(5000, purchases[1].Amount)
+	suite.Equal(order.ID, *purchases[1].OrderID)
+
+	// Checking out the same tickets again gives the same order
+	again, againPayment, rerr := suite.service.CreateOrder("buyer", []int{int(suite.afterparty[0].ID), int(suite.dinner[0].ID)}, "")
+	suite.Require().Nil(rerr)
+	suite.Equal(order.ID, again.ID)
+	suite.Equal(payment.ID, againPayment.ID)
+
+	callback, payload := suite.stripe.Callback(payment_provider.Callback{
+		ID:        "evt_order_paid",
+		Type:      payment_provider.CallbackPaymentSucceeded,
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+	})
+	_, rerr = suite.service.HandleCallback(models.STRIPE, callback, payload)
+	suite.Require().Nil(rerr)
+
+	suite.Equal(models.OrderStatusPaid, suite.getOrder(order.ID).Status)
+
+	var unpaid int64
+	suite.Require().NoError(suite.db.Model(&models.Ticket{}).Where("is_paid = ?", false).Count(&unpaid).Error)
+	suite.Equal(int64(0), unpaid)
+
+	var completed int64
+	suite.Require().NoError(suite.db.Model(&models.Transaction{}).
+		Where("payment_intent_id = ? AND status = ?", payment.ID, models.TransactionStatusCompleted).Count(&completed).Error)
+	suite.Equal(int64(2), completed)
+
+	// The purchases of the order are refunded on their own
+	refund, err := services.RefundPayment(suite.db, &purchases[1], -1, "refund-afterparty")
+	suite.Require().NoError(err)
+	suite.Equal(5000, refund.Amount)
+
+	refunded, err := models.GetPurchaseRefundedAmount(suite.db, &purchases[0])
+	suite.Require().NoError(err)
+	suite.Equal(0, refunded)
+}
+
+func (suite *OrderTestSuite) TestCancelOneRequestOfDiscountedOrder() {
+	// Half is refunded a month before the events
+	suite.Require().NoError(suite.db.Model(&models.TicketReleaseMethodDetail{}).Where("1 = 1").Updates(map[string]interface{}{
+		"cancellation_policy": models.TIERED_REFUND,
+		"refund_tiers":        `[{"days_before_event":60,"percentage":100},{"days_before_event":7,"percentage":50}]`,
+	}).Error)
+
+	// A quarter off the dinner tickets, the afterparty is paid in full
+	var dinner models.TicketRequest
+	suite.Require().NoError(suite.db.Preload("TicketRelease").First(&dinner, suite.dinner[0].TicketRequestID).Error)
+	suite.Require().NoError(suite.db.Create(&models.DiscountCode{
+		EventID: dinner.TicketRelease.EventID, Code: "QUARTER", DiscountType: models.DiscountPercent, Amount: 25,
+	}).Error)
+
+	order, payment, rerr := suite.service.CreateOrder("buyer", []int{int(suite.dinner[0].ID), int(suite.afterparty[0].ID)}, "QUARTER")
+	suite.Require().Nil(rerr)
+	suite.Equal(20000, order.Amount)
+	suite.Equal(5000, order.DiscountAmount)
+
+	callback, payload := suite.stripe.Callback(payment_provider.Callback{
+		ID:        "evt_discounted_order_paid",
+		Type:      payment_provider.CallbackPaymentSucceeded,
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+	})
+	_, rerr = suite.service.HandleCallback(models.STRIPE, callback, payload)
+	suite.Require().Nil(rerr)
+
+	// Half of the 150 SEK paid for the dinner, the afterparty's money and the discount stay with their requests
+	service := services.NewTicketService(suite.db)
+	suite.Require().Nil(service.CancelTicket("buyer", int(suite.dinner[0].ID)))
+	suite.Require().Len(suite.stripe.Refunds, 1)
+	suite.Equal(7500, suite.stripe.Refunds[0].Amount)
+
+	suite.Require().Nil(service.CancelTicket("buyer", int(suite.afterparty[0].ID)))
+	suite.Require().Len(suite.stripe.Refunds, 2)
+	suite.Equal(2500, suite.stripe.Refunds[1].Amount)
+
+	refunded, err := models.GetRefundedAmount(suite.db, payment.ID)
+	suite.Require().NoError(err)
+	suite.Equal(10000, refunded)
+}
+
+func (suite *OrderTestSuite) TestCheckoutReplacesUnfinishedPayments() {
+	single, _, rerr := suite.service.CreatePayment("buyer", int(suite.dinner[0].ID), "")
+	suite.Require().Nil(rerr)
+
+	order, payment, rerr := suite.service.CreateOrder("buyer", []int{int(suite.dinner[0].ID), int(suite.afterparty[0].ID)}, "")
+	suite.Require().Nil(rerr)
+
+	// The payment of the dinner tickets alone can no longer be completed
+	suite.Equal(payment_provider.PaymentCanceled, suite.stripe.Payments[single.ID].Status)
+
+	// Paying for the afterparty on its own cancels the order
+	_, _, rerr = suite.service.CreatePayment("buyer", int(suite.afterparty[0].ID), "")
+	suite.Require().Nil(rerr)
+
+	suite.Equal(payment_provider.PaymentCanceled, suite.stripe.Payments[payment.ID].Status)
+	suite.Equal(models.OrderStatusCanceled, suite.getOrder(order.ID).Status)
+
+	var dinnerPurchase models.Transaction
+	suite.Require().NoError(suite.db.Where("ticket_id = ? AND transaction_type = ?", suite.dinner[0].ID, models.TypePurchase).
+		First(&dinnerPurchase).Error)
+	suite.Equal(models.TransactionStatusCanceled, dinnerPurchase.Status)
+}
+
+func (suite *OrderTestSuite) TestCheckoutRejectsPaidTickets() {
+	suite.Require().NoError(suite.db.Model(&models.Ticket{}).Where("id = ?", suite.afterparty[0].ID).Update("is_paid", true).Error)
+
+	_, _, rerr := suite.service.CreateOrder("buyer", []int{int(suite.dinner[0].ID), int(suite.afterparty[0].ID)}, "")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Ticket is already paid for", rerr.Message)
+
+	_, _, rerr = suite.service.CreateOrder("buyer", nil, "")
+	suite.Require().NotNil(rerr)
+}
+
+func TestOrderTestSuite(t *testing.T) {
+	suite.Run(t, new(OrderTestSuite))
+}
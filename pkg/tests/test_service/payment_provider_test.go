@@ -0,0 +1,177 @@
+package test_service
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type PaymentProviderTestSuite struct {
+	suite.Suite
+	db       *gorm.DB
+	service  *services.PaymentService
+	swish    *testutils.FakePaymentProvider
+	previous payment_provider.PaymentProvider
+	org      models.Organization
+	release  models.TicketRelease
+	tickets  []models.Ticket
+}
+
+func (suite *PaymentProviderTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewPaymentService(db)
+
+	suite.swish = testutils.NewFakePaymentProvider(models.SWISH)
+	suite.previous = payment_provider.Register(suite.swish)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "payer", Username: "payer", Email: "payer@kth.se"}).Error)
+
+	suite.org = models.Organization{Name: "Swish Org", Email: "org@kth.se"}
+	suite.Require().NoError(db.Create(&suite.org).Error)
+
+	event := models.Event{Name: "Swish", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: int(suite.org.ID)}
+	suite.Require().NoError(db.Create(&event).Error)
+
+	suite.release = models.TicketRelease{
+		EventID:          int(event.ID),
+		TicketsAvailable: 10,
+		PaymentProvider:  models.SWISH,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&suite.release).Error)
+
+	request := models.TicketRequest{TicketReleaseID: suite.release.ID, TicketTypeID: suite.release.TicketTypes[0].ID, TicketAmount: 2, UserUGKthID: "payer", IsHandled: true}
+	suite.Require().NoError(db.Create(&request).Error)
+
+	deadline := time.Now().Add(24 * time.Hour)
+	suite.tickets = nil
+	for _, qrCode := range []string{"swish-1", "swish-2"} {
+		ticket := models.Ticket{TicketRequestID: request.ID, UserUGKthID: "payer", QrCode: qrCode, PaymentDeadline: &deadline}
+		suite.Require().NoError(db.Create(&ticket).Error)
+		suite.tickets = append(suite.tickets, ticket)
+	}
+}
+
+func (suite *PaymentProviderTestSuite) TearDownTest() {
+	payment_provider.Register(suite.previous)
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *PaymentProviderTestSuite) getPurchase(paymentID string) models.Transaction {
+	var purchase models.Transaction
+	suite.Require().NoError(suite.db.Where("payment_intent_id = ? AND transaction_type = ?", paymentID, models.TypePurchase).
+		First(&purchase).Error)
+	return purchase
+}
+
+func (suite *PaymentProviderTestSuite) TestProviderResolution() {
+	provider, err := models.GetPaymentProviderForTicketRelease(suite.db, &suite.release)
+	suite.Require().NoError(err)
+	suite.Equal(models.SWISH, provider)
+
+	// Without a provider on the ticket release the organization's provider is used
+	suite.release.PaymentProvider = ""
+	provider, err = models.GetPaymentProviderForTicketRelease(suite.db, &suite.release)
+	suite.Require().NoError(err)
+	suite.Equal(models.DefaultPaymentProvider, provider)
+
+	suite.Require().NoError(suite.db.Model(&suite.org).Update("payment_provider", models.SWISH).Error)
+	provider, err = models.GetPaymentProviderForTicketRelease(suite.db, &suite.release)
+	suite.Require().NoError(err)
+	suite.Equal(models.SWISH, provider)
+
+	suite.Error(models.ValidatePaymentProvider("paypal"))
+}
+
+func (suite *PaymentProviderTestSuite) TestCreatePayment() {
+	payment, providerName, rerr := suite.service.CreatePayment("payer", int(suite.tickets[1].ID), "")
+	suite.Require().Nil(rerr)
+	suite.Equal(models.SWISH, providerName)
+	suite.Equal(20000, payment.Amount)
+	suite.NotEmpty(payment.ClientSecret)
+
+	// The payment is for both tickets of the request, through the first ticket
+	purchase := suite.getPurchase(payment.ID)
+	suite.Equal(int(suite.tickets[0].ID), purchase.TicketID)
+	suite.Equal(models.SWISH, purchase.Provider())
+	suite.Equal(models.TransactionStatusPending, purchase.Status)
+
+	// An unfinished payment is reused
+	again, _, rerr := suite.service.CreatePayment("payer", int(suite.tickets[0].ID), "")
+	suite.Require().Nil(rerr)
+	suite.Equal(payment.ID, again.ID)
+
+	// A declined payment is replaced by a new one
+	suite.swish.SetPaymentStatus(payment.ID, payment_provider.PaymentFailed)
+	retry, _, rerr := suite.service.CreatePayment("payer", int(suite.tickets[0].ID), "")
+	suite.Require().Nil(rerr)
+	suite.NotEqual(payment.ID, retry.ID)
+}
+
+func (suite *PaymentProviderTestSuite) TestCallbackAndRefund() {
+	payment, _, rerr := suite.service.CreatePayment("payer", int(suite.tickets[0].ID), "")
+	suite.Require().Nil(rerr)
+
+	callback, payload := suite.swish.Callback(payment_provider.Callback{
+		ID:        "swish-paid",
+		Type:      payment_provider.CallbackPaymentSucceeded,
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+	})
+
+	alreadyProcessed, rerr := suite.service.HandleCallback(models.SWISH, callback, payload)
+	suite.Require().Nil(rerr)
+	suite.False(alreadyProcessed)
+
+	alreadyProcessed, rerr = suite.service.HandleCallback(models.SWISH, callback, payload)
+	suite.Require().Nil(rerr)
+	suite.True(alreadyProcessed)
+
+	purchase := suite.getPurchase(payment.ID)
+	suite.Equal(models.TransactionStatusCompleted, purchase.Status)
+
+	var tickets []models.Ticket
+	suite.Require().NoError(suite.db.Where("id IN ?", []uint{suite.tickets[0].ID, suite.tickets[1].ID}).Find(&tickets).Error)
+	for _, ticket := range tickets {
+		suite.True(ticket.IsPaid)
+	}
+
+	// Swish refunds are completed through a callback
+	suite.swish.RefundStatus = payment_provider.RefundPending
+	refund, err := services.RefundPayment(suite.db, &purchase, -1, "refund-swish")
+	suite.Require().NoError(err)
+	suite.Equal(models.TransactionStatusPending, refund.Status)
+	suite.Equal(models.SWISH, refund.Provider())
+	suite.Len(suite.swish.Refunds, 1)
+
+	callback, payload = suite.swish.Callback(payment_provider.Callback{
+		ID:       "swish-refund-error",
+		Type:     payment_provider.CallbackRefundFailed,
+		RefundID: *refund.RefundID,
+	})
+	_, rerr = suite.service.HandleCallback(models.SWISH, callback, payload)
+	suite.Require().Nil(rerr)
+
+	refunded, err := models.GetRefundedAmount(suite.db, payment.ID)
+	suite.Require().NoError(err)
+	suite.Equal(0, refunded)
+	suite.False(suite.getPurchase(payment.ID).Refunded)
+}
+
+func TestPaymentProviderTestSuite(t *testing.T) {
+	suite.Run(t, new(PaymentProviderTestSuite))
+}
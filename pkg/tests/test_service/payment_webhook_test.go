@@ -0,0 +1,318 @@
+package test_service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+)
+
+type PaymentWebhookTestSuite struct {
+	suite.Suite
+	db      *gorm.DB
+	service *services.PaymentService
+	event   models.Event
+	request models.TicketRequest
+	tickets []models.Ticket
+	payment models.Transaction
+}
+
+func (suite *PaymentWebhookTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewPaymentService(db)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "payer", Username: "payer", Email: "payer@kth.se"}).Error)
+
+	suite.event = models.Event{Name: "Webhook", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&suite.event).Error)
+
+	tr := models.TicketRelease{
+		EventID:          int(suite.event.ID),
+		TicketsAvailable: 10,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: 100, EventID: suite.event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&tr).Error)
+
+	suite.request = models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID, TicketAmount: 2, UserUGKthID: "payer", IsHandled: true}
+	suite.Require().NoError(db.Create(&suite.request).Error)
+
+	deadline := time.Now().Add(24 * time.Hour)
+	suite.tickets = nil
+	for _, qrCode := range []string{"webhook-1", "webhook-2"} {
+		ticket := models.Ticket{TicketRequestID: suite.request.ID, UserUGKthID: "payer", QrCode: qrCode, PaymentDeadline: &deadline}
+		suite.Require().NoError(db.Create(&ticket).Error)
+		suite.tickets = append(suite.tickets, ticket)
+	}
+
+	suite.payment = models.Transaction{
+		PaymentIntentID: "pi_webhook",
+		EventID:         int(suite.event.ID),
+		TicketID:        int(suite.tickets[0].ID),
+		UserUGKthID:     "payer",
+		Amount:          20000,
+		Currency:        "sek",
+		Status:          models.TransactionStatusPending,
+		TransactionType: models.TypePurchase,
+	}
+	suite.Require().NoError(db.Create(&suite.payment).Error)
+}
+
+func (suite *PaymentWebhookTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *PaymentWebhookTestSuite) paymentIntentEvent(id, eventType, status, failureMessage string) *stripe.Event {
+	raw := fmt.Sprintf(`{"id":"pi_webhook","object":"payment_intent","amount":20000,"currency":"sek","status":%q,`+
+		`"last_payment_error":{"message":%q},"metadata":{"tessera_ticket_id":"%d"}}`,
+		status, failureMessage, suite.tickets[0].ID)
+
+	return &stripe.Event{ID: id, Type: eventType, Data: &stripe.EventData{Raw: []byte(raw)}}
+}
+
+func disputeEvent(id, eventType, status string) *stripe.Event {
+	raw := fmt.Sprintf(`{"id":"dp_webhook","object":"dispute","payment_intent":"pi_webhook","amount":20000,"currency":"sek","reason":"fraudulent","status":%q}`,
+		status)
+
+	return &stripe.Event{ID: id, Type: eventType, Data: &stripe.EventData{Raw: []byte(raw)}}
+}
+
+func (suite *PaymentWebhookTestSuite) handle(event *stripe.Event) bool {
+	alreadyProcessed, rerr := suite.service.HandleWebhookEvent(event, nil)
+	suite.Require().Nil(rerr)
+	return alreadyProcessed
+}
+
+func (suite *PaymentWebhookTestSuite) getPayment() models.Transaction {
+	var payment models.Transaction
+	suite.Require().NoError(suite.db.First(&payment, suite.payment.ID).Error)
+	return payment
+}
+
+func (suite *PaymentWebhookTestSuite) TestFailedPaymentCanBeRetried() {
+	suite.False(suite.handle(suite.paymentIntentEvent("evt_failed", "payment_intent.payment_failed", "requires_payment_method", "Your card was declined.")))
+	suite.True(suite.handle(suite.paymentIntentEvent("evt_failed", "payment_intent.payment_failed", "requires_payment_method", "Your card was declined.")))
+
+	payment := suite.getPayment()
+	suite.Equal(models.TransactionStatusFailed, payment.Status)
+	suite.Equal("Your card was declined.", payment.FailureMessage)
+
+	// The user pays with another card before the deadline
+	suite.False(suite.handle(suite.paymentIntentEvent("evt_succeeded", "payment_intent.succeeded", "succeeded", "")))
+
+	payment = suite.getPayment()
+	suite.Equal(models.TransactionStatusCompleted, payment.Status)
+	suite.Empty(payment.FailureMessage)
+
+	// A late cancellation does not undo the payment
+	suite.False(suite.handle(suite.paymentIntentEvent("evt_canceled", "payment_intent.canceled", "canceled", "")))
+	suite.Equal(models.TransactionStatusCompleted, suite.getPayment().Status)
+
+	var ticket models.Ticket
+	suite.Require().NoError(suite.db.First(&ticket, suite.tickets[1].ID).Error)
+	suite.True(ticket.IsPaid)
+}
+
+func (suite *PaymentWebhookTestSuite) TestCanceledPayment() {
+	suite.False(suite.handle(suite.paymentIntentEvent("evt_canceled", "payment_intent.canceled", "canceled", "")))
+	suite.Equal(models.TransactionStatusCanceled, suite.getPayment().Status)
+}
+
+func (suite *PaymentWebhookTestSuite) TestLostDispute() {
+	suite.False(suite.handle(suite.paymentIntentEvent("evt_succeeded", "payment_intent.succeeded", "succeeded", "")))
+
+	suite.False(suite.handle(disputeEvent("evt_dispute_created", "charge.dispute.created", "needs_response")))
+
+	payment := suite.getPayment()
+	suite.Require().NotNil(payment.DisputeID)
+	suite.Equal("dp_webhook", *payment.DisputeID)
+	suite.Equal("needs_response", payment.DisputeStatus)
+
+	var tickets []models.Ticket
+	suite.Require().NoError(suite.db.Where("ticket_request_id = ?", suite.request.ID).Find(&tickets).Error)
+	suite.Len(tickets, 2)
+	for _, ticket := range tickets {
+		suite.True(ticket.Disputed)
+	}
+
+	suite.False(suite.handle(disputeEvent("evt_dispute_closed", "charge.dispute.closed", "lost")))
+	suite.True(suite.handle(disputeEvent("evt_dispute_closed", "charge.dispute.closed", "lost")))
+	// Stripe can send a closed dispute in a new event, the money is only taken back once
+	suite.False(suite.handle(disputeEvent("evt_dispute_closed_again", "charge.dispute.closed", "lost")))
+
+	suite.Equal("lost", suite.getPayment().DisputeStatus)
+
+	income, err := models.GetEventTotalIncome(suite.db, int(suite.event.ID))
+	suite.Require().NoError(err)
+	suite.Equal(float64(0), income)
+
+	var count int64
+	suite.db.Model(&models.Ticket{}).Where("ticket_request_id = ?", suite.request.ID).Count(&count)
+	suite.Equal(int64(0), count)
+}
+
+func (suite *PaymentWebhookTestSuite) TestWonDispute() {
+	suite.False(suite.handle(suite.paymentIntentEvent("evt_succeeded", "payment_intent.succeeded", "succeeded", "")))
+	suite.False(suite.handle(disputeEvent("evt_dispute_created", "charge.dispute.created", "needs_response")))
+	suite.False(suite.handle(disputeEvent("evt_dispute_closed", "charge.dispute.closed", "won")))
+
+	var tickets []models.Ticket
+	suite.Require().NoError(suite.db.Where("ticket_request_id = ?", suite.request.ID).Find(&tickets).Error)
+	suite.Len(tickets, 2)
+	for _, ticket := range tickets {
+		suite.False(ticket.Disputed)
+		suite.True(ticket.IsPaid)
+	}
+
+	income, err := models.GetEventTotalIncome(suite.db, int(suite.event.ID))
+	suite.Require().NoError(err)
+	suite.Equal(float64(20000), income)
+}
+
+// A payment intent created for a user that does not exist yet fails until the user is created
+func (suite *PaymentWebhookTestSuite) handleFailingEvent() *models.WebhookEvent {
+	payload := []byte(fmt.Sprintf(`{"id":"evt_created","object":"event","type":"payment_intent.created","data":{"object":`+
+		`{"id":"pi_late","object":"payment_intent","amount":10000,"currency":"sek","metadata":`+
+		`{"tessera_user_id":"late","tessera_ticket_id":"%d","tessera_event_id":"%d"}}}}`, suite.tickets[0].ID, suite.event.ID))
+
+	var event stripe.Event
+	suite.Require().NoError(json.Unmarshal(payload, &event))
+
+	_, rerr := suite.service.HandleWebhookEvent(&event, payload)
+	suite.Require().NotNil(rerr)
+
+	var webhookEvent models.WebhookEvent
+	suite.Require().NoError(suite.db.Where("stripe_id = ?", "evt_created").First(&webhookEvent).Error)
+	return &webhookEvent
+}
+
+func (suite *PaymentWebhookTestSuite) TestReplayFailedEvent() {
+	webhookEvent := suite.handleFailingEvent()
+	suite.Equal(1, webhookEvent.Attempts)
+	suite.NotEmpty(webhookEvent.LastError)
+	suite.NotEmpty(webhookEvent.Payload)
+	suite.Require().NotNil(webhookEvent.NextRetryAt)
+	suite.WithinDuration(time.Now().Add(5*time.Minute), *webhookEvent.NextRetryAt, time.Minute)
+
+	events, rerr := suite.service.ListWebhookEvents(models.WebhookEventStatusFailed)
+	suite.Require().Nil(rerr)
+	suite.Require().Len(events, 1)
+	suite.Empty(events[0].Payload)
+
+	// The event is not retried before the backoff has passed
+	suite.Require().NoError(suite.service.RetryFailedWebhookEvents())
+	webhookEvent, rerr = suite.service.GetWebhookEvent(webhookEvent.ID)
+	suite.Require().Nil(rerr)
+	suite.Equal(1, webhookEvent.Attempts)
+
+	suite.Require().NoError(suite.db.Model(webhookEvent).Update("next_retry_at", time.Now().Add(-time.Second)).Error)
+	suite.Require().NoError(suite.service.RetryFailedWebhookEvents())
+	webhookEvent, rerr = suite.service.GetWebhookEvent(webhookEvent.ID)
+	suite.Require().Nil(rerr)
+	suite.Equal(2, webhookEvent.Attempts)
+	suite.Require().NotNil(webhookEvent.NextRetryAt)
+	suite.WithinDuration(time.Now().Add(10*time.Minute), *webhookEvent.NextRetryAt, time.Minute)
+
+	// Once the problem is fixed an admin replays the failed events
+	suite.Require().NoError(suite.db.Create(&models.User{UGKthID: "late", Username: "late", Email: "late@kth.se"}).Error)
+	replayed, rerr := suite.service.ReplayWebhookEvents(nil)
+	suite.Require().Nil(rerr)
+	suite.Require().Len(replayed, 1)
+	suite.True(replayed[0].Processed)
+	suite.Nil(replayed[0].NextRetryAt)
+
+	var count int64
+	suite.db.Model(&models.Transaction{}).Where("payment_intent_id = ?", "pi_late").Count(&count)
+	suite.Equal(int64(1), count)
+
+	_, rerr = suite.service.ReplayWebhookEvent(webhookEvent.ID)
+	suite.Require().NotNil(rerr)
+}
+
+func (suite *PaymentWebhookTestSuite) TestEventIsClaimedBeforeItIsProcessed() {
+	webhookEvent := suite.handleFailingEvent()
+	suite.Require().NoError(suite.db.Create(&models.User{UGKthID: "late", Username: "late", Email: "late@kth.se"}).Error)
+
+	var stored models.WebhookEvent
+	suite.Require().NoError(suite.db.First(&stored, webhookEvent.ID).Error)
+
+	// Stripe delivers the event again while the retry of it is running
+	var replayed *models.WebhookEvent
+	suite.Require().NoError(suite.db.Callback().Query().After("gorm:query").Register("test:retry_during_delivery", func(tx *gorm.DB) {
+		if tx.Statement.Table != "webhook_events" || replayed != nil {
+			return
+		}
+		replayed = &models.WebhookEvent{} // The replay reads the event too
+
+		var rerr *types.ErrorResponse
+		replayed, rerr = suite.service.ReplayWebhookEvent(webhookEvent.ID)
+		suite.Require().Nil(rerr)
+	}))
+	defer suite.db.Callback().Query().Remove("test:retry_during_delivery")
+
+	alreadyProcessed, rerr := suite.service.HandleWebhookEvent(&stripe.Event{ID: "evt_created"}, []byte(stored.Payload))
+	suite.False(alreadyProcessed)
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusConflict, rerr.StatusCode)
+
+	suite.Require().NotNil(replayed)
+	suite.True(replayed.Processed)
+	suite.Equal(2, replayed.Attempts)
+
+	var count int64
+	suite.db.Model(&models.Transaction{}).Where("payment_intent_id = ?", "pi_late").Count(&count)
+	suite.Equal(int64(1), count)
+}
+
+func (suite *PaymentWebhookTestSuite) TestUnhandledEventIsNotRetried() {
+	event := &stripe.Event{ID: "evt_customer", Type: "customer.updated", Data: &stripe.EventData{Raw: []byte(`{"id":"cus_webhook","object":"customer"}`)}}
+	suite.False(suite.handle(event))
+	suite.True(suite.handle(event))
+
+	var webhookEvent models.WebhookEvent
+	suite.Require().NoError(suite.db.Where("stripe_id = ?", "evt_customer").First(&webhookEvent).Error)
+	suite.True(webhookEvent.Processed)
+	suite.Empty(webhookEvent.LastError)
+	suite.Nil(webhookEvent.NextRetryAt)
+	suite.False(webhookEvent.DeadLettered)
+}
+
+func (suite *PaymentWebhookTestSuite) TestDeadLetter() {
+	webhookEvent := suite.handleFailingEvent()
+
+	suite.Require().NoError(suite.db.Model(webhookEvent).Updates(map[string]interface{}{
+		"attempts":      5,
+		"next_retry_at": time.Now().Add(-time.Second),
+	}).Error)
+	suite.Require().NoError(suite.service.RetryFailedWebhookEvents())
+
+	events, rerr := suite.service.ListWebhookEvents(models.WebhookEventStatusDeadLetter)
+	suite.Require().Nil(rerr)
+	suite.Require().Len(events, 1)
+	suite.Equal(6, events[0].Attempts)
+	suite.Nil(events[0].NextRetryAt)
+
+	due, err := models.GetWebhookEventsDueForRetry(suite.db, time.Now().Add(time.Hour))
+	suite.Require().NoError(err)
+	suite.Empty(due)
+}
+
+func TestPaymentWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(PaymentWebhookTestSuite))
+}
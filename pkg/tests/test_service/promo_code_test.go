@@ -0,0 +1,230 @@
+package test_service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/utils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type PromoCodeTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	service       *services.PromoCodeService
+	event         models.Event
+	ticketRelease models.TicketRelease
+}
+
+func (suite *PromoCodeTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	os.Setenv("SECRET_KEY", "0123456789abcdef0123456789abcdef")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewPromoCodeService(db, ratelimit.NewAttemptLimiter(ratelimit.NewMemoryAttemptStore(), ratelimit.DefaultLockoutPolicy))
+
+	for _, id := range []string{"alice", "bob", "carol"} {
+		suite.Require().NoError(db.Create(&models.User{UGKthID: id, Username: id, Email: id + "@kth.se"}).Error)
+	}
+
+	suite.event = models.Event{Name: "Sponsor dinner", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&suite.event).Error)
+
+	suite.ticketRelease = models.TicketRelease{EventID: int(suite.event.ID), Name: "Reserved", IsReserved: true, TicketsAvailable: 10}
+	suite.Require().NoError(db.Create(&suite.ticketRelease).Error)
+	suite.Require().NoError(services.SetPrimaryPromoCode(db, &suite.ticketRelease, "MEMBERS"))
+}
+
+func (suite *PromoCodeTestSuite) TearDownTest() {
+	// The join table of the unlocked ticket releases is not dropped with the models
+	suite.db.Exec("DELETE FROM user_unlocked_ticket_releases")
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *PromoCodeTestSuite) createPromoCode(req types.TicketReleasePromoCodeRequest) *models.TicketReleasePromoCode {
+	promoCode, rerr := suite.service.CreatePromoCode(int(suite.event.ID), int(suite.ticketRelease.ID), &req)
+	suite.Require().Nil(rerr)
+	return promoCode
+}
+
+func (suite *PromoCodeTestSuite) TestSeveralCodesUnlockTheRelease() {
+	sponsor := suite.createPromoCode(types.TicketReleasePromoCodeRequest{Code: "SPONSOR", Label: "Sponsor"})
+
+	_, rerr := suite.service.ActivatePromoCode("alice", "10.0.0.1", int(suite.event.ID), "MEMBERS")
+	suite.Require().Nil(rerr)
+	_, rerr = suite.service.ActivatePromoCode("bob", "10.0.0.1", int(suite.event.ID), "SPONSOR")
+	suite.Require().Nil(rerr)
+
+	_, rerr = suite.service.ActivatePromoCode("carol", "10.0.0.1", int(suite.event.ID), "members")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Invalid promo code", rerr.Message)
+
+	var unlocked []string
+	suite.Require().NoError(suite.db.Table("user_unlocked_ticket_releases").
+		Where("ticket_release_id = ?", suite.ticketRelease.ID).Order("user_ug_kth_id").Pluck("user_ug_kth_id", &unlocked).Error)
+	suite.Equal([]string{"alice", "bob"}, unlocked)
+
+	unlocks, rerr := suite.service.ListPromoCodeUnlocks(int(suite.event.ID), int(suite.ticketRelease.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().Len(unlocks, 2)
+
+	usedBy := map[string]uint{}
+	for _, unlock := range unlocks {
+		usedBy[unlock.UserUGKthID] = unlock.PromoCodeID
+	}
+	suite.Equal(sponsor.ID, usedBy["bob"])
+
+	promoCodes, rerr := suite.service.ListPromoCodes(int(suite.event.ID), int(suite.ticketRelease.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().Len(promoCodes, 2)
+	suite.Equal("MEMBERS", promoCodes[0].Code)
+	suite.True(promoCodes[0].IsPrimary)
+	suite.Equal(1, promoCodes[1].Uses)
+
+	// The codes are unique within the event
+	_, rerr = suite.service.CreatePromoCode(int(suite.event.ID), int(suite.ticketRelease.ID), &types.TicketReleasePromoCodeRequest{Code: "SPONSOR"})
+	suite.Require().NotNil(rerr)
+}
+
+func (suite *PromoCodeTestSuite) TestMaxUsesAndExpiry() {
+	maxUses := 1
+	suite.createPromoCode(types.TicketReleasePromoCodeRequest{Code: "ONCE", MaxUses: &maxUses})
+
+	expired := time.Now().Add(-time.Hour)
+	suite.createPromoCode(types.TicketReleasePromoCodeRequest{Code: "OLD", ExpiresAt: &expired})
+
+	_, rerr := suite.service.ActivatePromoCode("alice", "10.0.0.1", int(suite.event.ID), "ONCE")
+	suite.Require().Nil(rerr)
+
+	// Entering the code again does not use it again
+	_, rerr = suite.service.ActivatePromoCode("alice", "10.0.0.1", int(suite.event.ID), "ONCE")
+	suite.Require().Nil(rerr)
+
+	_, rerr = suite.service.ActivatePromoCode("bob", "10.0.0.1", int(suite.event.ID), "ONCE")
+	suite.Require().NotNil(rerr)
+	suite.Equal("The promo code has been used the maximum number of times", rerr.Message)
+
+	_, rerr = suite.service.ActivatePromoCode("bob", "10.0.0.1", int(suite.event.ID), "OLD")
+	suite.Require().NotNil(rerr)
+	suite.Equal("The promo code has expired", rerr.Message)
+}
+
+func (suite *PromoCodeTestSuite) TestMigrateLegacyPromoCode() {
+	encrypted, err := utils.EncryptString("LEGACY")
+	suite.Require().NoError(err)
+
+	legacy := models.TicketRelease{EventID: int(suite.event.ID), Name: "Old reserved", IsReserved: true, PromoCode: &encrypted}
+	suite.Require().NoError(suite.db.Create(&legacy).Error)
+
+	suite.Require().NoError(services.MigrateTicketReleasePromoCodes(suite.db))
+	suite.Require().NoError(services.MigrateTicketReleasePromoCodes(suite.db))
+
+	promoCodes, err := models.GetPromoCodesToTicketRelease(suite.db, legacy.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(promoCodes, 1)
+
+	_, rerr := suite.service.ActivatePromoCode("carol", "10.0.0.1", int(suite.event.ID), "LEGACY")
+	suite.Require().Nil(rerr)
+}
+
+func (suite *PromoCodeTestSuite) TestInvalidCodesLockOut() {
+	eventID := int(suite.event.ID)
+
+	for i := 0; i < ratelimit.DefaultLockoutPolicy.FreeAttempts; i++ {
+		_, rerr := suite.service.ActivatePromoCode("alice", "10.0.0.1", eventID, fmt.Sprintf("GUESS%d", i))
+		suite.Require().NotNil(rerr)
+		suite.Equal("Invalid promo code", rerr.Message)
+	}
+
+	// The failure after the free attempts locks out both the user and the IP address
+	_, rerr := suite.service.ActivatePromoCode("alice", "10.0.0.1", eventID, "GUESS")
+	suite.Require().NotNil(rerr)
+
+	_, rerr = suite.service.ActivatePromoCode("alice", "10.0.0.2", eventID, "MEMBERS")
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusTooManyRequests, rerr.StatusCode)
+
+	_, rerr = suite.service.ActivatePromoCode("bob", "10.0.0.1", eventID, "MEMBERS")
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusTooManyRequests, rerr.StatusCode)
+
+	_, rerr = suite.service.ActivatePromoCode("bob", "10.0.0.2", eventID, "MEMBERS")
+	suite.Require().Nil(rerr)
+
+	stats, rerr := suite.service.GetPromoCodeAttemptStats(eventID)
+	suite.Require().Nil(rerr)
+	suite.Equal(int64(6), stats.FailedAttempts)
+	suite.Equal(int64(2), stats.LockedOutAttempts)
+	suite.Equal(int64(2), stats.Users)
+	suite.Len(stats.RecentAttempts, 8)
+}
+
+func (suite *PromoCodeTestSuite) TestLockoutDoubles() {
+	policy := ratelimit.LockoutPolicy{FreeAttempts: 2, BaseLockout: time.Minute, MaxLockout: 5 * time.Minute, Window: time.Hour}
+	store := ratelimit.NewMemoryAttemptStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	var lockouts []time.Duration
+	for i := 0; i < 6; i++ {
+		state, err := store.RecordFailure(ctx, "user:alice", policy, now)
+		suite.Require().NoError(err)
+		if state.LockedUntil.IsZero() {
+			lockouts = append(lockouts, 0)
+		} else {
+			lockouts = append(lockouts, state.LockedUntil.Sub(now))
+		}
+	}
+	suite.Equal([]time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute}, lockouts)
+
+	// The failures are forgotten after the window
+	state, err := store.RecordFailure(ctx, "user:alice", policy, now.Add(2*time.Hour))
+	suite.Require().NoError(err)
+	suite.Equal(1, state.Failures)
+}
+
+func (suite *PromoCodeTestSuite) TestConcurrentAttemptsAreCounted() {
+	policy := ratelimit.LockoutPolicy{FreeAttempts: 2, BaseLockout: time.Minute, MaxLockout: 5 * time.Minute, Window: time.Hour}
+	limiter := ratelimit.NewAttemptLimiter(ratelimit.NewMemoryAttemptStore(), policy)
+	ctx := context.Background()
+
+	// Guesses sent at the same time can not all get past the lockout before any of them has failed
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter.Attempt(ctx, "user:alice", "ip:10.0.0.1") == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	suite.Equal(int32(policy.FreeAttempts+1), allowed)
+
+	// A successful attempt is not counted against the IP address
+	suite.Require().NoError(limiter.Attempt(ctx, "user:bob", "ip:10.0.0.2"))
+	suite.Require().NoError(limiter.Forgive(ctx, "user:bob", "ip:10.0.0.2"))
+	for i := 0; i < policy.FreeAttempts+1; i++ {
+		suite.Require().NoError(limiter.Attempt(ctx, "user:bob", "ip:10.0.0.2"))
+	}
+	suite.Error(limiter.Attempt(ctx, "user:bob", "ip:10.0.0.2"))
+}
+
+func TestPromoCodeTestSuite(t *testing.T) {
+	suite.Run(t, new(PromoCodeTestSuite))
+}
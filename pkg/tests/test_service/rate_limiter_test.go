@@ -0,0 +1,148 @@
+package test_service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/middleware"
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimiterTestSuite struct {
+	suite.Suite
+	limiter *ratelimit.MemoryLimiter
+}
+
+func (suite *RateLimiterTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.limiter = ratelimit.NewMemoryLimiter()
+}
+
+func (suite *RateLimiterTestSuite) newRouter(group string, limit ratelimit.Limit, key middleware.KeyFunc) *gin.Engine {
+	rlm := middleware.NewRateLimiterMiddleware(suite.limiter, group, limit, key)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("ugkthid", c.GetHeader("X-User"))
+	})
+	r.POST("/events/:eventID/ticket-requests", rlm.MiddlewareFunc(), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	return r
+}
+
+func (suite *RateLimiterTestSuite) request(r *gin.Engine, user string, eventID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/events/"+eventID+"/ticket-requests", nil)
+	req.Header.Set("X-User", user)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *RateLimiterTestSuite) TestLimitsPerUser() {
+	r := suite.newRouter("ticket_requests", ratelimit.Limit{Rate: 0.5, Burst: 2}, middleware.KeyByUser)
+
+	suite.Equal(http.StatusCreated, suite.request(r, "alice", "1").Code)
+	suite.Equal(http.StatusCreated, suite.request(r, "alice", "1").Code)
+
+	w := suite.request(r, "alice", "1")
+	suite.Equal(http.StatusTooManyRequests, w.Code)
+	suite.Equal("2", w.Header().Get("Retry-After"))
+
+	// Other users have their own buckets
+	suite.Equal(http.StatusCreated, suite.request(r, "bob", "1").Code)
+}
+
+func (suite *RateLimiterTestSuite) TestLimitsPerEvent() {
+	r := suite.newRouter("ticket_requests_event", ratelimit.Limit{Rate: 1, Burst: 1}, middleware.KeyByEvent)
+
+	suite.Equal(http.StatusCreated, suite.request(r, "alice", "1").Code)
+	suite.Equal(http.StatusTooManyRequests, suite.request(r, "bob", "1").Code)
+	suite.Equal(http.StatusCreated, suite.request(r, "bob", "2").Code)
+}
+
+func (suite *RateLimiterTestSuite) TestLimitFromEnv() {
+	os.Setenv("RATE_LIMIT_TEST_GROUP", "1:3")
+	defer os.Unsetenv("RATE_LIMIT_TEST_GROUP")
+
+	r := suite.newRouter("test_group", ratelimit.Limit{Rate: 1, Burst: 1}, middleware.KeyByIP)
+	for i := 0; i < 3; i++ {
+		suite.Equal(http.StatusCreated, suite.request(r, "alice", "1").Code)
+	}
+	suite.Equal(http.StatusTooManyRequests, suite.request(r, "alice", "1").Code)
+
+	os.Setenv("RATE_LIMIT_TEST_GROUP", "fast")
+	_, err := ratelimit.LimitFromEnv("test_group", ratelimit.Limit{Rate: 1, Burst: 1})
+	suite.Error(err)
+}
+
+func (suite *RateLimiterTestSuite) TestIdleBucketsAreEvicted() {
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 1, Burst: 5}
+
+	for _, key := range []string{"user:alice", "user:bob"} {
+		result, err := suite.limiter.Allow(ctx, key, limit)
+		suite.Require().NoError(err)
+		suite.True(result.Allowed)
+	}
+	suite.Equal(2, suite.limiter.Len())
+
+	suite.limiter.EvictIdle(time.Now().Add(30 * time.Second))
+	suite.Equal(2, suite.limiter.Len())
+
+	suite.limiter.EvictIdle(time.Now().Add(2 * time.Minute))
+	suite.Equal(0, suite.limiter.Len())
+}
+
+// newIPRouter returns a router configured from the environment that responds with the client IP
+func (suite *RateLimiterTestSuite) newIPRouter() *gin.Engine {
+	r := gin.New()
+	suite.Require().NoError(middleware.SetTrustedProxies(r))
+	r.GET("/ip", func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP())
+	})
+
+	return r
+}
+
+func clientIP(r *gin.Engine, remoteAddr string, header string, value string) string {
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set(header, value)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func (suite *RateLimiterTestSuite) TestForwardedForIsOnlyTrustedFromProxies() {
+	defer os.Unsetenv("TRUSTED_PROXIES")
+	defer os.Unsetenv("TRUSTED_PLATFORM")
+
+	// Anyone can send X-Forwarded-For, so it is ignored unless the proxies are configured
+	os.Setenv("TRUSTED_PROXIES", "")
+	r := suite.newIPRouter()
+	suite.Equal("203.0.113.7", clientIP(r, "203.0.113.7:1234", "X-Forwarded-For", "10.0.0.1"))
+
+	os.Setenv("TRUSTED_PROXIES", "10.1.0.0/16, 10.2.0.1")
+	r = suite.newIPRouter()
+	suite.Equal("198.51.100.4", clientIP(r, "10.1.2.3:1234", "X-Forwarded-For", "198.51.100.4"))
+	suite.Equal("203.0.113.7", clientIP(r, "203.0.113.7:1234", "X-Forwarded-For", "198.51.100.4"))
+
+	os.Setenv("TRUSTED_PLATFORM", "cloudflare")
+	r = suite.newIPRouter()
+	suite.Equal("198.51.100.4", clientIP(r, "10.1.2.3:1234", "CF-Connecting-IP", "198.51.100.4"))
+
+	os.Setenv("TRUSTED_PROXIES", "not-an-ip")
+	suite.Error(middleware.SetTrustedProxies(gin.New()))
+}
+
+func TestRateLimiterTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimiterTestSuite))
+}
@@ -0,0 +1,219 @@
+package test_service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"github.com/stripe/stripe-go/v72"
+	"gorm.io/gorm"
+)
+
+type RefundTestSuite struct {
+	suite.Suite
+	db      *gorm.DB
+	event   models.Event
+	request models.TicketRequest
+	tickets []models.Ticket
+	payment models.Transaction
+}
+
+func (suite *RefundTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "holder", Username: "holder", Email: "holder@kth.se"}).Error)
+
+	suite.event = models.Event{Name: "Refund", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&suite.event).Error)
+
+	tr := models.TicketRelease{
+		EventID:          int(suite.event.ID),
+		TicketsAvailable: 10,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: 100, EventID: suite.event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			CancellationPolicy:  models.NO_REFUND,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&tr).Error)
+
+	suite.request = models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID, TicketAmount: 2, UserUGKthID: "holder", IsHandled: true}
+	suite.Require().NoError(db.Create(&suite.request).Error)
+
+	suite.tickets = nil
+	for _, qrCode := range []string{"refund-1", "refund-2"} {
+		ticket := models.Ticket{TicketRequestID: suite.request.ID, UserUGKthID: "holder", QrCode: qrCode, IsPaid: true}
+		suite.Require().NoError(db.Create(&ticket).Error)
+		suite.tickets = append(suite.tickets, ticket)
+	}
+
+	suite.payment = models.Transaction{
+		PaymentIntentID: "pi_refund",
+		EventID:         int(suite.event.ID),
+		TicketID:        int(suite.tickets[0].ID),
+		UserUGKthID:     "holder",
+		Amount:          20000,
+		Currency:        "sek",
+		Status:          models.TransactionStatusCompleted,
+		TransactionType: models.TypePurchase,
+	}
+	suite.Require().NoError(db.Create(&suite.payment).Error)
+}
+
+func (suite *RefundTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func chargeRefundedEvent(amountRefunded int64, refunded bool) *stripe.Event {
+	raw := fmt.Sprintf(`{"id":"ch_refund","object":"charge","payment_intent":"pi_refund","amount":20000,"amount_refunded":%d,"refunded":%t}`,
+		amountRefunded, refunded)
+
+	return &stripe.Event{Type: "charge.refunded", Data: &stripe.EventData{Raw: []byte(raw)}}
+}
+
+func (suite *RefundTestSuite) TestNoRefundPolicy() {
+	service := services.NewTicketService(suite.db)
+
+	rerr := service.CancelTicket("holder", int(suite.tickets[0].ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal("Paid tickets in this ticket release can not be refunded", rerr.Message)
+
+	var count int64
+	suite.db.Model(&models.Ticket{}).Where("ticket_request_id = ?", suite.request.ID).Count(&count)
+	suite.Equal(int64(2), count)
+}
+
+func (suite *RefundTestSuite) TestRefundTierPassed() {
+	// Only tickets cancelled at least 60 days before the event are refunded
+	suite.Require().NoError(suite.db.Model(&models.TicketReleaseMethodDetail{}).Where("1 = 1").Updates(map[string]interface{}{
+		"cancellation_policy": models.TIERED_REFUND,
+		"refund_tiers":        `[{"days_before_event":60,"percentage":100}]`,
+	}).Error)
+
+	rerr := services.NewTicketService(suite.db).CancelTicket("holder", int(suite.tickets[0].ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal("The ticket can no longer be refunded", rerr.Message)
+}
+
+func (suite *RefundTestSuite) TestChargeRefundedWebhook() {
+	service := services.NewPaymentService(suite.db)
+
+	// A partial refund made in the Stripe dashboard is recorded, the tickets are kept
+	suite.Require().Nil(service.ProcessEvent(chargeRefundedEvent(5000, false)))
+	// Receiving the same event again does not record the refund twice
+	suite.Require().Nil(service.ProcessEvent(chargeRefundedEvent(5000, false)))
+
+	refunded, err := models.GetRefundedAmount(suite.db, "pi_refund")
+	suite.Require().NoError(err)
+	suite.Equal(5000, refunded)
+
+	income, err := models.GetEventTotalIncome(suite.db, int(suite.event.ID))
+	suite.Require().NoError(err)
+	suite.Equal(float64(15000), income)
+
+	var count int64
+	suite.db.Model(&models.Ticket{}).Where("ticket_request_id = ?", suite.request.ID).Count(&count)
+	suite.Equal(int64(2), count)
+
+	// The rest is refunded, the tickets are refunded and the seats are given back
+	suite.Require().Nil(service.ProcessEvent(chargeRefundedEvent(20000, true)))
+
+	refunded, err = models.GetRefundedAmount(suite.db, "pi_refund")
+	suite.Require().NoError(err)
+	suite.Equal(20000, refunded)
+
+	var payment models.Transaction
+	suite.Require().NoError(suite.db.First(&payment, suite.payment.ID).Error)
+	suite.True(payment.Refunded)
+
+	suite.db.Model(&models.Ticket{}).Where("ticket_request_id = ?", suite.request.ID).Count(&count)
+	suite.Equal(int64(0), count)
+
+	var tickets []models.Ticket
+	suite.Require().NoError(suite.db.Unscoped().Where("ticket_request_id = ?", suite.request.ID).Find(&tickets).Error)
+	for _, ticket := range tickets {
+		suite.True(ticket.Refunded)
+	}
+}
+
+func (suite *RefundTestSuite) TestRefundIssuedAfterCommit() {
+	stripeProvider := testutils.NewFakePaymentProvider(models.STRIPE)
+	previous := payment_provider.Register(stripeProvider)
+	defer payment_provider.Register(previous)
+
+	suite.Require().NoError(suite.db.Model(&models.TicketReleaseMethodDetail{}).Where("1 = 1").
+		Update("cancellation_policy", models.FULL_REFUND).Error)
+
+	// The provider can not be reached, the ticket is still cancelled and the refund is kept
+	stripeProvider.RefundErr = errors.New("connection refused")
+	suite.Require().Nil(services.NewTicketService(suite.db).CancelTicket("holder", int(suite.tickets[0].ID)))
+
+	var refund models.Transaction
+	suite.Require().NoError(suite.db.Where("transaction_type = ?", models.TypeRefund).First(&refund).Error)
+	suite.Equal(models.TransactionStatusPending, refund.Status)
+	suite.Nil(refund.RefundID)
+	suite.Equal(20000, refund.Amount, "both tickets of the request are refunded")
+
+	// Refunds are only retried once the request that recorded them has had its chance
+	stripeProvider.RefundErr = nil
+	suite.Require().NoError(services.IssuePendingRefunds(suite.db))
+	suite.Empty(stripeProvider.Refunds)
+
+	suite.Require().NoError(suite.db.Model(&refund).Update("created_at", time.Now().Add(-time.Hour)).Error)
+	suite.Require().NoError(services.IssuePendingRefunds(suite.db))
+	suite.Require().NoError(services.IssuePendingRefunds(suite.db))
+	suite.Require().Len(stripeProvider.Refunds, 1)
+	suite.Equal(20000, stripeProvider.Refunds[0].Amount)
+
+	suite.Require().NoError(suite.db.First(&refund, refund.ID).Error)
+	suite.Equal(models.TransactionStatusCompleted, refund.Status)
+	suite.NotNil(refund.RefundID)
+}
+
+func (suite *RefundTestSuite) TestDiscountedPartialRefund() {
+	stripeProvider := testutils.NewFakePaymentProvider(models.STRIPE)
+	previous := payment_provider.Register(stripeProvider)
+	defer payment_provider.Register(previous)
+
+	// Half is refunded a month before the event
+	suite.Require().NoError(suite.db.Model(&models.TicketReleaseMethodDetail{}).Where("1 = 1").Updates(map[string]interface{}{
+		"cancellation_policy": models.TIERED_REFUND,
+		"refund_tiers":        `[{"days_before_event":60,"percentage":100},{"days_before_event":7,"percentage":50}]`,
+	}).Error)
+
+	// The tickets cost 200 SEK, less 50 SEK with a discount code, and the add-on 50 SEK
+	addOn := models.AddOn{Name: "Breakfast", Price: 50, MaxQuantity: 1, IsEnabled: true, TicketReleaseID: int(suite.request.TicketReleaseID)}
+	suite.Require().NoError(suite.db.Create(&addOn).Error)
+	ticketAddOn := models.TicketAddOn{AddOnID: addOn.ID, TicketRequestID: &suite.request.ID, TicketID: &suite.tickets[0].ID, Quantity: 1}
+	suite.Require().NoError(suite.db.Create(&ticketAddOn).Error)
+	suite.Require().NoError(suite.db.Model(&suite.payment).Updates(map[string]interface{}{"amount": 20000, "discount_amount": 5000}).Error)
+
+	service := services.NewTicketService(suite.db)
+	_, rerr := service.RefundAddOn("holder", int(suite.tickets[0].ID), int(ticketAddOn.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().Nil(service.CancelTicket("holder", int(suite.tickets[0].ID)))
+
+	// Half of what was paid is refunded, not half of the list price
+	suite.Require().Len(stripeProvider.Refunds, 2)
+	suite.Equal(2500, stripeProvider.Refunds[0].Amount)
+	suite.Equal(7500, stripeProvider.Refunds[1].Amount)
+
+	refunded, err := models.GetRefundedAmount(suite.db, "pi_refund")
+	suite.Require().NoError(err)
+	suite.Equal(10000, refunded)
+}
+
+func TestRefundTestSuite(t *testing.T) {
+	suite.Run(t, new(RefundTestSuite))
+}
@@ -0,0 +1,147 @@
+package test_service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/jobs"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type TicketPDFTestSuite struct {
+	suite.Suite
+	db      *gorm.DB
+	service *services.TicketPDFService
+	ticket  models.Ticket
+}
+
+func (suite *TicketPDFTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewTicketPDFService(db)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "guest", Username: "guest", Email: "guest@kth.se", FirstName: "Åsa", LastName: "Öberg"}).Error)
+
+	organization := models.Organization{Name: "Datasektionen", Email: "pdf@datasektionen.se"}
+	suite.Require().NoError(db.Create(&organization).Error)
+
+	event := models.Event{Name: "Vårbal", Date: time.Date(2030, 5, 17, 18, 0, 0, 0, time.UTC), Location: "Nymble", OrganizationID: int(organization.ID)}
+	suite.Require().NoError(db.Create(&event).Error)
+
+	tr := models.TicketRelease{EventID: int(event.ID), TicketsAvailable: 10}
+	suite.Require().NoError(db.Create(&tr).Error)
+
+	ticketType := models.TicketType{Name: "Dinner and party", EventID: event.ID, TicketReleaseID: tr.ID, Price: 450}
+	suite.Require().NoError(db.Create(&ticketType).Error)
+
+	addOn := models.AddOn{Name: "Wine package", Price: 150, MaxQuantity: 2, IsEnabled: true, TicketReleaseID: int(tr.ID)}
+	suite.Require().NoError(db.Create(&addOn).Error)
+
+	request := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketType.ID, TicketAmount: 1, UserUGKthID: "guest", IsHandled: true}
+	suite.Require().NoError(db.Create(&request).Error)
+
+	suite.ticket = models.Ticket{TicketRequestID: request.ID, UserUGKthID: "guest", QrCode: "pdf-qr-code", IsPaid: true}
+	suite.Require().NoError(db.Create(&suite.ticket).Error)
+
+	ticketID := suite.ticket.ID
+	suite.Require().NoError(db.Create(&models.TicketAddOn{AddOnID: addOn.ID, TicketID: &ticketID, Quantity: 2}).Error)
+}
+
+func (suite *TicketPDFTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *TicketPDFTestSuite) TestTicketPDF() {
+	pdf, rerr := suite.service.TicketPDF("guest", int(suite.ticket.ID))
+	suite.Require().Nil(rerr)
+
+	suite.True(bytes.HasPrefix(pdf, []byte("%PDF-")))
+	suite.True(bytes.HasSuffix(bytes.TrimSpace(pdf), []byte("%%EOF")))
+	suite.Contains(string(pdf), "/Subtype /Image", "the QR code is embedded as an image")
+	suite.Equal(1, bytes.Count(pdf, []byte("/Type /Page\n")), "the ticket is one page")
+}
+
+func (suite *TicketPDFTestSuite) TestOnlyPaidTicketsArePrintable() {
+	_, rerr := suite.service.TicketPDF("someone-else", int(suite.ticket.ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusNotFound, rerr.StatusCode)
+
+	suite.Require().NoError(suite.db.Model(&suite.ticket).Update("is_paid", false).Error)
+
+	_, rerr = suite.service.TicketPDF("guest", int(suite.ticket.ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusBadRequest, rerr.StatusCode)
+}
+
+func (suite *TicketPDFTestSuite) TestEveryPaidTicketIsAttached() {
+	var request models.TicketRequest
+	suite.Require().NoError(suite.db.First(&request, suite.ticket.TicketRequestID).Error)
+
+	// A group request has one ticket per guest, tickets that can't be used are left out
+	guestName := "Guest"
+	group := []models.Ticket{
+		{TicketRequestID: request.ID, UserUGKthID: "guest", QrCode: "pdf-group-1", IsPaid: true, GuestName: &guestName},
+		{TicketRequestID: request.ID, UserUGKthID: "guest", QrCode: "pdf-group-2", IsPaid: true, Refunded: true},
+		{TicketRequestID: request.ID, UserUGKthID: "guest", QrCode: "pdf-group-3", IsPaid: false},
+	}
+	for i := range group {
+		suite.Require().NoError(suite.db.Create(&group[i]).Error)
+	}
+
+	// A request in the same order
+	other := models.TicketRequest{TicketReleaseID: request.TicketReleaseID, TicketTypeID: request.TicketTypeID, TicketAmount: 1, UserUGKthID: "guest", IsHandled: true}
+	suite.Require().NoError(suite.db.Create(&other).Error)
+	otherTicket := models.Ticket{TicketRequestID: other.ID, UserUGKthID: "guest", QrCode: "pdf-other", IsPaid: true}
+	suite.Require().NoError(suite.db.Create(&otherTicket).Error)
+
+	attachments, err := services.TicketPDFAttachments(suite.db, request.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(attachments, 2)
+	suite.Equal(fmt.Sprintf("ticket-%d.pdf", suite.ticket.ID), attachments[0].Name)
+	suite.Equal(fmt.Sprintf("ticket-%d.pdf", group[0].ID), attachments[1].Name)
+	for _, attachment := range attachments {
+		suite.Equal("application/pdf", attachment.ContentType)
+		suite.True(bytes.HasPrefix(attachment.Content, []byte("%PDF-")))
+	}
+
+	attachments, err = services.TicketPDFAttachments(suite.db, request.ID, other.ID)
+	suite.Require().NoError(err)
+	suite.Len(attachments, 3)
+}
+
+func (suite *TicketPDFTestSuite) TestMailAttachmentsAreBase64Encoded() {
+	data, err := json.Marshal(jobs.MailData{
+		To:          "guest@kth.se",
+		Attachments: []jobs.MailAttachment{{OriginalName: "ticket.pdf", MimeType: "application/pdf", Buffer: []byte("%PDF-1.3"), Encoding: "base64"}},
+	})
+	suite.Require().NoError(err)
+
+	var decoded struct {
+		Attachments []map[string]string `json:"attachments"`
+	}
+	suite.Require().NoError(json.Unmarshal(data, &decoded))
+	suite.Require().Len(decoded.Attachments, 1)
+	suite.Equal("ticket.pdf", decoded.Attachments[0]["originalname"])
+	suite.Equal(base64.StdEncoding.EncodeToString([]byte("%PDF-1.3")), decoded.Attachments[0]["buffer"])
+
+	// Emails without attachments are sent as before
+	data, err = json.Marshal(jobs.MailData{To: "guest@kth.se"})
+	suite.Require().NoError(err)
+	suite.NotContains(string(data), "attachments")
+}
+
+func TestTicketPDFTestSuite(t *testing.T) {
+	suite.Run(t, new(TicketPDFTestSuite))
+}
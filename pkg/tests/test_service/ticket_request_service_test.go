@@ -9,6 +9,7 @@ import (
 	"github.com/DowLucas/gin-ticket-release/pkg/models"
 	"github.com/DowLucas/gin-ticket-release/pkg/services"
 	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
 	"github.com/DowLucas/gin-ticket-release/utils"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
@@ -321,6 +322,51 @@ func (suite *TicketRequestTestSuite) TestUserMakesTwoTicketRequestUnderMaxTicket
 	suite.Equal(2, len(ticketRequestFromDB))
 }
 
+func (suite *TicketRequestTestSuite) TestCreateReservedTicketRequestsBeyondCapacity() {
+	testutils.SetupOrganizationWorkflow(suite.db)
+	event := testutils.CreateEventWorkflow(suite.db)
+
+	ticketReleaseMethod := factory.NewTicketReleaseMethod(string(models.RESERVED_TICKET_RELEASE), "Reserved")
+	suite.Require().NoError(suite.db.Create(ticketReleaseMethod).Error)
+
+	ticketReleaseMethodDetail := factory.NewTicketReleaseMethodDetail(10, "Email", "Standard", 0, ticketReleaseMethod.ID)
+	suite.Require().NoError(suite.db.Create(ticketReleaseMethodDetail).Error)
+
+	ticketRelease := models.TicketRelease{
+		EventID:                     int(event.ID),
+		Open:                        time.Now().Unix() - 1000,
+		Close:                       time.Now().Unix() + 1000,
+		TicketsAvailable:            3,
+		TicketReleaseMethodDetailID: ticketReleaseMethodDetail.ID,
+	}
+	suite.Require().NoError(suite.db.Create(&ticketRelease).Error)
+
+	capacity := 2
+	limited := factory.NewTicketType(event.ID, "Limited", "validTicketTypeDescription", 100, 100, false, ticketRelease.ID)
+	limited.Capacity = &capacity
+	suite.Require().NoError(suite.db.Create(limited).Error)
+
+	standard := factory.NewTicketType(event.ID, "Standard", "validTicketTypeDescription", 100, 100, false, ticketRelease.ID)
+	suite.Require().NoError(suite.db.Create(standard).Error)
+
+	// The requests are not allocated in between, the job that allocates them has not run
+	request := func(ticketTypeID uint) *types.ErrorResponse {
+		ticketRequest := factory.NewTicketRequest(1, ticketRelease.ID, ticketTypeID, "validUserUGKthID", false, time.Now())
+		_, err := suite.ticketRequestService.CreateTicketRequests([]models.TicketRequest{*ticketRequest}, &[]types.SelectedAddOns{})
+		return err
+	}
+
+	suite.Nil(request(limited.ID))
+	suite.Nil(request(limited.ID))
+	suite.NotNil(request(limited.ID), "the ticket type is full")
+	suite.Nil(request(standard.ID))
+	suite.NotNil(request(standard.ID), "the ticket release is full")
+
+	var count int64
+	suite.db.Model(&models.TicketRequest{}).Where("ticket_release_id = ?", ticketRelease.ID).Count(&count)
+	suite.Equal(int64(3), count)
+}
+
 func TestTicketRequestTestSuite(t *testing.T) {
 	suite.Run(t, new(TicketRequestTestSuite))
 }
@@ -0,0 +1,226 @@
+package test_service
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/jobs"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type TicketResaleTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	service       *services.TicketResaleService
+	ticketRelease models.TicketRelease
+	sellerTicket  models.Ticket
+	groupRequest  models.TicketRequest
+	buyerRequest  models.TicketRequest
+}
+
+func (suite *TicketResaleTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+
+	suite.db = db
+	suite.service = services.NewTicketResaleService(db)
+
+	for _, id := range []string{"seller", "group", "buyer"} {
+		suite.Require().NoError(db.Create(&models.User{UGKthID: id, Username: id, Email: id + "@kth.se"}).Error)
+	}
+
+	event := models.Event{Name: "Resale", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&event).Error)
+
+	tr := models.TicketRelease{
+		EventID:             int(event.ID),
+		TicketsAvailable:    1,
+		AllowResale:         true,
+		HasAllocatedTickets: true,
+		TicketTypes:         []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS_LOTTERY)},
+		},
+	}
+	suite.Require().NoError(db.Create(&tr).Error)
+	suite.ticketRelease = tr
+
+	ticketTypeID := tr.TicketTypes[0].ID
+	deadline := time.Now().Add(-time.Hour)
+
+	sellerRequest := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketTypeID, TicketAmount: 1, UserUGKthID: "seller", IsHandled: true}
+	suite.Require().NoError(db.Create(&sellerRequest).Error)
+	suite.sellerTicket = models.Ticket{TicketRequestID: sellerRequest.ID, UserUGKthID: "seller", QrCode: "seller", IsPaid: true, PaymentDeadline: &deadline}
+	suite.Require().NoError(db.Create(&suite.sellerTicket).Error)
+
+	// The first reserve requested two tickets and can not buy the seller's single ticket
+	suite.groupRequest = models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketTypeID, TicketAmount: 2, UserUGKthID: "group", IsHandled: true}
+	suite.Require().NoError(db.Create(&suite.groupRequest).Error)
+	for _, qrCode := range []string{"group-1", "group-2"} {
+		suite.Require().NoError(db.Create(&models.Ticket{TicketRequestID: suite.groupRequest.ID, UserUGKthID: "group", QrCode: qrCode, IsReserve: true, ReserveNumber: 1}).Error)
+	}
+
+	suite.buyerRequest = models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketTypeID, TicketAmount: 1, UserUGKthID: "buyer", IsHandled: true}
+	suite.Require().NoError(db.Create(&suite.buyerRequest).Error)
+	suite.Require().NoError(db.Create(&models.Ticket{TicketRequestID: suite.buyerRequest.ID, UserUGKthID: "buyer", QrCode: "buyer", IsReserve: true, ReserveNumber: 2}).Error)
+}
+
+func (suite *TicketResaleTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *TicketResaleTestSuite) getBuyerTicket() models.Ticket {
+	var ticket models.Ticket
+	suite.Require().NoError(suite.db.Where("ticket_request_id = ?", suite.buyerRequest.ID).First(&ticket).Error)
+	return ticket
+}
+
+func (suite *TicketResaleTestSuite) TestResaleToReserve() {
+	resale, rerr := suite.service.ListTicket("seller", int(suite.sellerTicket.ID))
+	suite.Require().Nil(rerr)
+
+	_, rerr = suite.service.ListTicket("seller", int(suite.sellerTicket.ID))
+	suite.Require().NotNil(rerr)
+
+	suite.Require().NoError(jobs.ManuallyProcessAllocateReserveTicketsJob(suite.db, suite.ticketRelease.ID))
+
+	// The seller's ticket is offered to the first reserve that requested a single ticket
+	suite.Require().NoError(suite.db.First(resale, resale.ID).Error)
+	suite.Equal(models.TicketResaleOffered, resale.Status)
+	suite.Require().NotNil(resale.BuyerTicketRequestID)
+	suite.Equal(suite.buyerRequest.ID, *resale.BuyerTicketRequestID)
+
+	buyerTicket := suite.getBuyerTicket()
+	suite.False(buyerTicket.IsReserve)
+	suite.False(buyerTicket.IsPaid)
+
+	var groupTickets []models.Ticket
+	suite.Require().NoError(suite.db.Where("ticket_request_id = ?", suite.groupRequest.ID).Find(&groupTickets).Error)
+	for _, ticket := range groupTickets {
+		suite.True(ticket.IsReserve)
+		suite.Equal(uint(1), ticket.ReserveNumber)
+	}
+
+	// Offered tickets can not be taken back
+	_, rerr = suite.service.CancelResale("seller", resale.ID)
+	suite.Require().NotNil(rerr)
+
+	// The buyer pays
+	_, err := services.HandleSuccessfulTicketPayment(suite.db, int(buyerTicket.ID))
+	suite.Require().NoError(err)
+	sold, err := services.CompleteTicketResale(suite.db, suite.buyerRequest.ID)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(sold)
+	suite.Equal(models.TicketResaleSold, sold.Status)
+
+	var sellerTicket models.Ticket
+	suite.Require().NoError(suite.db.Unscoped().First(&sellerTicket, suite.sellerTicket.ID).Error)
+	suite.True(sellerTicket.Refunded)
+	suite.True(sellerTicket.DeletedAt.Valid)
+}
+
+func (suite *TicketResaleTestSuite) TestSellerRefundIsRetriedWithWebhook() {
+	swish := testutils.NewFakePaymentProvider(models.SWISH)
+	previous := payment_provider.Register(swish)
+	defer payment_provider.Register(previous)
+
+	paidAt := time.Now().Unix()
+	suite.Require().NoError(suite.db.Create(&models.Transaction{
+		PaymentIntentID: "pay_seller",
+		TicketID:        int(suite.sellerTicket.ID),
+		UserUGKthID:     "seller",
+		Amount:          10000,
+		Currency:        "SEK",
+		PayedAt:         &paidAt,
+		Status:          models.TransactionStatusCompleted,
+		PaymentProvider: models.SWISH,
+		TransactionType: models.TypePurchase,
+	}).Error)
+
+	resale, rerr := suite.service.ListTicket("seller", int(suite.sellerTicket.ID))
+	suite.Require().Nil(rerr)
+	suite.Require().NoError(jobs.ManuallyProcessAllocateReserveTicketsJob(suite.db, suite.ticketRelease.ID))
+
+	buyerTicket := suite.getBuyerTicket()
+	suite.Require().NoError(suite.db.Create(&models.Transaction{
+		PaymentIntentID: "pay_buyer",
+		TicketID:        int(buyerTicket.ID),
+		UserUGKthID:     "buyer",
+		Amount:          10000,
+		Currency:        "SEK",
+		Status:          models.TransactionStatusPending,
+		PaymentProvider: models.SWISH,
+		TransactionType: models.TypePurchase,
+	}).Error)
+
+	// The seller can not be refunded when the buyer's payment comes in
+	swish.RefundErr = errors.New("provider unavailable")
+	paymentService := services.NewPaymentService(suite.db)
+	callback, payload := swish.Callback(payment_provider.Callback{
+		ID:        "evt_buyer_paid",
+		Type:      payment_provider.CallbackPaymentSucceeded,
+		PaymentID: "pay_buyer",
+		Amount:    10000,
+	})
+	_, rerr = paymentService.HandleCallback(models.SWISH, callback, payload)
+	suite.Require().NotNil(rerr)
+
+	suite.True(suite.getBuyerTicket().IsPaid)
+	suite.Require().NoError(suite.db.First(resale, resale.ID).Error)
+	suite.Equal(models.TicketResaleSold, resale.Status)
+
+	var webhookEvent models.WebhookEvent
+	suite.Require().NoError(suite.db.Where("stripe_id = ?", "evt_buyer_paid").First(&webhookEvent).Error)
+	suite.False(webhookEvent.Processed)
+
+	// The retry of the webhook refunds the seller without handling the payment again
+	swish.RefundErr = nil
+	_, rerr = paymentService.ReplayWebhookEvent(webhookEvent.ID)
+	suite.Require().Nil(rerr)
+
+	suite.Require().Len(swish.Refunds, 1)
+	suite.Equal("pay_seller", swish.Refunds[0].PaymentID)
+
+	var refund models.Transaction
+	suite.Require().NoError(suite.db.First(&refund, *resale.RefundTransactionID).Error)
+	suite.Equal(models.TransactionStatusCompleted, refund.Status)
+}
+
+func (suite *TicketResaleTestSuite) TestOfferExpires() {
+	resale, rerr := suite.service.ListTicket("seller", int(suite.sellerTicket.ID))
+	suite.Require().Nil(rerr)
+
+	suite.Require().NoError(jobs.ManuallyProcessAllocateReserveTicketsJob(suite.db, suite.ticketRelease.ID))
+
+	// The buyer does not pay in time and loses the ticket
+	deadline := time.Now().Add(-time.Minute)
+	suite.Require().NoError(suite.db.Model(&models.Ticket{}).Where("id = ?", suite.getBuyerTicket().ID).Update("payment_deadline", deadline).Error)
+
+	suite.Require().NoError(jobs.ManuallyProcessAllocateReserveTicketsJob(suite.db, suite.ticketRelease.ID))
+
+	// No other reserve can buy the ticket, so the seller keeps it until someone can
+	suite.Require().NoError(suite.db.First(resale, resale.ID).Error)
+	suite.Equal(models.TicketResaleListed, resale.Status)
+	suite.Nil(resale.BuyerTicketRequestID)
+
+	var sellerTicket models.Ticket
+	suite.Require().NoError(suite.db.First(&sellerTicket, suite.sellerTicket.ID).Error)
+	suite.False(sellerTicket.Refunded)
+
+	resale, rerr = suite.service.CancelResale("seller", resale.ID)
+	suite.Require().Nil(rerr)
+	suite.Equal(models.TicketResaleCancelled, resale.Status)
+}
+
+func TestTicketResaleTestSuite(t *testing.T) {
+	suite.Run(t, new(TicketResaleTestSuite))
+}
@@ -0,0 +1,175 @@
+package test_service
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/checkinfeed"
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/qrtoken"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type TicketSigningTestSuite struct {
+	suite.Suite
+	db      *gorm.DB
+	service *services.TicketSigningService
+	event   models.Event
+	tickets []models.Ticket
+}
+
+func (suite *TicketSigningTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	os.Setenv("SECRET_KEY", "0123456789abcdef0123456789abcdef")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewTicketSigningService(db)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "guest", Username: "guest", Email: "guest@kth.se"}).Error)
+
+	// The event is tonight so that the signed QR codes are valid now
+	suite.event = models.Event{Name: "Gasque", Date: time.Now().Add(2 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&suite.event).Error)
+
+	tr := models.TicketRelease{
+		EventID:          int(suite.event.ID),
+		TicketsAvailable: 10,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: 100, EventID: suite.event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&tr).Error)
+
+	request := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID, TicketAmount: 2, UserUGKthID: "guest", IsHandled: true}
+	suite.Require().NoError(db.Create(&request).Error)
+
+	suite.tickets = nil
+	for _, qrCode := range []string{"signing-1", "signing-2"} {
+		ticket := models.Ticket{TicketRequestID: request.ID, UserUGKthID: "guest", QrCode: qrCode, IsPaid: true}
+		suite.Require().NoError(db.Create(&ticket).Error)
+		suite.tickets = append(suite.tickets, ticket)
+	}
+}
+
+func (suite *TicketSigningTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *TicketSigningTestSuite) sign(ticket models.Ticket) string {
+	qrCode, rerr := suite.service.SignTicket("guest", int(ticket.ID))
+	suite.Require().Nil(rerr)
+	return qrCode.Token
+}
+
+func (suite *TicketSigningTestSuite) TestTokensVerifyOfflineWithThePublicKey() {
+	token := suite.sign(suite.tickets[0])
+
+	publicKey, rerr := suite.service.GetPublicKey(int(suite.event.ID))
+	suite.Require().Nil(rerr)
+	suite.Equal("Ed25519", publicKey.Algorithm)
+
+	key, err := base64.StdEncoding.DecodeString(publicKey.PublicKey)
+	suite.Require().NoError(err)
+
+	claims, err := qrtoken.Verify(ed25519.PublicKey(key), token)
+	suite.Require().NoError(err)
+	suite.Equal(suite.tickets[0].ID, claims.TicketID)
+	suite.Equal(suite.event.ID, claims.EventID)
+	suite.NoError(claims.ValidAt(time.Now()))
+	suite.ErrorIs(claims.ValidAt(time.Now().Add(3*24*time.Hour)), qrtoken.ErrExpired)
+
+	// The key pair is created once per event
+	again, rerr := suite.service.GetPublicKey(int(suite.event.ID))
+	suite.Require().Nil(rerr)
+	suite.Equal(publicKey.PublicKey, again.PublicKey)
+
+	// Tokens signed with another key are rejected
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	suite.Require().NoError(err)
+	forged, err := qrtoken.Sign(otherKey, claims)
+	suite.Require().NoError(err)
+	_, err = qrtoken.Verify(ed25519.PublicKey(key), forged)
+	suite.ErrorIs(err, qrtoken.ErrSignature)
+}
+
+func (suite *TicketSigningTestSuite) TestCheckInWithSignedQrCode() {
+	token := suite.sign(suite.tickets[0])
+	checkInService := services.NewCheckInService(suite.db, checkinfeed.NewMemoryBroker())
+
+	_, rerr := checkInService.CheckIn(int(suite.event.ID)+1, "organizer", &types.CheckInRequest{QrCode: token})
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusBadRequest, rerr.StatusCode)
+
+	result, rerr := checkInService.CheckIn(int(suite.event.ID), "organizer", &types.CheckInRequest{QrCode: token})
+	suite.Require().Nil(rerr)
+	suite.Equal(suite.tickets[0].ID, result.Ticket.ID)
+	suite.True(result.Ticket.CheckedIn)
+
+	// The random QR codes still work
+	result, rerr = checkInService.CheckIn(int(suite.event.ID), "organizer", &types.CheckInRequest{QrCode: "signing-2"})
+	suite.Require().Nil(rerr)
+	suite.Equal(suite.tickets[1].ID, result.Ticket.ID)
+}
+
+func (suite *TicketSigningTestSuite) TestTransferredTicketsInvalidateOldTokens() {
+	token := suite.sign(suite.tickets[0])
+	suite.Require().NoError(suite.db.Model(&suite.tickets[0]).Update("qr_code", "transferred").Error)
+
+	_, rerr := services.NewCheckInService(suite.db, checkinfeed.NewMemoryBroker()).CheckIn(int(suite.event.ID), "organizer", &types.CheckInRequest{QrCode: token})
+	suite.Require().NotNil(rerr)
+	suite.Equal("Invalid QR code", rerr.Message)
+}
+
+func (suite *TicketSigningTestSuite) TestReconcileOfflineCheckIns() {
+	first := suite.sign(suite.tickets[0])
+	second := suite.sign(suite.tickets[1])
+
+	// The second ticket was already checked in online
+	checkedInOnline := time.Now().Add(-time.Hour).Truncate(time.Second)
+	suite.Require().NoError(suite.db.Model(&suite.tickets[1]).
+		Updates(map[string]interface{}{"checked_in": true, "checked_in_at": checkedInOnline}).Error)
+
+	scannedAt := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	results, rerr := services.NewCheckInService(suite.db, checkinfeed.NewMemoryBroker()).ReconcileOfflineCheckIns(int(suite.event.ID), "organizer", &types.OfflineCheckInBatchRequest{
+		Gate: "Scanner 1",
+		CheckIns: []types.OfflineCheckIn{
+			{Token: first, CheckedInAt: scannedAt.Add(time.Minute)}, // Scanned again at another gate
+			{Token: first, CheckedInAt: scannedAt},
+			{Token: second, CheckedInAt: scannedAt},
+			{Token: "TT1.garbage.token", CheckedInAt: scannedAt},
+		},
+	})
+	suite.Require().Nil(rerr)
+	suite.Require().Len(results, 4)
+
+	suite.Equal(types.OfflineCheckInDuplicate, results[0].Status)
+	suite.True(scannedAt.Equal(*results[0].CheckedInAt))
+
+	suite.Equal(types.OfflineCheckInAccepted, results[1].Status)
+	suite.Equal(suite.tickets[0].ID, results[1].TicketID)
+
+	suite.Equal(types.OfflineCheckInDuplicate, results[2].Status)
+	suite.True(checkedInOnline.Equal(*results[2].CheckedInAt))
+
+	suite.Equal(types.OfflineCheckInRejected, results[3].Status)
+
+	var ticket models.Ticket
+	suite.Require().NoError(suite.db.First(&ticket, suite.tickets[0].ID).Error)
+	suite.True(ticket.CheckedIn)
+	suite.True(scannedAt.Equal(ticket.CheckedInAt.Time))
+}
+
+func TestTicketSigningTestSuite(t *testing.T) {
+	suite.Run(t, new(TicketSigningTestSuite))
+}
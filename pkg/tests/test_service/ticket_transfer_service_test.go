@@ -0,0 +1,120 @@
+package test_service
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type TicketTransferTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	service       *services.TicketTransferService
+	ticketRelease models.TicketRelease
+	ticket        models.Ticket
+}
+
+func (suite *TicketTransferTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+
+	suite.db = db
+	suite.service = services.NewTicketTransferService(db)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "holder", Username: "holder", Email: "holder@kth.se"}).Error)
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "friend", Username: "friend", Email: "Friend@kth.se"}).Error)
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "external", Username: "external", Email: "external@example.com", IsExternal: true}).Error)
+
+	event := models.Event{Name: "Transfer", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&event).Error)
+
+	tr := models.TicketRelease{
+		EventID:          int(event.ID),
+		TicketsAvailable: 10,
+		AllowTransfers:   true,
+		TicketTypes:      []models.TicketType{{Name: "Standard", Price: 100, EventID: event.ID}},
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   1,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&tr).Error)
+	suite.ticketRelease = tr
+
+	req := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: tr.TicketTypes[0].ID, TicketAmount: 1, UserUGKthID: "holder"}
+	suite.Require().NoError(db.Create(&req).Error)
+
+	suite.ticket = models.Ticket{TicketRequestID: req.ID, UserUGKthID: "holder", QrCode: "original-qr-code"}
+	suite.Require().NoError(db.Create(&suite.ticket).Error)
+}
+
+func (suite *TicketTransferTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *TicketTransferTestSuite) TestTransferTicket() {
+	transfer, rerr := suite.service.InitiateTransfer("holder", int(suite.ticket.ID), "friend@kth.se")
+	suite.Require().Nil(rerr)
+	suite.Equal("friend", transfer.ToUGKthID)
+
+	// Only one transfer of a ticket can be pending
+	_, rerr = suite.service.InitiateTransfer("holder", int(suite.ticket.ID), "friend")
+	suite.Require().NotNil(rerr)
+
+	// Only the recipient can accept the transfer
+	_, rerr = suite.service.AcceptTransfer("holder", transfer.ID)
+	suite.Require().NotNil(rerr)
+
+	transfer, rerr = suite.service.AcceptTransfer("friend", transfer.ID)
+	suite.Require().Nil(rerr)
+	suite.Equal(models.TicketTransferAccepted, transfer.Status)
+
+	var ticket models.Ticket
+	suite.Require().NoError(suite.db.Preload("TicketRequest").First(&ticket, suite.ticket.ID).Error)
+	suite.Equal("friend", ticket.UserUGKthID)
+	suite.Equal("friend", ticket.TicketRequest.UserUGKthID)
+	suite.NotEqual("original-qr-code", ticket.QrCode)
+}
+
+func (suite *TicketTransferTestSuite) TestTransferRules() {
+	// External users can not hold tickets to the ticket release
+	_, rerr := suite.service.InitiateTransfer("holder", int(suite.ticket.ID), "external")
+	suite.Require().NotNil(rerr)
+
+	// The recipient already has as many tickets as they are allowed
+	other := models.TicketRequest{TicketReleaseID: suite.ticketRelease.ID, TicketTypeID: suite.ticketRelease.TicketTypes[0].ID, TicketAmount: 1, UserUGKthID: "friend"}
+	suite.Require().NoError(suite.db.Create(&other).Error)
+
+	_, rerr = suite.service.InitiateTransfer("holder", int(suite.ticket.ID), "friend")
+	suite.Require().NotNil(rerr)
+	suite.Require().NoError(suite.db.Delete(&other).Error)
+
+	// The ticket has not been paid for
+	suite.Require().NoError(suite.db.Model(&models.TicketRelease{}).Where("id = ?", suite.ticketRelease.ID).Update("transfer_paid_only", true).Error)
+
+	_, rerr = suite.service.InitiateTransfer("holder", int(suite.ticket.ID), "friend")
+	suite.Require().NotNil(rerr)
+	suite.Equal("Only paid tickets can be transferred", rerr.Message)
+
+	// The transfer deadline has passed
+	deadline := time.Now().Add(-time.Hour).Unix()
+	suite.Require().NoError(suite.db.Model(&models.TicketRelease{}).Where("id = ?", suite.ticketRelease.ID).Updates(map[string]interface{}{
+		"transfer_paid_only": false,
+		"transfer_deadline":  deadline,
+	}).Error)
+
+	_, rerr = suite.service.InitiateTransfer("holder", int(suite.ticket.ID), "friend")
+	suite.Require().NotNil(rerr)
+	suite.Equal("The deadline for transferring tickets has passed", rerr.Message)
+}
+
+func TestTicketTransferTestSuite(t *testing.T) {
+	suite.Run(t, new(TicketTransferTestSuite))
+}
@@ -0,0 +1,165 @@
+package test_service
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/types"
+	"github.com/DowLucas/gin-ticket-release/pkg/waitingroom"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type WaitingRoomTestSuite struct {
+	suite.Suite
+	db            *gorm.DB
+	service       *services.WaitingRoomService
+	event         models.Event
+	ticketRelease models.TicketRelease
+}
+
+func (suite *WaitingRoomTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+	suite.service = services.NewWaitingRoomService(db, waitingroom.NewMemoryStore())
+
+	suite.event = models.Event{Name: "Spring ball", Date: time.Now().Add(30 * 24 * time.Hour), OrganizationID: 1}
+	suite.Require().NoError(db.Create(&suite.event).Error)
+
+	suite.ticketRelease = models.TicketRelease{
+		EventID:          int(suite.event.ID),
+		Name:             "Tickets",
+		Open:             time.Now().Add(time.Hour).Unix(),
+		Close:            time.Now().Add(48 * time.Hour).Unix(),
+		TicketsAvailable: 100,
+		TicketReleaseMethodDetail: models.TicketReleaseMethodDetail{
+			MaxTicketsPerUser:   2,
+			TicketReleaseMethod: models.TicketReleaseMethod{MethodName: string(models.FCFS)},
+		},
+	}
+	suite.Require().NoError(db.Create(&suite.ticketRelease).Error)
+}
+
+func (suite *WaitingRoomTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *WaitingRoomTestSuite) setWaitingRoom(order models.WaitingRoomOrder) {
+	_, rerr := suite.service.SetWaitingRoom(int(suite.event.ID), int(suite.ticketRelease.ID), &types.WaitingRoomRequest{
+		Order:         order,
+		JoinBefore:    2 * 3600,
+		BatchSize:     2,
+		BatchInterval: 60,
+	})
+	suite.Require().Nil(rerr)
+}
+
+func (suite *WaitingRoomTestSuite) join(ugkthid string) *types.WaitingRoomStatus {
+	status, rerr := suite.service.JoinWaitingRoom(ugkthid, int(suite.event.ID), int(suite.ticketRelease.ID))
+	suite.Require().Nil(rerr)
+	return status
+}
+
+// openedAgo moves the opening of the ticket release into the past
+func (suite *WaitingRoomTestSuite) openedAgo(d time.Duration) {
+	suite.Require().NoError(suite.db.Model(&suite.ticketRelease).Update("open", time.Now().Add(-d).Unix()).Error)
+}
+
+func (suite *WaitingRoomTestSuite) TestOnlyFCFSReleasesHaveWaitingRooms() {
+	var method models.TicketReleaseMethod
+	suite.Require().NoError(suite.db.Create(&models.TicketReleaseMethod{MethodName: string(models.FCFS_LOTTERY)}).Error)
+	suite.Require().NoError(suite.db.Where("method_name = ?", models.FCFS_LOTTERY).First(&method).Error)
+	suite.Require().NoError(suite.db.Model(&models.TicketReleaseMethodDetail{}).
+		Where("id = ?", suite.ticketRelease.TicketReleaseMethodDetailID).Update("ticket_release_method_id", method.ID).Error)
+
+	_, rerr := suite.service.SetWaitingRoom(int(suite.event.ID), int(suite.ticketRelease.ID), &types.WaitingRoomRequest{
+		Order: models.WaitingRoomArrival, BatchSize: 2, BatchInterval: 60,
+	})
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusBadRequest, rerr.StatusCode)
+}
+
+func (suite *WaitingRoomTestSuite) TestAdmitsBatchesInArrivalOrder() {
+	suite.setWaitingRoom(models.WaitingRoomArrival)
+
+	for i, ugkthid := range []string{"alice", "bob", "carol", "dave", "erik"} {
+		status := suite.join(ugkthid)
+		suite.False(status.Admitted)
+		suite.Equal(int64(i+1), status.Position)
+	}
+
+	// Joining again keeps the place in the queue
+	suite.Equal(int64(1), suite.join("alice").Position)
+	suite.Equal(int64(5), suite.join("erik").QueueSize)
+
+	// Before the release opens nobody is admitted
+	rerr := suite.service.CheckAdmitted("alice", suite.ticketRelease.ID)
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusForbidden, rerr.StatusCode)
+
+	// Half way through the second batch, four users have been admitted
+	suite.openedAgo(90 * time.Second)
+	for _, ugkthid := range []string{"alice", "bob", "carol", "dave"} {
+		suite.Nil(suite.service.CheckAdmitted(ugkthid, suite.ticketRelease.ID))
+	}
+
+	status, rerr := suite.service.GetWaitingRoomStatus("erik", int(suite.event.ID), int(suite.ticketRelease.ID))
+	suite.Require().Nil(rerr)
+	suite.False(status.Admitted)
+	suite.Equal(int64(1), status.Position)
+	suite.InDelta(30, status.EstimatedWait, 2)
+
+	rerr = suite.service.CheckAdmitted("erik", suite.ticketRelease.ID)
+	suite.Require().NotNil(rerr)
+	suite.Equal("You are in the waiting room, your position is 1", rerr.Message)
+
+	rerr = suite.service.CheckAdmitted("fredrik", suite.ticketRelease.ID)
+	suite.Require().NotNil(rerr)
+	suite.Equal("Join the waiting room to request tickets", rerr.Message)
+}
+
+func (suite *WaitingRoomTestSuite) TestRandomOrderQueuesLateUsersLast() {
+	suite.setWaitingRoom(models.WaitingRoomRandom)
+
+	early := []string{"alice", "bob", "carol", "dave"}
+	for _, ugkthid := range early {
+		suite.join(ugkthid)
+	}
+
+	suite.openedAgo(time.Second)
+	suite.Equal(int64(5), suite.join("erik").QueueSize)
+
+	status, rerr := suite.service.GetWaitingRoomStatus("erik", int(suite.event.ID), int(suite.ticketRelease.ID))
+	suite.Require().Nil(rerr)
+	suite.Equal(int64(3), status.Position)
+
+	// The users that joined before the release opened share the first two batches
+	admitted := 0
+	for _, ugkthid := range early {
+		if suite.service.CheckAdmitted(ugkthid, suite.ticketRelease.ID) == nil {
+			admitted++
+		}
+	}
+	suite.Equal(2, admitted)
+}
+
+func (suite *WaitingRoomTestSuite) TestReleasesWithoutWaitingRoomAreNotGated() {
+	suite.Nil(suite.service.CheckAdmitted("alice", suite.ticketRelease.ID))
+
+	suite.setWaitingRoom(models.WaitingRoomArrival)
+	suite.NotNil(suite.service.CheckAdmitted("alice", suite.ticketRelease.ID))
+
+	suite.Require().Nil(suite.service.RemoveWaitingRoom(int(suite.event.ID), int(suite.ticketRelease.ID)))
+	suite.Nil(suite.service.CheckAdmitted("alice", suite.ticketRelease.ID))
+}
+
+func TestWaitingRoomTestSuite(t *testing.T) {
+	suite.Run(t, new(WaitingRoomTestSuite))
+}
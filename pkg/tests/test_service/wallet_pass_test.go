@@ -0,0 +1,237 @@
+package test_service
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services"
+	"github.com/DowLucas/gin-ticket-release/pkg/tests/testutils"
+	"github.com/DowLucas/gin-ticket-release/pkg/wallet"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type WalletPassTestSuite struct {
+	suite.Suite
+	db      *gorm.DB
+	apple   *wallet.AppleConfig
+	google  *wallet.GoogleConfig
+	service *services.WalletPassService
+	ticket  models.Ticket
+}
+
+func (suite *WalletPassTestSuite) readFixture(name string) []byte {
+	data, err := os.ReadFile("testdata/wallet/" + name)
+	suite.Require().NoError(err)
+	return data
+}
+
+func (suite *WalletPassTestSuite) SetupTest() {
+	os.Setenv("ENV", "test")
+	db, err := testutils.SetupTestDatabase(false)
+	suite.Require().NoError(err)
+	suite.db = db
+
+	suite.apple, err = wallet.NewAppleConfig("pass.se.tessera.test", "TESTTEAM01", "Tessera",
+		suite.readFixture("pass.pem"), suite.readFixture("pass.key"), suite.readFixture("wwdr.pem"))
+	suite.Require().NoError(err)
+
+	suite.google, err = wallet.NewGoogleConfig("3388000000012345678", suite.readFixture("google_service_account.json"), []string{"https://tessera.test"})
+	suite.Require().NoError(err)
+
+	suite.service = services.NewWalletPassService(db, suite.apple, suite.google)
+
+	suite.Require().NoError(db.Create(&models.User{UGKthID: "guest", Username: "guest", Email: "guest@kth.se", FirstName: "Ada", LastName: "Lovelace"}).Error)
+
+	organization := models.Organization{Name: "Datasektionen", Email: "wallet@datasektionen.se"}
+	suite.Require().NoError(db.Create(&organization).Error)
+
+	event := models.Event{Name: "Spring Ball", Date: time.Date(2030, 5, 17, 18, 0, 0, 0, time.UTC), Location: "Nymble", OrganizationID: int(organization.ID)}
+	suite.Require().NoError(db.Create(&event).Error)
+
+	tr := models.TicketRelease{EventID: int(event.ID), TicketsAvailable: 10}
+	suite.Require().NoError(db.Create(&tr).Error)
+
+	ticketType := models.TicketType{Name: "Dinner and party", EventID: event.ID, TicketReleaseID: tr.ID, Price: 450}
+	suite.Require().NoError(db.Create(&ticketType).Error)
+
+	addOn := models.AddOn{Name: "Wine package", Price: 150, MaxQuantity: 2, IsEnabled: true, TicketReleaseID: int(tr.ID)}
+	suite.Require().NoError(db.Create(&addOn).Error)
+
+	request := models.TicketRequest{TicketReleaseID: tr.ID, TicketTypeID: ticketType.ID, TicketAmount: 1, UserUGKthID: "guest", IsHandled: true}
+	suite.Require().NoError(db.Create(&request).Error)
+
+	suite.ticket = models.Ticket{TicketRequestID: request.ID, UserUGKthID: "guest", QrCode: "wallet-qr-code", IsPaid: true}
+	suite.Require().NoError(db.Create(&suite.ticket).Error)
+
+	ticketID := suite.ticket.ID
+	suite.Require().NoError(db.Create(&models.TicketAddOn{AddOnID: addOn.ID, TicketID: &ticketID, Quantity: 2}).Error)
+}
+
+func (suite *WalletPassTestSuite) TearDownTest() {
+	testutils.CleanupTestDatabase(suite.db)
+}
+
+func (suite *WalletPassTestSuite) TestApplePass() {
+	data, rerr := suite.service.ApplePass("guest", int(suite.ticket.ID))
+	suite.Require().Nil(rerr)
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	suite.Require().NoError(err)
+
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		suite.Require().NoError(err)
+		files[f.Name], err = io.ReadAll(r)
+		suite.Require().NoError(err)
+		r.Close()
+	}
+
+	for _, name := range []string{"pass.json", "manifest.json", "signature", "icon.png"} {
+		suite.Contains(files, name)
+	}
+
+	var pass map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(files["pass.json"], &pass))
+	suite.Equal("pass.se.tessera.test", pass["passTypeIdentifier"])
+	suite.Equal("TESTTEAM01", pass["teamIdentifier"])
+	suite.Equal("wallet-qr-code", pass["barcodes"].([]interface{})[0].(map[string]interface{})["message"])
+
+	passJSON := string(files["pass.json"])
+	for _, value := range []string{"Spring Ball", "Nymble", "2030-05-17T18:00:00Z", "Dinner and party", "2 x Wine package", "Ada Lovelace"} {
+		suite.Contains(passJSON, value)
+	}
+
+	// The manifest has the hash of every other file
+	var manifest map[string]string
+	suite.Require().NoError(json.Unmarshal(files["manifest.json"], &manifest))
+	suite.Len(manifest, len(files)-2)
+	for name, hash := range manifest {
+		sum := sha1.Sum(files[name])
+		suite.Equal(hex.EncodeToString(sum[:]), hash, name)
+	}
+
+	suite.verifySignature(files["signature"], files["manifest.json"])
+}
+
+// verifySignature checks the detached PKCS #7 signature of the manifest against the fixture certificates
+func (suite *WalletPassTestSuite) verifySignature(signature []byte, manifest []byte) {
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	_, err := asn1.Unmarshal(signature, &contentInfo)
+	suite.Require().NoError(err)
+
+	var signedData struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"tag:0"`
+		SignerInfos      []struct {
+			Version               int
+			IssuerAndSerialNumber struct {
+				Issuer       asn1.RawValue
+				SerialNumber *big.Int
+			}
+			DigestAlgorithm           pkix.AlgorithmIdentifier
+			AuthenticatedAttributes   asn1.RawValue `asn1:"tag:0"`
+			DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+			EncryptedDigest           []byte
+		} `asn1:"set"`
+	}
+	_, err = asn1.Unmarshal(contentInfo.Content.Bytes, &signedData)
+	suite.Require().NoError(err)
+	suite.Require().Len(signedData.SignerInfos, 1)
+
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	suite.Require().NoError(err)
+	suite.Require().Len(certificates, 2)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(suite.apple.WWDR)
+	_, err = certificates[0].Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	suite.Require().NoError(err)
+
+	signer := signedData.SignerInfos[0]
+	suite.Equal(0, certificates[0].SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber))
+
+	// The signature is of the attributes encoded as a SET, and the attributes have the digest of the manifest
+	attributes := signer.AuthenticatedAttributes.Bytes
+	signedAttributes, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+	suite.Require().NoError(err)
+
+	digest := sha256.Sum256(signedAttributes)
+	suite.NoError(rsa.VerifyPKCS1v15(certificates[0].PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signer.EncryptedDigest))
+
+	manifestDigest := sha256.Sum256(manifest)
+	suite.True(bytes.Contains(attributes, manifestDigest[:]))
+}
+
+func (suite *WalletPassTestSuite) TestGoogleSaveLink() {
+	link, rerr := suite.service.GoogleSaveLink("guest", int(suite.ticket.ID))
+	suite.Require().Nil(rerr)
+	suite.True(strings.HasPrefix(link, "https://pay.google.com/gp/v/save/"))
+
+	var claims wallet.GoogleClaims
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(link, "https://pay.google.com/gp/v/save/"), &claims, func(token *jwt.Token) (interface{}, error) {
+		return &suite.google.PrivateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	suite.Require().NoError(err)
+
+	suite.Equal("wallet@tessera-test.iam.gserviceaccount.com", claims.Issuer)
+	suite.Equal("google", claims.Audience)
+	suite.Equal("savetowallet", claims.Type)
+	suite.Equal([]string{"https://tessera.test"}, claims.Origins)
+
+	suite.Require().Len(claims.Payload.EventTicketClasses, 1)
+	suite.Require().Len(claims.Payload.EventTicketObjects, 1)
+	class := claims.Payload.EventTicketClasses[0]
+	object := claims.Payload.EventTicketObjects[0]
+
+	suite.Equal("3388000000012345678.event-1", class.ID)
+	suite.Equal(class.ID, object.ClassID)
+	suite.Equal("3388000000012345678.tessera-ticket-1", object.ID)
+	suite.Equal("wallet-qr-code", object.Barcode.Value)
+	suite.Equal("Ada Lovelace", object.TicketHolderName)
+	suite.Equal("2 x Wine package", object.TextModulesData[0].Body)
+}
+
+func (suite *WalletPassTestSuite) TestOnlyPaidTicketsGetPasses() {
+	suite.Require().NoError(suite.db.Model(&suite.ticket).Update("is_paid", false).Error)
+
+	_, rerr := suite.service.ApplePass("guest", int(suite.ticket.ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusBadRequest, rerr.StatusCode)
+
+	_, rerr = suite.service.GoogleSaveLink("someone-else", int(suite.ticket.ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusNotFound, rerr.StatusCode)
+
+	_, rerr = services.NewWalletPassService(suite.db, nil, nil).ApplePass("guest", int(suite.ticket.ID))
+	suite.Require().NotNil(rerr)
+	suite.Equal(http.StatusServiceUnavailable, rerr.StatusCode)
+}
+
+func TestWalletPassTestSuite(t *testing.T) {
+	suite.Run(t, new(WalletPassTestSuite))
+}
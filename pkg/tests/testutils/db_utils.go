@@ -25,6 +25,25 @@ var modelslist = []interface{}{
 	&models.Role{},
 	&models.OrganizationRole{},
 	&models.OrganizationUserRole{},
+	&models.Transaction{},
+	&models.AddOn{},
+	&models.TicketAddOn{},
+	&models.TicketReleasePaymentDeadline{},
+	&models.LotteryDraw{},
+	&models.AllocationRun{},
+	&models.AllocationRunTicket{},
+	&models.TicketTransfer{},
+	&models.TicketResale{},
+	&models.WebhookEvent{},
+	&models.Order{},
+	&models.OrderItem{},
+	&models.DiscountCode{},
+	&models.TicketReleasePromoCode{},
+	&models.TicketReleasePromoCodeUnlock{},
+	&models.PromoCodeAttempt{},
+	&models.TicketReleaseWaitingRoom{},
+	&models.EventSigningKey{},
+	&models.CheckInLog{},
 	&tr_methods.LotteryConfig{},
 }
 
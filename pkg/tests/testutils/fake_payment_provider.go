@@ -0,0 +1,128 @@
+package testutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+	"github.com/DowLucas/gin-ticket-release/pkg/services/payment_provider"
+)
+
+// FakePaymentProvider is a payment provider that keeps its payments in memory. Register it
+// with payment_provider.Register to replace a real provider in tests.
+type FakePaymentProvider struct {
+	ProviderName models.PaymentProviderName
+	RefundStatus payment_provider.RefundStatus // The status of new refunds, succeeded by default
+	RefundErr    error                         // Returned by Refund, as if the provider could not be reached
+
+	Payments map[string]*payment_provider.Payment
+	Refunds  []payment_provider.RefundParams
+
+	mu      sync.Mutex
+	byKey   map[string]string
+	counter int
+}
+
+func NewFakePaymentProvider(name models.PaymentProviderName) *FakePaymentProvider {
+	return &FakePaymentProvider{
+		ProviderName: name,
+		RefundStatus: payment_provider.RefundSucceeded,
+		Payments:     map[string]*payment_provider.Payment{},
+		byKey:        map[string]string{},
+	}
+}
+
+func (fp *FakePaymentProvider) Name() models.PaymentProviderName {
+	return fp.ProviderName
+}
+
+func (fp *FakePaymentProvider) CreatePayment(params *payment_provider.PaymentParams) (*payment_provider.Payment, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if id, ok := fp.byKey[params.IdempotencyKey]; ok {
+		payment := *fp.Payments[id]
+		return &payment, nil
+	}
+
+	fp.counter++
+	id := fmt.Sprintf("fake_pay_%d", fp.counter)
+	fp.Payments[id] = &payment_provider.Payment{
+		ID:           id,
+		Status:       payment_provider.PaymentPending,
+		Amount:       params.Amount,
+		Currency:     params.Currency,
+		ClientSecret: "secret_" + id,
+	}
+	fp.byKey[params.IdempotencyKey] = id
+
+	payment := *fp.Payments[id]
+	return &payment, nil
+}
+
+func (fp *FakePaymentProvider) GetPayment(paymentID string) (*payment_provider.Payment, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	payment, ok := fp.Payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	result := *payment
+	return &result, nil
+}
+
+func (fp *FakePaymentProvider) CancelPayment(paymentID string) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	payment, ok := fp.Payments[paymentID]
+	if !ok {
+		return fmt.Errorf("payment %s not found", paymentID)
+	}
+
+	payment.Status = payment_provider.PaymentCanceled
+	return nil
+}
+
+// SetPaymentStatus changes the status of a payment, as if the payer had acted on it
+func (fp *FakePaymentProvider) SetPaymentStatus(paymentID string, status payment_provider.PaymentStatus) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.Payments[paymentID].Status = status
+}
+
+func (fp *FakePaymentProvider) Refund(params *payment_provider.RefundParams) (*payment_provider.Refund, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.RefundErr != nil {
+		return nil, fp.RefundErr
+	}
+
+	fp.Refunds = append(fp.Refunds, *params)
+	return &payment_provider.Refund{
+		ID:     fmt.Sprintf("fake_re_%d", len(fp.Refunds)),
+		Status: fp.RefundStatus,
+	}, nil
+}
+
+// ParseCallback reads the callback as JSON, use Callback to create the payload
+func (fp *FakePaymentProvider) ParseCallback(payload []byte, header http.Header) (*payment_provider.Callback, error) {
+	var callback payment_provider.Callback
+	if err := json.Unmarshal(payload, &callback); err != nil {
+		return nil, err
+	}
+
+	return &callback, nil
+}
+
+// Callback returns the callback and its payload as the fake provider would send it
+func (fp *FakePaymentProvider) Callback(callback payment_provider.Callback) (*payment_provider.Callback, []byte) {
+	payload, _ := json.Marshal(callback)
+	return &callback, payload
+}
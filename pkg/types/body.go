@@ -1,6 +1,10 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/models"
+)
 
 type Body struct {
 	FirstName string `json:"first_name"`
@@ -46,26 +50,39 @@ type TicketReleaseFullWorkFlowRequest struct {
 }
 
 type TicketReleasePostReq struct {
-	Name                  string `json:"name"`
-	Description           string `json:"description"`
-	Open                  int64  `json:"open"`
-	Close                 int64  `json:"close"`
-	AllowExternal         bool   `json:"allow_external"`
-	OpenWindowDuration    int    `json:"open_window_duration,omitempty"`
-	MethodDescription     string `json:"method_description,omitempty"`
-	MaxTicketsPerUser     int    `json:"max_tickets_per_user"`
-	NotificationMethod    string `json:"notification_method"`
-	CancellationPolicy    string `json:"cancellation_policy"`
-	TicketReleaseMethodID int    `json:"ticket_release_method_id"`
-	IsReserved            bool   `json:"is_reserved"`
-	PromoCode             string `json:"promo_code"`
-	TicketsAvailable      int    `json:"tickets_available"`
+	Name                  string              `json:"name"`
+	Description           string              `json:"description"`
+	Open                  int64               `json:"open"`
+	Close                 int64               `json:"close"`
+	AllowExternal         bool                `json:"allow_external"`
+	AllowTransfers        bool                `json:"allow_transfers"`
+	TransferDeadline      *int64              `json:"transfer_deadline,omitempty"`
+	TransferPaidOnly      bool                `json:"transfer_paid_only"`
+	AllowResale           bool                `json:"allow_resale"`
+	PaymentProvider       string              `json:"payment_provider"`
+	OpenWindowDuration    int                 `json:"open_window_duration,omitempty"`
+	MethodDescription     string              `json:"method_description,omitempty"`
+	MaxTicketsPerUser     int                 `json:"max_tickets_per_user"`
+	NotificationMethod    string              `json:"notification_method"`
+	CancellationPolicy    string              `json:"cancellation_policy"`
+	RefundTiers           []models.RefundTier `json:"refund_tiers,omitempty"`
+	RefundHandlingFee     float64             `json:"refund_handling_fee,omitempty"`
+	TicketReleaseMethodID int                 `json:"ticket_release_method_id"`
+	IsReserved            bool                `json:"is_reserved"`
+	PromoCode             string              `json:"promo_code"`
+	TicketsAvailable      int                 `json:"tickets_available"`
+
+	LotteryPriorityMode    string `json:"lottery_priority_mode,omitempty"`
+	MemberLotteryWeight    int    `json:"member_lottery_weight,omitempty"`
+	InternalLotteryWeight  int    `json:"internal_lottery_weight,omitempty"`
+	PromoCodeLotteryWeight int    `json:"promo_code_lottery_weight,omitempty"`
 }
 
 type TicketTypePostReq struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
+	Capacity    *int    `json:"capacity,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -125,6 +142,24 @@ type AllocateTicketsRequest struct {
 	CalculatedDuration     time.Duration `json:"-"`
 }
 
+type AllocationPreview struct {
+	Tickets          []AllocationPreviewTicket `json:"tickets"`
+	NumberOfTickets  int                       `json:"number_of_tickets"`
+	NumberOfReserves int                       `json:"number_of_reserves"`
+	ProjectedRevenue float64                   `json:"projected_revenue"` // Ticket and add-on prices of the tickets that would be allocated
+	PaymentDeadline  *time.Time                `json:"payment_deadline"`
+}
+
+type AllocationPreviewTicket struct {
+	TicketRequestID uint    `json:"ticket_request_id"`
+	UserUGKthID     string  `json:"user_ug_kth_id"`
+	TicketTypeID    uint    `json:"ticket_type_id"`
+	TicketTypeName  string  `json:"ticket_type_name"`
+	IsReserve       bool    `json:"is_reserve"`
+	ReserveNumber   uint    `json:"reserve_number"`
+	Price           float64 `json:"price"`
+}
+
 type UpdateTicketBody struct {
 	PaymentDeadline *time.Time `json:"payment_deadline"`
 	CheckedIn       *bool      `json:"checked_in"`
@@ -137,6 +172,134 @@ type BankingDetailsRequest struct {
 	ClearingNumber string `json:"clearing_number" binding:"required"`
 }
 
+type UpdateGuestNameBody struct {
+	GuestName string `json:"guest_name"`
+}
+
+type TicketTransferRequest struct {
+	Recipient string `json:"recipient" binding:"required"` // Username or email of the recipient
+}
+
+type CreateOrderRequest struct {
+	TicketIDs    []int  `json:"ticket_ids" binding:"required"` // Any ticket of each ticket request to pay for
+	DiscountCode string `json:"discount_code"`
+}
+
+type DiscountCodeRequest struct {
+	Code                  string              `json:"code" binding:"required"`
+	DiscountType          models.DiscountType `json:"discount_type" binding:"required"`
+	Amount                float64             `json:"amount" binding:"required"`
+	TicketReleaseID       *uint               `json:"ticket_release_id"`
+	TicketTypeID          *uint               `json:"ticket_type_id"`
+	MaxRedemptions        *int                `json:"max_redemptions"`
+	MaxRedemptionsPerUser *int                `json:"max_redemptions_per_user"`
+	ValidFrom             *time.Time          `json:"valid_from"`
+	ValidUntil            *time.Time          `json:"valid_until"`
+}
+
+// TicketReleasePromoCodeRequest creates or updates a promo code of a reserved ticket release,
+// the code is kept as it is when updating with an empty code
+type TicketReleasePromoCodeRequest struct {
+	Code      string     `json:"code"`
+	Label     string     `json:"label"`
+	MaxUses   *int       `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type WaitingRoomRequest struct {
+	Order         models.WaitingRoomOrder `json:"order" binding:"required"`
+	JoinBefore    int64                   `json:"join_before"`
+	BatchSize     int                     `json:"batch_size" binding:"required"`
+	BatchInterval int64                   `json:"batch_interval" binding:"required"`
+}
+
+// WaitingRoomStatus is the place of a user in the waiting room of a ticket release
+type WaitingRoomStatus struct {
+	Position      int64     `json:"position"` // 0 once the user has been admitted
+	Admitted      bool      `json:"admitted"`
+	QueueSize     int64     `json:"queue_size"`
+	EstimatedWait int64     `json:"estimated_wait"` // In seconds
+	AdmittedAt    time.Time `json:"admitted_at"`    // When the user is expected to be admitted
+}
+
+// CheckInRequest is a scan of the QR code of a ticket at the gate or device Gate
+type CheckInRequest struct {
+	QrCode string `json:"qr_code" binding:"required"`
+	Gate   string `json:"gate"`
+}
+
+type UndoCheckInRequest struct {
+	Gate string `json:"gate"`
+}
+
+// CheckInResult is the outcome of a scan, repeat scans return the time the ticket was first checked in
+type CheckInResult struct {
+	Ticket           *models.Ticket `json:"ticket"`
+	AlreadyCheckedIn bool           `json:"already_checked_in"`
+	CheckedInAt      time.Time      `json:"checked_in_at"`
+}
+
+// CheckInDashboard is the live state of the check-in of an event
+type CheckInDashboard struct {
+	TicketTypes []models.TicketTypeCheckIns `json:"ticket_types"`
+	CheckedIn   int64                       `json:"checked_in"`
+	Total       int64                       `json:"total"`
+	ArrivalRate float64                     `json:"arrival_rate"` // Check-ins per minute during the last 15 minutes
+	RecentScans []models.CheckInLog         `json:"recent_scans"`
+}
+
+// SignedQrCode is the QR code of a ticket signed with the key pair of the event
+type SignedQrCode struct {
+	Token      string    `json:"token"`
+	ValidFrom  time.Time `json:"valid_from"`
+	ValidUntil time.Time `json:"valid_until"`
+}
+
+// EventPublicKey is what the scanners need to verify signed QR codes of an event offline
+type EventPublicKey struct {
+	EventID   uint   `json:"event_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // Base64 encoded
+}
+
+// OfflineCheckIn is a signed QR code that a scanner checked in while it was offline
+type OfflineCheckIn struct {
+	Token       string    `json:"token" binding:"required"`
+	CheckedInAt time.Time `json:"checked_in_at" binding:"required"`
+}
+
+type OfflineCheckInBatchRequest struct {
+	Gate     string           `json:"gate"`
+	CheckIns []OfflineCheckIn `json:"check_ins" binding:"required,max=1000,dive"`
+}
+
+type OfflineCheckInStatus string
+
+const (
+	OfflineCheckInAccepted  OfflineCheckInStatus = "checked_in" // The ticket was checked in at the time of the scan
+	OfflineCheckInDuplicate OfflineCheckInStatus = "duplicate"  // The ticket had already been checked in
+	OfflineCheckInRejected  OfflineCheckInStatus = "rejected"   // The QR code was not valid for the event
+)
+
+// OfflineCheckInResult is the outcome of one offline check-in, in the same order as the request
+type OfflineCheckInResult struct {
+	TicketID    uint                 `json:"ticket_id,omitempty"`
+	Status      OfflineCheckInStatus `json:"status"`
+	CheckedInAt *time.Time           `json:"checked_in_at,omitempty"` // When the ticket was checked in, the original time for duplicates
+	Error       string               `json:"error,omitempty"`
+}
+
 type UpdateTicketTypeBody struct {
 	TicketTypeID uint `json:"ticket_type_id" binding:"required"`
 }
+
+type LotteryDrawVerification struct {
+	Seed             int64  `json:"seed"`
+	RequestIDsHash   string `json:"request_ids_hash"`
+	HashMatches      bool   `json:"hash_matches"`
+	WinnersMatch     bool   `json:"winners_match"`
+	ReserveMatches   bool   `json:"reserve_matches"`
+	Verified         bool   `json:"verified"`
+	WinnerRequestIDs []uint `json:"winner_request_ids"`
+	ReserveOrder     []uint `json:"reserve_order"`
+}
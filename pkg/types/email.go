@@ -45,6 +45,20 @@ type EmailTicketPaymentConfirmation struct {
 	OrganizationEmail string
 }
 
+// Associated with order_payment_confirmation
+type EmailOrderPaymentConfirmation struct {
+	FullName    string
+	OrderID     uint
+	TicketsHTML template.HTML
+	Total       string
+	Events      []EmailOrderEvent
+}
+
+type EmailOrderEvent struct {
+	Name              string
+	OrganizationEmail string
+}
+
 // Associated with ticket_cancelled_confirmation
 type EmailTicketCancelledConfirmation struct {
 	FullName          string
@@ -139,3 +153,74 @@ type EmailUpdatePaymentDeadline struct {
 	PayBefore         string
 	OrganizationEmail string
 }
+
+// Associated with ticket_transfer_initiated
+type EmailTicketTransferInitiated struct {
+	FullName          string
+	SenderName        string
+	EventName         string
+	TransfersURL      string
+	OrganizationEmail string
+}
+
+// Associated with ticket_transfer_completed
+type EmailTicketTransferCompleted struct {
+	FullName          string
+	OtherName         string
+	EventName         string
+	NumberOfTickets   int
+	Received          bool
+	TicketURL         string
+	OrganizationEmail string
+}
+
+// Associated with ticket_transfer_organization
+type EmailTicketTransferOrganization struct {
+	OrganizationName string
+	EventName        string
+	SenderName       string
+	SenderEmail      string
+	RecipientName    string
+	RecipientEmail   string
+	NumberOfTickets  int
+}
+
+// Associated with ticket_resold
+type EmailTicketResold struct {
+	FullName          string
+	EventName         string
+	NumberOfTickets   int
+	RefundAmount      string
+	OrganizationEmail string
+}
+
+// Associated with ticket_payment_failed
+type EmailTicketPaymentFailed struct {
+	FullName          string
+	EventName         string
+	FailureMessage    string
+	PayBefore         string
+	TicketURL         string
+	OrganizationEmail string
+}
+
+// Associated with payment_dispute_organization
+type EmailPaymentDisputeOrganization struct {
+	OrganizationName string
+	EventName        string
+	UserName         string
+	UserEmail        string
+	Amount           string
+	Reason           string
+	Status           string
+	Closed           bool
+}
+
+// Associated with webhook_event_dead_letter
+type EmailWebhookEventDeadLetter struct {
+	FullName  string
+	StripeID  string
+	EventType string
+	Attempts  int
+	LastError string
+}
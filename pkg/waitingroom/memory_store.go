@@ -0,0 +1,70 @@
+package waitingroom
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps the queues in memory, which only works with one instance of the server
+type MemoryStore struct {
+	queues   map[string]map[string]float64
+	arrivals map[string]int64
+	mtx      sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		queues:   make(map[string]map[string]float64),
+		arrivals: make(map[string]int64),
+	}
+}
+
+func (s *MemoryStore) Join(ctx context.Context, queue string, userID string, score float64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.queues[queue] == nil {
+		s.queues[queue] = make(map[string]float64)
+	}
+
+	if _, exists := s.queues[queue][userID]; !exists {
+		s.queues[queue][userID] = score
+	}
+
+	return nil
+}
+
+// Rank orders users with the same score by their ID, the same way as Redis sorted sets
+func (s *MemoryStore) Rank(ctx context.Context, queue string, userID string) (int64, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	score, exists := s.queues[queue][userID]
+	if !exists {
+		return 0, false, nil
+	}
+
+	var rank int64
+	for otherID, otherScore := range s.queues[queue] {
+		if otherScore < score || (otherScore == score && otherID < userID) {
+			rank++
+		}
+	}
+
+	return rank, true, nil
+}
+
+func (s *MemoryStore) NextArrival(ctx context.Context, queue string) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.arrivals[queue]++
+	return s.arrivals[queue], nil
+}
+
+func (s *MemoryStore) Size(ctx context.Context, queue string) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return int64(len(s.queues[queue])), nil
+}
@@ -0,0 +1,63 @@
+package waitingroom
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// queueTTL is how long a queue is kept after the last user joined it
+const queueTTL = 7 * 24 * time.Hour
+
+// RedisStore keeps each queue in a sorted set so that all replicas share the queues
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Join(ctx context.Context, queue string, userID string, score float64) error {
+	key := s.prefix + queue
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddNX(ctx, key, redis.Z{Score: score, Member: userID})
+		pipe.Expire(ctx, key, queueTTL)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Rank(ctx context.Context, queue string, userID string) (int64, bool, error) {
+	rank, err := s.client.ZRank(ctx, s.prefix+queue, userID).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return rank, true, nil
+}
+
+func (s *RedisStore) NextArrival(ctx context.Context, queue string) (int64, error) {
+	key := s.prefix + queue + ":arrivals"
+	var arrival *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		arrival = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, queueTTL)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return arrival.Val(), nil
+}
+
+func (s *RedisStore) Size(ctx context.Context, queue string) (int64, error) {
+	return s.client.ZCard(ctx, s.prefix+queue).Result()
+}
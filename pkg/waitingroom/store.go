@@ -0,0 +1,34 @@
+package waitingroom
+
+import (
+	"context"
+	"os"
+
+	"github.com/DowLucas/gin-ticket-release/pkg/ratelimit"
+)
+
+// Store keeps the queues of the waiting rooms. Users are ordered by their score, lowest first.
+type Store interface {
+	// Join adds the user to the queue with the score, a user that is already in the queue keeps their score
+	Join(ctx context.Context, queue string, userID string, score float64) error
+	// Rank returns the number of users ahead of the user, false if the user is not in the queue
+	Rank(ctx context.Context, queue string, userID string) (int64, bool, error)
+	// NextArrival returns a number that is larger for every call, used to order users by arrival
+	NextArrival(ctx context.Context, queue string) (int64, error)
+	Size(ctx context.Context, queue string) (int64, error)
+}
+
+// NewStore returns the Redis store if WAITING_ROOM_STORE is "redis", otherwise the memory store.
+// Redis is needed when there are several replicas so that they share the queues
+func NewStore(prefix string) (Store, error) {
+	if os.Getenv("WAITING_ROOM_STORE") != "redis" {
+		return NewMemoryStore(), nil
+	}
+
+	client, err := ratelimit.NewRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRedisStore(client, prefix), nil
+}
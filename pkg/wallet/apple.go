@@ -0,0 +1,189 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed assets/*.png
+var assets embed.FS
+
+// TicketPass is what the passes of a ticket show
+type TicketPass struct {
+	SerialNumber string // Unique per ticket, the same for every pass of the ticket
+	EventID      uint
+	EventName    string
+	Organization string
+	Date         time.Time
+	EndDate      *time.Time
+	Location     string
+	TicketType   string
+	AddOns       []string
+	HolderName   string
+	QrCode       string
+}
+
+type applePass struct {
+	FormatVersion      int           `json:"formatVersion"`
+	PassTypeIdentifier string        `json:"passTypeIdentifier"`
+	SerialNumber       string        `json:"serialNumber"`
+	TeamIdentifier     string        `json:"teamIdentifier"`
+	OrganizationName   string        `json:"organizationName"`
+	Description        string        `json:"description"`
+	RelevantDate       string        `json:"relevantDate"`
+	ExpirationDate     string        `json:"expirationDate"`
+	BackgroundColor    string        `json:"backgroundColor"`
+	ForegroundColor    string        `json:"foregroundColor"`
+	LabelColor         string        `json:"labelColor"`
+	GroupingIdentifier string        `json:"groupingIdentifier"`
+	Barcodes           []appleCode   `json:"barcodes"`
+	EventTicket        appleFieldSet `json:"eventTicket"`
+}
+
+type appleCode struct {
+	Format          string `json:"format"`
+	Message         string `json:"message"`
+	MessageEncoding string `json:"messageEncoding"`
+}
+
+type appleFieldSet struct {
+	PrimaryFields   []appleField `json:"primaryFields"`
+	SecondaryFields []appleField `json:"secondaryFields,omitempty"`
+	AuxiliaryFields []appleField `json:"auxiliaryFields,omitempty"`
+	BackFields      []appleField `json:"backFields,omitempty"`
+}
+
+type appleField struct {
+	Key       string `json:"key"`
+	Label     string `json:"label,omitempty"`
+	Value     string `json:"value"`
+	DateStyle string `json:"dateStyle,omitempty"`
+	TimeStyle string `json:"timeStyle,omitempty"`
+}
+
+// ApplePass returns the signed .pkpass bundle of the ticket
+func ApplePass(config *AppleConfig, pass *TicketPass) ([]byte, error) {
+	passJSON, err := json.MarshalIndent(newApplePass(config, pass), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{"pass.json": passJSON}
+	icons, err := assets.ReadDir("assets")
+	if err != nil {
+		return nil, err
+	}
+	for _, icon := range icons {
+		data, err := assets.ReadFile("assets/" + icon.Name())
+		if err != nil {
+			return nil, err
+		}
+		files[icon.Name()] = data
+	}
+
+	// The manifest has the SHA-1 hash of every file of the pass, and the signature is of the manifest
+	manifest := make(map[string]string, len(files))
+	for name, data := range files {
+		hash := sha1.Sum(data)
+		manifest[name] = hex.EncodeToString(hash[:])
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	files["manifest.json"] = manifestJSON
+
+	signature, err := signDetached(manifestJSON, config.Certificate, config.PrivateKey, []*x509.Certificate{config.WWDR}, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("signing pass: %w", err)
+	}
+	files["signature"] = signature
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range sortedKeys(files) {
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func newApplePass(config *AppleConfig, pass *TicketPass) *applePass {
+	organization := config.OrganizationName
+	if organization == "" {
+		organization = pass.Organization
+	}
+
+	end := pass.Date
+	if pass.EndDate != nil {
+		end = *pass.EndDate
+	}
+
+	ap := &applePass{
+		FormatVersion:      1,
+		PassTypeIdentifier: config.PassTypeID,
+		SerialNumber:       pass.SerialNumber,
+		TeamIdentifier:     config.TeamID,
+		OrganizationName:   organization,
+		Description:        "Ticket to " + pass.EventName,
+		RelevantDate:       pass.Date.Format(time.RFC3339),
+		ExpirationDate:     end.Add(24 * time.Hour).Format(time.RFC3339),
+		BackgroundColor:    "rgb(155, 28, 46)",
+		ForegroundColor:    "rgb(255, 255, 255)",
+		LabelColor:         "rgb(255, 220, 225)",
+		GroupingIdentifier: fmt.Sprintf("event-%d", pass.EventID),
+		Barcodes: []appleCode{{
+			Format:          "PKBarcodeFormatQR",
+			Message:         pass.QrCode,
+			MessageEncoding: "iso-8859-1",
+		}},
+		EventTicket: appleFieldSet{
+			PrimaryFields: []appleField{{Key: "event", Label: "EVENT", Value: pass.EventName}},
+			SecondaryFields: []appleField{
+				{Key: "date", Label: "DATE", Value: pass.Date.Format(time.RFC3339), DateStyle: "PKDateStyleMedium", TimeStyle: "PKDateStyleShort"},
+				{Key: "location", Label: "LOCATION", Value: pass.Location},
+			},
+			AuxiliaryFields: []appleField{
+				{Key: "ticket-type", Label: "TICKET", Value: pass.TicketType},
+				{Key: "holder", Label: "NAME", Value: pass.HolderName},
+			},
+			BackFields: []appleField{{Key: "organizer", Label: "Organizer", Value: pass.Organization}},
+		},
+	}
+
+	if len(pass.AddOns) > 0 {
+		ap.EventTicket.BackFields = append(ap.EventTicket.BackFields,
+			appleField{Key: "add-ons", Label: "Add-ons", Value: strings.Join(pass.AddOns, "\n")})
+	}
+
+	return ap
+}
+
+func sortedKeys(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
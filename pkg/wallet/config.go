@@ -0,0 +1,166 @@
+// Package wallet generates Apple Wallet and Google Wallet passes of tickets
+package wallet
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AppleConfig is the pass type certificate that signs the Apple Wallet passes, with the Apple WWDR
+// intermediate certificate that issued it
+type AppleConfig struct {
+	PassTypeID       string
+	TeamID           string
+	OrganizationName string
+	Certificate      *x509.Certificate
+	PrivateKey       crypto.Signer
+	WWDR             *x509.Certificate
+}
+
+// GoogleConfig is the issuer and the service account that signs the Google Wallet save links
+type GoogleConfig struct {
+	IssuerID            string
+	ServiceAccountEmail string
+	PrivateKey          *rsa.PrivateKey
+	Origins             []string // The domains that show the save button
+}
+
+// NewAppleConfig parses the PEM encoded pass type certificate, its private key and the WWDR certificate
+func NewAppleConfig(passTypeID, teamID, organizationName string, certPEM, keyPEM, wwdrPEM []byte) (*AppleConfig, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("pass certificate: %w", err)
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("pass key: %w", err)
+	}
+
+	wwdr, err := parseCertificate(wwdrPEM)
+	if err != nil {
+		return nil, fmt.Errorf("WWDR certificate: %w", err)
+	}
+
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("pass key must be an RSA key")
+	}
+
+	return &AppleConfig{
+		PassTypeID:       passTypeID,
+		TeamID:           teamID,
+		OrganizationName: organizationName,
+		Certificate:      cert,
+		PrivateKey:       key,
+		WWDR:             wwdr,
+	}, nil
+}
+
+// NewGoogleConfig parses the JSON key file of the service account
+func NewGoogleConfig(issuerID string, serviceAccountJSON []byte, origins []string) (*GoogleConfig, error) {
+	var serviceAccount struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(serviceAccountJSON, &serviceAccount); err != nil {
+		return nil, fmt.Errorf("service account: %w", err)
+	}
+
+	key, err := parsePrivateKey([]byte(serviceAccount.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("service account key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account key must be an RSA key")
+	}
+
+	return &GoogleConfig{
+		IssuerID:            issuerID,
+		ServiceAccountEmail: serviceAccount.ClientEmail,
+		PrivateKey:          rsaKey,
+		Origins:             origins,
+	}, nil
+}
+
+// AppleConfigFromEnv reads the Apple Wallet configuration, the certificates are paths to PEM files.
+// Returns nil if APPLE_WALLET_PASS_TYPE_ID is not set.
+func AppleConfigFromEnv() (*AppleConfig, error) {
+	passTypeID := os.Getenv("APPLE_WALLET_PASS_TYPE_ID")
+	if passTypeID == "" {
+		return nil, nil
+	}
+
+	files := make(map[string][]byte)
+	for _, name := range []string{"APPLE_WALLET_CERT", "APPLE_WALLET_KEY", "APPLE_WALLET_WWDR_CERT"} {
+		data, err := os.ReadFile(os.Getenv(name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[name] = data
+	}
+
+	return NewAppleConfig(passTypeID, os.Getenv("APPLE_WALLET_TEAM_ID"), os.Getenv("APPLE_WALLET_ORGANIZATION_NAME"),
+		files["APPLE_WALLET_CERT"], files["APPLE_WALLET_KEY"], files["APPLE_WALLET_WWDR_CERT"])
+}
+
+// GoogleConfigFromEnv reads the Google Wallet configuration, the service account is a path to its
+// JSON key file. Returns nil if GOOGLE_WALLET_ISSUER_ID is not set.
+func GoogleConfigFromEnv() (*GoogleConfig, error) {
+	issuerID := os.Getenv("GOOGLE_WALLET_ISSUER_ID")
+	if issuerID == "" {
+		return nil, nil
+	}
+
+	serviceAccount, err := os.ReadFile(os.Getenv("GOOGLE_WALLET_SERVICE_ACCOUNT"))
+	if err != nil {
+		return nil, fmt.Errorf("GOOGLE_WALLET_SERVICE_ACCOUNT: %w", err)
+	}
+
+	var origins []string
+	if env := os.Getenv("GOOGLE_WALLET_ORIGINS"); env != "" {
+		origins = strings.Split(env, ",")
+	}
+
+	return NewGoogleConfig(issuerID, serviceAccount, origins)
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const googleSaveURL = "https://pay.google.com/gp/v/save/"
+
+// GoogleClaims is the JWT that adds the pass to Google Wallet when the user opens the save link
+type GoogleClaims struct {
+	Issuer   string        `json:"iss"` // The email of the service account
+	Audience string        `json:"aud"`
+	IssuedAt int64         `json:"iat"`
+	Type     string        `json:"typ"`
+	Origins  []string      `json:"origins"`
+	Payload  GooglePayload `json:"payload"`
+}
+
+// Valid is required by jwt.Claims, the claims are only validated by Google
+func (c *GoogleClaims) Valid() error {
+	return nil
+}
+
+type GooglePayload struct {
+	EventTicketClasses []GoogleEventTicketClass  `json:"eventTicketClasses"`
+	EventTicketObjects []GoogleEventTicketObject `json:"eventTicketObjects"`
+}
+
+// GoogleEventTicketClass is the event, shared by the passes of all tickets to it
+type GoogleEventTicketClass struct {
+	ID           string              `json:"id"`
+	IssuerName   string              `json:"issuerName"`
+	ReviewStatus string              `json:"reviewStatus"`
+	EventName    googleLocalized     `json:"eventName"`
+	Venue        *googleVenue        `json:"venue,omitempty"`
+	DateTime     googleEventDateTime `json:"dateTime"`
+}
+
+// GoogleEventTicketObject is the pass of one ticket
+type GoogleEventTicketObject struct {
+	ID               string             `json:"id"`
+	ClassID          string             `json:"classId"`
+	State            string             `json:"state"`
+	TicketHolderName string             `json:"ticketHolderName,omitempty"`
+	TicketType       googleLocalized    `json:"ticketType"`
+	Barcode          googleBarcode      `json:"barcode"`
+	TextModulesData  []googleTextModule `json:"textModulesData,omitempty"`
+}
+
+type googleLocalized struct {
+	DefaultValue googleTranslated `json:"defaultValue"`
+}
+
+type googleTranslated struct {
+	Language string `json:"language"`
+	Value    string `json:"value"`
+}
+
+type googleVenue struct {
+	Name    googleLocalized `json:"name"`
+	Address googleLocalized `json:"address"`
+}
+
+type googleEventDateTime struct {
+	Start string `json:"start"`
+	End   string `json:"end,omitempty"`
+}
+
+type googleBarcode struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type googleTextModule struct {
+	ID     string `json:"id"`
+	Header string `json:"header"`
+	Body   string `json:"body"`
+}
+
+// GoogleSaveLink returns the link that adds the pass of the ticket to Google Wallet. The event class is
+// created by Google the first time one of its passes is saved.
+func GoogleSaveLink(config *GoogleConfig, pass *TicketPass) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, newGoogleClaims(config, pass))
+	signed, err := token.SignedString(config.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("signing Google Wallet pass: %w", err)
+	}
+
+	return googleSaveURL + signed, nil
+}
+
+func newGoogleClaims(config *GoogleConfig, pass *TicketPass) *GoogleClaims {
+	class := GoogleEventTicketClass{
+		ID:           fmt.Sprintf("%s.event-%d", config.IssuerID, pass.EventID),
+		IssuerName:   pass.Organization,
+		ReviewStatus: "UNDER_REVIEW",
+		EventName:    localized(pass.EventName),
+		DateTime:     googleEventDateTime{Start: pass.Date.Format(time.RFC3339)},
+	}
+
+	if pass.EndDate != nil {
+		class.DateTime.End = pass.EndDate.Format(time.RFC3339)
+	}
+
+	if pass.Location != "" {
+		class.Venue = &googleVenue{Name: localized(pass.Location), Address: localized(pass.Location)}
+	}
+
+	object := GoogleEventTicketObject{
+		ID:               config.IssuerID + "." + pass.SerialNumber,
+		ClassID:          class.ID,
+		State:            "ACTIVE",
+		TicketHolderName: pass.HolderName,
+		TicketType:       localized(pass.TicketType),
+		Barcode:          googleBarcode{Type: "QR_CODE", Value: pass.QrCode},
+	}
+
+	if len(pass.AddOns) > 0 {
+		object.TextModulesData = []googleTextModule{{ID: "add-ons", Header: "Add-ons", Body: strings.Join(pass.AddOns, "\n")}}
+	}
+
+	origins := config.Origins
+	if origins == nil {
+		origins = []string{}
+	}
+
+	return &GoogleClaims{
+		Issuer:   config.ServiceAccountEmail,
+		Audience: "google",
+		IssuedAt: time.Now().Unix(),
+		Type:     "savetowallet",
+		Origins:  origins,
+		Payload: GooglePayload{
+			EventTicketClasses: []GoogleEventTicketClass{class},
+			EventTicketObjects: []GoogleEventTicketObject{object},
+		},
+	}
+}
+
+func localized(value string) googleLocalized {
+	return googleLocalized{DefaultValue: googleTranslated{Language: "en-US", Value: value}}
+}
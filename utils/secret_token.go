@@ -5,6 +5,7 @@ import (
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -84,6 +85,19 @@ func CompareHashAndString(hash, s string) (bool, error) {
 	return hmac.Equal([]byte(decryptedString), []byte(s)), nil
 }
 
+// HashPromoCode returns a HMAC-SHA256 of the promo code keyed with the secret key, so that promo codes
+// can be looked up without decrypting them
+func HashPromoCode(code string) string {
+	key := os.Getenv("SECRET_KEY")
+	if key == "" {
+		panic("SECRET_KEY environment variable not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func GenerateSecretToken() (string, error) {
 	token := make([]byte, 32) // Generate a 32 characters long token
 	_, err := rand.Read(token)